@@ -299,6 +299,14 @@ func (b *Builder) mcaPkgInfo(manifests []*swupd.Manifest, version, downloadRetri
 
 	pInfo := make(map[string]*mcaBundlePkgInfo)
 
+	// Packages pinned in any bundle must be checked at their pinned version.
+	pins := make(map[string]*packagePin)
+	for _, m := range manifests {
+		for pkg, pin := range m.BundleInfo.PackagePins {
+			pins[pkg] = &packagePin{Version: pin.Version, Arch: pin.Arch}
+		}
+	}
+
 	// Download and query file metadata from all packages in each bundle
 	for _, m := range manifests {
 		pInfo[m.Name] = &mcaBundlePkgInfo{
@@ -315,6 +323,9 @@ func (b *Builder) mcaPkgInfo(manifests []*swupd.Manifest, version, downloadRetri
 		pkgList := []string{}
 
 		for pkg := range m.BundleInfo.AllPackages {
+			if pin, ok := pins[pkg]; ok {
+				pkg = pin.spec(pkg)
+			}
 			pkgList = append(pkgList, pkg)
 		}
 
@@ -442,6 +453,11 @@ func (info *mcaBundleInfo) getManFiles(manifest *swupd.Manifest) error {
 			continue
 		}
 
+		// Files from content overlays have no package to be checked against
+		if manifest.BundleInfo.ContentFiles[file.Name] {
+			continue
+		}
+
 		info.manFiles[file.Name] = file
 	}
 	return nil
@@ -474,6 +490,11 @@ func (info *mcaBundleInfo) getSubPkgFiles(manifest *swupd.Manifest, pInfo map[st
 	// Collect all files from bundle's subtracted packages
 	for p := range info.subPkgs {
 		for _, f := range pInfo[manifest.Name].allPkgs[p].files {
+			// Files dropped by exclude directives are not expected in the manifest
+			if isExcludedPath(manifest.BundleInfo.Excludes, f.name) {
+				continue
+			}
+
 			isIncluded := false
 
 			for _, inc := range includes {
@@ -684,32 +705,32 @@ func getPkgDiffLists(fromPkgs, toPkgs map[string]bool, bundleDiff *mcaBundleDiff
 }
 
 func getManFileDiffLists(fromFiles, toFiles map[string]*swupd.File) (bool, diffLists) {
+	fromM := &swupd.Manifest{}
+	for _, f := range fromFiles {
+		fromM.Files = append(fromM.Files, f)
+	}
+	toM := &swupd.Manifest{}
+	for _, f := range toFiles {
+		toM.Files = append(toM.Files, f)
+	}
+	d := swupd.DiffManifests(fromM, toM, swupd.DiffOptions{})
+
 	addList := []string{}
 	delList := []string{}
 	modList := []string{}
-	minversion := false
-
-	for f := range toFiles {
-		if fromFiles[f] != nil {
-			// Match
-			if isManFileMod(fromFiles[f], toFiles[f]) {
-				modList = append(modList, f)
-			} else if !minversion {
-				minversion = isMinversion(fromFiles[f], toFiles[f])
-			}
-		} else {
-			// Added file
-			addList = append(addList, f)
-		}
+	for _, f := range d.Added {
+		addList = append(addList, f.Name)
 	}
-
-	for f := range fromFiles {
-		if toFiles[f] == nil {
-			// Deleted file
-			delList = append(delList, f)
+	for _, f := range d.Removed {
+		delList = append(delList, f.Name)
+	}
+	for _, c := range d.Modified {
+		// Only content changes are relevant when comparing against the package files.
+		if c.HashChanged {
+			modList = append(modList, c.New.Name)
 		}
 	}
-	return minversion, diffLists{modList: modList, addList: addList, delList: delList}
+	return d.Minversion, diffLists{modList: modList, addList: addList, delList: delList}
 }
 
 func isFileMod(from, to *fileInfo) bool {
@@ -725,16 +746,6 @@ func isPkgMod(key string, bundleDiff *mcaBundleDiff) bool {
 	return (bundleDiff.pkgFileCounts[key].add + bundleDiff.pkgFileCounts[key].mod + bundleDiff.pkgFileCounts[key].del) != 0
 }
 
-func isManFileMod(from, to *swupd.File) bool {
-	return (from.Hash != to.Hash)
-}
-
-func isMinversion(from, to *swupd.File) bool {
-	return ((from.Name == to.Name) &&
-		(from.Hash == to.Hash) &&
-		(from.Version != to.Version))
-}
-
 // analyzeMcaResults compares manifest file changes against package file changes.
 // When there are inconsistencies between the manifest and package file lists,
 // a slice of error strings is returned.
@@ -63,6 +63,10 @@ type Builder struct {
 	NumDeltaWorkers    int
 	NumBundleWorkers   int
 
+	// LockFile is the package lock used to resolve the packages when
+	// building bundles. The build fails if the packages drift from it.
+	LockFile string
+
 	// Parsed versions.
 	MixVerUint32      uint32
 	UpstreamVerUint32 uint32
@@ -82,6 +86,8 @@ type UpdateParameters struct {
 	SkipFullfiles bool
 	// Skip zero packs generation
 	SkipPacks bool
+	// Write the changelog from the previous version as release notes
+	ReleaseNotes bool
 }
 
 var localPackages = make(map[string]bool)
@@ -265,6 +271,17 @@ func (b *Builder) BuildBundles(template *x509.Certificate, privkey *rsa.PrivateK
 		}
 	}
 
+	// Read the lock before cleaning, since it may be the one from the
+	// version being rebuilt.
+	var lock *packageLock
+	if b.LockFile != "" {
+		var err error
+		if lock, err = readPackageLock(b.LockFile); err != nil {
+			return err
+		}
+		fmt.Printf("Using package lock %s\n", b.LockFile)
+	}
+
 	if _, err := os.Stat(b.Config.Builder.ServerStateDir + "/image/" + b.MixVer); err == nil && clean {
 		fmt.Printf("* Wiping away previous version %s...\n", b.MixVer)
 		err = os.RemoveAll(b.Config.Builder.ServerStateDir + "/www/" + b.MixVer)
@@ -297,7 +314,7 @@ func (b *Builder) BuildBundles(template *x509.Certificate, privkey *rsa.PrivateK
 	}
 
 	// TODO: Merge the rest of this function into buildBundles (or vice-versa).
-	err = b.buildBundles(set, downloadRetries)
+	err = b.buildBundles(set, downloadRetries, lock)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,211 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/clearlinux/mixer-tools/helpers"
+	"github.com/pkg/errors"
+)
+
+// bundleContent is a directory of files not provided by packages that is
+// overlaid into a bundle. The files keep the modes they have in the content
+// directory, but are always owned by UID and GID instead of the user running
+// mixer.
+type bundleContent struct {
+	Path string
+	UID  int
+	GID  int
+}
+
+// parseContentDirective parses the arguments of a "content()" directive, which
+// have the form "path" or "path, uid:gid". The owner defaults to root.
+func parseContentDirective(args string) (*bundleContent, error) {
+	parts := strings.Split(args, ",")
+	if len(parts) > 2 {
+		return nil, fmt.Errorf("too many arguments in %q", args)
+	}
+
+	c := &bundleContent{Path: strings.TrimSpace(parts[0])}
+	if c.Path == "" {
+		return nil, fmt.Errorf("empty content path")
+	}
+
+	if len(parts) == 2 {
+		owner := strings.Split(strings.TrimSpace(parts[1]), ":")
+		if len(owner) != 2 {
+			return nil, fmt.Errorf("invalid owner %q, must be uid:gid", strings.TrimSpace(parts[1]))
+		}
+		var err error
+		if c.UID, err = strconv.Atoi(owner[0]); err != nil || c.UID < 0 {
+			return nil, fmt.Errorf("invalid uid %q", owner[0])
+		}
+		if c.GID, err = strconv.Atoi(owner[1]); err != nil || c.GID < 0 {
+			return nil, fmt.Errorf("invalid gid %q", owner[1])
+		}
+	}
+
+	return c, nil
+}
+
+// walkContent calls fn for every entry of the content directory, with the
+// absolute path the entry will have in the bundle.
+func walkContent(c *bundleContent, fn func(name, src string, fi os.FileInfo) error) error {
+	fi, err := os.Stat(c.Path)
+	if err != nil {
+		return errors.Wrap(err, "couldn't read content directory")
+	}
+	if !fi.IsDir() {
+		return errors.Errorf("content %s is not a directory", c.Path)
+	}
+
+	return filepath.Walk(c.Path, func(src string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if src == c.Path {
+			return nil
+		}
+		name := "/" + strings.TrimPrefix(src, c.Path+"/")
+		if isBannedPath(name) || isBannedPath(name+"/") {
+			return errors.Errorf("content file %s from %s is in a banned path", name, c.Path)
+		}
+		mode := fi.Mode()
+		if !mode.IsDir() && !mode.IsRegular() && mode&os.ModeSymlink == 0 {
+			return errors.Errorf("content file %s from %s has unsupported type", name, c.Path)
+		}
+		return fn(name, src, fi)
+	})
+}
+
+// addContentFiles adds the files from the content directories to the file
+// lists of their bundles. It must be called after the package files are
+// resolved: a content file can't replace a file provided by a package in any
+// bundle, nor be provided by more than one content directory. Directories
+// may be shared.
+func addContentFiles(set bundleSet) error {
+	provider := make(map[string]string)
+	for _, bundle := range set {
+		for _, c := range bundle.Content {
+			err := walkContent(c, func(name, src string, fi os.FileInfo) error {
+				if fi.IsDir() {
+					return nil
+				}
+				for _, other := range set {
+					if other.Files[name] {
+						return errors.Errorf("content file %s from %s conflicts with a file provided by packages in bundle %s", name, c.Path, other.Name)
+					}
+				}
+				if p, ok := provider[name]; ok {
+					return errors.Errorf("content file %s from %s is also provided by the content of bundle %s", name, c.Path, p)
+				}
+				provider[name] = bundle.Name
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, bundle := range set {
+		if len(bundle.Content) == 0 {
+			continue
+		}
+		bundle.ContentFiles = make(map[string]bool)
+		for _, c := range bundle.Content {
+			err := walkContent(c, func(name, src string, fi os.FileInfo) error {
+				addFileAndPath(bundle.Files, name)
+				bundle.ContentFiles[name] = true
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		fmt.Printf("Bundle %s\t%d content files\n", bundle.Name, len(bundle.ContentFiles))
+	}
+	return nil
+}
+
+// overlayContent copies the content directories of the bundles into the full
+// chroot, setting the owner of each content directory and keeping the modes.
+// Directories that already exist in the chroot are left untouched. Like the
+// extraction of the packages, the owners are only set when running as root.
+func overlayContent(set bundleSet, fullDir string) error {
+	isRoot := os.Geteuid() == 0
+	for _, bundle := range set {
+		for _, c := range bundle.Content {
+			err := walkContent(c, func(name, src string, fi os.FileInfo) error {
+				return overlayContentFile(c, filepath.Join(fullDir, name), src, fi, isRoot)
+			})
+			if err != nil {
+				return errors.Wrapf(err, "couldn't overlay content for bundle %s", bundle.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func overlayContentFile(c *bundleContent, dest, src string, fi os.FileInfo, isRoot bool) error {
+	mode := fi.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+
+	if fi.IsDir() {
+		if _, err := os.Lstat(dest); err == nil {
+			return nil
+		}
+		if err := os.Mkdir(dest, 0755); err != nil {
+			return err
+		}
+		if isRoot {
+			if err := os.Chown(dest, c.UID, c.GID); err != nil {
+				return err
+			}
+		}
+		return os.Chmod(dest, mode)
+	}
+
+	// A file excluded from the packages may still be in the chroot.
+	if err := os.Remove(dest); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if fi.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		if err = os.Symlink(target, dest); err != nil || !isRoot {
+			return err
+		}
+		return os.Lchown(dest, c.UID, c.GID)
+	}
+
+	if err := helpers.CopyFile(dest, src); err != nil {
+		return err
+	}
+	if isRoot {
+		if err := os.Chown(dest, c.UID, c.GID); err != nil {
+			return err
+		}
+	}
+	// Chmod after Chown, since changing the owner clears setuid and setgid.
+	return os.Chmod(dest, mode)
+}
@@ -0,0 +1,173 @@
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestParseContentDirective(t *testing.T) {
+	testCases := []struct {
+		args       string
+		exp        bundleContent
+		shouldFail bool
+	}{
+		{args: "content", exp: bundleContent{Path: "content"}},
+		{args: " /abs/content ", exp: bundleContent{Path: "/abs/content"}},
+		{args: "content, 1000:100", exp: bundleContent{Path: "content", UID: 1000, GID: 100}},
+		{args: "", shouldFail: true},
+		{args: "content, 1000", shouldFail: true},
+		{args: "content, a:b", shouldFail: true},
+		{args: "content, -1:0", shouldFail: true},
+		{args: "content, 0:0, extra", shouldFail: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.args, func(t *testing.T) {
+			c, err := parseContentDirective(tc.args)
+			if tc.shouldFail {
+				if err == nil {
+					t.Errorf("unexpected success parsing %q", tc.args)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error parsing %q: %s", tc.args, err)
+			}
+			if !reflect.DeepEqual(*c, tc.exp) {
+				t.Errorf("expected %+v on input %q but got %+v", tc.exp, tc.args, *c)
+			}
+		})
+	}
+}
+
+func TestAddContentFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bundle-content-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	mustWrite := func(name string) {
+		name = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte("content"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustWrite("a/etc/a.conf")
+	mustWrite("a/usr/share/brand/logo.png")
+	mustWrite("b/etc/b.conf")
+
+	newSet := func() bundleSet {
+		return bundleSet{
+			"a": &bundle{
+				Name:    "a",
+				Content: []*bundleContent{{Path: filepath.Join(dir, "a")}},
+				Files:   map[string]bool{"/usr": true, "/usr/bin": true, "/usr/bin/a": true},
+			},
+			"b": &bundle{
+				Name:    "b",
+				Content: []*bundleContent{{Path: filepath.Join(dir, "b")}},
+				Files:   map[string]bool{"/etc": true},
+			},
+		}
+	}
+
+	set := newSet()
+	if err = addContentFiles(set); err != nil {
+		t.Fatalf("unexpected error adding content files: %s", err)
+	}
+	expected := map[string]bool{
+		"/etc":                      true,
+		"/etc/a.conf":               true,
+		"/usr":                      true,
+		"/usr/share":                true,
+		"/usr/share/brand":          true,
+		"/usr/share/brand/logo.png": true,
+	}
+	if !reflect.DeepEqual(set["a"].ContentFiles, expected) {
+		t.Errorf("got wrong content files\nFILES: %v\nEXPECTED: %v", set["a"].ContentFiles, expected)
+	}
+	if !set["a"].Files["/usr/bin/a"] || !set["a"].Files["/etc/a.conf"] {
+		t.Errorf("package and content files not merged in bundle file list")
+	}
+
+	// Conflict with a file from packages in another bundle.
+	set = newSet()
+	set["b"].Files["/etc/a.conf"] = true
+	err = addContentFiles(set)
+	if err == nil || !strings.Contains(err.Error(), "conflicts with a file provided by packages") {
+		t.Errorf("expected conflict with package file, got %v", err)
+	}
+
+	// Conflict between content directories.
+	mustWrite("b/etc/a.conf")
+	set = newSet()
+	err = addContentFiles(set)
+	if err == nil || !strings.Contains(err.Error(), "also provided by the content") {
+		t.Errorf("expected conflict between content directories, got %v", err)
+	}
+}
+
+func TestOverlayContentNotRoot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bundle-content-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	src, dest := filepath.Join(dir, "content"), filepath.Join(dir, "full")
+	if err = os.MkdirAll(filepath.Join(src, "usr", "bin"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Mkdir(dest, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(src, "usr", "bin", "tool"), []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chmod(filepath.Join(src, "usr", "bin", "tool"), 0755|os.ModeSetuid); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Symlink("tool", filepath.Join(src, "usr", "bin", "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Owners that can't be set by a regular user are skipped, while the
+	// modes are kept.
+	c := &bundleContent{Path: src, UID: 4321, GID: 4321}
+	err = walkContent(c, func(name, src string, fi os.FileInfo) error {
+		return overlayContentFile(c, filepath.Join(dest, name), src, fi, false)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, mode := range map[string]os.FileMode{
+		"/usr":          os.ModeDir | 0700,
+		"/usr/bin/tool": 0755 | os.ModeSetuid,
+		"/usr/bin/link": os.ModeSymlink | 0777,
+	} {
+		fi, err := os.Lstat(filepath.Join(dest, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode() != mode {
+			t.Errorf("got mode %s for %s, want %s", fi.Mode(), name, mode)
+		}
+		if uid := fi.Sys().(*syscall.Stat_t).Uid; int(uid) != os.Geteuid() {
+			t.Errorf("got owner %d for %s, want %d", uid, name, os.Geteuid())
+		}
+	}
+}
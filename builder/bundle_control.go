@@ -40,8 +40,12 @@ func getUpstreamBundlesVerDir(ver string) string {
 }
 
 func (b *Builder) getUpstreamBundlesPath() string {
+	return b.getUpstreamBundlesPathForVersion(b.UpstreamVer)
+}
+
+func (b *Builder) getUpstreamBundlesPathForVersion(ver string) string {
 	return filepath.Join(b.Config.Builder.VersionPath, upstreamBundlesBaseDir,
-		fmt.Sprintf(upstreamBundlesVerDirFmt, b.UpstreamVer), upstreamBundlesBundleDir)
+		fmt.Sprintf(upstreamBundlesVerDirFmt, ver), upstreamBundlesBundleDir)
 }
 
 func (b *Builder) getLocalPackagesPath() string {
@@ -53,13 +57,19 @@ func (b *Builder) getUpstreamPackagesPath() string {
 }
 
 func (b *Builder) getUpstreamBundles() error {
+	return b.getUpstreamBundlesForVersion(b.UpstreamVer)
+}
+
+// getUpstreamBundlesForVersion downloads and unpacks the upstream bundles of
+// version ver, unless they are already in the upstream-bundles dir.
+func (b *Builder) getUpstreamBundlesForVersion(ver string) error {
 	if Offline {
 		return nil
 	}
 
-	bundleDir := b.getUpstreamBundlesPath()
+	bundleDir := b.getUpstreamBundlesPathForVersion(ver)
 
-	// Return if upstream bundle dir for current version already exists
+	// Return if upstream bundle dir for the version already exists
 	if _, err := os.Stat(bundleDir); err == nil {
 		return nil
 	}
@@ -70,18 +80,18 @@ func (b *Builder) getUpstreamBundles() error {
 	}
 
 	// Download the upstream bundles
-	tmpTarFile := filepath.Join(upstreamBundlesBaseDir, b.UpstreamVer+".tar.gz")
-	URL := b.Config.Swupd.UpstreamBundlesURL + b.UpstreamVer + ".tar.gz"
+	tmpTarFile := filepath.Join(upstreamBundlesBaseDir, ver+".tar.gz")
+	URL := b.Config.Swupd.UpstreamBundlesURL + ver + ".tar.gz"
 	fmt.Printf("Fetching upstream bundles from %s\n", URL)
 	if err := helpers.DownloadFile(URL, tmpTarFile); err != nil {
-		return errors.Wrapf(err, "Failed to download bundles for upstream version %s", b.UpstreamVer)
+		return errors.Wrapf(err, "Failed to download bundles for upstream version %s", ver)
 	}
 
 	if err := helpers.UnpackFile(tmpTarFile, upstreamBundlesBaseDir); err != nil {
-		err = errors.Wrapf(err, "Error unpacking bundles for upstream version %s\n%s left for debuging", b.UpstreamVer, tmpTarFile)
+		err = errors.Wrapf(err, "Error unpacking bundles for upstream version %s\n%s left for debuging", ver, tmpTarFile)
 
 		// Clean up upstream bundle dir, since unpack failed
-		path := filepath.Join(upstreamBundlesBaseDir, getUpstreamBundlesVerDir(b.UpstreamVer))
+		path := filepath.Join(upstreamBundlesBaseDir, getUpstreamBundlesVerDir(ver))
 		if cErr := os.RemoveAll(path); cErr != nil {
 			err = errors.Wrapf(err, "Error cleaning up upstream bundle dir: %s", path)
 		}
@@ -539,22 +549,23 @@ const (
 	treeEnd = "└── "
 )
 
-func (b *Builder) buildTreePrintValue(bundle *bundle, level int, levelEnded []bool) string {
-	// Set up the value for this bundle
-	value := bundle.Name
+// bundleSource describes where the definition of a bundle comes from.
+func (b *Builder) bundleSource(bundle *bundle) string {
 	if b.isLocalBundle(bundle.Filename) {
 		if b.isLocalPackagePath(bundle.Filename) {
-			value += " (local package)"
-		} else {
-			value += " (local bundle)"
-		}
-	} else {
-		if isUpstreamPackagePath(bundle.Filename) {
-			value += " (upstream package)"
-		} else {
-			value += " (upstream bundle)"
+			return "local package"
 		}
+		return "local bundle"
+	}
+	if isUpstreamPackagePath(bundle.Filename) {
+		return "upstream package"
 	}
+	return "upstream bundle"
+}
+
+func (b *Builder) buildTreePrintValue(bundle *bundle, level int, levelEnded []bool) string {
+	// Set up the value for this bundle
+	value := bundle.Name + " (" + b.bundleSource(bundle) + ")"
 
 	if level == 0 {
 		return value
@@ -750,6 +761,9 @@ const bundleTemplateFormat = `# [TITLE]: %s
 # 
 # List bundles one per line. Includes have format: include(bundle)
 # also-adds have format: also-add(bundle)
+# Files can be dropped from the bundle with: exclude(/path/or/glob)
+# Non-package files can be added from a directory with: content(dir[, uid:gid])
+# Packages can be pinned with: package=[epoch:]version-release[.arch]
 `
 
 func createBundleFile(bundle string, path string) error {
@@ -788,6 +802,12 @@ func (b *Builder) CreateBundles(bundles []string, add bool, git bool) error {
 				if err = helpers.CopyFile(localPath, path); err != nil {
 					return err
 				}
+				// Keep track of the upstream version for 'bundle rebase'
+				if !isUpstreamPackagePath(path) {
+					if err = b.recordUpstreamBase(bundle, b.UpstreamVer); err != nil {
+						return err
+					}
+				}
 			}
 		}
 		fmt.Printf("Created bundle %q in %q\n", bundle, b.Config.Mixer.LocalBundleDir)
@@ -0,0 +1,289 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/clearlinux/mixer-tools/helpers"
+	"github.com/pkg/errors"
+)
+
+// bundleDefinitionDiff has the differences between two definitions of the
+// same bundle.
+type bundleDefinitionDiff struct {
+	Name string
+
+	HeaderChanges []bundleHeaderChange
+
+	AddedPackages   []string
+	RemovedPackages []string
+	ChangedPins     []string
+
+	AddedIncludes   []string
+	RemovedIncludes []string
+	AddedOptional   []string
+	RemovedOptional []string
+
+	AddedExcludes   []string
+	RemovedExcludes []string
+}
+
+type bundleHeaderChange struct {
+	Field string
+	Old   string
+	New   string
+}
+
+func (d *bundleDefinitionDiff) empty() bool {
+	return len(d.HeaderChanges) == 0 &&
+		len(d.AddedPackages) == 0 && len(d.RemovedPackages) == 0 && len(d.ChangedPins) == 0 &&
+		len(d.AddedIncludes) == 0 && len(d.RemovedIncludes) == 0 &&
+		len(d.AddedOptional) == 0 && len(d.RemovedOptional) == 0 &&
+		len(d.AddedExcludes) == 0 && len(d.RemovedExcludes) == 0
+}
+
+// diffStrings returns the sorted elements only in a and only in b.
+func diffStrings(a, b []string) (onlyA, onlyB []string) {
+	inA := make(map[string]bool, len(a))
+	for _, s := range a {
+		inA[s] = true
+	}
+	inB := make(map[string]bool, len(b))
+	for _, s := range b {
+		inB[s] = true
+	}
+	for s := range inA {
+		if !inB[s] {
+			onlyA = append(onlyA, s)
+		}
+	}
+	for s := range inB {
+		if !inA[s] {
+			onlyB = append(onlyB, s)
+		}
+	}
+	sort.Strings(onlyA)
+	sort.Strings(onlyB)
+	return onlyA, onlyB
+}
+
+func setKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// diffBundleDefinitions compares the definitions of a bundle, from old to cur.
+func diffBundleDefinitions(old, cur *bundle) *bundleDefinitionDiff {
+	d := &bundleDefinitionDiff{Name: cur.Name}
+
+	headers := []struct {
+		field    string
+		old, new string
+	}{
+		{"TITLE", old.Header.Title, cur.Header.Title},
+		{"DESCRIPTION", old.Header.Description, cur.Header.Description},
+		{"STATUS", old.Header.Status, cur.Header.Status},
+		{"CAPABILITIES", old.Header.Capabilities, cur.Header.Capabilities},
+		{"MAINTAINER", old.Header.Maintainer, cur.Header.Maintainer},
+	}
+	for _, h := range headers {
+		if h.old != h.new {
+			d.HeaderChanges = append(d.HeaderChanges, bundleHeaderChange{h.field, h.old, h.new})
+		}
+	}
+
+	d.RemovedPackages, d.AddedPackages = diffStrings(setKeys(old.DirectPackages), setKeys(cur.DirectPackages))
+	d.RemovedIncludes, d.AddedIncludes = diffStrings(old.DirectIncludes, cur.DirectIncludes)
+	d.RemovedOptional, d.AddedOptional = diffStrings(old.OptionalIncludes, cur.OptionalIncludes)
+	d.RemovedExcludes, d.AddedExcludes = diffStrings(old.Excludes, cur.Excludes)
+
+	// Pins of packages in both definitions.
+	pkgs := setKeys(cur.DirectPackages)
+	sort.Strings(pkgs)
+	for _, pkg := range pkgs {
+		if !old.DirectPackages[pkg] {
+			continue
+		}
+		oldPin, curPin := old.PackagePins[pkg], cur.PackagePins[pkg]
+		switch {
+		case oldPin == nil && curPin == nil:
+		case oldPin == nil:
+			d.ChangedPins = append(d.ChangedPins, fmt.Sprintf("%s: pinned to %s", pkg, curPin))
+		case curPin == nil:
+			d.ChangedPins = append(d.ChangedPins, fmt.Sprintf("%s: unpinned from %s", pkg, oldPin))
+		case *oldPin != *curPin:
+			d.ChangedPins = append(d.ChangedPins, fmt.Sprintf("%s: pin %s -> %s", pkg, oldPin, curPin))
+		}
+	}
+
+	return d
+}
+
+func (d *bundleDefinitionDiff) print(w io.Writer, oldLabel, newLabel string) error {
+	var lines []string
+	add := func(format string, a ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, a...))
+	}
+	add("bundle %s (%s -> %s)", d.Name, oldLabel, newLabel)
+	for _, h := range d.HeaderChanges {
+		add("  %s: %q -> %q", h.Field, h.Old, h.New)
+	}
+	for _, s := range d.RemovedIncludes {
+		add("  - include(%s)", s)
+	}
+	for _, s := range d.AddedIncludes {
+		add("  + include(%s)", s)
+	}
+	for _, s := range d.RemovedOptional {
+		add("  - also-add(%s)", s)
+	}
+	for _, s := range d.AddedOptional {
+		add("  + also-add(%s)", s)
+	}
+	for _, s := range d.RemovedExcludes {
+		add("  - exclude(%s)", s)
+	}
+	for _, s := range d.AddedExcludes {
+		add("  + exclude(%s)", s)
+	}
+	for _, s := range d.RemovedPackages {
+		add("  - %s", s)
+	}
+	for _, s := range d.AddedPackages {
+		add("  + %s", s)
+	}
+	for _, s := range d.ChangedPins {
+		add("  ~ %s", s)
+	}
+	for _, l := range lines {
+		if _, err := fmt.Fprintln(w, l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DiffBundles prints the differences in the definitions of bundles. When
+// fromUpstream is empty, the bundles in local-bundles are compared against the
+// upstream definitions they override. Otherwise, the upstream definitions in
+// version fromUpstream are compared against the ones in toUpstream. toUpstream
+// defaults to the upstream version of the mix. When bundles is empty, all
+// bundles are compared.
+func (b *Builder) DiffBundles(bundles []string, fromUpstream, toUpstream string) error {
+	if toUpstream == "" {
+		toUpstream = b.UpstreamVer
+	}
+	if err := b.getUpstreamBundlesForVersion(toUpstream); err != nil {
+		return err
+	}
+	toDir := b.getUpstreamBundlesPathForVersion(toUpstream)
+	toLabel := "upstream " + toUpstream
+
+	if fromUpstream == "" {
+		return diffBundleDirs(os.Stdout, bundles, toDir, b.Config.Mixer.LocalBundleDir, toLabel, "local", true)
+	}
+
+	if err := b.getUpstreamBundlesForVersion(fromUpstream); err != nil {
+		return err
+	}
+	fromDir := b.getUpstreamBundlesPathForVersion(fromUpstream)
+	return diffBundleDirs(os.Stdout, bundles, fromDir, toDir, "upstream "+fromUpstream, toLabel, false)
+}
+
+// diffBundleDirs compares the bundle definition files in oldDir and newDir.
+// When bundles is empty, the bundles in both directories are compared, unless
+// onlyInBoth is set; then only the bundles in newDir that are also in oldDir
+// are compared.
+func diffBundleDirs(w io.Writer, bundles []string, oldDir, newDir, oldLabel, newLabel string, onlyInBoth bool) error {
+	explicit := len(bundles) > 0
+	if !explicit {
+		newFiles, err := helpers.ListVisibleFiles(newDir)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to read bundles dir: %s", newDir)
+		}
+		bundles = newFiles
+		if !onlyInBoth {
+			oldFiles, err := helpers.ListVisibleFiles(oldDir)
+			if err != nil {
+				return errors.Wrapf(err, "Failed to read bundles dir: %s", oldDir)
+			}
+			removed, _ := diffStrings(oldFiles, newFiles)
+			bundles = append(bundles, removed...)
+		}
+		sort.Strings(bundles)
+	}
+
+	changed := false
+	for _, name := range bundles {
+		oldBundle, err := parseBundleIfExists(filepath.Join(oldDir, name))
+		if err != nil {
+			return err
+		}
+		newBundle, err := parseBundleIfExists(filepath.Join(newDir, name))
+		if err != nil {
+			return err
+		}
+
+		var line string
+		switch {
+		case oldBundle == nil && newBundle == nil:
+			return errors.Errorf("bundle %s is not in %s nor %s", name, oldLabel, newLabel)
+		case oldBundle == nil:
+			if onlyInBoth && !explicit {
+				continue
+			}
+			line = fmt.Sprintf("bundle %s is only in %s", name, newLabel)
+		case newBundle == nil:
+			line = fmt.Sprintf("bundle %s is only in %s", name, oldLabel)
+		default:
+			d := diffBundleDefinitions(oldBundle, newBundle)
+			if d.empty() {
+				continue
+			}
+			changed = true
+			if err = d.print(w, oldLabel, newLabel); err != nil {
+				return err
+			}
+			continue
+		}
+		changed = true
+		if _, err = fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+
+	if !changed {
+		_, err := fmt.Fprintln(w, "No differences found")
+		return err
+	}
+	return nil
+}
+
+// parseBundleIfExists parses a bundle definition file, returning nil if the
+// file doesn't exist.
+func parseBundleIfExists(path string) (*bundle, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+	return parseBundleFile(path)
+}
@@ -0,0 +1,113 @@
+package builder
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffBundleDefinitions(t *testing.T) {
+	old := &bundle{
+		Name:             "editors",
+		DirectIncludes:   []string{"os-core"},
+		OptionalIncludes: []string{"vim"},
+		DirectPackages:   map[string]bool{"nano": true, "joe": true, "emacs": true},
+		PackagePins:      map[string]*packagePin{"nano": {Version: "2.9-1"}},
+	}
+	old.Header.Title = "editors"
+	old.Header.Status = "Active"
+
+	cur := &bundle{
+		Name:           "editors",
+		DirectIncludes: []string{"os-core", "c-basic"},
+		Excludes:       []string{"/usr/share/doc"},
+		DirectPackages: map[string]bool{"nano": true, "emacs": true, "micro": true},
+		PackagePins:    map[string]*packagePin{"nano": {Version: "3.0-1"}, "emacs": {Version: "26-1"}},
+	}
+	cur.Header.Title = "editors"
+	cur.Header.Status = "Deprecated"
+
+	d := diffBundleDefinitions(old, cur)
+	expected := &bundleDefinitionDiff{
+		Name:            "editors",
+		HeaderChanges:   []bundleHeaderChange{{"STATUS", "Active", "Deprecated"}},
+		AddedPackages:   []string{"micro"},
+		RemovedPackages: []string{"joe"},
+		ChangedPins:     []string{"emacs: pinned to 26-1", "nano: pin 2.9-1 -> 3.0-1"},
+		AddedIncludes:   []string{"c-basic"},
+		RemovedOptional: []string{"vim"},
+		AddedExcludes:   []string{"/usr/share/doc"},
+	}
+	if !reflect.DeepEqual(d, expected) {
+		t.Errorf("got diff\n%+v\nexpected\n%+v", d, expected)
+	}
+
+	if !diffBundleDefinitions(old, old).empty() {
+		t.Errorf("expected empty diff comparing a bundle with itself")
+	}
+}
+
+func TestDiffBundleDirs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bundle-diff-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	mustWrite := func(name, content string) {
+		name = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustWrite("upstream/same", "pkg-a\n")
+	mustWrite("upstream/changed", "# [TITLE]: changed\npkg-a\npkg-b\n")
+	mustWrite("upstream/removed", "pkg-a\n")
+	mustWrite("local/same", "pkg-a\n")
+	mustWrite("local/changed", "# [TITLE]: changed\npkg-a\npkg-c\n")
+	mustWrite("local/custom", "pkg-a\n")
+
+	upstream := filepath.Join(dir, "upstream")
+	local := filepath.Join(dir, "local")
+
+	// Local bundles not overriding an upstream bundle are skipped.
+	var buf bytes.Buffer
+	if err = diffBundleDirs(&buf, nil, upstream, local, "upstream", "local", true); err != nil {
+		t.Fatal(err)
+	}
+	expected := "bundle changed (upstream -> local)\n  - pkg-b\n  + pkg-c\n"
+	if buf.String() != expected {
+		t.Errorf("got output\n%s\nexpected\n%s", buf.String(), expected)
+	}
+
+	buf.Reset()
+	if err = diffBundleDirs(&buf, nil, upstream, local, "old", "new", false); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"bundle custom is only in new", "bundle removed is only in old", "bundle changed (old -> new)"} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("output doesn't contain %q:\n%s", s, buf.String())
+		}
+	}
+
+	buf.Reset()
+	if err = diffBundleDirs(&buf, []string{"same"}, upstream, local, "upstream", "local", true); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "No differences found\n" {
+		t.Errorf("got unexpected output for unchanged bundle:\n%s", buf.String())
+	}
+
+	if err = diffBundleDirs(&buf, []string{"missing"}, upstream, local, "upstream", "local", true); err == nil {
+		t.Errorf("unexpected success comparing missing bundle")
+	}
+}
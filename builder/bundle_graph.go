@@ -0,0 +1,189 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type graphFormat int
+
+// Enum of available graph formats
+const (
+	DOTGraph  graphFormat = iota // Graphviz DOT language
+	JSONGraph                    // JSON object with nodes and edges
+)
+
+// Types of edges in a bundle graph
+const (
+	includeEdge = "include"
+	alsoAddEdge = "also-add"
+)
+
+// bundleGraph is the graph of a bundle set, with a node per bundle and an
+// edge per include.
+type bundleGraph struct {
+	Nodes []*bundleGraphNode
+	Edges []*bundleGraphEdge
+}
+
+type bundleGraphNode struct {
+	Name       string
+	Title      string
+	Status     string
+	Maintainer string
+	Source     string
+
+	// InMix is set for bundles listed in the Mix Bundle List.
+	InMix bool
+
+	DirectPackages int
+	AllPackages    int
+}
+
+type bundleGraphEdge struct {
+	From string
+	To   string
+	Type string
+}
+
+// newBundleGraph creates the graph for set, which must have been filled with
+// validateAndFillBundleSet. Nodes and edges are sorted by name.
+func newBundleGraph(set, mixBundles bundleSet) *bundleGraph {
+	g := &bundleGraph{
+		Nodes: []*bundleGraphNode{},
+		Edges: []*bundleGraphEdge{},
+	}
+	for _, name := range getBundleSetKeysSorted(set) {
+		bundle := set[name]
+		_, inMix := mixBundles[name]
+		g.Nodes = append(g.Nodes, &bundleGraphNode{
+			Name:           name,
+			Title:          bundle.Header.Title,
+			Status:         bundle.Header.Status,
+			Maintainer:     bundle.Header.Maintainer,
+			InMix:          inMix,
+			DirectPackages: len(bundle.DirectPackages),
+			AllPackages:    len(bundle.AllPackages),
+		})
+		for _, inc := range bundle.DirectIncludes {
+			g.Edges = append(g.Edges, &bundleGraphEdge{From: name, To: inc, Type: includeEdge})
+		}
+		for _, inc := range bundle.OptionalIncludes {
+			g.Edges = append(g.Edges, &bundleGraphEdge{From: name, To: inc, Type: alsoAddEdge})
+		}
+	}
+	return g
+}
+
+func (g *bundleGraph) writeJSON(w io.Writer) error {
+	content, err := json.MarshalIndent(g, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(content, '\n'))
+	return err
+}
+
+// writeDOT writes the graph in the DOT language. Bundles in the Mix Bundle
+// List are drawn in bold and also-add edges are dashed.
+func (g *bundleGraph) writeDOT(w io.Writer) error {
+	// Write errors are kept by the buffered writer and returned by Flush.
+	bw := bufio.NewWriter(w)
+	_, _ = fmt.Fprintln(bw, "digraph bundles {")
+	_, _ = fmt.Fprintln(bw, "\tnode [shape=box];")
+	for _, n := range g.Nodes {
+		label := dotQuote(n.Name)
+		if n.Title != "" && n.Title != n.Name {
+			label += `\n` + dotQuote(n.Title)
+		}
+		label += fmt.Sprintf(`\n%d/%d packages`, n.DirectPackages, n.AllPackages)
+
+		attrs := []string{
+			`label="` + label + `"`,
+			`title="` + dotQuote(n.Title) + `"`,
+			`status="` + dotQuote(n.Status) + `"`,
+			`maintainer="` + dotQuote(n.Maintainer) + `"`,
+			`source="` + dotQuote(n.Source) + `"`,
+			fmt.Sprintf("direct_packages=%d", n.DirectPackages),
+			fmt.Sprintf("all_packages=%d", n.AllPackages),
+		}
+		if n.InMix {
+			attrs = append(attrs, "style=bold")
+		}
+		_, _ = fmt.Fprintf(bw, "\t\"%s\" [%s];\n", dotQuote(n.Name), strings.Join(attrs, ", "))
+	}
+	for _, e := range g.Edges {
+		attrs := ""
+		if e.Type == alsoAddEdge {
+			attrs = ` [style=dashed, label="also-add"]`
+		}
+		_, _ = fmt.Fprintf(bw, "\t\"%s\" -> \"%s\"%s;\n", dotQuote(e.From), dotQuote(e.To), attrs)
+	}
+	_, _ = fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+// dotQuote escapes s to be used inside a quoted DOT string.
+func dotQuote(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// BundleGraph writes the graph of the bundles in the mix, recursively
+// following includes and also-adds. When bundle is not empty, only that bundle
+// and the bundles it includes are in the graph.
+func (b *Builder) BundleGraph(w io.Writer, format graphFormat, bundle string) error {
+	if err := b.getUpstreamBundles(); err != nil {
+		return err
+	}
+
+	mixBundles, err := b.getMixBundlesListAsSet()
+	if err != nil {
+		return err
+	}
+
+	top := mixBundles
+	if bundle != "" {
+		bundleObj, err := b.getBundleFromName(bundle)
+		if err != nil {
+			return err
+		}
+		top = bundleSet{bundle: bundleObj}
+	}
+
+	set, err := b.getFullBundleSet(top)
+	if err != nil {
+		return err
+	}
+	if err = validateAndFillBundleSet(set); err != nil {
+		return err
+	}
+
+	g := newBundleGraph(set, mixBundles)
+	for _, n := range g.Nodes {
+		n.Source = b.bundleSource(set[n.Name])
+	}
+
+	switch format {
+	case JSONGraph:
+		return g.writeJSON(w)
+	default:
+		return g.writeDOT(w)
+	}
+}
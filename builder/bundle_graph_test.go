@@ -0,0 +1,84 @@
+package builder
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/clearlinux/mixer-tools/swupd"
+)
+
+func TestBundleGraph(t *testing.T) {
+	set := bundleSet{
+		"os-core": &bundle{
+			Name:           "os-core",
+			Header:         swupd.BundleHeader{Title: "os-core", Status: "Active", Maintainer: "someone"},
+			DirectPackages: map[string]bool{"a": true, "b": true},
+		},
+		"editors": &bundle{
+			Name:             "editors",
+			Header:           swupd.BundleHeader{Title: "Text \"editors\""},
+			DirectIncludes:   []string{"os-core"},
+			OptionalIncludes: []string{"vim"},
+			DirectPackages:   map[string]bool{"c": true},
+		},
+		"vim": &bundle{
+			Name:           "vim",
+			DirectPackages: map[string]bool{"vim": true},
+		},
+	}
+	if err := validateAndFillBundleSet(set); err != nil {
+		t.Fatalf("unexpected error filling bundle set: %s", err)
+	}
+	g := newBundleGraph(set, bundleSet{"editors": set["editors"]})
+
+	if len(g.Nodes) != 3 || g.Nodes[0].Name != "editors" || !g.Nodes[0].InMix || g.Nodes[1].InMix {
+		t.Fatalf("got wrong nodes %+v", g.Nodes)
+	}
+	if g.Nodes[0].DirectPackages != 1 || g.Nodes[0].AllPackages != 3 {
+		t.Errorf("got wrong package counts %d/%d for editors", g.Nodes[0].DirectPackages, g.Nodes[0].AllPackages)
+	}
+	if g.Nodes[1].Status != "Active" || g.Nodes[1].Maintainer != "someone" {
+		t.Errorf("got wrong header fields for os-core: %+v", g.Nodes[1])
+	}
+	expectedEdges := []bundleGraphEdge{
+		{From: "editors", To: "os-core", Type: includeEdge},
+		{From: "editors", To: "vim", Type: alsoAddEdge},
+	}
+	if len(g.Edges) != len(expectedEdges) {
+		t.Fatalf("got %d edges, expected %d", len(g.Edges), len(expectedEdges))
+	}
+	for i, e := range expectedEdges {
+		if *g.Edges[i] != e {
+			t.Errorf("got edge %+v, expected %+v", *g.Edges[i], e)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := g.writeDOT(&buf); err != nil {
+		t.Fatalf("unexpected error writing DOT: %s", err)
+	}
+	dot := buf.String()
+	for _, s := range []string{
+		`"editors" [label="editors\nText \"editors\"\n1/3 packages"`,
+		`"editors" -> "os-core";`,
+		`"editors" -> "vim" [style=dashed, label="also-add"];`,
+	} {
+		if !strings.Contains(dot, s) {
+			t.Errorf("DOT output doesn't contain %q:\n%s", s, dot)
+		}
+	}
+
+	buf.Reset()
+	if err := g.writeJSON(&buf); err != nil {
+		t.Fatalf("unexpected error writing JSON: %s", err)
+	}
+	var decoded bundleGraph
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("couldn't parse JSON output: %s", err)
+	}
+	if len(decoded.Nodes) != 3 || len(decoded.Edges) != 2 || decoded.Edges[1].Type != alsoAddEdge {
+		t.Errorf("got wrong JSON graph:\n%s", buf.String())
+	}
+}
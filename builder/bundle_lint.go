@@ -0,0 +1,237 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+)
+
+// LintSeverity represents the severity of a problem found by the linter
+type LintSeverity int
+
+// Enum of available lint severities
+const (
+	LintInfo LintSeverity = iota
+	LintWarning
+	LintError
+)
+
+func (s LintSeverity) String() string {
+	switch s {
+	case LintInfo:
+		return "info"
+	case LintWarning:
+		return "warning"
+	default:
+		return "error"
+	}
+}
+
+// ParseLintSeverity returns the severity with the given name.
+func ParseLintSeverity(s string) (LintSeverity, error) {
+	for _, sev := range []LintSeverity{LintInfo, LintWarning, LintError} {
+		if s == sev.String() {
+			return sev, nil
+		}
+	}
+	return LintError, errors.Errorf("invalid severity %q, must be info, warning or error", s)
+}
+
+type lintProblem struct {
+	severity LintSeverity
+	bundle   string
+	message  string
+}
+
+// lintBundleSet looks for problems in set, which must have been filled with
+// validateAndFillBundleSet. Problems in the definition of a bundle are only
+// reported for the bundles in local, since upstream bundles are trusted the
+// same way as in validation. The problems are sorted by bundle.
+func (b *Builder) lintBundleSet(set, mixBundles, local bundleSet) []*lintProblem {
+	var problems []*lintProblem
+	report := func(sev LintSeverity, bundle, format string, a ...interface{}) {
+		problems = append(problems, &lintProblem{sev, bundle, fmt.Sprintf(format, a...)})
+	}
+
+	// Bundles of the mix, including the ones added by includes and also-adds.
+	inMix := make(map[string]bool)
+	var addToMix func(name string)
+	addToMix = func(name string) {
+		if inMix[name] {
+			return
+		}
+		inMix[name] = true
+		for _, inc := range set[name].DirectIncludes {
+			addToMix(inc)
+		}
+		for _, inc := range set[name].OptionalIncludes {
+			addToMix(inc)
+		}
+	}
+	for name := range mixBundles {
+		addToMix(name)
+	}
+
+	referenced := make(map[string]bool)
+	for name := range mixBundles {
+		referenced[name] = true
+	}
+	for _, bundle := range set {
+		for _, inc := range bundle.DirectIncludes {
+			referenced[inc] = true
+		}
+		for _, inc := range bundle.OptionalIncludes {
+			referenced[inc] = true
+		}
+	}
+
+	for _, name := range getBundleSetKeysSorted(set) {
+		bundle := set[name]
+		_, isLocal := local[name]
+
+		if isLocal && !referenced[name] {
+			report(LintInfo, name, "bundle is not in the mix and is not included by any bundle")
+		}
+
+		if inMix[name] {
+			for _, inc := range bundle.OptionalIncludes {
+				if _, ok := mixBundles[inc]; !ok {
+					report(LintWarning, name, "also-add target %s is not in the Mix Bundle List", inc)
+				}
+			}
+		}
+
+		if isLocal || inMix[name] {
+			var incs []string
+			incs = append(incs, bundle.DirectIncludes...)
+			incs = append(incs, bundle.OptionalIncludes...)
+			for _, inc := range incs {
+				if isDeprecated(set[inc]) && !isDeprecated(bundle) {
+					report(LintError, name, "bundle includes deprecated bundle %s", inc)
+				}
+			}
+		}
+
+		if !isLocal {
+			continue
+		}
+
+		// Bundles listed in local-packages have no header.
+		if !b.isLocalPackagePath(bundle.Filename) {
+			if bundle.Header.Title == "" {
+				report(LintWarning, name, "bundle is missing the TITLE header")
+			}
+			if bundle.Header.Description == "" {
+				report(LintWarning, name, "bundle is missing the DESCRIPTION header")
+			}
+		}
+
+		if len(bundle.DirectPackages) == 0 && len(bundle.DirectIncludes) == 0 &&
+			len(bundle.OptionalIncludes) == 0 && len(bundle.Content) == 0 {
+			report(LintWarning, name, "bundle is empty")
+		}
+
+		var pkgs []string
+		for pkg := range bundle.DirectPackages {
+			pkgs = append(pkgs, pkg)
+		}
+		sort.Strings(pkgs)
+		for _, pkg := range pkgs {
+			for _, inc := range bundle.DirectIncludes {
+				if set[inc].AllPackages[pkg] {
+					report(LintWarning, name, "package %s is already included through bundle %s", pkg, inc)
+					break
+				}
+			}
+		}
+	}
+
+	return problems
+}
+
+// isDeprecated matches the STATUS header the same way as ModifyBundles.
+func isDeprecated(bundle *bundle) bool {
+	return strings.HasPrefix(bundle.Header.Status, "Deprecated")
+}
+
+func printLintProblems(w io.Writer, problems []*lintProblem) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+	for _, p := range problems {
+		if _, err := fmt.Fprintf(tw, "%s:\t%s:\t%s\n", strings.ToUpper(p.severity.String()), p.bundle, p.message); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+// LintBundles looks for problems across the bundles in the mix and the local
+// bundles, and prints a report. An error is returned if any problem has at
+// least the failOn severity.
+func (b *Builder) LintBundles(failOn LintSeverity) error {
+	if err := b.getUpstreamBundles(); err != nil {
+		return err
+	}
+
+	mixBundles, err := b.getMixBundlesListAsSet()
+	if err != nil {
+		return err
+	}
+	local, err := b.getDirBundlesListAsSet(b.Config.Mixer.LocalBundleDir)
+	if err != nil {
+		return err
+	}
+	err = populateSetFromPackages(&localPackages, local, b.getLocalPackagesPath())
+	if err != nil {
+		return err
+	}
+
+	top := make(bundleSet)
+	for name, bundle := range mixBundles {
+		top[name] = bundle
+	}
+	for name, bundle := range local {
+		top[name] = bundle
+	}
+	set, err := b.getFullBundleSet(top)
+	if err != nil {
+		return err
+	}
+	if err = validateAndFillBundleSet(set); err != nil {
+		return err
+	}
+
+	problems := b.lintBundleSet(set, mixBundles, local)
+	if err = printLintProblems(os.Stdout, problems); err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, p := range problems {
+		if p.severity >= failOn {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return errors.Errorf("found %d problem(s) with severity %s or higher", failed, failOn)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package builder
+
+import (
+	"testing"
+
+	"github.com/clearlinux/mixer-tools/swupd"
+)
+
+func TestLintBundleSet(t *testing.T) {
+	header := func(status string) swupd.BundleHeader {
+		return swupd.BundleHeader{Title: "t", Description: "d", Status: status}
+	}
+	set := bundleSet{
+		"os-core": &bundle{Name: "os-core", Header: header("Active"), DirectPackages: map[string]bool{"bash": true}},
+		"old":     &bundle{Name: "old", Header: header("Deprecated"), DirectPackages: map[string]bool{"old": true}},
+		"extra":   &bundle{Name: "extra", Header: header("Active"), DirectPackages: map[string]bool{"extra": true}},
+		"editors": &bundle{
+			Name:             "editors",
+			Header:           header("Active"),
+			DirectIncludes:   []string{"os-core", "old"},
+			OptionalIncludes: []string{"extra"},
+			DirectPackages:   map[string]bool{"bash": true, "vim": true},
+		},
+		"unused":  &bundle{Name: "unused", DirectPackages: map[string]bool{"p": true}},
+		"empty":   &bundle{Name: "empty", Header: header("Active")},
+		"retired": &bundle{Name: "retired", Header: header("Deprecated"), DirectIncludes: []string{"old"}},
+	}
+	if err := validateAndFillBundleSet(set); err != nil {
+		t.Fatalf("unexpected error filling bundle set: %s", err)
+	}
+	mixBundles := bundleSet{"editors": set["editors"], "empty": set["empty"], "retired": set["retired"]}
+	local := bundleSet{"editors": set["editors"], "unused": set["unused"], "empty": set["empty"], "retired": set["retired"]}
+
+	b := &Builder{LocalPackagesFile: "local-packages"}
+	problems := b.lintBundleSet(set, mixBundles, local)
+
+	expected := []lintProblem{
+		{LintWarning, "editors", "also-add target extra is not in the Mix Bundle List"},
+		{LintError, "editors", "bundle includes deprecated bundle old"},
+		{LintWarning, "editors", "package bash is already included through bundle os-core"},
+		{LintWarning, "empty", "bundle is empty"},
+		{LintInfo, "unused", "bundle is not in the mix and is not included by any bundle"},
+		{LintWarning, "unused", "bundle is missing the TITLE header"},
+		{LintWarning, "unused", "bundle is missing the DESCRIPTION header"},
+	}
+	if len(problems) != len(expected) {
+		for _, p := range problems {
+			t.Logf("%+v", *p)
+		}
+		t.Fatalf("got %d problems, expected %d", len(problems), len(expected))
+	}
+	for i, p := range expected {
+		if *problems[i] != p {
+			t.Errorf("got problem %+v, expected %+v", *problems[i], p)
+		}
+	}
+}
+
+func TestParseLintSeverity(t *testing.T) {
+	for _, sev := range []LintSeverity{LintInfo, LintWarning, LintError} {
+		parsed, err := ParseLintSeverity(sev.String())
+		if err != nil || parsed != sev {
+			t.Errorf("couldn't parse severity %s: got %v, %v", sev, parsed, err)
+		}
+	}
+	if _, err := ParseLintSeverity("fatal"); err == nil {
+		t.Errorf("unexpected success parsing invalid severity")
+	}
+}
@@ -0,0 +1,316 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/clearlinux/mixer-tools/helpers"
+	"github.com/pkg/errors"
+)
+
+// upstreamBasesFile records, for each local bundle copied from upstream, the
+// upstream version it was copied from. It is hidden so it is not taken as a
+// bundle.
+const upstreamBasesFile = ".upstream-bases"
+
+const (
+	conflictStart = "<<<<<<< local"
+	conflictSep   = "======="
+	conflictEnd   = ">>>>>>> "
+)
+
+func (b *Builder) getUpstreamBasesPath() string {
+	return filepath.Join(b.Config.Mixer.LocalBundleDir, upstreamBasesFile)
+}
+
+// readUpstreamBases reads the upstream base versions of the local bundles. An
+// empty map is returned if the file doesn't exist.
+func readUpstreamBases(filename string) (map[string]string, error) {
+	bases := make(map[string]string)
+	lines, err := helpers.ReadFileAndSplit(filename)
+	if os.IsNotExist(err) {
+		return bases, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "Failed to read upstream base versions")
+	}
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, errors.Errorf("Invalid line in %s: %q", filename, line)
+		}
+		bases[fields[0]] = fields[1]
+	}
+	return bases, nil
+}
+
+func writeUpstreamBases(filename string, bases map[string]string) error {
+	var names []string
+	for name := range bases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf strings.Builder
+	for _, name := range names {
+		buf.WriteString(name + " " + bases[name] + "\n")
+	}
+	return errors.Wrap(ioutil.WriteFile(filename, []byte(buf.String()), 0644), "Failed to write upstream base versions")
+}
+
+// recordUpstreamBase records that the local bundle was copied from upstream
+// version ver.
+func (b *Builder) recordUpstreamBase(bundle, ver string) error {
+	filename := b.getUpstreamBasesPath()
+	bases, err := readUpstreamBases(filename)
+	if err != nil {
+		return err
+	}
+	bases[bundle] = ver
+	return writeUpstreamBases(filename, bases)
+}
+
+// bundleLineKey identifies what a line of a bundle definition file defines, so
+// the same definition can be matched across versions of the file even if its
+// value changed. Header fields are keyed by name, packages by package name
+// (ignoring the pin) and content directories by path. Comments and empty lines
+// have an empty key. The value is the part of the line that is compared.
+func bundleLineKey(line string) (key, value string) {
+	if matches := bundleHeaderFieldRegex.FindStringSubmatch(line); len(matches) > 2 {
+		return "[" + matches[1] + "]", strings.TrimSpace(matches[2])
+	}
+
+	value = line
+	if i := strings.Index(value, "#"); i > -1 {
+		value = value[:i]
+	}
+	value = strings.TrimSpace(value)
+
+	switch {
+	case value == "":
+		return "", ""
+	case strings.HasPrefix(value, "content("):
+		args := strings.TrimSuffix(strings.TrimPrefix(value, "content("), ")")
+		return "content(" + strings.TrimSpace(strings.Split(args, ",")[0]) + ")", value
+	case strings.Contains(value, "("):
+		return value, value
+	case strings.Contains(value, "="):
+		return value[:strings.Index(value, "=")], value
+	}
+	return value, value
+}
+
+type keyedLines struct {
+	values map[string]string
+	lines  map[string]string
+	keys   []string
+}
+
+func indexBundleLines(lines []string) *keyedLines {
+	k := &keyedLines{
+		values: make(map[string]string),
+		lines:  make(map[string]string),
+	}
+	for _, line := range lines {
+		key, value := bundleLineKey(line)
+		if key == "" {
+			continue
+		}
+		if _, ok := k.values[key]; ok {
+			continue
+		}
+		k.values[key] = value
+		k.lines[key] = line
+		k.keys = append(k.keys, key)
+	}
+	return k
+}
+
+// mergeBundleLines runs a three-way merge of the lines of a bundle definition
+// file. Each definition changed only in upstream since base is taken from
+// upstream, otherwise the local one is kept. Definitions changed differently
+// in both are conflicts, marked in the result. Comments and the order of lines
+// are kept from local, and definitions added upstream go to the end.
+func mergeBundleLines(base, upstream, local []string, upstreamLabel string) ([]string, int) {
+	b := indexBundleLines(base)
+	u := indexBundleLines(upstream)
+	l := indexBundleLines(local)
+
+	var merged []string
+	conflicts := 0
+	done := make(map[string]bool)
+
+	merge := func(key string) {
+		done[key] = true
+		bv, inB := b.values[key]
+		uv, inU := u.values[key]
+		lv, inL := l.values[key]
+		same := func(v1 string, in1 bool, v2 string, in2 bool) bool {
+			return in1 == in2 && v1 == v2
+		}
+
+		switch {
+		case same(uv, inU, bv, inB), same(lv, inL, uv, inU):
+			if inL {
+				merged = append(merged, l.lines[key])
+			}
+		case same(lv, inL, bv, inB):
+			if inU {
+				merged = append(merged, u.lines[key])
+			}
+		default:
+			conflicts++
+			merged = append(merged, conflictStart)
+			if inL {
+				merged = append(merged, l.lines[key])
+			}
+			merged = append(merged, conflictSep)
+			if inU {
+				merged = append(merged, u.lines[key])
+			}
+			merged = append(merged, conflictEnd+upstreamLabel)
+		}
+	}
+
+	for _, line := range local {
+		key, _ := bundleLineKey(line)
+		if key == "" || done[key] {
+			merged = append(merged, line)
+			continue
+		}
+		merge(key)
+	}
+	for _, key := range u.keys {
+		if !done[key] {
+			merge(key)
+		}
+	}
+	return merged, conflicts
+}
+
+func readLines(filename string) ([]string, error) {
+	content, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if len(content) == 0 {
+		return nil, nil
+	}
+	return strings.Split(strings.TrimSuffix(string(content), "\n"), "\n"), nil
+}
+
+// RebaseBundles merges the changes made upstream into the local bundles that
+// override upstream bundles. Each local bundle is merged with the upstream
+// bundle in the upstream version of the mix, using as base the upstream bundle
+// in the version it was copied from, or in version base if not empty. When
+// bundles is empty, all local bundles are rebased.
+func (b *Builder) RebaseBundles(bundles []string, base string) error {
+	if err := b.getUpstreamBundles(); err != nil {
+		return err
+	}
+
+	basesFile := b.getUpstreamBasesPath()
+	bases, err := readUpstreamBases(basesFile)
+	if err != nil {
+		return err
+	}
+
+	explicit := len(bundles) > 0
+	if !explicit {
+		bundles, err = helpers.ListVisibleFiles(b.Config.Mixer.LocalBundleDir)
+		if err != nil {
+			return errors.Wrap(err, "Failed to read local-bundles")
+		}
+	}
+
+	var conflicted []string
+	for _, bundle := range bundles {
+		localPath := filepath.Join(b.Config.Mixer.LocalBundleDir, bundle)
+		if _, err = os.Stat(localPath); err != nil {
+			return errors.Errorf("Bundle %q not found in local-bundles", bundle)
+		}
+		upstreamPath := filepath.Join(b.getUpstreamBundlesPath(), bundle)
+		if _, err = os.Stat(upstreamPath); err != nil {
+			if explicit {
+				fmt.Printf("Bundle %q does not override an upstream bundle; skipping\n", bundle)
+			}
+			continue
+		}
+
+		baseVer := base
+		if baseVer == "" {
+			baseVer = bases[bundle]
+		}
+		if baseVer == "" {
+			fmt.Printf("Bundle %q has no recorded upstream base version; skipping (use --base)\n", bundle)
+			continue
+		}
+		if baseVer == b.UpstreamVer {
+			if explicit {
+				fmt.Printf("Bundle %q is already based on upstream version %s\n", bundle, baseVer)
+			}
+			continue
+		}
+
+		if err = b.getUpstreamBundlesForVersion(baseVer); err != nil {
+			return err
+		}
+		// A bundle added upstream after the base version is merged with an
+		// empty base.
+		baseLines, err := readLines(filepath.Join(b.getUpstreamBundlesPathForVersion(baseVer), bundle))
+		if err != nil {
+			return err
+		}
+		upstreamLines, err := readLines(upstreamPath)
+		if err != nil {
+			return err
+		}
+		localLines, err := readLines(localPath)
+		if err != nil {
+			return err
+		}
+
+		merged, conflicts := mergeBundleLines(baseLines, upstreamLines, localLines, "upstream "+b.UpstreamVer)
+		if err = ioutil.WriteFile(localPath, []byte(strings.Join(merged, "\n")+"\n"), 0644); err != nil {
+			return errors.Wrapf(err, "Failed to write bundle %q", bundle)
+		}
+		bases[bundle] = b.UpstreamVer
+
+		if conflicts > 0 {
+			conflicted = append(conflicted, bundle)
+			fmt.Printf("Rebased bundle %q from upstream version %s to %s with %d conflict(s)\n", bundle, baseVer, b.UpstreamVer, conflicts)
+		} else {
+			fmt.Printf("Rebased bundle %q from upstream version %s to %s\n", bundle, baseVer, b.UpstreamVer)
+		}
+	}
+
+	if err = writeUpstreamBases(basesFile, bases); err != nil {
+		return err
+	}
+
+	if len(conflicted) > 0 {
+		return errors.Errorf("Conflicts found in bundles %s; resolve the marked lines in local-bundles", strings.Join(conflicted, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBundleLineKey(t *testing.T) {
+	testCases := []struct {
+		line  string
+		key   string
+		value string
+	}{
+		{"# [TITLE]: editors", "[TITLE]", "editors"},
+		{"# some comment", "", ""},
+		{"", "", ""},
+		{"vim # the editor", "vim", "vim"},
+		{"nano=2.9-1", "nano", "nano=2.9-1"},
+		{"include(os-core)", "include(os-core)", "include(os-core)"},
+		{"content(files, 1000:1000)", "content(files)", "content(files, 1000:1000)"},
+	}
+	for _, tc := range testCases {
+		key, value := bundleLineKey(tc.line)
+		if key != tc.key || value != tc.value {
+			t.Errorf("got key %q and value %q for %q, expected %q and %q", key, value, tc.line, tc.key, tc.value)
+		}
+	}
+}
+
+func TestMergeBundleLines(t *testing.T) {
+	base := []string{
+		"# [TITLE]: editors",
+		"# [STATUS]: Active",
+		"include(os-core)",
+		"joe",
+		"nano",
+		"vim",
+	}
+	upstream := []string{
+		"# [TITLE]: editors",
+		"# [STATUS]: Deprecated",
+		"include(os-core)",
+		"nano=3.0-1",
+		"vim=8.1-2",
+		"micro",
+	}
+	local := []string{
+		"# [TITLE]: editors",
+		"# [STATUS]: Active",
+		"# Our editors",
+		"include(os-core)",
+		"joe",
+		"nano",
+		"vim=8.0-1",
+		"emacs",
+	}
+
+	merged, conflicts := mergeBundleLines(base, upstream, local, "upstream 20")
+	expected := []string{
+		"# [TITLE]: editors",
+		"# [STATUS]: Deprecated",
+		"# Our editors",
+		"include(os-core)",
+		"nano=3.0-1",
+		conflictStart,
+		"vim=8.0-1",
+		conflictSep,
+		"vim=8.1-2",
+		conflictEnd + "upstream 20",
+		"emacs",
+		"micro",
+	}
+	if conflicts != 1 {
+		t.Errorf("got %d conflicts, expected 1", conflicts)
+	}
+	if !reflect.DeepEqual(merged, expected) {
+		t.Errorf("got merged lines\n%s\nexpected\n%s", strings.Join(merged, "\n"), strings.Join(expected, "\n"))
+	}
+
+	// A local bundle without upstream changes is kept as is.
+	merged, conflicts = mergeBundleLines(base, base, local, "upstream 20")
+	if conflicts != 0 || !reflect.DeepEqual(merged, local) {
+		t.Errorf("got unexpected merge without upstream changes:\n%s", strings.Join(merged, "\n"))
+	}
+}
+
+func TestUpstreamBases(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bundle-rebase-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	filename := filepath.Join(dir, upstreamBasesFile)
+	bases, err := readUpstreamBases(filename)
+	if err != nil || len(bases) != 0 {
+		t.Fatalf("expected empty bases for missing file, got %v, %v", bases, err)
+	}
+
+	bases = map[string]string{"editors": "20", "vim": "10"}
+	if err = writeUpstreamBases(filename, bases); err != nil {
+		t.Fatal(err)
+	}
+	read, err := readUpstreamBases(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, bases) {
+		t.Errorf("got bases %v, expected %v", read, bases)
+	}
+}
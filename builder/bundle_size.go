@@ -0,0 +1,236 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+)
+
+// bundleSize is the estimated installed size of a bundle.
+type bundleSize struct {
+	name string
+
+	// packages and size are for all the packages resolved for the bundle.
+	packages int
+	size     int64
+
+	// ownPackages and ownSize exclude the packages of the included bundles,
+	// the same way their files are subtracted from the bundle manifest.
+	ownPackages int
+	ownSize     int64
+}
+
+// packageKey identifies a resolved package independently of its version, as
+// there is only one version of each package in a mix.
+func packageKey(pkg packageMetadata) string {
+	return pkg.name + "." + pkg.arch
+}
+
+// resolveBundleClosures resolves the packages of each bundle in set with a
+// noop install, the same way as resolvePackages, but without changing the
+// bundles.
+func resolveBundleClosures(numWorkers int, set bundleSet, packagerCmd []string, emptyDir string) map[string][]packageMetadata {
+	var mu sync.Mutex
+	closures := make(map[string][]packageMetadata, len(set))
+
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+	bundleCh := make(chan *bundle)
+	worker := func() {
+		defer wg.Done()
+		for bundle := range bundleCh {
+			var pkgs []string
+			for p := range bundle.AllPackages {
+				pkgs = append(pkgs, p)
+			}
+			resolved := parseNoopInstall(noopInstall(packagerCmd, emptyDir, pkgs).String())
+			mu.Lock()
+			closures[bundle.Name] = resolved
+			mu.Unlock()
+		}
+	}
+	for i := 0; i < numWorkers; i++ {
+		go worker()
+	}
+	for _, bundle := range set {
+		bundleCh <- bundle
+	}
+	close(bundleCh)
+	wg.Wait()
+
+	return closures
+}
+
+// queryInstalledSizes returns the installed size of the packages from the
+// repository metadata, by package key.
+func queryInstalledSizes(pkgs map[string]packageMetadata, md *repoMetadata) (map[string]int64, error) {
+	sizes := make(map[string]int64, len(pkgs))
+	for key, pkg := range pkgs {
+		p, err := md.lookup(pkg)
+		if err != nil {
+			return nil, err
+		}
+		sizes[key] = p.InstalledSize
+	}
+	return sizes, nil
+}
+
+// subtractedBundles returns the bundles whose files are subtracted from the
+// manifest of bundle: os-core, which every bundle includes, and the included
+// bundles, recursively. Also-adds are not subtracted.
+func subtractedBundles(set bundleSet, name string) []string {
+	var result []string
+	visited := map[string]bool{name: true}
+	var visit func(string)
+	visit = func(inc string) {
+		if visited[inc] {
+			return
+		}
+		visited[inc] = true
+		result = append(result, inc)
+		if b, ok := set[inc]; ok {
+			for _, i := range b.DirectIncludes {
+				visit(i)
+			}
+		}
+	}
+	if _, ok := set["os-core"]; ok {
+		visit("os-core")
+	}
+	for _, inc := range set[name].DirectIncludes {
+		visit(inc)
+	}
+	return result
+}
+
+// computeBundleSizes sums the sizes of the packages resolved for each bundle
+// in set, with and without the packages of the bundles subtracted from it. The
+// results are sorted by bundle name.
+func computeBundleSizes(set bundleSet, closures map[string][]packageMetadata, sizes map[string]int64) []*bundleSize {
+	var result []*bundleSize
+	for _, name := range getBundleSetKeysSorted(set) {
+		bs := &bundleSize{name: name}
+
+		subtracted := make(map[string]bool)
+		for _, inc := range subtractedBundles(set, name) {
+			for _, pkg := range closures[inc] {
+				subtracted[packageKey(pkg)] = true
+			}
+		}
+
+		for _, pkg := range closures[name] {
+			key := packageKey(pkg)
+			bs.packages++
+			bs.size += sizes[key]
+			if !subtracted[key] {
+				bs.ownPackages++
+				bs.ownSize += sizes[key]
+			}
+		}
+		result = append(result, bs)
+	}
+	return result
+}
+
+func printBundleSizes(w io.Writer, result []*bundleSize, mixPackages int, mixSize int64) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	_, _ = fmt.Fprintln(tw, "BUNDLE\tPACKAGES\tSIZE\tTOTAL PACKAGES\tTOTAL SIZE\t")
+	for _, bs := range result {
+		_, _ = fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t\n", bs.name, bs.ownPackages, bs.ownSize, bs.packages, bs.size)
+	}
+	_, _ = fmt.Fprintf(tw, "%s\t\t\t%d\t%d\t\n", "mix", mixPackages, mixSize)
+	return tw.Flush()
+}
+
+// BundleSizes estimates the installed size of each bundle in the mix before
+// building it. The packages of each bundle are resolved like in the build,
+// and their installed sizes are taken from the repository metadata. The size
+// of a bundle is reported both with the packages of the bundles it includes
+// subtracted, like in its manifest, and with all of its packages.
+func (b *Builder) BundleSizes() error {
+	if err := b.getUpstreamBundles(); err != nil {
+		return err
+	}
+	if err := b.NewDNFConfIfNeeded(); err != nil {
+		return err
+	}
+
+	mixBundles, err := b.getMixBundlesListAsSet()
+	if err != nil {
+		return err
+	}
+	set, err := b.getFullBundleSet(mixBundles)
+	if err != nil {
+		return err
+	}
+	if err = validateAndFillBundleSet(set); err != nil {
+		return err
+	}
+
+	packagerCmd := []string{
+		"dnf",
+		"--config=" + b.Config.Builder.DNFConf,
+		"-y",
+		"--releasever=" + b.UpstreamVer,
+	}
+
+	emptyDir, err := ioutil.TempDir("", "MixerEmptyDirForNoopInstall")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.RemoveAll(emptyDir)
+	}()
+
+	md, err := newRepoMetadata(b.Config.Builder.DNFConf, emptyDir)
+	if err != nil {
+		return err
+	}
+	packagerCmd, _, err = pinPackages(set, packagerCmd, emptyDir, md, nil)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Resolving packages for %d bundles using %d workers\n", len(set), b.NumBundleWorkers)
+	closures := resolveBundleClosures(b.NumBundleWorkers, set, packagerCmd, emptyDir)
+
+	all := make(map[string]packageMetadata)
+	for name, pkgs := range closures {
+		if len(pkgs) == 0 && len(set[name].AllPackages) > 0 {
+			return errors.Errorf("couldn't resolve packages for bundle %s", name)
+		}
+		for _, pkg := range pkgs {
+			all[packageKey(pkg)] = pkg
+		}
+	}
+
+	sizes, err := queryInstalledSizes(all, md)
+	if err != nil {
+		return err
+	}
+
+	var mixSize int64
+	for key := range all {
+		mixSize += sizes[key]
+	}
+	return printBundleSizes(os.Stdout, computeBundleSizes(set, closures, sizes), len(all), mixSize)
+}
@@ -0,0 +1,52 @@
+package builder
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestComputeBundleSizes(t *testing.T) {
+	set := bundleSet{
+		"os-core":   &bundle{Name: "os-core"},
+		"c-basic":   &bundle{Name: "c-basic", DirectIncludes: []string{"os-core"}},
+		"dev":       &bundle{Name: "dev", DirectIncludes: []string{"c-basic"}, OptionalIncludes: []string{"editors"}},
+		"editors":   &bundle{Name: "editors"},
+		"unrelated": &bundle{Name: "unrelated"},
+	}
+	pkg := func(name string) packageMetadata {
+		return packageMetadata{name: name, arch: "x86_64", version: "1-1", repo: "clear"}
+	}
+	closures := map[string][]packageMetadata{
+		"os-core":   {pkg("glibc"), pkg("bash")},
+		"c-basic":   {pkg("glibc"), pkg("bash"), pkg("gcc")},
+		"dev":       {pkg("glibc"), pkg("bash"), pkg("gcc"), pkg("git")},
+		"editors":   {pkg("glibc"), pkg("vim")},
+		"unrelated": {pkg("glibc"), pkg("bash"), pkg("vim")},
+	}
+	sizes := map[string]int64{
+		"glibc.x86_64": 100,
+		"bash.x86_64":  10,
+		"gcc.x86_64":   1000,
+		"git.x86_64":   50,
+		"vim.x86_64":   20,
+	}
+
+	if got := subtractedBundles(set, "dev"); !reflect.DeepEqual(got, []string{"os-core", "c-basic"}) {
+		t.Errorf("got subtracted bundles %v for dev", got)
+	}
+
+	expected := []*bundleSize{
+		{name: "c-basic", packages: 3, size: 1110, ownPackages: 1, ownSize: 1000},
+		{name: "dev", packages: 4, size: 1160, ownPackages: 1, ownSize: 50},
+		{name: "editors", packages: 2, size: 120, ownPackages: 1, ownSize: 20},
+		{name: "os-core", packages: 2, size: 110, ownPackages: 2, ownSize: 110},
+		{name: "unrelated", packages: 3, size: 130, ownPackages: 1, ownSize: 20},
+	}
+	got := computeBundleSizes(set, closures, sizes)
+	if !reflect.DeepEqual(got, expected) {
+		for _, bs := range got {
+			t.Logf("%+v", *bs)
+		}
+		t.Errorf("got wrong bundle sizes")
+	}
+}
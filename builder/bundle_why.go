@@ -0,0 +1,354 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/clearlinux/mixer-tools/helpers"
+	"github.com/pkg/errors"
+)
+
+// whyReason explains why a package is part of a bundle.
+type whyReason struct {
+	bundle string
+
+	// includeChain goes from a bundle in the Mix Bundle List to bundle.
+	includeChain []string
+
+	// direct is set when the package is in the bundle's DirectPackages.
+	direct bool
+
+	// depChains go from a package in the bundle's DirectPackages to the
+	// package, following its requirements.
+	depChains [][]string
+}
+
+// BundleWhy prints the bundles in the mix containing a package or a file path,
+// explaining how each bundle is reached from the Mix Bundle List and which of
+// its packages pulled the package in.
+func (b *Builder) BundleWhy(target string) error {
+	if err := b.getUpstreamBundles(); err != nil {
+		return err
+	}
+	if err := b.NewDNFConfIfNeeded(); err != nil {
+		return err
+	}
+
+	mixBundles, err := b.getMixBundlesListAsSet()
+	if err != nil {
+		return err
+	}
+	set, err := b.getFullBundleSet(mixBundles)
+	if err != nil {
+		return err
+	}
+
+	packagerCmd := []string{
+		"dnf",
+		"--config=" + b.Config.Builder.DNFConf,
+		"-y",
+		"--releasever=" + b.UpstreamVer,
+	}
+
+	var lock *packageLock
+	if b.LockFile != "" {
+		if lock, err = readPackageLock(b.LockFile); err != nil {
+			return err
+		}
+		fmt.Printf("Using package lock %s\n", b.LockFile)
+	}
+
+	emptyDir, err := ioutil.TempDir("", "MixerEmptyDirForNoopInstall")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.RemoveAll(emptyDir)
+	}()
+
+	// Resolve with the same pins and lock as when building the bundles.
+	md, err := newRepoMetadata(b.Config.Builder.DNFConf, emptyDir)
+	if err != nil {
+		return err
+	}
+	packagerCmd, _, err = pinPackages(set, packagerCmd, emptyDir, md, lock)
+	if err != nil {
+		return err
+	}
+
+	pkg := target
+	if strings.HasPrefix(target, "/") {
+		found, err := printContentWhy(set, mixBundles, target)
+		if err != nil {
+			return err
+		}
+		pkg, err = queryFileOwner(packagerCmd, target)
+		if err != nil {
+			return err
+		}
+		if pkg == "" {
+			if found {
+				return nil
+			}
+			return errors.Errorf("no package provides %s", target)
+		}
+		fmt.Printf("%s is provided by package %s\n", target, pkg)
+	}
+
+	reasons, err := whyPackage(set, mixBundles, packagerCmd, emptyDir, pkg)
+	if err != nil {
+		return err
+	}
+	if len(reasons) == 0 {
+		fmt.Printf("package %s is not in any bundle of the mix\n", pkg)
+		return nil
+	}
+
+	for _, r := range reasons {
+		fmt.Printf("bundle %s (%s)\n", r.bundle, formatIncludeChain(r.includeChain))
+		if strings.HasPrefix(target, "/") && isExcludedPath(set[r.bundle].Excludes, target) {
+			fmt.Printf("  %s is excluded from this bundle\n", target)
+		}
+		if r.direct {
+			fmt.Printf("  %s: direct package\n", pkg)
+		}
+		for _, chain := range r.depChains {
+			fmt.Printf("  %s: dependency of %s: %s\n", pkg, chain[0], strings.Join(chain, " -> "))
+		}
+	}
+	return nil
+}
+
+func formatIncludeChain(chain []string) string {
+	if len(chain) == 1 {
+		return "in mix"
+	}
+	return "included by " + strings.Join(chain, " -> ")
+}
+
+// printContentWhy prints the bundles with content overlays providing path.
+func printContentWhy(set, mixBundles bundleSet, path string) (bool, error) {
+	found := false
+	for _, name := range getBundleSetKeysSorted(set) {
+		for _, c := range set[name].Content {
+			err := walkContent(c, func(n, src string, fi os.FileInfo) error {
+				if n == path {
+					fmt.Printf("bundle %s (%s)\n", name, formatIncludeChain(findIncludeChain(set, mixBundles, name)))
+					fmt.Printf("  %s: from content %s\n", path, c.Path)
+					found = true
+				}
+				return nil
+			})
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+	return found, nil
+}
+
+// whyPackage returns the reasons for pkg to be in each bundle of set, sorted
+// by bundle name. Only the bundles that pull pkg with their own packages are
+// considered, not the ones getting it from an included bundle.
+func whyPackage(set, mixBundles bundleSet, packagerCmd []string, emptyDir, pkg string) ([]*whyReason, error) {
+	var reasons []*whyReason
+
+	cache := make(map[string][]string)
+	requiredBy := func(p string) ([]string, error) {
+		if reqs, ok := cache[p]; ok {
+			return reqs, nil
+		}
+		reqs, err := queryRequiredBy(packagerCmd, p)
+		if err != nil {
+			return nil, err
+		}
+		cache[p] = reqs
+		return reqs, nil
+	}
+
+	for _, name := range getBundleSetKeysSorted(set) {
+		bundle := set[name]
+		if len(bundle.DirectPackages) == 0 {
+			continue
+		}
+
+		var direct []string
+		for p := range bundle.DirectPackages {
+			direct = append(direct, p)
+		}
+		sort.Strings(direct)
+
+		closure := make(map[string]bool)
+		for _, p := range parseNoopInstall(noopInstall(packagerCmd, emptyDir, direct).String()) {
+			closure[p.name] = true
+		}
+		if !closure[pkg] {
+			continue
+		}
+
+		r := &whyReason{
+			bundle:       name,
+			includeChain: findIncludeChain(set, mixBundles, name),
+			direct:       bundle.DirectPackages[pkg],
+		}
+		if !r.direct {
+			chains, err := findDependencyChains(requiredBy, bundle.DirectPackages, closure, pkg)
+			if err != nil {
+				return nil, err
+			}
+			r.depChains = chains
+		}
+		reasons = append(reasons, r)
+	}
+	return reasons, nil
+}
+
+// findIncludeChain returns the shortest chain of includes from a bundle in the
+// Mix Bundle List to name. Optional includes are marked with "(also-add)".
+func findIncludeChain(set, mixBundles bundleSet, name string) []string {
+	type node struct {
+		name  string
+		chain []string
+	}
+	var queue []node
+	visited := make(map[string]bool)
+	for _, top := range getBundleSetKeysSorted(mixBundles) {
+		queue = append(queue, node{top, []string{top}})
+		visited[top] = true
+	}
+
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		if n.name == name {
+			return n.chain
+		}
+		bundle, ok := set[n.name]
+		if !ok {
+			continue
+		}
+		next := func(inc, label string) {
+			if visited[inc] {
+				return
+			}
+			visited[inc] = true
+			chain := make([]string, len(n.chain), len(n.chain)+1)
+			copy(chain, n.chain)
+			queue = append(queue, node{inc, append(chain, inc+label)})
+		}
+		for _, inc := range bundle.DirectIncludes {
+			next(inc, "")
+		}
+		for _, inc := range bundle.OptionalIncludes {
+			next(inc, " (also-add)")
+		}
+	}
+	return []string{name}
+}
+
+// findDependencyChains walks the requirements backwards from pkg, restricted to
+// the packages in closure, until reaching the nearest direct packages. The
+// chains go from each of those direct packages to pkg. requiredBy returns the
+// packages requiring a package.
+func findDependencyChains(requiredBy func(string) ([]string, error), direct, closure map[string]bool, pkg string) ([][]string, error) {
+	next := map[string]bool{pkg: true}
+	parent := map[string]string{pkg: ""}
+	var found []string
+
+	for len(next) > 0 && len(found) == 0 {
+		var level []string
+		for p := range next {
+			level = append(level, p)
+		}
+		sort.Strings(level)
+		next = make(map[string]bool)
+
+		for _, p := range level {
+			reqs, err := requiredBy(p)
+			if err != nil {
+				return nil, err
+			}
+			for _, r := range reqs {
+				if _, seen := parent[r]; seen || !closure[r] {
+					continue
+				}
+				parent[r] = p
+				if direct[r] {
+					found = append(found, r)
+				} else {
+					next[r] = true
+				}
+			}
+		}
+	}
+
+	sort.Strings(found)
+	var chains [][]string
+	for _, d := range found {
+		var chain []string
+		for p := d; p != ""; p = parent[p] {
+			chain = append(chain, p)
+		}
+		chains = append(chains, chain)
+	}
+	return chains, nil
+}
+
+func queryRequiredBy(packagerCmd []string, pkg string) ([]string, error) {
+	args := merge(packagerCmd, "repoquery", "--quiet", "--qf", "%{name}", "--whatrequires", pkg)
+	out, err := helpers.RunCommandOutputEnv(args[0], args[1:], []string{"LC_ALL=en_US.UTF-8"})
+	if err != nil {
+		return nil, err
+	}
+	return splitUniqueLines(out.String()), nil
+}
+
+// queryFileOwner returns the name of the package providing path, or an empty
+// string if there is none.
+func queryFileOwner(packagerCmd []string, path string) (string, error) {
+	args := merge(packagerCmd, "repoquery", "--quiet", "--qf", "%{name}", "--file", path)
+	out, err := helpers.RunCommandOutputEnv(args[0], args[1:], []string{"LC_ALL=en_US.UTF-8"})
+	if err != nil {
+		return "", err
+	}
+	owners := splitUniqueLines(out.String())
+	if len(owners) == 0 {
+		return "", nil
+	}
+	if len(owners) > 1 {
+		fmt.Printf("%s is provided by multiple packages: %s\n", path, strings.Join(owners, ", "))
+	}
+	return owners[0], nil
+}
+
+func splitUniqueLines(s string) []string {
+	seen := make(map[string]bool)
+	var lines []string
+	for _, l := range strings.Split(s, "\n") {
+		l = strings.TrimSpace(l)
+		if l == "" || seen[l] {
+			continue
+		}
+		seen[l] = true
+		lines = append(lines, l)
+	}
+	sort.Strings(lines)
+	return lines
+}
@@ -0,0 +1,70 @@
+package builder
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindIncludeChain(t *testing.T) {
+	set := bundleSet{
+		"os-core":   &bundle{Name: "os-core"},
+		"editors":   &bundle{Name: "editors", DirectIncludes: []string{"os-core"}, OptionalIncludes: []string{"vim"}},
+		"vim":       &bundle{Name: "vim", DirectIncludes: []string{"os-core"}},
+		"dev-utils": &bundle{Name: "dev-utils", DirectIncludes: []string{"editors"}},
+	}
+	mixBundles := bundleSet{
+		"dev-utils": set["dev-utils"],
+		"os-core":   set["os-core"],
+	}
+
+	testCases := []struct {
+		name     string
+		expected []string
+	}{
+		{"os-core", []string{"os-core"}},
+		{"editors", []string{"dev-utils", "editors"}},
+		{"vim", []string{"dev-utils", "editors", "vim (also-add)"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			chain := findIncludeChain(set, mixBundles, tc.name)
+			if !reflect.DeepEqual(chain, tc.expected) {
+				t.Errorf("got include chain %v, expected %v", chain, tc.expected)
+			}
+		})
+	}
+}
+
+func TestFindDependencyChains(t *testing.T) {
+	reqs := map[string][]string{
+		"glibc":   {"bash", "coreutils", "openssl", "other"},
+		"openssl": {"curl"},
+		"curl":    {"git"},
+		"bash":    {"git"},
+	}
+	requiredBy := func(p string) ([]string, error) {
+		return reqs[p], nil
+	}
+	closure := map[string]bool{"glibc": true, "bash": true, "coreutils": true, "openssl": true, "curl": true, "git": true}
+
+	// The nearest direct packages stop the walk.
+	direct := map[string]bool{"coreutils": true, "git": true}
+	chains, err := findDependencyChains(requiredBy, direct, closure, "glibc")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := [][]string{{"coreutils", "glibc"}}
+	if !reflect.DeepEqual(chains, expected) {
+		t.Errorf("got chains %v, expected %v", chains, expected)
+	}
+
+	direct = map[string]bool{"git": true}
+	chains, err = findDependencyChains(requiredBy, direct, closure, "openssl")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected = [][]string{{"git", "curl", "openssl"}}
+	if !reflect.DeepEqual(chains, expected) {
+		t.Errorf("got chains %v, expected %v", chains, expected)
+	}
+}
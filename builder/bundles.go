@@ -8,6 +8,7 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"regexp"
 	"sort"
@@ -17,6 +18,7 @@ import (
 	"time"
 
 	"github.com/clearlinux/mixer-tools/helpers"
+	"github.com/clearlinux/mixer-tools/internal/rpm"
 	"github.com/pkg/errors"
 )
 
@@ -37,7 +39,6 @@ var bannedPaths = [...]string{
 }
 
 const rpmDir = "/var/cache/yum/clear/"
-const extractRetries = 5
 
 func isBannedPath(path string) bool {
 	if path == "/" {
@@ -140,22 +141,19 @@ func addUpdateBundleSpecialFiles(b *Builder, bundle *bundle) {
 	addFileAndPath(bundle.Files, filesToAdd...)
 }
 
-// repoPkgMap is a map of repo names to the packages they provide
-type repoPkgMap map[string][]string
+// repoRpmMap is a map of repo names to the packages they provide
 type repoRpmMap map[string][]packageMetadata
 
-func resolveFilesForBundle(bundle *bundle, repoPkgs repoPkgMap, packagerCmd []string) error {
+func resolveFilesForBundle(bundle *bundle, repoPkgs repoRpmMap, md *repoMetadata) error {
 	bundle.Files = make(map[string]bool)
 
-	for repo, pkgs := range repoPkgs {
-		queryString := merge(packagerCmd, "repoquery", "-l", "--quiet", "--repo", repo)
-		queryString = append(queryString, pkgs...)
-		outBuf, err := helpers.RunCommandOutputEnv(queryString[0], queryString[1:], []string{"LC_ALL=en_US.UTF-8"})
-		if err != nil {
-			return err
-		}
-		for _, f := range strings.Split(outBuf.String(), "\n") {
-			if len(f) > 0 {
+	for _, pkgs := range repoPkgs {
+		for _, pkg := range pkgs {
+			files, err := md.files(pkg)
+			if err != nil {
+				return err
+			}
+			for _, f := range files {
 				addFileAndPath(bundle.Files, resolveFileName(f))
 			}
 		}
@@ -166,7 +164,58 @@ func resolveFilesForBundle(bundle *bundle, repoPkgs repoPkgMap, packagerCmd []st
 	return nil
 }
 
-func resolveFiles(numWorkers int, set bundleSet, bundleRepoPkgs *sync.Map, packagerCmd []string) error {
+// isExcludedPath returns true when fpath or one of its parent directories
+// matches one of the exclude patterns of a bundle.
+func isExcludedPath(excludes []string, fpath string) bool {
+	for _, pattern := range excludes {
+		for p := fpath; p != "/" && p != "."; p = path.Dir(p) {
+			if matched, _ := path.Match(pattern, p); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// excludeFilesForBundle drops the files matching the bundle's exclude
+// directives from its resolved file list. The parent directories of the
+// excluded files are kept, and the bundle's own tracking file is never
+// excluded.
+func excludeFilesForBundle(bundle *bundle) {
+	if len(bundle.Excludes) == 0 {
+		return
+	}
+
+	bundleFile := fmt.Sprintf("/usr/share/clear/bundles/%s", bundle.Name)
+	excluded := 0
+	for f := range bundle.Files {
+		if f == bundleFile {
+			continue
+		}
+		if isExcludedPath(bundle.Excludes, f) {
+			delete(bundle.Files, f)
+			excluded++
+		}
+	}
+	// Keep the path to the tracking file in case a parent was excluded.
+	addFileAndPath(bundle.Files, bundleFile)
+	fmt.Printf("Bundle %s\t%d files excluded\n", bundle.Name, excluded)
+}
+
+func resolveFiles(numWorkers int, set bundleSet, bundleRepoPkgs *sync.Map, md *repoMetadata) error {
+	// Read the file lists of the packages of all bundles at once, so each
+	// repository's metadata is only read once.
+	all := make(repoRpmMap)
+	bundleRepoPkgs.Range(func(_, r interface{}) bool {
+		for repo, pkgs := range r.(repoRpmMap) {
+			all[repo] = append(all[repo], pkgs...)
+		}
+		return true
+	})
+	if err := md.readFiles(all); err != nil {
+		return err
+	}
+
 	var err error
 	var wg sync.WaitGroup
 	fmt.Printf("Resolving files using %d workers\n", numWorkers)
@@ -187,7 +236,7 @@ func resolveFiles(numWorkers int, set bundleSet, bundleRepoPkgs *sync.Map, packa
 				errorCh <- fmt.Errorf("couldn't find %s bundle", bundle.Name)
 				break
 			}
-			e := resolveFilesForBundle(bundle, r.(repoPkgMap), packagerCmd)
+			e := resolveFilesForBundle(bundle, r.(repoRpmMap), md)
 			if e != nil {
 				// break on the first error we get
 				// causes wg.Done to be called and the worker to exit
@@ -195,6 +244,7 @@ func resolveFiles(numWorkers int, set bundleSet, bundleRepoPkgs *sync.Map, packa
 				errorCh <- e
 				break
 			}
+			excludeFilesForBundle(bundle)
 		}
 		wg.Done()
 	}
@@ -298,90 +348,107 @@ func parseNoopInstall(installOut string) []packageMetadata {
 	return pkgs
 }
 
-func repoPkgFromNoopInstall(installOut string) (repoPkgMap, repoRpmMap) {
-	repoPkgs := make(repoPkgMap)
+func repoPkgFromNoopInstall(installOut string) repoRpmMap {
 	repoRpmMap := make(repoRpmMap)
-	// TODO: parseNoopInstall may fail, so consider a way to stop the processing
-	// once we find that failure. See how errorCh works in fullfiles.go.
+	// Packages that parseNoopInstall gets wrong are not in the repository
+	// metadata, so they fail in addBundleRpms.
 	pkgs := parseNoopInstall(installOut)
 
 	for _, p := range pkgs {
-		repoPkgs[p.repo] = append(repoPkgs[p.repo], p.name)
 		repoRpmMap[p.repo] = append(repoRpmMap[p.repo], p)
 	}
-	return repoPkgs, repoRpmMap
+	return repoRpmMap
 }
 
-func queryRpmName(packageCmd []string, pkgName string) string {
-	queryStringRpm := merge(
-		packageCmd,
-		"repoquery",
-		"--location",
-		"--repo",
-		"local",
+// noopInstall runs a dnf install of pkgs to emptyDir that is always aborted,
+// returning its output. The packages that would be installed can be obtained
+// with parseNoopInstall.
+func noopInstall(packagerCmd []string, emptyDir string, pkgs []string) *bytes.Buffer {
+	queryString := merge(
+		packagerCmd,
+		"--installroot="+emptyDir,
+		"--assumeno",
+		"install",
 	)
-	queryStringRpm = append(queryStringRpm, pkgName)
-	outBuf, _ := helpers.RunCommandOutputEnv(queryStringRpm[0], queryStringRpm[1:], []string{"LC_ALL=en_US.UTF-8"})
-	if outBuf.String() != "" {
-		rpm := strings.Split(outBuf.String(), "\n")
-		_, rpmName := filepath.Split(rpm[0])
-		return rpmName
-	}
-	return ""
+	queryString = append(queryString, pkgs...)
+	// ignore error from the --assumeno install. It is an error every time because
+	// --assumeno forces the install command to "abort" and return a non-zero exit
+	// status. This exit status is 1, which is the same as any other dnf install
+	// error. Fortunately if this is a different error than we expect, it should
+	// fail in the actual install to the full chroot.
+	outBuf, _ := helpers.RunCommandOutputEnv(queryString[0], queryString[1:], []string{"LC_ALL=en_US.UTF-8"})
+	return outBuf
 }
 
 var fileSystemRpm string
 
-func resolvePackages(numWorkers int, set bundleSet, packagerCmd []string, emptyDir string, localPath string) *sync.Map {
+// addBundleRpms adds the packages resolved for a bundle to it. dnf only
+// reports the packages it resolves in a table meant for humans, so each of
+// them is checked against the repository metadata, which also has the name of
+// the package file.
+func addBundleRpms(bundle *bundle, fullRpm repoRpmMap, md *repoMetadata) error {
+	bundle.AllRpmPackages = make(map[string]bool)
+	bundle.rpmRepos = make(map[string]string)
+	for _, pkgs := range fullRpm {
+		// Add packages to bundle's AllPackages
+		for _, pkg := range pkgs {
+			p, err := md.lookup(pkg)
+			if err != nil {
+				return errors.Wrapf(err, "couldn't resolve packages for bundle %s", bundle.Name)
+			}
+			rpmName := p.Filename()
+			bundle.AllPackages[pkg.name] = true
+			bundle.AllRpmPackages[rpmName] = true
+			bundle.rpmRepos[rpmName] = pkg.repo
+			// to find the fullName of filesystem rpm, as filesystem needs to be extracted first
+			if bundle.Name == "os-core" {
+				if pkg.name == "filesystem" {
+					fileSystemRpm = rpmName
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func resolvePackages(numWorkers int, set bundleSet, packagerCmd []string, emptyDir string, pins packagePins, lock *packageLock, md *repoMetadata) (*sync.Map, error) {
 	var wg sync.WaitGroup
 	fmt.Printf("Resolving packages using %d workers\n", numWorkers)
 	wg.Add(numWorkers)
 	bundleCh := make(chan *bundle)
 	// bundleRepoPkgs is a map of bundles -> map of repos -> list of packages
 	var bundleRepoPkgs sync.Map
+	// buffer errorCh so every bundle can report an error without blocking
+	errorCh := make(chan error, len(set))
 
 	packageWorker := func() {
 		for bundle := range bundleCh {
 			fmt.Printf("processing %s\n", bundle.Name)
-			queryString := merge(
-				packagerCmd,
-				"--installroot="+emptyDir,
-				"--assumeno",
-				"install",
-			)
+			var pkgs []string
 			for p := range bundle.AllPackages {
-				queryString = append(queryString, p)
+				pkgs = append(pkgs, p)
 			}
-			bundle.AllRpmPackages = make(map[string]bool)
-			// ignore error from the --assumeno install. It is an error every time because
-			// --assumeno forces the install command to "abort" and return a non-zero exit
-			// status. This exit status is 1, which is the same as any other dnf install
-			// error. Fortunately if this is a different error than we expect, it should
-			// fail in the actual install to the full chroot.
-			outBuf, _ := helpers.RunCommandOutputEnv(queryString[0], queryString[1:], []string{"LC_ALL=en_US.UTF-8"})
-
-			rpm, fullRpm := repoPkgFromNoopInstall(outBuf.String())
-			for _, pkgs := range fullRpm {
-				// Add packages to bundle's AllPackages
-				for _, pkg := range pkgs {
-					rpmName := pkg.name + "-" + pkg.version + "." + pkg.arch + ".rpm"
-					if pkg.repo == "local" {
-						if _, err := os.Stat(filepath.Join(localPath, rpmName)); os.IsNotExist(err) {
-							rpmName = queryRpmName(packagerCmd, pkg.name)
-						}
-					}
-					bundle.AllPackages[pkg.name] = true
-					bundle.AllRpmPackages[rpmName] = true
-					// to find the fullName of filesystem rpm, as filesystem needs to be extracted first
-					if bundle.Name == "os-core" {
-						if pkg.name == "filesystem" {
-							fileSystemRpm = rpmName
-						}
-					}
-				}
+			outBuf := noopInstall(packagerCmd, emptyDir, pkgs)
+
+			// The pins and the lock are already applied by
+			// packagerCmd, this only guards against dnf ignoring them.
+			resolved := parseNoopInstall(outBuf.String())
+			err := checkResolvedPins(bundle, pins, resolved)
+			if err == nil && lock != nil {
+				err = lock.checkResolved(bundle, resolved)
+			}
+			if err != nil {
+				errorCh <- err
+				continue
 			}
 
-			bundleRepoPkgs.Store(bundle.Name, rpm)
+			fullRpm := repoPkgFromNoopInstall(outBuf.String())
+			if err := addBundleRpms(bundle, fullRpm, md); err != nil {
+				errorCh <- err
+				continue
+			}
+
+			bundleRepoPkgs.Store(bundle.Name, fullRpm)
 
 			fmt.Printf("... done with %s\n", bundle.Name)
 		}
@@ -399,10 +466,13 @@ func resolvePackages(numWorkers int, set bundleSet, packagerCmd []string, emptyD
 	close(bundleCh)
 	wg.Wait()
 
-	return &bundleRepoPkgs
+	if len(errorCh) > 0 {
+		return nil, <-errorCh
+	}
+	return &bundleRepoPkgs, nil
 }
 
-func installFilesystem(chrootDir string, localPath string, packagerCmd []string, downloadRetries int) error {
+func installFilesystem(chrootDir string, localPath string, packagerCmd []string, downloadRetries int, repo string, verifier *rpmVerifier) error {
 	var rpmFull string
 	var err error
 
@@ -410,7 +480,8 @@ func installFilesystem(chrootDir string, localPath string, packagerCmd []string,
 	rpmMap[fileSystemRpm] = true
 	if _, err = os.Stat(rpmFull); os.IsNotExist(err) {
 		if !Offline {
-			rpmPath, err := downloadRpm(packagerCmd, []string{"filesystem"}, chrootDir, downloadRetries)
+			// Download the exact resolved version, which may be pinned.
+			rpmPath, err := downloadRpm(packagerCmd, []string{strings.TrimSuffix(fileSystemRpm, ".rpm")}, chrootDir, downloadRetries)
 			if err != nil {
 				return err
 			}
@@ -423,14 +494,12 @@ func installFilesystem(chrootDir string, localPath string, packagerCmd []string,
 		}
 	}
 
-	for i := 0; i < extractRetries; i++ {
-		err = extractRpm(chrootDir, rpmFull)
-		if err != nil {
-			continue
-		}
-		break
+	rpmPaths[fileSystemRpm] = rpmFull
+
+	if err = verifier.verify(map[string]string{rpmFull: repo}, 1); err != nil {
+		return err
 	}
-	return err
+	return extractRpm(chrootDir, rpmFull, rpm.LoadOwners(chrootDir))
 }
 
 func createClearDir(chrootDir, version string) error {
@@ -560,39 +629,13 @@ func downloadRpm(packagerCmd []string, rpmList []string, baseDir string, downloa
 	return fullRpmPath[0], nil
 }
 
-func extractRpm(baseDir string, rpm string) error {
-	dir, file := filepath.Split(rpm)
-
-	rpm2Cmd := exec.Command("rpm2archive", file)
-	rpm2Cmd.Dir = dir
-	rpm2Cmd.Env = os.Environ()
-
-	rpmTar := file + ".tgz"
-	tarCmd := exec.Command("tar", "-xf", rpmTar, "-C", baseDir)
-	tarCmd.Env = os.Environ()
-	tarCmd.Dir = dir
-
-	var err error
-
-	err = rpm2Cmd.Run()
-	if err != nil {
-		return fmt.Errorf("rpm2archive cmd failed for %s with %s", file, err.Error())
-	}
-
-	err = tarCmd.Run()
-	if err != nil {
-		return fmt.Errorf("tarCmd failed for %s with %s", rpmTar, err.Error())
-	}
-
-	err = os.Remove(dir + rpmTar)
-	if err != nil {
-		fmt.Println("failed to remove file")
-	}
-
-	return nil
+// extractRpm extracts the files of an RPM to baseDir, with their owners mapped
+// to the users and groups of baseDir.
+func extractRpm(baseDir string, rpmFile string, owners *rpm.Owners) error {
+	return rpm.Extract(rpmFile, baseDir, owners)
 }
 
-func installBundleToFull(packagerCmd []string, baseDir string, bundle *bundle, downloadRetries int, numWorkers int, localPath string) error {
+func installBundleToFull(packagerCmd []string, baseDir string, bundle *bundle, downloadRetries int, numWorkers int, localPath string, owners *rpm.Owners, verifier *rpmVerifier) error {
 	var err error
 	var wg sync.WaitGroup
 	rpmCh := make(chan string)
@@ -608,16 +651,8 @@ func installBundleToFull(packagerCmd []string, baseDir string, bundle *bundle, d
 	wg.Add(numWorkers)
 
 	rpmWorker := func() {
-		var e error
-		for rpm := range rpmCh {
-			// running for extractRetries times as rpm2archive and tar can fail if two files are extracted at exact same path
-			for i := 0; i < extractRetries; i++ {
-				e = extractRpm(baseDir, rpm)
-				if e == nil {
-					break
-				}
-			}
-			if e != nil {
+		for rpmFile := range rpmCh {
+			if e := extractRpm(baseDir, rpmFile, owners); e != nil {
 				errorCh <- e
 				break
 			}
@@ -631,6 +666,8 @@ func installBundleToFull(packagerCmd []string, baseDir string, bundle *bundle, d
 		}
 		rpmFull := filepath.Join(localPath, rpm)
 		if _, err = os.Stat(rpmFull); os.IsNotExist(err) {
+			// The full NEVRA is passed to dnf, so the downloaded package is
+			// the version resolved by resolvePackages, including pins.
 			rpmName := strings.TrimSuffix(rpm, ".rpm")
 			missingRpms = append(missingRpms, rpmName)
 		}
@@ -648,11 +685,9 @@ func installBundleToFull(packagerCmd []string, baseDir string, bundle *bundle, d
 		}
 	}
 
-	for i := 0; i < numWorkers; i++ {
-		go rpmWorker()
-	}
-
-	// feed the channel
+	// Find and verify all the RPMs before extracting any of them, so a
+	// failure reports every RPM with a bad signature.
+	rpmFiles := make(map[string]string)
 	for rpm := range bundle.AllRpmPackages {
 		if rpmMap[rpm] {
 			continue
@@ -664,7 +699,24 @@ func installBundleToFull(packagerCmd []string, baseDir string, bundle *bundle, d
 				return fmt.Errorf("rpm not found: %s", rpmFull)
 			}
 		}
+		rpmFiles[rpm] = rpmFull
+	}
+	fileRepos := make(map[string]string, len(rpmFiles))
+	for rpm, rpmFull := range rpmFiles {
+		fileRepos[rpmFull] = bundle.rpmRepos[rpm]
+	}
+	if err = verifier.verify(fileRepos, numWorkers); err != nil {
+		return err
+	}
+
+	for i := 0; i < numWorkers; i++ {
+		go rpmWorker()
+	}
+
+	// feed the channel
+	for rpm, rpmFull := range rpmFiles {
 		rpmMap[rpm] = true
+		rpmPaths[rpm] = rpmFull
 		select {
 		case rpmCh <- rpmFull:
 		case errCh = <-errorCh:
@@ -718,6 +770,9 @@ func rmDNFStatePaths(fullDir string) {
 
 var rpmMap map[string]bool
 
+// rpmPaths maps the RPMs installed to the full chroot to their files.
+var rpmPaths map[string]string
+
 func buildFullChroot(b *Builder, set *bundleSet, packagerCmd []string, buildVersionDir, version string, downloadRetries int, numWorkers int) error {
 	fmt.Println("Cleaning DNF cache before full install")
 	if err := clearDNFCache(packagerCmd); err != nil {
@@ -732,16 +787,29 @@ func buildFullChroot(b *Builder, set *bundleSet, packagerCmd []string, buildVers
 	}
 	i := 0
 	rpmMap = make(map[string]bool)
+	rpmPaths = make(map[string]string)
+
+	verifier, err := b.newRPMVerifier()
+	if err != nil {
+		return err
+	}
+	var fileSystemRepo string
+	if osCore, ok := (*set)["os-core"]; ok {
+		fileSystemRepo = osCore.rpmRepos[fileSystemRpm]
+	}
 
-	if err := installFilesystem(fullDir, b.Config.Mixer.LocalRepoDir, packagerCmd, downloadRetries); err != nil {
+	if err := installFilesystem(fullDir, b.Config.Mixer.LocalRepoDir, packagerCmd, downloadRetries, fileSystemRepo, verifier); err != nil {
 		return err
 	}
+	// The users and groups come from the filesystem package, so they are
+	// only known after it is installed.
+	owners := rpm.LoadOwners(fullDir)
 
 	for _, bundle := range *set {
 		i++
 		fmt.Printf("[%d/%d] %s\n", i, totalBundles, bundle.Name)
 
-		if err := installBundleToFull(packagerCmd, fullDir, bundle, downloadRetries, numWorkers, b.Config.Mixer.LocalRepoDir); err != nil {
+		if err := installBundleToFull(packagerCmd, fullDir, bundle, downloadRetries, numWorkers, b.Config.Mixer.LocalRepoDir, owners, verifier); err != nil {
 			return err
 		}
 		// special handling for os-core
@@ -763,6 +831,81 @@ func buildFullChroot(b *Builder, set *bundleSet, packagerCmd []string, buildVers
 	return nil
 }
 
+// removeExcludedFromFull removes from the full chroot the files excluded by
+// bundles that are not part of any other bundle, so they don't end up in
+// Manifest.full. Excluded directories are removed once they are empty.
+func removeExcludedFromFull(set bundleSet, fullDir string) error {
+	var excluders []*bundle
+	for _, bundle := range set {
+		if len(bundle.Excludes) > 0 {
+			excluders = append(excluders, bundle)
+		}
+	}
+	if len(excluders) == 0 {
+		return nil
+	}
+
+	var toRemove, dirs []string
+	err := filepath.Walk(fullDir, func(fpath string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fpath == fullDir {
+			return nil
+		}
+		name := strings.TrimPrefix(fpath, fullDir)
+		excluded := false
+		for _, bundle := range excluders {
+			if isExcludedPath(bundle.Excludes, name) {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			return nil
+		}
+		for _, bundle := range set {
+			if bundle.Files[name] {
+				return nil
+			}
+		}
+		if fi.IsDir() {
+			dirs = append(dirs, fpath)
+		} else {
+			toRemove = append(toRemove, fpath)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, fpath := range toRemove {
+		if err = os.Remove(fpath); err != nil {
+			return err
+		}
+	}
+
+	// Walk visits the parents first, so going backwards removes the
+	// subdirectories before their parents.
+	removedDirs := 0
+	for i := len(dirs) - 1; i >= 0; i-- {
+		entries, err := ioutil.ReadDir(dirs[i])
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			continue
+		}
+		if err = os.Remove(dirs[i]); err != nil {
+			return err
+		}
+		removedDirs++
+	}
+	fmt.Printf("Removed %d excluded files and %d excluded directories from full chroot\n", len(toRemove), removedDirs)
+	return nil
+}
+
 func writeBundleInfo(bundle *bundle, path string) error {
 	b, err := json.Marshal(*bundle)
 	if err != nil {
@@ -772,7 +915,7 @@ func writeBundleInfo(bundle *bundle, path string) error {
 	return ioutil.WriteFile(path, b, 0644)
 }
 
-func (b *Builder) buildBundles(set bundleSet, downloadRetries int) error {
+func (b *Builder) buildBundles(set bundleSet, downloadRetries int, lock *packageLock) error {
 	var err error
 
 	if b.Config.Builder.ServerStateDir == "" {
@@ -886,10 +1029,27 @@ src=%s
 		_ = os.RemoveAll(emptyDir)
 	}()
 
+	// Package and file lookups use the repository metadata directly, dnf is
+	// only needed to resolve the dependencies and install the packages.
+	md, err := newRepoMetadata(b.Config.Builder.DNFConf, emptyDir)
+	if err != nil {
+		return err
+	}
+
+	// From here on, every dnf command only sees the pinned and locked
+	// versions.
+	packagerCmd, pins, err := pinPackages(set, packagerCmd, emptyDir, md, lock)
+	if err != nil {
+		return err
+	}
+
 	// bundleRepoPkgs is a map of bundles -> map of repos -> list of packages
-	bundleRepoPkgs := resolvePackages(numWorkers, set, packagerCmd, emptyDir, b.Config.Mixer.LocalRepoDir)
+	bundleRepoPkgs, err := resolvePackages(numWorkers, set, packagerCmd, emptyDir, pins, lock, md)
+	if err != nil {
+		return err
+	}
 
-	err = resolveFiles(numWorkers, set, bundleRepoPkgs, packagerCmd)
+	err = resolveFiles(numWorkers, set, bundleRepoPkgs, md)
 	if err != nil {
 		return err
 	}
@@ -905,6 +1065,11 @@ src=%s
 	addOsCoreSpecialFiles(osCore)
 	addUpdateBundleSpecialFiles(b, updateBundle)
 
+	err = addContentFiles(set)
+	if err != nil {
+		return err
+	}
+
 	for _, bundle := range set {
 		err = writeBundleInfo(bundle, filepath.Join(buildVersionDir, bundle.Name+"-info"))
 		if err != nil {
@@ -918,6 +1083,22 @@ src=%s
 		return err
 	}
 
+	err = removeExcludedFromFull(set, filepath.Join(buildVersionDir, "full"))
+	if err != nil {
+		return err
+	}
+
+	err = overlayContent(set, filepath.Join(buildVersionDir, "full"))
+	if err != nil {
+		return err
+	}
+
+	// record the exact packages installed, checking them against the lock if any
+	err = lockBuild(b, set, buildVersionDir, version, lock)
+	if err != nil {
+		return err
+	}
+
 	// create os-packages file for validation tools
 	err = createOsPackagesFile(buildVersionDir)
 	if err != nil {
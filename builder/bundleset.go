@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"path"
 	"path/filepath"
 	"regexp"
 	"strings"
@@ -25,13 +26,28 @@ type bundle struct {
 	Header           swupd.BundleHeader
 	DirectIncludes   []string
 	OptionalIncludes []string
+	Excludes         []string
 	DirectPackages   map[string]bool
 	AllPackages      map[string]bool
 
+	// PackagePins maps names in DirectPackages to the exact version they
+	// must be installed with.
+	PackagePins map[string]*packagePin `json:",omitempty"`
+
 	Files map[string]bool
 
-	/* hidden property, not to be included in file usr/share/clear/allbundles */
-	AllRpmPackages map[string]bool `json:"-"`
+	// ContentFiles are the files in Files provided by content overlays
+	// instead of packages.
+	ContentFiles map[string]bool
+
+	// AllRpmPackages are the file names of the RPMs installed for the
+	// packages in AllPackages.
+	AllRpmPackages map[string]bool `json:",omitempty"`
+
+	Content []*bundleContent `json:"-"`
+
+	// rpmRepos maps the file names in AllRpmPackages to their repository.
+	rpmRepos map[string]string
 }
 
 type bundleSet map[string]*bundle
@@ -296,17 +312,29 @@ func parseBundleFile(filename string) (*bundle, error) {
 	bundle.Name = name
 	bundle.Filename = filename
 
+	// Content directories are relative to the bundle file.
+	for _, c := range bundle.Content {
+		if !filepath.IsAbs(c.Path) {
+			c.Path = filepath.Join(filepath.Dir(filename), c.Path)
+		}
+	}
+
 	return bundle, nil
 }
 
 // parseBundle parses the bytes of a bundle file, ignoring comments and
-// processing "include()" directives the same way that m4 works.
+// processing "include()" directives the same way that m4 works. The
+// "exclude()" directive takes an absolute path or glob pattern of files
+// to be dropped from the bundle after its files are resolved, and the
+// "content()" directive takes a directory to be overlaid into the bundle,
+// see parseContentDirective. A package can be pinned to a version with
+// "name=[epoch:]version-release[.arch]".
 func parseBundle(contents []byte) (*bundle, error) {
 	scanner := bufio.NewScanner(bytes.NewReader(contents))
 
 	var b bundle
 	var duplicate bool
-	var includes, packages, optional []string
+	var includes, packages, optional, excludes []string
 
 	line := 0
 	for scanner.Scan() {
@@ -355,10 +383,46 @@ func parseBundle(contents []byte) (*bundle, error) {
 				return nil, fmt.Errorf("Invalid bundle name %q in line %d", text, line)
 			}
 			optional = append(optional, text)
+		} else if strings.HasPrefix(text, "exclude(") {
+			if !strings.HasSuffix(text, ")") {
+				return nil, fmt.Errorf("Missing end parenthesis in line %d: %q", line, text)
+			}
+			text = strings.TrimSpace(text[8 : len(text)-1])
+			if !strings.HasPrefix(text, "/") {
+				return nil, fmt.Errorf("Invalid exclude %q in line %d, path must be absolute", text, line)
+			}
+			if _, err := path.Match(text, ""); err != nil {
+				return nil, fmt.Errorf("Invalid exclude pattern %q in line %d: %s", text, line, err)
+			}
+			excludes = append(excludes, text)
+		} else if strings.HasPrefix(text, "content(") {
+			if !strings.HasSuffix(text, ")") {
+				return nil, fmt.Errorf("Missing end parenthesis in line %d: %q", line, text)
+			}
+			c, err := parseContentDirective(text[8 : len(text)-1])
+			if err != nil {
+				return nil, fmt.Errorf("Invalid content directive in line %d: %s", line, err)
+			}
+			b.Content = append(b.Content, c)
 		} else {
+			var pin *packagePin
+			if i := strings.Index(text, "="); i > -1 {
+				var err error
+				pin, err = parsePackagePin(strings.TrimSpace(text[i+1:]))
+				if err != nil {
+					return nil, fmt.Errorf("Invalid package version in line %d: %s", line, err)
+				}
+				text = strings.TrimSpace(text[:i])
+			}
 			if !validPackageNameRegex.MatchString(text) {
 				return nil, fmt.Errorf("Invalid package name %q in line %d", text, line)
 			}
+			if pin != nil {
+				if b.PackagePins == nil {
+					b.PackagePins = make(map[string]*packagePin)
+				}
+				b.PackagePins[text] = pin
+			}
 			packages = append(packages, text)
 		}
 	}
@@ -382,6 +446,7 @@ func parseBundle(contents []byte) (*bundle, error) {
 		}
 	}
 	b.DirectIncludes = includes
+	b.Excludes = excludes
 	b.DirectPackages = make(map[string]bool)
 	for _, p := range packages {
 		b.DirectPackages[p] = true
@@ -18,7 +18,9 @@ func TestParseBundle(t *testing.T) {
 		ExpectedHeader   swupd.BundleHeader
 		ExpectedIncludes []string
 		ExpectedOptional []string
+		ExpectedExcludes []string
 		ExpectedPackages map[string]bool
+		ExpectedPins     map[string]*packagePin
 		ShouldFail       bool
 	}{
 		{
@@ -83,6 +85,34 @@ pkg1 # [TITLE]: wrongtitle
 			ExpectedPackages: map[string]bool{"pkg1": true},
 		},
 
+		{
+			Contents: []byte(`# Bundle with excludes
+# [TITLE]: fake
+include(a)
+pkg1
+exclude(/usr/share/doc)
+exclude( /usr/share/locale/*/LC_MESSAGES/*.mo )
+`),
+			ExpectedHeader:   swupd.BundleHeader{Title: "fake"},
+			ExpectedIncludes: []string{"a"},
+			ExpectedExcludes: []string{"/usr/share/doc", "/usr/share/locale/*/LC_MESSAGES/*.mo"},
+			ExpectedPackages: map[string]bool{"pkg1": true},
+		},
+		{
+			Contents: []byte(`# Bundle with pinned packages
+# [TITLE]: fake
+pkg1=1.2.3-4
+pkg2 = 1:2.0-1.x86_64 # Comment
+pkg3
+`),
+			ExpectedHeader:   swupd.BundleHeader{Title: "fake"},
+			ExpectedPackages: map[string]bool{"pkg1": true, "pkg2": true, "pkg3": true},
+			ExpectedPins: map[string]*packagePin{
+				"pkg1": {Version: "1.2.3-4"},
+				"pkg2": {Version: "1:2.0-1", Arch: "x86_64"},
+			},
+		},
+
 		// Error cases.
 		{Contents: []byte(`include(`), ShouldFail: true},
 		{Contents: []byte(`()`), ShouldFail: true},
@@ -93,6 +123,15 @@ pkg1 # [TITLE]: wrongtitle
 		{Contents: []byte(`Also-add(`), ShouldFail: true},
 		{Contents: []byte(`also-add())`), ShouldFail: true},
 		{Contents: []byte(`also-add(abc))`), ShouldFail: true},
+		{Contents: []byte(`exclude(/usr/share/doc`), ShouldFail: true},
+		{Contents: []byte(`exclude(usr/share/doc)`), ShouldFail: true},
+		{Contents: []byte(`exclude(/usr/share/[doc)`), ShouldFail: true},
+		{Contents: []byte(`content(`), ShouldFail: true},
+		{Contents: []byte(`content()`), ShouldFail: true},
+		{Contents: []byte(`content(dir, root)`), ShouldFail: true},
+		{Contents: []byte(`pkg1=`), ShouldFail: true},
+		{Contents: []byte(`pkg1=1.2.3`), ShouldFail: true},
+		{Contents: []byte(`=1.2.3-4`), ShouldFail: true},
 	}
 
 	for _, tt := range tests {
@@ -122,9 +161,99 @@ pkg1 # [TITLE]: wrongtitle
 			t.Errorf("got wrong optional includes when parsing bundle\nCONTENTS:\n%s\nPARSED OPTIONAL INCLUDES (%d): %s\nEXPECTED OPTIONAL INCLUDES (%d): %s", tt.Contents, len(b.OptionalIncludes), b.OptionalIncludes, len(tt.ExpectedOptional), tt.ExpectedOptional)
 		}
 
+		if !reflect.DeepEqual(b.Excludes, tt.ExpectedExcludes) {
+			t.Errorf("got wrong excludes when parsing bundle\nCONTENTS:\n%s\nPARSED EXCLUDES (%d): %s\nEXPECTED EXCLUDES (%d): %s", tt.Contents, len(b.Excludes), b.Excludes, len(tt.ExpectedExcludes), tt.ExpectedExcludes)
+		}
+
 		if !reflect.DeepEqual(b.DirectPackages, tt.ExpectedPackages) {
 			t.Errorf("got wrong packages when parsing bundle\nCONTENTS:\n%s\nPARSED PACKAGES (%d):\n%v\nEXPECTED PACKAGES (%d):\n%v", tt.Contents, len(b.DirectPackages), b.DirectPackages, len(tt.ExpectedPackages), tt.ExpectedPackages)
 		}
+
+		if !reflect.DeepEqual(b.PackagePins, tt.ExpectedPins) {
+			t.Errorf("got wrong package pins when parsing bundle\nCONTENTS:\n%s\nPARSED PINS: %v\nEXPECTED PINS: %v", tt.Contents, b.PackagePins, tt.ExpectedPins)
+		}
+	}
+}
+
+func TestExcludeFilesForBundle(t *testing.T) {
+	b := &bundle{
+		Name:     "fake",
+		Excludes: []string{"/usr/share/doc", "/usr/share/locale/*/LC_MESSAGES/*.mo", "/usr/share/clear/*"},
+		Files:    make(map[string]bool),
+	}
+	addFileAndPath(b.Files,
+		"/usr/bin/fake",
+		"/usr/share/doc/fake/README",
+		"/usr/share/locale/de/LC_MESSAGES/fake.mo",
+		"/usr/share/locale/de/LC_MESSAGES/other.txt",
+		"/usr/share/clear/bundles/fake",
+	)
+
+	excludeFilesForBundle(b)
+
+	expected := map[string]bool{
+		"/usr":                             true,
+		"/usr/bin":                         true,
+		"/usr/bin/fake":                    true,
+		"/usr/share":                       true,
+		"/usr/share/locale":                true,
+		"/usr/share/locale/de":             true,
+		"/usr/share/locale/de/LC_MESSAGES": true,
+		"/usr/share/locale/de/LC_MESSAGES/other.txt": true,
+		"/usr/share/clear":                           true,
+		"/usr/share/clear/bundles":                   true,
+		"/usr/share/clear/bundles/fake":              true,
+	}
+	if !reflect.DeepEqual(b.Files, expected) {
+		t.Errorf("got wrong files after excludes\nFILES: %v\nEXPECTED: %v", b.Files, expected)
+	}
+}
+
+func TestRemoveExcludedFromFull(t *testing.T) {
+	fullDir, err := ioutil.TempDir("", "exclude-full-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(fullDir)
+	}()
+
+	for _, f := range []string{
+		"/usr/bin/fake",
+		"/usr/share/doc/fake/README",
+		"/usr/share/doc/fake/html/index.html",
+		"/usr/share/doc/other/README",
+	} {
+		if err = os.MkdirAll(filepath.Join(fullDir, filepath.Dir(f)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(filepath.Join(fullDir, f), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	set := bundleSet{
+		"fake": &bundle{
+			Name:     "fake",
+			Excludes: []string{"/usr/share/doc"},
+			Files:    map[string]bool{"/usr": true, "/usr/bin": true, "/usr/bin/fake": true},
+		},
+		"other": &bundle{
+			Name:  "other",
+			Files: map[string]bool{"/usr/share/doc/other": true, "/usr/share/doc/other/README": true},
+		},
+	}
+	if err = removeExcludedFromFull(set, fullDir); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range []string{"/usr/bin/fake", "/usr/share/doc", "/usr/share/doc/other/README"} {
+		if _, err = os.Stat(filepath.Join(fullDir, f)); err != nil {
+			t.Errorf("%s was removed from the full chroot: %s", f, err)
+		}
+	}
+	if _, err = os.Stat(filepath.Join(fullDir, "/usr/share/doc/fake")); !os.IsNotExist(err) {
+		t.Errorf("excluded directory /usr/share/doc/fake was not removed from the full chroot")
 	}
 }
 
@@ -0,0 +1,451 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/clearlinux/mixer-tools/internal/rpm"
+	"github.com/clearlinux/mixer-tools/swupd"
+	"github.com/pkg/errors"
+)
+
+type changelogFormat int
+
+// Enum of available changelog formats
+const (
+	MarkdownChangelog changelogFormat = iota // Markdown report
+	JSONChangelog                            // JSON object
+)
+
+// ReleaseNotesFile is the name of the changelog from the previous version
+// written to the www directory of a version.
+const ReleaseNotesFile = "release-notes.json"
+
+// Types of changes in a changelog
+const (
+	changeAdded      = "added"
+	changeRemoved    = "removed"
+	changeModified   = "modified"
+	changeUpgraded   = "upgraded"
+	changeDowngraded = "downgraded"
+)
+
+// changelog has the changes between two built versions of the mix.
+type changelog struct {
+	FromVersion uint32
+	ToVersion   uint32
+	Bundles     []*bundleChange
+	Files       fileChanges
+}
+
+// bundleChange is a bundle added, removed or modified. The versions are the
+// ones of the bundle manifest.
+type bundleChange struct {
+	Name        string
+	Change      string
+	FromVersion uint32 `json:",omitempty"`
+	ToVersion   uint32 `json:",omitempty"`
+	Packages    []*packageChange
+	Files       fileChanges
+}
+
+type packageChange struct {
+	Name        string
+	Change      string
+	FromVersion string `json:",omitempty"`
+	ToVersion   string `json:",omitempty"`
+}
+
+type fileChanges struct {
+	Added    int
+	Removed  int
+	Modified int
+}
+
+func (c *fileChanges) add(o fileChanges) {
+	c.Added += o.Added
+	c.Removed += o.Removed
+	c.Modified += o.Modified
+}
+
+func (c fileChanges) String() string {
+	return fmt.Sprintf("%d added, %d removed, %d modified", c.Added, c.Removed, c.Modified)
+}
+
+// versionContent is the content of a built version of the mix.
+type versionContent struct {
+	version uint32
+
+	// bundles maps the bundles to the version of their manifest.
+	bundles map[string]uint32
+
+	// packages maps the bundles to the names of their packages. Bundles
+	// without a bundle-info file are not listed.
+	packages map[string]map[string]bool
+
+	// versions maps the packages installed to their version.
+	versions map[string]string
+}
+
+// readVersionContent reads the content of a version from its Manifest.MoM and
+// the bundle-info files and package lock of its build. Version 0 has no content.
+func readVersionContent(stateDir string, version uint32) (*versionContent, error) {
+	c := &versionContent{
+		version:  version,
+		bundles:  make(map[string]uint32),
+		packages: make(map[string]map[string]bool),
+		versions: make(map[string]string),
+	}
+	if version == 0 {
+		return c, nil
+	}
+
+	mom, err := swupd.ParseManifestFile(filepath.Join(stateDir, "www", fmt.Sprint(version), "Manifest.MoM"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't read Manifest.MoM of version %d", version)
+	}
+	imageDir := filepath.Join(stateDir, "image", fmt.Sprint(version))
+	var infos []*swupd.BundleInfo
+	for _, f := range mom.Files {
+		if f.Type != swupd.TypeManifest || f.Status == swupd.StatusDeleted {
+			continue
+		}
+		c.bundles[f.Name] = f.Version
+
+		var info swupd.BundleInfo
+		content, err := ioutil.ReadFile(filepath.Join(imageDir, f.Name+"-info"))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err == nil {
+			err = json.Unmarshal(content, &info)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't read bundle-info of %s in version %d", f.Name, version)
+		}
+		infos = append(infos, &info)
+		c.packages[f.Name] = make(map[string]bool)
+		for _, name := range bundlePackageNames(&info) {
+			c.packages[f.Name][name] = true
+		}
+	}
+
+	c.versions, err = readPackageVersions(imageDir, infos)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// readPackageVersions returns the versions of the packages in a build, from
+// its package lock. Builds without a lock fall back to the file names of the
+// RPMs in the bundle-info files, which have no epoch.
+func readPackageVersions(imageDir string, infos []*swupd.BundleInfo) (map[string]string, error) {
+	versions := make(map[string]string)
+	set := func(name, evr string) {
+		// Packages of multiple architectures are listed once, with
+		// their latest version.
+		if v, ok := versions[name]; !ok || rpm.CompareEVR(evr, v) > 0 {
+			versions[name] = evr
+		}
+	}
+
+	lock, err := readPackageLock(filepath.Join(imageDir, PackageLockFile))
+	if err == nil {
+		for _, p := range lock.Packages {
+			set(p.Name, p.evr())
+		}
+		return versions, nil
+	}
+	if !os.IsNotExist(errors.Cause(err)) {
+		return nil, err
+	}
+
+	for _, info := range infos {
+		for file := range info.AllRpmPackages {
+			if p := parseRPMFilename(file); p != nil {
+				set(p.Name, p.evr())
+			}
+		}
+	}
+	return versions, nil
+}
+
+// readManifestHashes returns the hashes of the files in the manifest of a
+// bundle, excluding deleted and ghosted files.
+func readManifestHashes(stateDir, bundle string, version uint32) (map[string]string, error) {
+	m, err := swupd.ParseManifestFile(filepath.Join(stateDir, "www", fmt.Sprint(version), "Manifest."+bundle))
+	if err != nil {
+		return nil, err
+	}
+	hashes := make(map[string]string, len(m.Files))
+	for _, f := range m.Files {
+		if f.Status == swupd.StatusUnset {
+			hashes[f.Name] = f.Hash.String()
+		}
+	}
+	return hashes, nil
+}
+
+// createChangelog compares the content of two versions. Bundles are modified
+// when their manifest or packages changed.
+func createChangelog(stateDir string, from, to *versionContent) (*changelog, error) {
+	cl := &changelog{
+		FromVersion: from.version,
+		ToVersion:   to.version,
+		Bundles:     []*bundleChange{},
+	}
+
+	names := make(map[string]bool)
+	for name := range from.bundles {
+		names[name] = true
+	}
+	for name := range to.bundles {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	for _, name := range sorted {
+		fromVer, inFrom := from.bundles[name]
+		toVer, inTo := to.bundles[name]
+		bc := &bundleChange{
+			Name:        name,
+			FromVersion: fromVer,
+			ToVersion:   toVer,
+			Packages:    []*packageChange{},
+		}
+		// Packages can't be compared when the bundle-info file of one of
+		// the builds is gone.
+		fromPkgs, fromOK := from.packages[name]
+		toPkgs, toOK := to.packages[name]
+		if (fromOK || !inFrom) && (toOK || !inTo) {
+			bc.Packages = diffBundlePackages(fromPkgs, toPkgs, from.versions, to.versions)
+		}
+		switch {
+		case !inFrom:
+			bc.Change = changeAdded
+		case !inTo:
+			bc.Change = changeRemoved
+		case fromVer != toVer || len(bc.Packages) > 0:
+			bc.Change = changeModified
+		default:
+			continue
+		}
+
+		fromFiles := map[string]string{}
+		toFiles := map[string]string{}
+		var err error
+		if inFrom && fromVer != toVer {
+			if fromFiles, err = readManifestHashes(stateDir, name, fromVer); err != nil {
+				return nil, err
+			}
+		}
+		if inTo && fromVer != toVer {
+			if toFiles, err = readManifestHashes(stateDir, name, toVer); err != nil {
+				return nil, err
+			}
+		}
+		for f, hash := range toFiles {
+			if fromHash, ok := fromFiles[f]; !ok {
+				bc.Files.Added++
+			} else if fromHash != hash {
+				bc.Files.Modified++
+			}
+		}
+		for f := range fromFiles {
+			if _, ok := toFiles[f]; !ok {
+				bc.Files.Removed++
+			}
+		}
+
+		cl.Files.add(bc.Files)
+		cl.Bundles = append(cl.Bundles, bc)
+	}
+	return cl, nil
+}
+
+// diffBundlePackages compares the packages of a bundle in two versions.
+func diffBundlePackages(from, to map[string]bool, fromVersions, toVersions map[string]string) []*packageChange {
+	names := make(map[string]bool)
+	for name := range from {
+		names[name] = true
+	}
+	for name := range to {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	changes := []*packageChange{}
+	for _, name := range sorted {
+		pc := &packageChange{Name: name}
+		switch {
+		case !from[name]:
+			pc.Change = changeAdded
+			pc.ToVersion = toVersions[name]
+		case !to[name]:
+			pc.Change = changeRemoved
+			pc.FromVersion = fromVersions[name]
+		default:
+			pc.FromVersion = fromVersions[name]
+			pc.ToVersion = toVersions[name]
+			if pc.FromVersion == "" || pc.ToVersion == "" {
+				continue
+			}
+			c := rpm.CompareEVR(pc.FromVersion, pc.ToVersion)
+			if c == 0 {
+				continue
+			}
+			pc.Change = changeUpgraded
+			if c > 0 {
+				pc.Change = changeDowngraded
+			}
+		}
+		changes = append(changes, pc)
+	}
+	return changes
+}
+
+func (cl *changelog) writeJSON(w io.Writer) error {
+	content, err := json.MarshalIndent(cl, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(content, '\n'))
+	return err
+}
+
+// writeMarkdown writes the changelog as a Markdown document, with a section
+// per type of bundle change.
+func (cl *changelog) writeMarkdown(w io.Writer) error {
+	// Write errors are kept by the buffered writer and returned by Flush.
+	bw := bufio.NewWriter(w)
+	_, _ = fmt.Fprintf(bw, "# Changes from version %d to %d\n\n", cl.FromVersion, cl.ToVersion)
+	if len(cl.Bundles) == 0 {
+		_, _ = fmt.Fprintln(bw, "No changes.")
+		return bw.Flush()
+	}
+
+	counts := make(map[string]int)
+	for _, bc := range cl.Bundles {
+		counts[bc.Change]++
+	}
+	_, _ = fmt.Fprintf(bw, "- Bundles: %d added, %d removed, %d modified\n", counts[changeAdded], counts[changeRemoved], counts[changeModified])
+	_, _ = fmt.Fprintf(bw, "- Files: %s\n", cl.Files)
+
+	sections := []struct {
+		change, title string
+	}{
+		{changeAdded, "Added bundles"},
+		{changeRemoved, "Removed bundles"},
+		{changeModified, "Modified bundles"},
+	}
+	for _, s := range sections {
+		if counts[s.change] == 0 {
+			continue
+		}
+		_, _ = fmt.Fprintf(bw, "\n## %s\n", s.title)
+		for _, bc := range cl.Bundles {
+			if bc.Change != s.change {
+				continue
+			}
+			_, _ = fmt.Fprintf(bw, "\n### %s\n\nFiles: %s\n", bc.Name, bc.Files)
+			if len(bc.Packages) == 0 {
+				continue
+			}
+			_, _ = fmt.Fprintf(bw, "\n| Package | Change | From | To |\n| --- | --- | --- | --- |\n")
+			for _, pc := range bc.Packages {
+				_, _ = fmt.Fprintf(bw, "| %s | %s | %s | %s |\n", pc.Name, pc.Change, pc.FromVersion, pc.ToVersion)
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// Changelog writes the changes between two built versions of the mix to w in
+// the given format. A zero to is the current mix version, and a zero from is
+// the previous version of to.
+func (b *Builder) Changelog(w io.Writer, from, to uint32, format changelogFormat) error {
+	stateDir := b.Config.Builder.ServerStateDir
+	if to == 0 {
+		to = b.MixVerUint32
+	}
+	if from == 0 {
+		mom, err := swupd.ParseManifestFile(filepath.Join(stateDir, "www", fmt.Sprint(to), "Manifest.MoM"))
+		if err != nil {
+			return errors.Wrapf(err, "couldn't read Manifest.MoM of version %d", to)
+		}
+		from = mom.Header.Previous
+	}
+
+	cl, err := b.createChangelog(from, to)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case JSONChangelog:
+		return cl.writeJSON(w)
+	default:
+		return cl.writeMarkdown(w)
+	}
+}
+
+func (b *Builder) createChangelog(from, to uint32) (*changelog, error) {
+	stateDir := b.Config.Builder.ServerStateDir
+	fromContent, err := readVersionContent(stateDir, from)
+	if err != nil {
+		return nil, err
+	}
+	toContent, err := readVersionContent(stateDir, to)
+	if err != nil {
+		return nil, err
+	}
+	return createChangelog(stateDir, fromContent, toContent)
+}
+
+// writeReleaseNotes writes the changelog from the previous version to the www
+// directory of the version.
+func (b *Builder) writeReleaseNotes(previous, version uint32) error {
+	cl, err := b.createChangelog(previous, version)
+	if err != nil {
+		return errors.Wrap(err, "couldn't create release notes")
+	}
+	f, err := os.Create(filepath.Join(b.Config.Builder.ServerStateDir, "www", fmt.Sprint(version), ReleaseNotesFile))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	if err = cl.writeJSON(f); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,206 @@
+package builder
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeTestBundleInfo writes a bundle-info file with the given packages, which
+// are RPM file names when they end with .rpm.
+func writeTestBundleInfo(t *testing.T, path string, packages ...string) {
+	t.Helper()
+	info := &bundle{AllPackages: make(map[string]bool), AllRpmPackages: make(map[string]bool)}
+	for _, p := range packages {
+		if strings.HasSuffix(p, ".rpm") {
+			info.AllRpmPackages[p] = true
+		} else {
+			info.AllPackages[p] = true
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeBundleInfo(info, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChangelog(t *testing.T) {
+	stateDir, err := ioutil.TempDir("", "changelog-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(stateDir)
+	}()
+
+	hash := func(c string) string { return strings.Repeat(c, 64) }
+	www := filepath.Join(stateDir, "www")
+	image := filepath.Join(stateDir, "image")
+
+	// Version 10.
+	writeTestManifest(t, filepath.Join(www, "10", "Manifest.MoM"), 10,
+		"M...\t"+hash("1")+"\t10\teditors",
+		"M...\t"+hash("1")+"\t10\told",
+		"M...\t"+hash("1")+"\t10\tos-core",
+	)
+	writeTestManifest(t, filepath.Join(www, "10", "Manifest.os-core"), 10,
+		"F...\t"+hash("1")+"\t10\t/a",
+		"F...\t"+hash("1")+"\t10\t/b",
+		"F...\t"+hash("1")+"\t10\t/c",
+	)
+	writeTestManifest(t, filepath.Join(www, "10", "Manifest.editors"), 10, "F...\t"+hash("1")+"\t10\t/e")
+	writeTestManifest(t, filepath.Join(www, "10", "Manifest.old"), 10, "F...\t"+hash("1")+"\t10\t/old")
+	// Version 10 has no package lock, so the versions come from the RPM
+	// file names. The latest version of a multilib package is used.
+	writeTestBundleInfo(t, filepath.Join(image, "10", "os-core-info"),
+		"a-1.0-1.x86_64.rpm", "a-0.9-1.i686.rpm", "b-1.0-1.x86_64.rpm", "e-2.0-1.x86_64.rpm", "gone-1-1.noarch.rpm")
+	writeTestBundleInfo(t, filepath.Join(image, "10", "editors-info"), "vim-8.0-1.x86_64.rpm")
+	writeTestBundleInfo(t, filepath.Join(image, "10", "old-info"), "oldpkg-3-1.noarch.rpm")
+
+	// Version 20.
+	writeTestFile(t, filepath.Join(www, "20", "Manifest.MoM"), strings.Replace(`MANIFEST	30
+version:	20
+previous:	10
+filecount:	3
+timestamp:	1500000000
+contentsize:	0
+
+M...	H	10	editors
+M...	H	20	new
+M...	H	20	os-core
+`, "H", hash("2"), -1))
+	writeTestManifest(t, filepath.Join(www, "20", "Manifest.os-core"), 20,
+		"F...\t"+hash("1")+"\t10\t/a",
+		"F...\t"+hash("2")+"\t20\t/b",
+		".d..\t"+hash("0")+"\t20\t/c",
+		"F...\t"+hash("1")+"\t20\t/d",
+	)
+	writeTestManifest(t, filepath.Join(www, "20", "Manifest.new"), 20,
+		"F...\t"+hash("1")+"\t20\t/new1",
+		"L...\t"+hash("1")+"\t20\t/new2",
+	)
+	writeTestBundleInfo(t, filepath.Join(image, "20", "os-core-info"), "a", "b", "e", "newpkg")
+	writeTestBundleInfo(t, filepath.Join(image, "20", "editors-info"), "vim")
+	writeTestBundleInfo(t, filepath.Join(image, "20", "new-info"), "n")
+	lock := &packageLock{Packages: []*lockedPackage{
+		{Name: "a", Version: "1.1", Release: "1"},
+		{Name: "b", Version: "0.9", Release: "1"},
+		{Name: "e", Epoch: "1", Version: "1.0", Release: "1"},
+		{Name: "newpkg", Version: "1", Release: "1"},
+		{Name: "vim", Version: "8.0", Release: "1"},
+		{Name: "n", Epoch: "1", Version: "2", Release: "1"},
+	}}
+	if err = writePackageLock(lock, filepath.Join(image, "20", PackageLockFile)); err != nil {
+		t.Fatal(err)
+	}
+
+	b := New()
+	b.Config.Builder.ServerStateDir = stateDir
+	b.MixVerUint32 = 20
+
+	cl, err := b.createChangelog(10, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &changelog{
+		FromVersion: 10,
+		ToVersion:   20,
+		Bundles: []*bundleChange{
+			{
+				Name:      "new",
+				Change:    changeAdded,
+				ToVersion: 20,
+				Packages:  []*packageChange{{Name: "n", Change: changeAdded, ToVersion: "1:2-1"}},
+				Files:     fileChanges{Added: 2},
+			},
+			{
+				Name:        "old",
+				Change:      changeRemoved,
+				FromVersion: 10,
+				Packages:    []*packageChange{{Name: "oldpkg", Change: changeRemoved, FromVersion: "3-1"}},
+				Files:       fileChanges{Removed: 1},
+			},
+			{
+				Name:        "os-core",
+				Change:      changeModified,
+				FromVersion: 10,
+				ToVersion:   20,
+				Packages: []*packageChange{
+					{Name: "a", Change: changeUpgraded, FromVersion: "1.0-1", ToVersion: "1.1-1"},
+					{Name: "b", Change: changeDowngraded, FromVersion: "1.0-1", ToVersion: "0.9-1"},
+					// The epoch makes a lower version an upgrade.
+					{Name: "e", Change: changeUpgraded, FromVersion: "2.0-1", ToVersion: "1:1.0-1"},
+					{Name: "gone", Change: changeRemoved, FromVersion: "1-1"},
+					{Name: "newpkg", Change: changeAdded, ToVersion: "1-1"},
+				},
+				Files: fileChanges{Added: 1, Removed: 1, Modified: 1},
+			},
+		},
+		Files: fileChanges{Added: 3, Removed: 2, Modified: 1},
+	}
+	if !reflect.DeepEqual(cl, expected) {
+		got, _ := json.MarshalIndent(cl, "", "  ")
+		t.Fatalf("unexpected changelog:\n%s", got)
+	}
+
+	// The previous version of the current mix version is used by default.
+	var buf bytes.Buffer
+	if err = b.Changelog(&buf, 0, 0, MarkdownChangelog); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"# Changes from version 10 to 20\n",
+		"- Bundles: 1 added, 1 removed, 1 modified\n",
+		"- Files: 3 added, 2 removed, 1 modified\n",
+		"## Modified bundles\n\n### os-core\n\nFiles: 1 added, 1 removed, 1 modified\n",
+		"| b | downgraded | 1.0-1 | 0.9-1 |\n",
+	} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("%q missing from Markdown output:\n%s", s, buf.String())
+		}
+	}
+
+	if err = b.writeReleaseNotes(10, 20); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(www, "20", ReleaseNotesFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var notes changelog
+	if err = json.Unmarshal(content, &notes); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&notes, expected) {
+		t.Errorf("unexpected release notes:\n%s", content)
+	}
+
+	// The first version has every bundle added.
+	buf.Reset()
+	if err = b.Changelog(&buf, 0, 10, JSONChangelog); err != nil {
+		t.Fatal(err)
+	}
+	if err = json.Unmarshal(buf.Bytes(), &notes); err != nil {
+		t.Fatal(err)
+	}
+	if len(notes.Bundles) != 3 || notes.Files.Added != 5 {
+		t.Errorf("unexpected changelog for first version:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,316 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-ini/ini"
+	"github.com/pkg/errors"
+)
+
+// Keys of the repository sections in the DNF configuration.
+const (
+	dnfKeyName           = "name"
+	dnfKeyBaseURL        = "baseurl"
+	dnfKeyEnabled        = "enabled"
+	dnfKeyPriority       = "priority"
+	dnfKeyGPGCheck       = "gpgcheck"
+	dnfKeyGPGKey         = "gpgkey"
+	dnfKeyExcludePkgs    = "excludepkgs"
+	dnfKeyIncludePkgs    = "includepkgs"
+	dnfKeyModuleHotfixes = "module_hotfixes"
+	dnfKeyProxy          = "proxy"
+	dnfKeyProxyUsername  = "proxy_username"
+	dnfKeyProxyPassword  = "proxy_password"
+)
+
+// dnfMainSection is the section with the global DNF options. It can't be used
+// as the name of a repository.
+const dnfMainSection = "main"
+
+// dnfDefaultPriority is the priority DNF uses for repositories that don't set
+// one. Lower values have higher priority.
+const dnfDefaultPriority = 99
+
+// DNFRepo is a repository configured in the DNF configuration used by mixer.
+// Fields not set in the configuration have the defaults used by DNF.
+type DNFRepo struct {
+	ID             string   `json:"id"`
+	Name           string   `json:"name"`
+	BaseURL        string   `json:"baseurl"`
+	Enabled        bool     `json:"enabled"`
+	Priority       int      `json:"priority"`
+	GPGCheck       bool     `json:"gpgcheck"`
+	GPGKey         string   `json:"gpgkey,omitempty"`
+	ExcludePkgs    []string `json:"excludepkgs,omitempty"`
+	IncludePkgs    []string `json:"includepkgs,omitempty"`
+	ModuleHotfixes bool     `json:"module_hotfixes"`
+	Proxy          string   `json:"proxy,omitempty"`
+	ProxyUsername  string   `json:"proxy_username,omitempty"`
+	// The password is not included in the JSON output of the repositories.
+	ProxyPassword string `json:"-"`
+}
+
+// dnfConfig is the DNF configuration file used by mixer. Changes are made on
+// the parsed file, so keys not handled by mixer and comments are kept.
+type dnfConfig struct {
+	path string
+	file *ini.File
+}
+
+func loadDNFConfig(path string) (*dnfConfig, error) {
+	f, err := ini.Load(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load DNF configuration, try initializing workspace")
+	}
+	return &dnfConfig{path: path, file: f}, nil
+}
+
+// save writes the configuration back to its file. A proxy password is stored
+// in plain text, so the file is only readable by its owner when it has one.
+func (c *dnfConfig) save() error {
+	perm := os.FileMode(0644)
+	if c.hasProxyPassword() {
+		perm = 0600
+	}
+	f, err := os.OpenFile(c.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	// OpenFile doesn't change the permissions of an existing file.
+	if perm == 0600 {
+		if err = f.Chmod(perm); err != nil {
+			return err
+		}
+	}
+	if _, err = c.file.WriteTo(f); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// hasProxyPassword returns whether any section sets a proxy password.
+func (c *dnfConfig) hasProxyPassword() bool {
+	for _, s := range c.file.Sections() {
+		if s.HasKey(dnfKeyProxyPassword) && s.Key(dnfKeyProxyPassword).Value() != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func isDNFRepoSection(name string) bool {
+	return name != ini.DEFAULT_SECTION && name != dnfMainSection
+}
+
+// section returns the section of the repository id.
+func (c *dnfConfig) section(id string) (*ini.Section, error) {
+	if !isDNFRepoSection(id) {
+		return nil, errors.Errorf("invalid repo name %q", id)
+	}
+	s, err := c.file.GetSection(id)
+	if err != nil {
+		return nil, errors.Errorf("repo %s does not exist in %s", id, c.path)
+	}
+	return s, nil
+}
+
+// hasRepo returns whether the repository id is configured.
+func (c *dnfConfig) hasRepo(id string) bool {
+	_, err := c.file.GetSection(id)
+	return err == nil && isDNFRepoSection(id)
+}
+
+// repos returns the configured repositories, in the order they appear in the
+// configuration.
+func (c *dnfConfig) repos() []*DNFRepo {
+	var repos []*DNFRepo
+	for _, s := range c.file.Sections() {
+		if isDNFRepoSection(s.Name()) {
+			repos = append(repos, parseDNFRepo(s))
+		}
+	}
+	return repos
+}
+
+// repo returns the repository id.
+func (c *dnfConfig) repo(id string) (*DNFRepo, error) {
+	s, err := c.section(id)
+	if err != nil {
+		return nil, err
+	}
+	return parseDNFRepo(s), nil
+}
+
+func parseDNFRepo(s *ini.Section) *DNFRepo {
+	value := func(key string) string {
+		if !s.HasKey(key) {
+			return ""
+		}
+		return s.Key(key).Value()
+	}
+	boolValue := func(key string, def bool) bool {
+		if !s.HasKey(key) {
+			return def
+		}
+		v, err := s.Key(key).Bool()
+		if err != nil {
+			return def
+		}
+		return v
+	}
+
+	r := &DNFRepo{
+		ID:             s.Name(),
+		Name:           value(dnfKeyName),
+		BaseURL:        value(dnfKeyBaseURL),
+		Enabled:        boolValue(dnfKeyEnabled, true),
+		Priority:       dnfDefaultPriority,
+		GPGCheck:       boolValue(dnfKeyGPGCheck, false),
+		GPGKey:         value(dnfKeyGPGKey),
+		ExcludePkgs:    splitDNFList(value(dnfKeyExcludePkgs)),
+		IncludePkgs:    splitDNFList(value(dnfKeyIncludePkgs)),
+		ModuleHotfixes: boolValue(dnfKeyModuleHotfixes, false),
+		Proxy:          value(dnfKeyProxy),
+		ProxyUsername:  value(dnfKeyProxyUsername),
+		ProxyPassword:  value(dnfKeyProxyPassword),
+	}
+	if p, err := strconv.Atoi(value(dnfKeyPriority)); err == nil {
+		r.Priority = p
+	}
+	return r
+}
+
+// splitDNFList splits list options, which DNF accepts separated by spaces or
+// commas.
+func splitDNFList(s string) []string {
+	list := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	})
+	if len(list) == 0 {
+		return nil
+	}
+	return list
+}
+
+// addRepo adds a new enabled repository id with the given URL, using the same
+// settings as the repositories created by mixer.
+func (c *dnfConfig) addRepo(id, url string) error {
+	if !isDNFRepoSection(id) {
+		return errors.Errorf("invalid repo name %q", id)
+	}
+	if c.hasRepo(id) {
+		return errors.Errorf("repo %s already exists in %s, not adding duplicate", id, c.path)
+	}
+	s, err := c.file.NewSection(id)
+	if err != nil {
+		return err
+	}
+	settings := [][2]string{
+		{dnfKeyName, id},
+		{"failovermethod", "priority"},
+		{dnfKeyBaseURL, url},
+		{dnfKeyEnabled, "1"},
+		{dnfKeyGPGCheck, "0"},
+		{dnfKeyPriority, "1"},
+	}
+	for _, kv := range settings {
+		if _, err = s.NewKey(kv[0], kv[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// set sets the key of the repository id, or removes it if value is empty.
+func (c *dnfConfig) set(id, key, value string) error {
+	s, err := c.section(id)
+	if err != nil {
+		return err
+	}
+	return setDNFKey(s, key, value)
+}
+
+func setDNFKey(s *ini.Section, key, value string) error {
+	if value == "" {
+		s.DeleteKey(key)
+		return nil
+	}
+	if s.HasKey(key) {
+		s.Key(key).SetValue(value)
+		return nil
+	}
+	_, err := s.NewKey(key, value)
+	return err
+}
+
+func dnfBool(v bool) string {
+	if v {
+		return "1"
+	}
+	return "0"
+}
+
+// setProxy sets the proxy of the repository id, or the global proxy used by
+// all repositories when id is "main". An empty url removes the proxy
+// settings.
+func (c *dnfConfig) setProxy(id, url, username, password string) error {
+	var s *ini.Section
+	var err error
+	if id == dnfMainSection {
+		s = c.file.Section(dnfMainSection)
+	} else if s, err = c.section(id); err != nil {
+		return err
+	}
+
+	if url == "" {
+		username, password = "", ""
+	}
+	settings := [][2]string{
+		{dnfKeyProxy, url},
+		{dnfKeyProxyUsername, username},
+		{dnfKeyProxyPassword, password},
+	}
+	for _, kv := range settings {
+		if err = setDNFKey(s, kv[0], kv[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gpgKeyURL returns the URL used in the gpgkey option for a key file. Values
+// that are already URLs are kept, except plain http ones since mixer refuses
+// to download keys without https.
+func gpgKeyURL(key string) (string, error) {
+	if strings.HasPrefix(key, "http://") {
+		return "", errors.Errorf("invalid GPG key %s: use https or a local file", key)
+	}
+	if key == "" || strings.Contains(key, "://") {
+		return key, nil
+	}
+	abs, err := filepath.Abs(key)
+	if err != nil {
+		return "", err
+	}
+	return "file://" + abs, nil
+}
@@ -0,0 +1,167 @@
+package builder
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testDNFConf = `[main]
+cachedir=/var/cache/yum/clear/
+
+[clear]
+name=Clear
+baseurl=https://example.com/clear/
+enabled=1
+gpgcheck=0
+timeout=45
+`
+
+func TestDNFConf(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "dnf-conf-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(testDir)
+	}()
+
+	b := New()
+	b.Config.LoadDefaultsForPath(testDir)
+	b.Config.Builder.DNFConf = filepath.Join(testDir, "dnf.conf")
+	b.Config.Mixer.LocalRepoDir = ""
+	if err = ioutil.WriteFile(b.Config.Builder.DNFConf, []byte(testDNFConf), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = b.AddRepo("extra", "file:///srv/extra"); err != nil {
+		t.Fatal(err)
+	}
+	if err = b.AddRepo("extra", "file:///srv/extra"); err == nil {
+		t.Error("unexpected success adding duplicate repo")
+	}
+	if err = b.AddRepo("main", "file:///srv/main"); err == nil {
+		t.Error("unexpected success adding repo named main")
+	}
+
+	key := filepath.Join(testDir, "key.asc")
+	if err = ioutil.WriteFile(key, []byte("key"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = b.SetRepoGPGCheck("clear", true, ""); err == nil {
+		t.Error("unexpected success enabling gpgcheck without a key")
+	}
+	steps := []error{
+		b.SetRepoPriority("clear", 10),
+		b.SetRepoEnabled("extra", false),
+		b.SetRepoGPGCheck("clear", true, key),
+		b.SetIncludesRepo("extra", "foo bar*"),
+		b.SetExcludesRepo("clear", "baz"),
+		b.SetRepoModuleHotfixes("extra", true),
+		b.SetRepoProxy("extra", "http://proxy:3128", "user", "secret"),
+		b.SetRepoProxy("main", "http://global:3128", "", ""),
+	}
+	for i, err := range steps {
+		if err != nil {
+			t.Fatalf("step %d failed: %s", i, err)
+		}
+	}
+	if err = b.SetRepoPriority("clear", 0); err == nil {
+		t.Error("unexpected success setting invalid priority")
+	}
+	if err = b.SetRepoPriority("extra", 1000); err != nil {
+		t.Errorf("unexpected error setting a priority above the default: %s", err)
+	}
+	if err = b.SetRepoPriority("extra", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err = b.SetRepoEnabled("missing", true); err == nil {
+		t.Error("unexpected success enabling missing repo")
+	}
+
+	repos, err := b.Repos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*DNFRepo{
+		{
+			ID:          "clear",
+			Name:        "Clear",
+			BaseURL:     "https://example.com/clear/",
+			Enabled:     true,
+			Priority:    10,
+			GPGCheck:    true,
+			GPGKey:      "file://" + key,
+			ExcludePkgs: []string{"baz"},
+		},
+		{
+			ID:             "extra",
+			Name:           "extra",
+			BaseURL:        "file:///srv/extra",
+			Priority:       1,
+			IncludePkgs:    []string{"foo", "bar*"},
+			ModuleHotfixes: true,
+			Proxy:          "http://proxy:3128",
+			ProxyUsername:  "user",
+			ProxyPassword:  "secret",
+		},
+	}
+	if !reflect.DeepEqual(repos, expected) {
+		for i := range repos {
+			t.Logf("got %+v", repos[i])
+		}
+		t.Fatalf("unexpected repos")
+	}
+
+	raw, err := ioutil.ReadFile(b.Config.Builder.DNFConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Settings not handled by mixer are kept.
+	for _, s := range []string{"timeout", "cachedir", "http://global:3128"} {
+		if !strings.Contains(string(raw), s) {
+			t.Errorf("%s missing from DNF conf:\n%s", s, raw)
+		}
+	}
+
+	// The proxy password is stored in plain text.
+	fi, err := os.Stat(b.Config.Builder.DNFConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("got mode %o for DNF conf with a proxy password, want 600", fi.Mode().Perm())
+	}
+
+	// Removing the proxy removes the credentials too.
+	if err = b.SetRepoProxy("extra", "", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err = b.ListRepos(&buf, true); err != nil {
+		t.Fatal(err)
+	}
+	var listed []map[string]interface{}
+	if err = json.Unmarshal(buf.Bytes(), &listed); err != nil {
+		t.Fatalf("invalid JSON output: %s\n%s", err, buf.String())
+	}
+	if len(listed) != 2 || listed[1]["id"] != "extra" || listed[1]["enabled"] != false {
+		t.Errorf("unexpected JSON output:\n%s", buf.String())
+	}
+	if strings.Contains(buf.String(), "proxy") || strings.Contains(buf.String(), "secret") {
+		t.Errorf("proxy settings not removed:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if err = b.ListRepos(&buf, false); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "clear\thttps://example.com/clear/\nextra\tfile:///srv/extra\n" {
+		t.Errorf("unexpected output:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,312 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/clearlinux/mixer-tools/internal/rpm"
+	"github.com/pkg/errors"
+)
+
+// PackageLockFile is the name of the lock file written to the image directory
+// of each version.
+const PackageLockFile = "packages.lock"
+
+// packageLock records the exact packages installed in a build, so the build
+// can be reproduced.
+type packageLock struct {
+	Version         string
+	UpstreamVersion string
+	Packages        []*lockedPackage
+}
+
+type lockedPackage struct {
+	Name     string
+	Epoch    string `json:",omitempty"`
+	Version  string
+	Release  string
+	Arch     string
+	Repo     string
+	Checksum string
+
+	// License is informational and is not compared with the locked
+	// packages, so locks written before it was recorded still match.
+	License string `json:",omitempty"`
+}
+
+// evr returns the [epoch:]version-release of the package, in the same form
+// used by dnf output and package pins.
+func (p *lockedPackage) evr() string {
+	evr := p.Version + "-" + p.Release
+	if p.Epoch != "" && p.Epoch != "0" {
+		evr = p.Epoch + ":" + evr
+	}
+	return evr
+}
+
+// sameAs returns whether p and o are the same package.
+func (p *lockedPackage) sameAs(o *lockedPackage) bool {
+	a, b := *p, *o
+	a.License, b.License = "", ""
+	return a == b
+}
+
+// key returns the name.arch of the package. Packages installed for more than
+// one arch have an entry for each of them in the lock.
+func (p *lockedPackage) key() string {
+	return p.Name + "." + p.Arch
+}
+
+// metadata returns the package in the same form as the packages resolved by
+// dnf.
+func (p *lockedPackage) metadata() packageMetadata {
+	return packageMetadata{name: p.Name, arch: p.Arch, version: p.evr(), repo: p.Repo}
+}
+
+func (p *lockedPackage) String() string {
+	return fmt.Sprintf("%s-%s.%s (%s, %s)", p.Name, p.evr(), p.Arch, p.Repo, p.Checksum)
+}
+
+func readPackageLock(filename string) (*packageLock, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't read package lock")
+	}
+	var lock packageLock
+	if err = json.Unmarshal(content, &lock); err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse package lock %s", filename)
+	}
+	return &lock, nil
+}
+
+func writePackageLock(lock *packageLock, filename string) error {
+	content, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, append(content, '\n'), 0644)
+}
+
+// DefaultPackageLock returns the package lock used by a locked build when no
+// lock file is given: the lock of the mix version when it is rebuilt, or else
+// the lock of the previous mix version.
+func (b *Builder) DefaultPackageLock() (string, error) {
+	versions := []string{b.MixVer}
+	if prev := b.State.Mix.PreviousMixVer; prev != "" && prev != "0" && prev != b.MixVer {
+		versions = append(versions, prev)
+	}
+	for _, v := range versions {
+		filename := filepath.Join(b.Config.Builder.ServerStateDir, "image", v, PackageLockFile)
+		if _, err := os.Stat(filename); err == nil {
+			return filename, nil
+		}
+	}
+	return "", errors.Errorf("no package lock found for version %s, use --lock-file", strings.Join(versions, " or "))
+}
+
+// checkPins verifies that the packages pinned in bundles are locked to the
+// pinned version.
+func (lock *packageLock) checkPins(pins packagePins) error {
+	for _, p := range lock.Packages {
+		if pin, ok := pins[p.Name]; ok && !pin.matches(p.metadata()) {
+			return fmt.Errorf("package %s is pinned to %s in bundle %s but locked to %s", p.key(), pin.packagePin, pin.bundle, p.evr())
+		}
+	}
+	return nil
+}
+
+// checkResolved verifies that the packages resolved for a bundle are all in
+// the lock with their locked version, and come from the locked repositories.
+func (lock *packageLock) checkResolved(bundle *bundle, resolved []packageMetadata) error {
+	locked := make(map[string]*lockedPackage, len(lock.Packages))
+	for _, p := range lock.Packages {
+		locked[p.key()] = p
+	}
+	var errs []string
+	for _, pkg := range resolved {
+		p, ok := locked[packageKey(pkg)]
+		switch {
+		case !ok:
+			errs = append(errs, fmt.Sprintf("package %s is not in the package lock", nevra(pkg)))
+		case p.evr() != pkg.version:
+			errs = append(errs, fmt.Sprintf("package %s resolved to %s but is locked to %s", packageKey(pkg), pkg.version, p.evr()))
+		case p.Repo != pkg.repo:
+			errs = append(errs, fmt.Sprintf("package %s resolved from repo %s but is locked to repo %s", packageKey(pkg), pkg.repo, p.Repo))
+		}
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("packages for bundle %s drifted from the lock:\n%s", bundle.Name, strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+// diffPackageLocks returns a description of each difference in the packages
+// of two locks.
+func diffPackageLocks(expected, actual *packageLock) []string {
+	byKey := func(lock *packageLock) map[string]*lockedPackage {
+		m := make(map[string]*lockedPackage, len(lock.Packages))
+		for _, p := range lock.Packages {
+			m[p.key()] = p
+		}
+		return m
+	}
+	exp := byKey(expected)
+	act := byKey(actual)
+
+	var diffs []string
+	for key, e := range exp {
+		a, ok := act[key]
+		switch {
+		case !ok:
+			diffs = append(diffs, fmt.Sprintf("locked package %s was not installed", e))
+		case !a.sameAs(e):
+			diffs = append(diffs, fmt.Sprintf("package %s was installed instead of locked %s", a, e))
+		}
+	}
+	for key, a := range act {
+		if _, ok := exp[key]; !ok {
+			diffs = append(diffs, fmt.Sprintf("package %s is not in the package lock", a))
+		}
+	}
+	sort.Strings(diffs)
+	return diffs
+}
+
+// createPackageLock reads the headers of the RPMs installed to the full
+// chroot to create the package lock of the build. rpmRepos maps the RPM file
+// names to the repository they were resolved from.
+func createPackageLock(b *Builder, version string, rpmRepos map[string]string) (*packageLock, error) {
+	lock := &packageLock{
+		Version:         version,
+		UpstreamVersion: b.UpstreamVer,
+	}
+
+	var files []string
+	for file := range rpmPaths {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		path := rpmPaths[file]
+		p, err := readLockedPackage(path)
+		if err != nil {
+			return nil, err
+		}
+		checksum, err := sha256File(path)
+		if err != nil {
+			return nil, err
+		}
+		p.Repo = rpmRepos[file]
+		p.Checksum = "sha256:" + checksum
+		lock.Packages = append(lock.Packages, p)
+	}
+
+	sort.Slice(lock.Packages, func(i, j int) bool {
+		a, b := lock.Packages[i], lock.Packages[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Arch < b.Arch
+	})
+	return lock, nil
+}
+
+// readLockedPackage reads the name, version, arch and license of the RPM
+// file at path from its header.
+func readLockedPackage(path string) (*lockedPackage, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	p, err := rpm.ReadPackage(bufio.NewReader(f))
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't read package information for %s", path)
+	}
+	epoch := "0"
+	if e := p.Header.Ints(rpm.TagEpoch); len(e) > 0 {
+		epoch = strconv.FormatInt(e[0], 10)
+	}
+	return &lockedPackage{
+		Name:    p.Name(),
+		Epoch:   epoch,
+		Version: p.Header.String(rpm.TagVersion),
+		Release: p.Header.String(rpm.TagRelease),
+		Arch:    p.Header.String(rpm.TagArch),
+		License: p.Header.String(rpm.TagLicense),
+	}, nil
+}
+
+func sha256File(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// lockBuild writes the package lock for the build in buildVersionDir. When
+// expected is not nil, the build fails if the installed packages differ from
+// it, and their lock is written to a separate file.
+func lockBuild(b *Builder, set bundleSet, buildVersionDir, version string, expected *packageLock) error {
+	rpmRepos := make(map[string]string)
+	for _, bundle := range set {
+		for rpm, repo := range bundle.rpmRepos {
+			rpmRepos[rpm] = repo
+		}
+	}
+
+	lock, err := createPackageLock(b, version, rpmRepos)
+	if err != nil {
+		return errors.Wrap(err, "couldn't create package lock")
+	}
+
+	// A drifted lock must not replace the expected one, which may be the lock
+	// of this same version read by the next locked build.
+	filename := filepath.Join(buildVersionDir, PackageLockFile)
+	if expected != nil {
+		if diffs := diffPackageLocks(expected, lock); len(diffs) > 0 {
+			drifted := filename + ".drifted"
+			if err = writePackageLock(lock, drifted); err != nil {
+				return err
+			}
+			return fmt.Errorf("packages drifted from the package lock, the installed packages are in %s:\n%s", drifted, strings.Join(diffs, "\n"))
+		}
+	}
+	return writePackageLock(lock, filename)
+}
@@ -0,0 +1,284 @@
+package builder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testPackageLock() *packageLock {
+	return &packageLock{
+		Version:         "10",
+		UpstreamVersion: "29000",
+		Packages: []*lockedPackage{
+			{Name: "a", Version: "1.2", Release: "3", Arch: "x86_64", Repo: "clear", Checksum: "sha256:aa"},
+			{Name: "b", Epoch: "1", Version: "2.0", Release: "1", Arch: "noarch", Repo: "local", Checksum: "sha256:bb"},
+		},
+	}
+}
+
+func TestPackageLockReadWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "package-lock-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	lock := testPackageLock()
+	filename := filepath.Join(dir, PackageLockFile)
+	if err = writePackageLock(lock, filename); err != nil {
+		t.Fatalf("couldn't write package lock: %s", err)
+	}
+	read, err := readPackageLock(filename)
+	if err != nil {
+		t.Fatalf("couldn't read package lock: %s", err)
+	}
+	if !reflect.DeepEqual(lock, read) {
+		t.Errorf("package lock changed after writing and reading it\nWRITTEN: %+v\nREAD: %+v", lock, read)
+	}
+}
+
+func TestPackageLockCheckPins(t *testing.T) {
+	lock := testPackageLock()
+
+	pins := packagePins{"a": {packagePin: &packagePin{Version: "1.2-3"}, bundle: "x"}}
+	if err := lock.checkPins(pins); err != nil {
+		t.Fatalf("unexpected error checking pins: %s", err)
+	}
+
+	pins = packagePins{"a": {packagePin: &packagePin{Version: "1.2-4"}, bundle: "x"}}
+	if err := lock.checkPins(pins); err == nil {
+		t.Errorf("unexpected success checking lock with a conflicting pin")
+	}
+}
+
+func TestPackageLockExcludes(t *testing.T) {
+	lock := testPackageLock()
+	// Multilib packages are locked for each arch.
+	lock.Packages = append(lock.Packages, &lockedPackage{Name: "a", Version: "1.2", Release: "3", Arch: "i686", Repo: "clear"})
+
+	available := []packageMetadata{
+		{name: "a", arch: "x86_64", version: "1.2-3", repo: "clear"},
+		{name: "a", arch: "i686", version: "1.2-3", repo: "clear"},
+		// An update of a locked dependency.
+		{name: "a", arch: "x86_64", version: "1.2-4", repo: "clear"},
+		{name: "a", arch: "i686", version: "1.2-4", repo: "clear"},
+		{name: "b", arch: "noarch", version: "1:2.0-1", repo: "local"},
+		{name: "b", arch: "x86_64", version: "1:2.0-1", repo: "local"},
+		// Not locked, so it fails in checkResolved if resolved.
+		{name: "c", arch: "x86_64", version: "1-1", repo: "clear"},
+	}
+	excludes, err := pinnedExcludes(available, nil, lock)
+	if err != nil {
+		t.Fatalf("unexpected error excluding packages: %s", err)
+	}
+	expected := []string{"a-1.2-4.i686", "a-1.2-4.x86_64", "b-1:2.0-1.x86_64"}
+	if !reflect.DeepEqual(excludes, expected) {
+		t.Errorf("got excludes %v, want %v", excludes, expected)
+	}
+
+	_, err = pinnedExcludes(available[1:], nil, lock)
+	if err == nil || !strings.Contains(err.Error(), "locked package a-1.2-3.x86_64") {
+		t.Errorf("expected error for unavailable locked package, got %v", err)
+	}
+}
+
+func TestPackageLockCheckResolved(t *testing.T) {
+	lock := testPackageLock()
+	b := &bundle{Name: "x"}
+
+	resolved := []packageMetadata{
+		{name: "a", arch: "x86_64", version: "1.2-3", repo: "clear"},
+		{name: "b", arch: "noarch", version: "1:2.0-1", repo: "local"},
+	}
+	if err := lock.checkResolved(b, resolved); err != nil {
+		t.Errorf("unexpected error checking resolved packages: %s", err)
+	}
+
+	resolved[1].repo = "clear"
+	resolved = append(resolved, packageMetadata{name: "c", arch: "x86_64", version: "1-1", repo: "clear"})
+	resolved = append(resolved, packageMetadata{name: "a", arch: "i686", version: "1.2-3", repo: "clear"})
+	resolved[0].version = "1.2-4"
+	err := lock.checkResolved(b, resolved)
+	if err == nil {
+		t.Fatalf("unexpected success checking drifted packages")
+	}
+	for _, msg := range []string{
+		"package a.x86_64 resolved to 1.2-4 but is locked to 1.2-3",
+		"package b.noarch resolved from repo clear",
+		"package c-1-1.x86_64 is not in the package lock",
+		"package a-1.2-3.i686 is not in the package lock",
+	} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("missing %q in error: %s", msg, err)
+		}
+	}
+}
+
+func TestDiffPackageLocks(t *testing.T) {
+	expected := testPackageLock()
+	actual := testPackageLock()
+	if diffs := diffPackageLocks(expected, actual); len(diffs) != 0 {
+		t.Errorf("unexpected differences between equal locks: %v", diffs)
+	}
+
+	// Locks written before licenses were recorded still match.
+	actual.Packages[1].License = "MIT"
+	if diffs := diffPackageLocks(expected, actual); len(diffs) != 0 {
+		t.Errorf("unexpected differences in license: %v", diffs)
+	}
+
+	actual.Packages[0].Checksum = "sha256:cc"
+	actual.Packages = actual.Packages[:1]
+	actual.Packages = append(actual.Packages, &lockedPackage{Name: "c", Version: "1", Release: "1", Arch: "x86_64", Repo: "clear"})
+	diffs := diffPackageLocks(expected, actual)
+	if len(diffs) != 3 {
+		t.Fatalf("got %d differences when 3 were expected: %v", len(diffs), diffs)
+	}
+	if !strings.Contains(diffs[0], "locked package b-1:2.0-1.noarch") {
+		t.Errorf("missing removed package in differences: %v", diffs)
+	}
+
+	// The same package for another arch is a different package.
+	actual = testPackageLock()
+	multilib := *actual.Packages[0]
+	multilib.Arch = "i686"
+	actual.Packages = append(actual.Packages, &multilib)
+	diffs = diffPackageLocks(expected, actual)
+	if len(diffs) != 1 || !strings.Contains(diffs[0], "package a-1.2-3.i686") {
+		t.Errorf("got wrong differences for multilib package: %v", diffs)
+	}
+}
+
+func TestDefaultPackageLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "package-lock-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	b := New()
+	b.Config.Builder.ServerStateDir = dir
+	b.MixVer = "20"
+	b.State.Mix.PreviousMixVer = "10"
+	if _, err = b.DefaultPackageLock(); err == nil {
+		t.Errorf("unexpected success without package locks")
+	}
+
+	previous := filepath.Join(dir, "image", "10", PackageLockFile)
+	if err = os.MkdirAll(filepath.Dir(previous), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = writePackageLock(testPackageLock(), previous); err != nil {
+		t.Fatal(err)
+	}
+	if lockFile, err := b.DefaultPackageLock(); err != nil || lockFile != previous {
+		t.Errorf("got lock %q (%v), want the lock of the previous version", lockFile, err)
+	}
+
+	// A version being rebuilt uses its own lock.
+	current := filepath.Join(dir, "image", "20", PackageLockFile)
+	if err = os.MkdirAll(filepath.Dir(current), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = writePackageLock(testPackageLock(), current); err != nil {
+		t.Fatal(err)
+	}
+	if lockFile, err := b.DefaultPackageLock(); err != nil || lockFile != current {
+		t.Errorf("got lock %q (%v), want the lock of the mix version", lockFile, err)
+	}
+}
+
+// writeTestRPM writes an RPM file without payload whose header has the given
+// string tags.
+func writeTestRPM(t *testing.T, filename string, tags map[int32]string) {
+	t.Helper()
+	header := func(tags map[int32]string) []byte {
+		var index, store bytes.Buffer
+		for tag, value := range tags {
+			_ = binary.Write(&index, binary.BigEndian, []uint32{uint32(tag), 6, uint32(store.Len()), 1})
+			store.WriteString(value)
+			store.WriteByte(0)
+		}
+		var h bytes.Buffer
+		h.Write([]byte{0x8E, 0xAD, 0xE8, 0x01, 0, 0, 0, 0})
+		_ = binary.Write(&h, binary.BigEndian, []uint32{uint32(len(tags)), uint32(store.Len())})
+		h.Write(index.Bytes())
+		h.Write(store.Bytes())
+		return h.Bytes()
+	}
+
+	lead := make([]byte, 96)
+	copy(lead, []byte{0xED, 0xAB, 0xEE, 0xDB})
+	content := append(lead, header(nil)...)
+	content = append(content, header(tags)...)
+	if err := ioutil.WriteFile(filename, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLockBuildDrifted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "package-lock-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	rpmFile := filepath.Join(dir, "a-1.2-3.x86_64.rpm")
+	writeTestRPM(t, rpmFile, map[int32]string{1000: "a", 1001: "1.2", 1002: "3", 1022: "x86_64", 1014: "MIT"})
+	savedPaths := rpmPaths
+	defer func() {
+		rpmPaths = savedPaths
+	}()
+	rpmPaths = map[string]string{"a-1.2-3.x86_64.rpm": rpmFile}
+	set := bundleSet{"os-core": &bundle{rpmRepos: map[string]string{"a-1.2-3.x86_64.rpm": "clear"}}}
+
+	b := New()
+	if err = lockBuild(b, set, dir, "10", nil); err != nil {
+		t.Fatal(err)
+	}
+	lockFile := filepath.Join(dir, PackageLockFile)
+	lock, err := readPackageLock(lockFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := lock.Packages[0]
+	if len(lock.Packages) != 1 || p.key() != "a.x86_64" || p.evr() != "1.2-3" || p.Repo != "clear" || p.License != "MIT" {
+		t.Fatalf("unexpected lock %+v", lock.Packages)
+	}
+	if err = lockBuild(b, set, dir, "10", lock); err != nil {
+		t.Fatalf("unexpected drift from the same packages: %s", err)
+	}
+
+	// A changed RPM fails the locked build, without replacing the lock.
+	expected, err := ioutil.ReadFile(lockFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestRPM(t, rpmFile, map[int32]string{1000: "a", 1001: "1.2", 1002: "3", 1022: "x86_64", 1014: "GPL"})
+	err = lockBuild(b, set, dir, "10", lock)
+	if err == nil || !strings.Contains(err.Error(), "drifted") {
+		t.Fatalf("got error %v for drifted packages", err)
+	}
+	if content, err := ioutil.ReadFile(lockFile); err != nil || !bytes.Equal(content, expected) {
+		t.Errorf("package lock was changed by a drifted build (%v):\n%s", err, content)
+	}
+	drifted, err := readPackageLock(lockFile + ".drifted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if drifted.Packages[0].Checksum == p.Checksum {
+		t.Errorf("drifted lock has the locked checksum %s", p.Checksum)
+	}
+}
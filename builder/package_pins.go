@@ -0,0 +1,240 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/clearlinux/mixer-tools/helpers"
+	"github.com/pkg/errors"
+)
+
+var validPackageEVRRegex = regexp.MustCompile(`^([0-9]+:)?[A-Za-z0-9._+~^]+-[A-Za-z0-9._+~^]+$`)
+
+// knownArches are the architectures recognized as suffix of a package pin,
+// the same way dnf tells the arch apart from the release in a NEVRA.
+var knownArches = map[string]bool{
+	"aarch64": true,
+	"armv7hl": true,
+	"i386":    true,
+	"i686":    true,
+	"noarch":  true,
+	"ppc64le": true,
+	"s390x":   true,
+	"x86_64":  true,
+}
+
+// packagePin is the version a package must be installed with.
+type packagePin struct {
+	// Version has the form [epoch:]version-release.
+	Version string
+	Arch    string `json:",omitempty"`
+}
+
+func parsePackagePin(s string) (*packagePin, error) {
+	pin := &packagePin{Version: s}
+	if i := strings.LastIndex(s, "."); i > -1 && knownArches[s[i+1:]] {
+		pin.Version = s[:i]
+		pin.Arch = s[i+1:]
+	}
+	if !validPackageEVRRegex.MatchString(pin.Version) {
+		return nil, fmt.Errorf("%q must have the form [epoch:]version-release[.arch]", s)
+	}
+	return pin, nil
+}
+
+func (p *packagePin) String() string {
+	if p.Arch != "" {
+		return p.Version + "." + p.Arch
+	}
+	return p.Version
+}
+
+// spec returns the package spec for dnf that selects the pinned version of
+// the package name.
+func (p *packagePin) spec(name string) string {
+	return name + "-" + p.String()
+}
+
+// matches returns true when a package resolved by dnf has the pinned version.
+// The epoch is only compared if the pin has one.
+func (p *packagePin) matches(pkg packageMetadata) bool {
+	if p.Arch != "" && p.Arch != pkg.arch {
+		return false
+	}
+	version := pkg.version
+	if !strings.Contains(p.Version, ":") {
+		if i := strings.Index(version, ":"); i > -1 {
+			version = version[i+1:]
+		}
+	}
+	return version == p.Version
+}
+
+// packagePins are the pins of all bundles in a set, with the bundle defining
+// each of them.
+type packagePins map[string]*bundlePin
+
+type bundlePin struct {
+	*packagePin
+	bundle string
+}
+
+// collectPackagePins merges the package pins of all bundles in set. Since all
+// bundles are installed to the same chroot, the same package can't be pinned
+// to different versions.
+func collectPackagePins(set bundleSet) (packagePins, error) {
+	pins := make(packagePins)
+	for _, name := range getBundleSetKeysSorted(set) {
+		bundle := set[name]
+		for pkg, pin := range bundle.PackagePins {
+			if other, ok := pins[pkg]; ok && *other.packagePin != *pin {
+				return nil, fmt.Errorf("package %s is pinned to %s in bundle %s and to %s in bundle %s",
+					pkg, other.packagePin, other.bundle, pin, bundle.Name)
+			}
+			pins[pkg] = &bundlePin{packagePin: pin, bundle: bundle.Name}
+		}
+	}
+	return pins, nil
+}
+
+// pinnedExcludes returns the packages for dnf to exclude so only the pinned
+// version of each pinned package, and the locked version of each locked
+// package, can be installed. Excluding them from the whole transaction applies
+// the pins to the dependencies of the bundles too, not only to the packages
+// listed in them. The lock may be nil.
+func pinnedExcludes(available []packageMetadata, pins packagePins, lock *packageLock) ([]string, error) {
+	locked := make(map[string]*lockedPackage)
+	lockedNames := make(map[string]bool)
+	if lock != nil {
+		for _, p := range lock.Packages {
+			locked[p.key()] = p
+			lockedNames[p.Name] = true
+		}
+	}
+
+	foundPins := make(map[string]bool)
+	foundLocked := make(map[string]bool)
+	excluded := make(map[string]bool)
+	for _, pkg := range available {
+		allowed := true
+		if pin, ok := pins[pkg.name]; ok && !pin.matches(pkg) {
+			allowed = false
+		}
+		if lockedNames[pkg.name] {
+			if p, ok := locked[packageKey(pkg)]; !ok || p.evr() != pkg.version {
+				allowed = false
+			}
+		}
+		if !allowed {
+			excluded[nevra(pkg)] = true
+			continue
+		}
+		foundPins[pkg.name] = true
+		foundLocked[packageKey(pkg)] = true
+	}
+
+	var errs []string
+	for name, pin := range pins {
+		if !foundPins[name] {
+			errs = append(errs, fmt.Sprintf("pinned version %s of package %s (bundle %s) is not available",
+				pin.packagePin, name, pin.bundle))
+		}
+	}
+	for key, p := range locked {
+		if !foundLocked[key] {
+			errs = append(errs, fmt.Sprintf("locked package %s is not available", p))
+		}
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return nil, errors.New(strings.Join(errs, "\n"))
+	}
+
+	excludes := make([]string, 0, len(excluded))
+	for pkg := range excluded {
+		excludes = append(excludes, pkg)
+	}
+	sort.Strings(excludes)
+	return excludes, nil
+}
+
+// pinPackages returns packagerCmd with the versions of the packages pinned in
+// set, or locked in lock, other than the pinned and locked ones excluded, so
+// every dnf command run with it installs exactly those. It also returns the
+// pins, to check the packages resolved. The metadata of the repositories is
+// cached to emptyDir, the installroot where md finds it. The lock may be nil.
+func pinPackages(set bundleSet, packagerCmd []string, emptyDir string, md *repoMetadata, lock *packageLock) ([]string, packagePins, error) {
+	pins, err := collectPackagePins(set)
+	if err != nil {
+		return nil, nil, err
+	}
+	names := make(map[string]bool, len(pins))
+	for name := range pins {
+		names[name] = true
+	}
+	if lock != nil {
+		if err = lock.checkPins(pins); err != nil {
+			return nil, nil, err
+		}
+		for _, p := range lock.Packages {
+			names[p.Name] = true
+		}
+	}
+	if len(names) == 0 {
+		return packagerCmd, pins, nil
+	}
+
+	args := merge(packagerCmd, "--installroot="+emptyDir, "makecache")
+	if _, err = helpers.RunCommandOutputEnv(args[0], args[1:], []string{"LC_ALL=en_US.UTF-8"}); err != nil {
+		return nil, nil, errors.Wrap(err, "couldn't download the repository metadata")
+	}
+	available, err := md.available(names)
+	if err != nil {
+		return nil, nil, err
+	}
+	excludes, err := pinnedExcludes(available, pins, lock)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "couldn't apply package pins")
+	}
+	if len(excludes) == 0 {
+		return packagerCmd, pins, nil
+	}
+	return merge(packagerCmd, "--exclude="+strings.Join(excludes, ",")), pins, nil
+}
+
+// checkResolvedPins verifies that the packages resolved for a bundle have the
+// pinned versions.
+func checkResolvedPins(bundle *bundle, pins packagePins, resolved []packageMetadata) error {
+	var errs []string
+	for _, pkg := range resolved {
+		pin, ok := pins[pkg.name]
+		if !ok {
+			continue
+		}
+		if !pin.matches(pkg) {
+			errs = append(errs, fmt.Sprintf("package %s resolved to %s.%s but is pinned to %s in bundle %s",
+				pkg.name, pkg.version, pkg.arch, pin.packagePin, pin.bundle))
+		}
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("couldn't resolve packages for bundle %s:\n%s", bundle.Name, strings.Join(errs, "\n"))
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package builder
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPackagePinMatches(t *testing.T) {
+	testCases := []struct {
+		pin   string
+		pkg   packageMetadata
+		match bool
+	}{
+		{"1.2-3", packageMetadata{name: "p", arch: "x86_64", version: "1.2-3"}, true},
+		{"1.2-3", packageMetadata{name: "p", arch: "x86_64", version: "1.2-4"}, false},
+		{"1.2-3", packageMetadata{name: "p", arch: "x86_64", version: "2:1.2-3"}, true},
+		{"1:1.2-3", packageMetadata{name: "p", arch: "x86_64", version: "2:1.2-3"}, false},
+		{"1.2-3.x86_64", packageMetadata{name: "p", arch: "x86_64", version: "1.2-3"}, true},
+		{"1.2-3.noarch", packageMetadata{name: "p", arch: "x86_64", version: "1.2-3"}, false},
+		{"1.2-3.fc30", packageMetadata{name: "p", arch: "x86_64", version: "1.2-3.fc30"}, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.pin, func(t *testing.T) {
+			pin, err := parsePackagePin(tc.pin)
+			if err != nil {
+				t.Fatalf("unexpected error parsing pin %q: %s", tc.pin, err)
+			}
+			if pin.matches(tc.pkg) != tc.match {
+				t.Errorf("expected match to be %t for pin %q and %+v", tc.match, tc.pin, tc.pkg)
+			}
+		})
+	}
+}
+
+func TestCollectPackagePins(t *testing.T) {
+	set := bundleSet{
+		"a": &bundle{Name: "a", PackagePins: map[string]*packagePin{"p": {Version: "1.2-3"}}},
+		"b": &bundle{Name: "b", PackagePins: map[string]*packagePin{"p": {Version: "1.2-3"}, "q": {Version: "2-1"}}},
+	}
+	pins, err := collectPackagePins(set)
+	if err != nil {
+		t.Fatalf("unexpected error collecting pins: %s", err)
+	}
+	if len(pins) != 2 || pins["p"].Version != "1.2-3" || pins["q"].bundle != "b" {
+		t.Errorf("got wrong pins %v", pins)
+	}
+
+	set["b"].PackagePins["p"] = &packagePin{Version: "1.2-4"}
+	if _, err = collectPackagePins(set); err == nil {
+		t.Errorf("unexpected success collecting conflicting pins")
+	}
+}
+
+func TestCheckResolvedPins(t *testing.T) {
+	b := &bundle{Name: "a", AllPackages: map[string]bool{"p": true, "q": true}}
+	pins := packagePins{
+		"p": {packagePin: &packagePin{Version: "1.2-3"}, bundle: "a"},
+		"d": {packagePin: &packagePin{Version: "5-1"}, bundle: "b"},
+	}
+
+	resolved := []packageMetadata{
+		{name: "p", arch: "x86_64", version: "1.2-3"},
+		{name: "q", arch: "x86_64", version: "7-1"},
+	}
+	if err := checkResolvedPins(b, pins, resolved); err != nil {
+		t.Errorf("unexpected error checking pins: %s", err)
+	}
+
+	// A dependency resolved to a version different from its pin.
+	resolved = append(resolved, packageMetadata{name: "d", arch: "x86_64", version: "6-1"})
+	err := checkResolvedPins(b, pins, resolved)
+	if err == nil || !strings.Contains(err.Error(), "package d resolved to 6-1.x86_64") {
+		t.Errorf("expected error for dependency with wrong version, got %v", err)
+	}
+}
+
+func TestPinnedExcludes(t *testing.T) {
+	// d is not in any bundle, only a dependency of one.
+	pins := packagePins{
+		"p": {packagePin: &packagePin{Version: "1.2-3"}, bundle: "a"},
+		"d": {packagePin: &packagePin{Version: "5-1", Arch: "x86_64"}, bundle: "b"},
+	}
+	available := []packageMetadata{
+		{name: "p", arch: "x86_64", version: "1.2-3", repo: "clear"},
+		{name: "p", arch: "x86_64", version: "1.2-4", repo: "clear"},
+		{name: "d", arch: "x86_64", version: "5-1", repo: "clear"},
+		{name: "d", arch: "x86_64", version: "6-1", repo: "clear"},
+		{name: "d", arch: "i686", version: "5-1", repo: "clear"},
+		{name: "d", arch: "x86_64", version: "1:6-1", repo: "local"},
+		{name: "q", arch: "x86_64", version: "7-1", repo: "clear"},
+	}
+	excludes, err := pinnedExcludes(available, pins, nil)
+	if err != nil {
+		t.Fatalf("unexpected error excluding packages: %s", err)
+	}
+	expected := []string{"d-1:6-1.x86_64", "d-5-1.i686", "d-6-1.x86_64", "p-1.2-4.x86_64"}
+	if !reflect.DeepEqual(excludes, expected) {
+		t.Errorf("got excludes %v, want %v", excludes, expected)
+	}
+
+	// The pinned version of the dependency is not available.
+	_, err = pinnedExcludes(append(available[:2:2], available[3]), pins, nil)
+	if err == nil || !strings.Contains(err.Error(), "pinned version 5-1.x86_64 of package d (bundle b)") {
+		t.Errorf("expected error for unavailable pinned version, got %v", err)
+	}
+}
@@ -16,34 +16,24 @@ package builder
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"text/tabwriter"
 	"text/template"
 
 	"github.com/clearlinux/mixer-tools/helpers"
-	"github.com/go-ini/ini"
+	"github.com/clearlinux/mixer-tools/internal/repodata"
 	"github.com/pkg/errors"
 )
 
-type dnfRepoConf struct {
-	RepoName, RepoURL string
-}
-
-const dnfConfRepoTemplate = `
-[{{.RepoName}}]
-name={{.RepoName}}
-failovermethod=priority
-baseurl={{.RepoURL}}
-enabled=1
-gpgcheck=0
-priority=1
-`
-
 // If Base == true, template will include the [main] and [clear] sections.
 // If Local == true, template will include the [local] section.
 type dnfConf struct {
@@ -125,103 +115,123 @@ func (b *Builder) NewDNFConfIfNeeded() error {
 	return nil
 }
 
-// AddRepo adds and enables a repo configuration named <name> pointing at
-// URL <url>. It calls b.NewDNFConfIfNeeded() to create the DNF config if it
-// does not exist and performs a check to see if the repo passed has already
-// been configured.
-func (b *Builder) AddRepo(name, url string) error {
-	repo := dnfRepoConf{
-		RepoName: name,
-		RepoURL:  url,
-	}
-
+// editDNFConf loads the DNF configuration, creating it if needed, calls fn to
+// change it and saves it back.
+func (b *Builder) editDNFConf(fn func(c *dnfConfig) error) error {
 	if err := b.NewDNFConfIfNeeded(); err != nil {
 		return err
 	}
-
-	f, err := os.OpenFile(b.Config.Builder.DNFConf, os.O_APPEND|os.O_WRONLY, 0644)
+	c, err := loadDNFConfig(b.Config.Builder.DNFConf)
 	if err != nil {
-		log.Println(err)
 		return err
 	}
-
-	DNFConf, err := ini.Load(b.Config.Builder.DNFConf)
-	if err != nil {
+	if err = fn(c); err != nil {
 		return err
 	}
+	return c.save()
+}
 
-	_, err = DNFConf.GetSection(repo.RepoName)
-	if err == nil {
-		return fmt.Errorf("repo %s already exists in %s, not adding duplicate",
-			repo.RepoName,
-			b.Config.Builder.DNFConf)
-	}
-
-	t, err := template.New("dnfConfRepoTemplate").Parse(dnfConfRepoTemplate)
-	if err != nil {
-		log.Println(err)
-		return err
-	}
-
-	if err = t.Execute(f, repo); err != nil {
-		return errors.Wrapf(err, "Failed to write to dnf file: %s", b.Config.Builder.DNFConf)
-	}
-
-	return nil
+// AddRepo adds and enables a repo configuration named <name> pointing at
+// URL <url>. It calls b.NewDNFConfIfNeeded() to create the DNF config if it
+// does not exist and performs a check to see if the repo passed has already
+// been configured.
+func (b *Builder) AddRepo(name, url string) error {
+	return b.editDNFConf(func(c *dnfConfig) error {
+		return c.addRepo(name, url)
+	})
 }
 
 // SetURLRepo sets the URL for the repo <name> to <url>. If <name> does not exist it is
 // created.
 func (b *Builder) SetURLRepo(name, url string) error {
-	if err := b.NewDNFConfIfNeeded(); err != nil {
-		return err
-	}
-
-	DNFConf, err := ini.Load(b.Config.Builder.DNFConf)
-	if err != nil {
-		return err
-	}
+	return b.editDNFConf(func(c *dnfConfig) error {
+		if !c.hasRepo(name) {
+			// the section doesn't exist, just add a new one
+			return c.addRepo(name, url)
+		}
+		return c.set(name, dnfKeyBaseURL, url)
+	})
+}
 
-	s, err := DNFConf.GetSection(name)
-	if err != nil {
-		// the section doesn't exist, just add a new one
-		return b.AddRepo(name, url)
-	}
+// SetExcludesRepo sets the ecludes for the repo <name> to [pkgs...]
+func (b *Builder) SetExcludesRepo(reponame, pkgs string) error {
+	return b.editDNFConf(func(c *dnfConfig) error {
+		return c.set(reponame, dnfKeyExcludePkgs, pkgs)
+	})
+}
 
-	k, err := s.GetKey("baseurl")
-	if err != nil {
-		return err
-	}
+// SetIncludesRepo sets the includes for the repo <name> to [pkgs...], so
+// only those packages are used from the repo.
+func (b *Builder) SetIncludesRepo(reponame, pkgs string) error {
+	return b.editDNFConf(func(c *dnfConfig) error {
+		return c.set(reponame, dnfKeyIncludePkgs, pkgs)
+	})
+}
 
-	k.SetValue(url)
-	return DNFConf.SaveTo(b.Config.Builder.DNFConf)
+// SetRepoEnabled enables or disables the repo <name>. Disabled repos are
+// kept in the configuration but not used when building bundles.
+func (b *Builder) SetRepoEnabled(name string, enabled bool) error {
+	return b.editDNFConf(func(c *dnfConfig) error {
+		return c.set(name, dnfKeyEnabled, dnfBool(enabled))
+	})
 }
 
-// SetExcludesRepo sets the ecludes for the repo <name> to [pkgs...]
-func (b *Builder) SetExcludesRepo(reponame, pkgs string) error {
-	if err := b.NewDNFConfIfNeeded(); err != nil {
-		return err
+// SetRepoPriority sets the priority of the repo <name>. When the same package
+// is available in multiple repos, the one with the lowest priority value is
+// used. Repos without a priority have dnfDefaultPriority.
+func (b *Builder) SetRepoPriority(name string, priority int) error {
+	if priority < 1 {
+		return errors.Errorf("invalid priority %d, must be 1 or higher", priority)
 	}
+	return b.editDNFConf(func(c *dnfConfig) error {
+		return c.set(name, dnfKeyPriority, strconv.Itoa(priority))
+	})
+}
 
-	DNFConf, err := ini.Load(b.Config.Builder.DNFConf)
-	if err != nil {
-		return err
+// SetRepoGPGCheck enables or disables the signature check of the packages
+// in the repo <name>. The key may be a path to a local file or an URL, and is
+// required to enable the check unless the repo already has one.
+func (b *Builder) SetRepoGPGCheck(name string, enabled bool, key string) error {
+	if key != "" && !strings.Contains(key, "://") {
+		if _, err := os.Stat(key); err != nil {
+			return errors.Wrap(err, "invalid GPG key")
+		}
 	}
-
-	s, err := DNFConf.GetSection(reponame)
+	keyURL, err := gpgKeyURL(key)
 	if err != nil {
 		return err
 	}
-
-	k, err := s.GetKey("excludepkgs")
-	if err != nil {
-		if k, err = DNFConf.Section(reponame).NewKey("excludepkgs", pkgs); err != nil {
+	return b.editDNFConf(func(c *dnfConfig) error {
+		repo, err := c.repo(name)
+		if err != nil {
 			return err
 		}
-	}
+		if enabled && keyURL == "" && repo.GPGKey == "" {
+			return errors.Errorf("a GPG key is needed to check the packages of repo %s", name)
+		}
+		if keyURL != "" {
+			if err = c.set(name, dnfKeyGPGKey, keyURL); err != nil {
+				return err
+			}
+		}
+		return c.set(name, dnfKeyGPGCheck, dnfBool(enabled))
+	})
+}
+
+// SetRepoModuleHotfixes sets whether packages of the repo <name> are used
+// even when they are filtered out by modules.
+func (b *Builder) SetRepoModuleHotfixes(name string, enabled bool) error {
+	return b.editDNFConf(func(c *dnfConfig) error {
+		return c.set(name, dnfKeyModuleHotfixes, dnfBool(enabled))
+	})
+}
 
-	k.SetValue(pkgs)
-	return DNFConf.SaveTo(b.Config.Builder.DNFConf)
+// SetRepoProxy sets the proxy used to access the repo <name>, or all repos
+// when <name> is "main". An empty url removes the proxy settings.
+func (b *Builder) SetRepoProxy(name, url, username, password string) error {
+	return b.editDNFConf(func(c *dnfConfig) error {
+		return c.setProxy(name, url, username, password)
+	})
 }
 
 // WriteRepoURLOverrides writes a copy of the DNF conf file
@@ -232,44 +242,25 @@ func (b *Builder) WriteRepoURLOverrides(tmpConf *os.File, repoURLs map[string]st
 		return err
 	}
 
-	DNFConf, err := ini.Load(b.Config.Builder.DNFConf)
+	c, err := loadDNFConfig(b.Config.Builder.DNFConf)
 	if err != nil {
 		return err
 	}
 
 	for repo, url := range repoURLs {
-		s, err := DNFConf.GetSection(repo)
-		if err != nil {
+		if !c.hasRepo(repo) {
 			// No existing repo, add new section for repo/baseurl pair
-			s, err = DNFConf.NewSection(repo)
-			if err != nil {
-				return err
-			}
-
-			repoSettings := map[string]string{
-				"failovermethod": "priority",
-				"priority":       "1",
-				"enabled":        "1",
-			}
-			repoSettings["name"] = repo
-			repoSettings["baseurl"] = url
-
-			for key, val := range repoSettings {
-				if _, err = s.NewKey(key, val); err != nil {
-					return err
-				}
-			}
+			err = c.addRepo(repo, url)
 		} else {
 			// Override the baseurl for existing repo
-			k, err := s.GetKey("baseurl")
-			if err != nil {
-				return err
-			}
-			k.SetValue(url)
+			err = c.set(repo, dnfKeyBaseURL, url)
+		}
+		if err != nil {
+			return err
 		}
 	}
 
-	_, err = DNFConf.WriteTo(tmpConf)
+	_, err = c.file.WriteTo(tmpConf)
 	return err
 }
 
@@ -280,49 +271,65 @@ func (b *Builder) RemoveRepo(name string) error {
 		return err
 	}
 
-	DNFConf, err := ini.Load(b.Config.Builder.DNFConf)
+	c, err := loadDNFConfig(b.Config.Builder.DNFConf)
 	if err != nil {
 		return err
 	}
 
-	_, err = DNFConf.GetSection(name)
-	if err != nil {
+	if !c.hasRepo(name) {
 		fmt.Printf("Repo %s does not exist.\n", name)
 	}
 
-	DNFConf.DeleteSection(name)
-	return DNFConf.SaveTo(b.Config.Builder.DNFConf)
+	c.file.DeleteSection(name)
+	return c.save()
 }
 
-// ListRepos lists all configured repositories in the DNF configuration file.
+// Repos returns the repositories configured in the DNF configuration file.
 // This will fail if a DNF conf has not yet been generated.
-func (b *Builder) ListRepos() error {
+func (b *Builder) Repos() ([]*DNFRepo, error) {
 	if _, err := os.Stat(b.Config.Builder.DNFConf); os.IsNotExist(err) {
-		return errors.Wrap(err, "unable to find DNF configuration, try initializing workspace")
+		return nil, errors.Wrap(err, "unable to find DNF configuration, try initializing workspace")
 	}
 
-	DNFConf, err := ini.Load(b.Config.Builder.DNFConf)
+	c, err := loadDNFConfig(b.Config.Builder.DNFConf)
 	if err != nil {
-		return errors.Wrap(err, "unable to load DNF configration, try initializing workspace")
+		return nil, err
 	}
+	return c.repos(), nil
+}
 
-	for _, s := range DNFConf.Sections() {
-		name := s.Name()
-		if name == "" {
-			continue
+// ListRepos lists all configured repositories in the DNF configuration file,
+// either with their names and URLs or, if jsonOutput is set, as a JSON list
+// with all their settings.
+// This will fail if a DNF conf has not yet been generated.
+func (b *Builder) ListRepos(w io.Writer, jsonOutput bool) error {
+	repos, err := b.Repos()
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		if repos == nil {
+			repos = []*DNFRepo{}
 		}
+		content, err := json.MarshalIndent(repos, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", content)
+		return err
+	}
 
-		url := s.Key("baseurl").Value()
-		if url == "" {
+	for _, r := range repos {
+		if r.BaseURL == "" {
 			continue
 		}
-
-		fmt.Printf("%s\t%s\n", name, url)
+		_, _ = fmt.Fprintf(w, "%s\t%s\n", r.ID, r.BaseURL)
 	}
 	return nil
 }
 
-// AddRPMList copies rpms into the repodir and calls createrepo_c on it to
+// AddRPMList copies rpms into the repodir and updates its metadata to
 // generate a dnf-consumable repository for the bundle builder to use.
 func (b *Builder) AddRPMList(rpms []string) error {
 	if b.Config.Mixer.LocalRepoDir == "" {
@@ -333,10 +340,15 @@ func (b *Builder) AddRPMList(rpms []string) error {
 		return errors.Wrapf(err, "couldn't create LOCAL_REPO_DIR")
 	}
 	for _, rpm := range rpms {
-		localPath := filepath.Join(b.Config.Mixer.LocalRPMDir, rpm)
-		if err := checkRPM(localPath); err != nil {
+		if err := checkRPM(filepath.Join(b.Config.Mixer.LocalRPMDir, rpm)); err != nil {
 			return err
 		}
+	}
+	if err = b.verifyLocalRPMs(rpms); err != nil {
+		return err
+	}
+	for _, rpm := range rpms {
+		localPath := filepath.Join(b.Config.Mixer.LocalRPMDir, rpm)
 		// Skip RPM already in repo.
 		repoPath := filepath.Join(b.Config.Mixer.LocalRepoDir, rpm)
 		if _, err := os.Stat(repoPath); err == nil {
@@ -359,12 +371,110 @@ func (b *Builder) AddRPMList(rpms []string) error {
 		}
 	}
 
-	cmd := exec.Command("createrepo_c", ".")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Dir = b.Config.Mixer.LocalRepoDir
+	stats, err := repodata.Update(b.Config.Mixer.LocalRepoDir)
+	if err != nil {
+		return errors.Wrap(err, "couldn't generate metadata for LOCAL_REPO_DIR")
+	}
+	fmt.Printf("Updated local repository metadata: %d added, %d removed, %d unchanged\n", stats.Added, stats.Removed, stats.Reused)
+	return nil
+}
+
+// verifyLocalRPMs checks the signatures of the RPMs to be added to the local
+// repo against the keys trusted for it, before any of them is added. Source
+// RPMs are not checked since they are not added.
+func (b *Builder) verifyLocalRPMs(rpms []string) error {
+	verifier, err := b.newRPMVerifier()
+	if err != nil {
+		return err
+	}
+	files := make(map[string]string)
+	for _, rpm := range rpms {
+		if !strings.HasSuffix(rpm, ".src.rpm") {
+			files[filepath.Join(b.Config.Mixer.LocalRPMDir, rpm)] = "local"
+		}
+	}
+	return verifier.verify(files, b.NumBundleWorkers)
+}
+
+// localRepoPackages returns the packages in the metadata of the local repo,
+// or nil if the metadata was not generated yet.
+func (b *Builder) localRepoPackages() ([]*repodata.Package, error) {
+	if b.Config.Mixer.LocalRepoDir == "" {
+		return nil, errors.Errorf("LOCAL_REPO_DIR not set in configuration")
+	}
+	r, err := repodata.Open(b.Config.Mixer.LocalRepoDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.Packages()
+}
+
+// ListLocalRPMs writes a table with the RPMs in the local repo to w.
+func (b *Builder) ListLocalRPMs(w io.Writer) error {
+	pkgs, err := b.localRepoPackages()
+	if err != nil {
+		return err
+	}
+	if len(pkgs) == 0 {
+		_, _ = fmt.Fprintln(w, "No RPMs in the local repository.")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "FILE\tNAME\tVERSION\tARCH")
+	for _, p := range pkgs {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", p.Location, p.Name, p.EVR(), p.Arch)
+	}
+	return tw.Flush()
+}
+
+// RemoveLocalRPMs removes RPMs from the local repo and from LOCAL_RPM_DIR,
+// then updates the metadata of the local repo. Each name may be either the
+// file name of the RPM or the name of the package, in which case all the
+// RPMs of that package are removed.
+func (b *Builder) RemoveLocalRPMs(names []string) error {
+	pkgs, err := b.localRepoPackages()
+	if err != nil {
+		return err
+	}
+
+	// Find all the RPMs before removing any of them, so a typo doesn't leave
+	// the repository partially changed.
+	var remove []*repodata.Package
+	for _, name := range names {
+		found := false
+		for _, p := range pkgs {
+			if p.Filename() == name || p.Location == name || p.Name == name {
+				remove = append(remove, p)
+				found = true
+			}
+		}
+		if !found {
+			return errors.Errorf("couldn't find %s in the local repository", name)
+		}
+	}
+
+	for _, p := range remove {
+		paths := []string{filepath.Join(b.Config.Mixer.LocalRepoDir, filepath.FromSlash(p.Location))}
+		if b.Config.Mixer.LocalRPMDir != "" {
+			paths = append(paths, filepath.Join(b.Config.Mixer.LocalRPMDir, p.Filename()))
+		}
+		for _, path := range paths {
+			if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return errors.Wrapf(err, "couldn't remove %s", p.Filename())
+			}
+		}
+		fmt.Printf("Removed %s\n", p.Filename())
+	}
 
-	return cmd.Run()
+	_, err = repodata.Update(b.Config.Mixer.LocalRepoDir)
+	if err != nil {
+		return errors.Wrap(err, "couldn't update metadata for LOCAL_REPO_DIR")
+	}
+	return nil
 }
 
 // checkRPM returns nil if path contains a valid RPM file.
@@ -0,0 +1,101 @@
+package builder
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/clearlinux/mixer-tools/internal/repodata"
+)
+
+func TestLocalRPMs(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "local-rpms-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(testDir)
+	}()
+
+	b := New()
+	b.Config.LoadDefaultsForPath(testDir)
+	for _, dir := range []string{b.Config.Mixer.LocalRepoDir, b.Config.Mixer.LocalRPMDir} {
+		if err = os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err = b.ListLocalRPMs(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "No RPMs") {
+		t.Errorf("unexpected output for empty repository: %s", buf.String())
+	}
+
+	// The metadata matches the files, so updating it doesn't need to read
+	// them as RPMs.
+	var pkgs []*repodata.Package
+	for _, name := range []string{"foo", "bar"} {
+		filename := name + "-1.0-1.x86_64.rpm"
+		for _, dir := range []string{b.Config.Mixer.LocalRepoDir, b.Config.Mixer.LocalRPMDir} {
+			if err = ioutil.WriteFile(filepath.Join(dir, filename), []byte(name), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		fi, err := os.Stat(filepath.Join(b.Config.Mixer.LocalRepoDir, filename))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkgs = append(pkgs, &repodata.Package{
+			PkgID:        name,
+			ChecksumType: "sha256",
+			Name:         name,
+			Arch:         "x86_64",
+			Epoch:        "0",
+			Version:      "1.0",
+			Release:      "1",
+			Location:     filename,
+			TimeFile:     fi.ModTime().Unix(),
+			PackageSize:  fi.Size(),
+		})
+	}
+	if err = repodata.Write(b.Config.Mixer.LocalRepoDir, pkgs); err != nil {
+		t.Fatal(err)
+	}
+
+	buf.Reset()
+	if err = b.ListLocalRPMs(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "bar-1.0-1.x86_64.rpm  bar   1.0-1") {
+		t.Errorf("unexpected list output:\n%s", buf.String())
+	}
+
+	if err = b.RemoveLocalRPMs([]string{"baz"}); err == nil {
+		t.Error("unexpected success removing RPM not in the repository")
+	}
+	if err = b.RemoveLocalRPMs([]string{"foo"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{b.Config.Mixer.LocalRepoDir, b.Config.Mixer.LocalRPMDir} {
+		if _, err = os.Stat(filepath.Join(dir, "foo-1.0-1.x86_64.rpm")); !os.IsNotExist(err) {
+			t.Errorf("foo RPM was not removed from %s", dir)
+		}
+	}
+
+	r, err := repodata.Open(b.Config.Mixer.LocalRepoDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkgs, err = r.Packages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkgs) != 1 || pkgs[0].Name != "bar" {
+		t.Errorf("unexpected packages after removal: %+v", pkgs)
+	}
+}
@@ -0,0 +1,270 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/clearlinux/mixer-tools/internal/repodata"
+	"github.com/go-ini/ini"
+	"github.com/pkg/errors"
+)
+
+// defaultDNFCacheDir is used when the DNF conf doesn't set a cachedir.
+const defaultDNFCacheDir = "/var/cache/dnf"
+
+// dnfCacheDirRegex matches the directories where dnf caches the metadata of a
+// repository, named after the repository and a hash of its URL.
+var dnfCacheDirRegex = regexp.MustCompile(`^(.+)-[0-9a-f]{16}$`)
+
+// repoMetadata reads the metadata of the repositories configured in the DNF
+// conf directly, so packages and their files are looked up without running
+// dnf. Local repositories are read from their directory, and remote ones from
+// the dnf cache of the installroot used to resolve the packages of the mix,
+// which dnf fills when resolving them. dnf still resolves the dependencies of
+// the bundles, but every package it resolves must be found in the metadata.
+type repoMetadata struct {
+	// dirs has the local repositories. Remote ones are looked up in cacheDir
+	// when first used, since dnf may not have cached them yet.
+	dirs     map[string]string
+	cacheDir string
+	// enabled has the names of the enabled repositories.
+	enabled []string
+
+	mu    sync.Mutex
+	repos map[string]*repoIndex
+	errs  map[string]error
+}
+
+type repoIndex struct {
+	repo *repodata.Repository
+	// pkgs is indexed by name-[epoch:]version-release.arch, the same way
+	// packages are reported by dnf.
+	pkgs map[string]*repodata.Package
+	// filesRead has the packages whose files were read from the filelists.
+	filesRead map[*repodata.Package]bool
+}
+
+// newRepoMetadata finds the metadata directories of the repositories
+// configured in dnfConf. The metadata itself is only read when needed. dnf
+// prepends the installroot to its cachedir, so installRoot must be the one
+// passed to dnf when resolving the packages.
+func newRepoMetadata(dnfConf string, installRoot string) (*repoMetadata, error) {
+	conf, err := ini.Load(dnfConf)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load DNF configuration")
+	}
+
+	cacheDir := defaultDNFCacheDir
+	if main, err := conf.GetSection("main"); err == nil && main.HasKey("cachedir") {
+		cacheDir = main.Key("cachedir").Value()
+	}
+
+	m := &repoMetadata{
+		dirs:     make(map[string]string),
+		cacheDir: filepath.Join(installRoot, cacheDir),
+		repos:    make(map[string]*repoIndex),
+		errs:     make(map[string]error),
+	}
+	for _, s := range conf.Sections() {
+		name := s.Name()
+		if name == ini.DEFAULT_SECTION || name == "main" {
+			continue
+		}
+		url := s.Key("baseurl").Value()
+		if strings.HasPrefix(url, "file://") {
+			m.dirs[name] = strings.TrimPrefix(url, "file://")
+		}
+		if s.Key("enabled").MustBool(true) {
+			m.enabled = append(m.enabled, name)
+		}
+	}
+	return m, nil
+}
+
+// findCachedRepoDirs returns the directories in the dnf cache that have
+// metadata, by repository name. When a repository was cached more than once,
+// e.g. for different URLs, the most recent metadata is used.
+func findCachedRepoDirs(cacheDir string) map[string]string {
+	dirs := make(map[string]string)
+	modTimes := make(map[string]int64)
+
+	entries, err := filepath.Glob(filepath.Join(cacheDir, "*", "repodata", "repomd.xml"))
+	if err != nil {
+		return dirs
+	}
+	for _, repomd := range entries {
+		dir := filepath.Dir(filepath.Dir(repomd))
+		matches := dnfCacheDirRegex.FindStringSubmatch(filepath.Base(dir))
+		if matches == nil {
+			continue
+		}
+		fi, err := os.Stat(repomd)
+		if err != nil {
+			continue
+		}
+		name := matches[1]
+		if t, ok := modTimes[name]; !ok || fi.ModTime().UnixNano() > t {
+			dirs[name] = dir
+			modTimes[name] = fi.ModTime().UnixNano()
+		}
+	}
+	return dirs
+}
+
+// index returns the packages of repo, reading its metadata the first time.
+func (m *repoMetadata) index(repo string) (*repoIndex, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if idx, ok := m.repos[repo]; ok {
+		return idx, nil
+	}
+	if err, ok := m.errs[repo]; ok {
+		return nil, err
+	}
+	idx, err := m.readIndex(repo)
+	if err != nil {
+		m.errs[repo] = err
+		return nil, err
+	}
+	m.repos[repo] = idx
+	return idx, nil
+}
+
+func (m *repoMetadata) readIndex(repo string) (*repoIndex, error) {
+	dir, ok := m.dirs[repo]
+	if !ok {
+		if dir, ok = findCachedRepoDirs(m.cacheDir)[repo]; !ok {
+			return nil, errors.Errorf("couldn't find metadata of repo %s in %s", repo, m.cacheDir)
+		}
+	}
+	r, err := repodata.Open(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't read metadata of repo %s", repo)
+	}
+	pkgs, err := r.Packages()
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't read metadata of repo %s", repo)
+	}
+
+	idx := &repoIndex{
+		repo:      r,
+		pkgs:      make(map[string]*repodata.Package, len(pkgs)),
+		filesRead: make(map[*repodata.Package]bool),
+	}
+	for _, p := range pkgs {
+		idx.pkgs[p.NEVRA()] = p
+	}
+	return idx, nil
+}
+
+// nevra returns the key of a package resolved by dnf in repoIndex.pkgs.
+func nevra(pkg packageMetadata) string {
+	return pkg.name + "-" + pkg.version + "." + pkg.arch
+}
+
+// lookup returns the metadata of a package resolved by dnf. A package that is
+// not in the metadata of its repository is an error, since the packages
+// resolved by dnf always come from the metadata.
+func (m *repoMetadata) lookup(pkg packageMetadata) (*repodata.Package, error) {
+	idx, err := m.index(pkg.repo)
+	if err != nil {
+		return nil, err
+	}
+	p, ok := idx.pkgs[nevra(pkg)]
+	if !ok {
+		return nil, errors.Errorf("package %s not found in the metadata of repo %s", nevra(pkg), pkg.repo)
+	}
+	return p, nil
+}
+
+// available returns every version of the named packages in the enabled
+// repositories.
+func (m *repoMetadata) available(names map[string]bool) ([]packageMetadata, error) {
+	var result []packageMetadata
+	for _, repo := range m.enabled {
+		idx, err := m.index(repo)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range idx.pkgs {
+			if names[p.Name] {
+				result = append(result, packageMetadata{name: p.Name, arch: p.Arch, version: p.EVR(), repo: repo})
+			}
+		}
+	}
+	return result, nil
+}
+
+// readFiles reads the files of pkgs from the filelists of their repositories.
+// Each repository is read once for all the packages, since filelists are
+// large.
+func (m *repoMetadata) readFiles(pkgs repoRpmMap) error {
+	for repo, repoPkgs := range pkgs {
+		idx, err := m.index(repo)
+		if err != nil {
+			return err
+		}
+		var wanted []*repodata.Package
+		seen := make(map[*repodata.Package]bool)
+		for _, pkg := range repoPkgs {
+			p, err := m.lookup(pkg)
+			if err != nil {
+				return err
+			}
+			if !seen[p] && !idx.filesRead[p] {
+				seen[p] = true
+				wanted = append(wanted, p)
+			}
+		}
+		if len(wanted) == 0 {
+			continue
+		}
+		if err := idx.repo.ReadFiles(wanted); err != nil {
+			return errors.Wrapf(err, "couldn't read file lists of repo %s", repo)
+		}
+		m.mu.Lock()
+		for _, p := range wanted {
+			idx.filesRead[p] = true
+		}
+		m.mu.Unlock()
+	}
+	return nil
+}
+
+// files returns the files of a package, which must have been read with
+// readFiles.
+func (m *repoMetadata) files(pkg packageMetadata) ([]string, error) {
+	p, err := m.lookup(pkg)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	read := m.repos[pkg.repo].filesRead[p]
+	m.mu.Unlock()
+	if !read {
+		return nil, errors.Errorf("files of package %s were not read", nevra(pkg))
+	}
+	files := make([]string, 0, len(p.Files))
+	for _, f := range p.Files {
+		files = append(files, f.Name)
+	}
+	return files, nil
+}
@@ -0,0 +1,163 @@
+package builder
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testRepomdXML = `<?xml version="1.0" encoding="UTF-8"?>
+<repomd xmlns="http://linux.duke.edu/metadata/repo">
+  <data type="primary">
+    <location href="repodata/primary.xml.gz"/>
+  </data>
+  <data type="filelists">
+    <location href="repodata/filelists.xml.gz"/>
+  </data>
+</repomd>
+`
+
+const testPrimaryXML = `<?xml version="1.0" encoding="UTF-8"?>
+<metadata xmlns="http://linux.duke.edu/metadata/common" xmlns:rpm="http://linux.duke.edu/metadata/rpm">
+<package type="rpm">
+  <name>%[1]s</name>
+  <arch>x86_64</arch>
+  <version epoch="0" ver="1.0" rel="1"/>
+  <checksum type="sha256" pkgid="YES">%[1]s-id</checksum>
+  <size package="1" installed="1234" archive="1"/>
+  <location href="Packages/%[1]s-1.0-1.x86_64.rpm"/>
+</package>
+</metadata>
+`
+
+const testFilelistsXML = `<?xml version="1.0" encoding="UTF-8"?>
+<filelists xmlns="http://linux.duke.edu/metadata/filelists">
+<package pkgid="%[1]s-id" name="%[1]s" arch="x86_64">
+  <version epoch="0" ver="1.0" rel="1"/>
+  <file>/usr/bin/%[1]s</file>
+  <file type="dir">/usr/share/%[1]s</file>
+</package>
+</filelists>
+`
+
+func writeTestRepo(t *testing.T, dir, pkg string) {
+	t.Helper()
+	repodataDir := filepath.Join(dir, "repodata")
+	if err := os.MkdirAll(repodataDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(repodataDir, "repomd.xml"), []byte(testRepomdXML), 0644); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"primary.xml.gz":   fmt.Sprintf(testPrimaryXML, pkg),
+		"filelists.xml.gz": fmt.Sprintf(testFilelistsXML, pkg),
+	}
+	for name, content := range files {
+		f, err := os.Create(filepath.Join(repodataDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := gzip.NewWriter(f)
+		_, _ = w.Write([]byte(content))
+		_ = w.Close()
+		_ = f.Close()
+	}
+}
+
+func TestRepoMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "repo-metadata-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	localDir := filepath.Join(dir, "local")
+	cacheDir := filepath.Join(dir, "cache")
+	writeTestRepo(t, localDir, "foo")
+	writeTestRepo(t, filepath.Join(cacheDir, "clear-0123456789abcdef"), "bar")
+	// Not a dnf cache directory, so it is ignored.
+	writeTestRepo(t, filepath.Join(cacheDir, "other"), "baz")
+
+	conf := fmt.Sprintf("[main]\ncachedir=%s\n\n[clear]\nbaseurl=https://example.com/\n\n[other]\nbaseurl=https://example.com/\n\n[local]\nbaseurl=file://%s\n", cacheDir, localDir)
+	confFile := filepath.Join(dir, "dnf.conf")
+	if err = ioutil.WriteFile(confFile, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	md, err := newRepoMetadata(confFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	foo := packageMetadata{name: "foo", arch: "x86_64", version: "1.0-1", repo: "local"}
+	bar := packageMetadata{name: "bar", arch: "x86_64", version: "1.0-1", repo: "clear"}
+	baz := packageMetadata{name: "baz", arch: "x86_64", version: "1.0-1", repo: "other"}
+	oldFoo := packageMetadata{name: "foo", arch: "x86_64", version: "0.9-1", repo: "local"}
+
+	if p, err := md.lookup(foo); err != nil || p.Filename() != "foo-1.0-1.x86_64.rpm" || p.InstalledSize != 1234 {
+		t.Errorf("unexpected lookup result for foo: %+v, %v", p, err)
+	}
+	if _, err := md.lookup(bar); err != nil {
+		t.Errorf("bar not found in cached metadata: %s", err)
+	}
+	if _, err := md.lookup(baz); err == nil {
+		t.Errorf("baz found in metadata not cached by dnf")
+	}
+	if _, err := md.lookup(oldFoo); err == nil {
+		t.Errorf("found foo for a version not in the repository")
+	}
+
+	if _, err := md.files(foo); err == nil {
+		t.Errorf("files of foo available before being read")
+	}
+	if err = md.readFiles(repoRpmMap{"local": {foo}, "clear": {bar}}); err != nil {
+		t.Fatal(err)
+	}
+
+	b := &bundle{Name: "test"}
+	err = resolveFilesForBundle(b, repoRpmMap{"local": {foo}, "clear": {bar}}, md)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{"/usr/bin/foo", "/usr/share/foo", "/usr/bin/bar", "/usr/share/bar", "/usr/share/clear/bundles/test"} {
+		if !b.Files[f] {
+			t.Errorf("missing file %s in bundle", f)
+		}
+	}
+
+	if err = resolveFilesForBundle(b, repoRpmMap{"local": {oldFoo}}, md); err == nil {
+		t.Errorf("resolved files of a package not in the metadata")
+	}
+}
+
+func TestRepoMetadataInstallRoot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "repo-metadata-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	// dnf caches the metadata under the installroot used for resolving.
+	writeTestRepo(t, filepath.Join(dir, "root", "var", "cache", "dnf", "clear-0123456789abcdef"), "bar")
+	confFile := filepath.Join(dir, "dnf.conf")
+	if err = ioutil.WriteFile(confFile, []byte("[main]\ncachedir=/var/cache/dnf\n\n[clear]\nbaseurl=https://example.com/\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	md, err := newRepoMetadata(confFile, filepath.Join(dir, "root"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bar := packageMetadata{name: "bar", arch: "x86_64", version: "1.0-1", repo: "clear"}
+	if _, err = md.lookup(bar); err != nil {
+		t.Errorf("bar not found in the cache of the installroot: %s", err)
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/clearlinux/mixer-tools/helpers"
+	"github.com/clearlinux/mixer-tools/internal/rpm"
+	"github.com/pkg/errors"
+)
+
+// rpmVerifier checks the signatures of RPMs against the keys trusted for the
+// repository they come from. Repositories are trusted through the gpgcheck
+// and gpgkey options of the DNF configuration, the RPMs of repositories
+// without gpgcheck enabled are not checked. A nil rpmVerifier checks nothing.
+type rpmVerifier struct {
+	keyrings map[string]*rpm.Keyring
+
+	// unchecked are the repositories without gpgcheck enabled.
+	unchecked map[string]bool
+}
+
+// newRPMVerifier loads the keys of the repositories with gpgcheck enabled in
+// the DNF configuration. A missing configuration has no trusted repositories.
+func (b *Builder) newRPMVerifier() (*rpmVerifier, error) {
+	v := &rpmVerifier{
+		keyrings:  make(map[string]*rpm.Keyring),
+		unchecked: make(map[string]bool),
+	}
+	if _, err := os.Stat(b.Config.Builder.DNFConf); os.IsNotExist(err) {
+		return v, nil
+	}
+	conf, err := loadDNFConfig(b.Config.Builder.DNFConf)
+	if err != nil {
+		return nil, err
+	}
+
+	// Repositories often share keys, download each of them only once.
+	downloaded := make(map[string][]byte)
+	for _, repo := range conf.repos() {
+		if !repo.GPGCheck {
+			v.unchecked[repo.ID] = true
+			continue
+		}
+		keys := splitDNFList(repo.GPGKey)
+		if len(keys) == 0 {
+			return nil, errors.Errorf("gpgcheck is enabled for repo %s but no gpgkey is set", repo.ID)
+		}
+		keyring := rpm.NewKeyring()
+		for _, key := range keys {
+			data, ok := downloaded[key]
+			if !ok {
+				if data, err = readGPGKey(key); err != nil {
+					return nil, errors.Wrapf(err, "couldn't read gpgkey of repo %s", repo.ID)
+				}
+				downloaded[key] = data
+			}
+			if err = keyring.Add(data); err != nil {
+				return nil, errors.Wrapf(err, "couldn't read gpgkey %s of repo %s", key, repo.ID)
+			}
+		}
+		v.keyrings[repo.ID] = keyring
+	}
+	return v, nil
+}
+
+// readGPGKey returns the contents of the key at a gpgkey URL, which may also
+// be a plain path. Keys are only downloaded over https, since a key fetched
+// over plain http could be replaced along with the RPMs it checks.
+func readGPGKey(key string) ([]byte, error) {
+	if strings.HasPrefix(key, "http://") {
+		return nil, errors.Errorf("refusing to download gpgkey %s over http, use https or a local file", key)
+	}
+	if strings.HasPrefix(key, "https://") {
+		content, err := helpers.DownloadFileAsString(key)
+		return []byte(content), err
+	}
+	path := strings.TrimPrefix(key, "file://")
+	if strings.Contains(path, "://") {
+		return nil, errors.Errorf("unsupported gpgkey URL %s", key)
+	}
+	return ioutil.ReadFile(path)
+}
+
+// rpmVerifyFailure is an RPM that failed signature verification.
+type rpmVerifyFailure struct {
+	file string
+	repo string
+	err  error
+}
+
+// rpmVerifyError reports all the RPMs that failed signature verification.
+type rpmVerifyError []rpmVerifyFailure
+
+func (e rpmVerifyError) Error() string {
+	var buf strings.Builder
+	_, _ = fmt.Fprintf(&buf, "%d RPM(s) failed signature verification:", len(e))
+	for _, f := range e {
+		_, _ = fmt.Fprintf(&buf, "\n  %s (repo %s): %s", filepath.Base(f.file), f.repo, f.err)
+	}
+	return buf.String()
+}
+
+// verify checks the RPM files, which are mapped to the repository they come
+// from, using up to numWorkers goroutines. RPMs from an unknown repository
+// fail, since they can't be trusted. The returned error is an rpmVerifyError
+// listing every RPM that failed.
+func (v *rpmVerifier) verify(files map[string]string, numWorkers int) error {
+	if v == nil || len(v.keyrings) == 0 {
+		return nil
+	}
+
+	var failures rpmVerifyError
+	var toCheck []string
+	for file, repo := range files {
+		switch {
+		case v.keyrings[repo] != nil:
+			toCheck = append(toCheck, file)
+		case !v.unchecked[repo]:
+			failures = append(failures, rpmVerifyFailure{file, repo, errors.New("unknown repository, gpgcheck is enabled for other repositories")})
+		}
+	}
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	fileCh := make(chan string)
+	wg.Add(numWorkers)
+	for i := 0; i < numWorkers; i++ {
+		go func() {
+			defer wg.Done()
+			for file := range fileCh {
+				repo := files[file]
+				if _, err := rpm.Verify(file, v.keyrings[repo]); err != nil {
+					mutex.Lock()
+					failures = append(failures, rpmVerifyFailure{file, repo, err})
+					mutex.Unlock()
+				}
+			}
+		}()
+	}
+	for _, file := range toCheck {
+		fileCh <- file
+	}
+	close(fileCh)
+	wg.Wait()
+
+	if len(failures) == 0 {
+		return nil
+	}
+	sort.Slice(failures, func(i, j int) bool {
+		return failures[i].file < failures[j].file
+	})
+	return failures
+}
@@ -0,0 +1,114 @@
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/clearlinux/mixer-tools/internal/rpm"
+)
+
+// writeTestGPGKey writes a public key created by gpg to filename.
+func writeTestGPGKey(t *testing.T, filename string) {
+	t.Helper()
+	key, err := ioutil.ReadFile("../internal/rpm/testdata/verify/signer.gpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filename, key, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyLocalRPMs(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "rpm-verify-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(testDir)
+	}()
+
+	b := New()
+	b.Config.LoadDefaultsForPath(testDir)
+	b.Config.Builder.DNFConf = filepath.Join(testDir, "dnf.conf")
+	if err = os.MkdirAll(b.Config.Mixer.LocalRPMDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	rpms := []string{"bad-1.0-1.x86_64.rpm", "bad-1.0-1.src.rpm", "worse-1.0-1.x86_64.rpm"}
+	for _, rpm := range rpms {
+		if err = ioutil.WriteFile(filepath.Join(b.Config.Mixer.LocalRPMDir, rpm), []byte("not a rpm"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Without a DNF configuration or with gpgcheck disabled nothing is checked.
+	if err = b.verifyLocalRPMs(rpms); err != nil {
+		t.Fatalf("unexpected error without DNF configuration: %s", err)
+	}
+	if err = ioutil.WriteFile(b.Config.Builder.DNFConf, []byte(testDNFConf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = b.verifyLocalRPMs(rpms); err != nil {
+		t.Fatalf("unexpected error with gpgcheck disabled: %s", err)
+	}
+
+	key := filepath.Join(testDir, "key.gpg")
+	writeTestGPGKey(t, key)
+	if err = b.SetRepoGPGCheck("local", true, key); err != nil {
+		t.Fatal(err)
+	}
+	err = b.verifyLocalRPMs(rpms)
+	if err == nil {
+		t.Fatal("unexpected success verifying invalid RPMs")
+	}
+	report := err.Error()
+	if !strings.HasPrefix(report, "2 RPM(s) failed signature verification:") ||
+		!strings.Contains(report, "\n  bad-1.0-1.x86_64.rpm (repo local): ") ||
+		!strings.Contains(report, "\n  worse-1.0-1.x86_64.rpm (repo local): ") {
+		t.Errorf("unexpected report:\n%s", report)
+	}
+
+	// A missing key is an error rather than skipping the verification.
+	if err = os.Remove(key); err != nil {
+		t.Fatal(err)
+	}
+	if err = b.verifyLocalRPMs(rpms); err == nil || !strings.Contains(err.Error(), "gpgkey") {
+		t.Errorf("unexpected error with missing key: %v", err)
+	}
+}
+
+func TestVerifyUnknownRepo(t *testing.T) {
+	v := &rpmVerifier{
+		keyrings:  map[string]*rpm.Keyring{"checked": rpm.NewKeyring()},
+		unchecked: map[string]bool{"unchecked": true},
+	}
+	err := v.verify(map[string]string{"/a.rpm": "unchecked", "/b.rpm": "", "/c.rpm": "gone"}, 2)
+	failures, ok := err.(rpmVerifyError)
+	if !ok || len(failures) != 2 {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if failures[0].file != "/b.rpm" || failures[1].file != "/c.rpm" || !strings.Contains(err.Error(), "unknown repository") {
+		t.Errorf("unexpected report:\n%s", err)
+	}
+
+	// Without any repo to check, RPMs of unknown repos are not an error.
+	v = &rpmVerifier{keyrings: map[string]*rpm.Keyring{}}
+	if err = v.verify(map[string]string{"/b.rpm": ""}, 1); err != nil {
+		t.Errorf("unexpected error %s", err)
+	}
+}
+
+func TestReadGPGKeyHTTP(t *testing.T) {
+	if _, err := readGPGKey("http://example.com/key"); err == nil || !strings.Contains(err.Error(), "http") {
+		t.Errorf("unexpected error %v reading key over http", err)
+	}
+	if _, err := gpgKeyURL("http://example.com/key"); err == nil {
+		t.Error("unexpected success setting key over http")
+	}
+	if url, err := gpgKeyURL("https://example.com/key"); err != nil || url != "https://example.com/key" {
+		t.Errorf("got %q, %v for https key", url, err)
+	}
+}
@@ -0,0 +1,374 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/clearlinux/mixer-tools/swupd"
+	"github.com/pkg/errors"
+)
+
+// SBOMFile is the name of the software bill of materials written to the www
+// directory of each version, in CycloneDX JSON format.
+const SBOMFile = "sbom.cdx.json"
+
+// The subset of the CycloneDX 1.4 format used by the SBOM.
+type cdxBOM struct {
+	BOMFormat    string           `json:"bomFormat"`
+	SpecVersion  string           `json:"specVersion"`
+	Version      int              `json:"version"`
+	Metadata     cdxMetadata      `json:"metadata"`
+	Components   []*cdxComponent  `json:"components"`
+	Dependencies []*cdxDependency `json:"dependencies,omitempty"`
+}
+
+type cdxMetadata struct {
+	Timestamp string        `json:"timestamp"`
+	Tools     []cdxTool     `json:"tools"`
+	Component *cdxComponent `json:"component"`
+}
+
+type cdxTool struct {
+	Vendor  string `json:"vendor"`
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type cdxComponent struct {
+	Type        string          `json:"type"`
+	BOMRef      string          `json:"bom-ref,omitempty"`
+	Name        string          `json:"name"`
+	Version     string          `json:"version,omitempty"`
+	Description string          `json:"description,omitempty"`
+	PURL        string          `json:"purl,omitempty"`
+	Hashes      []cdxHash       `json:"hashes,omitempty"`
+	Licenses    []cdxLicense    `json:"licenses,omitempty"`
+	Properties  []cdxProperty   `json:"properties,omitempty"`
+	Components  []*cdxComponent `json:"components,omitempty"`
+}
+
+type cdxHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+type cdxLicense struct {
+	License struct {
+		Name string `json:"name"`
+	} `json:"license"`
+}
+
+type cdxProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type cdxDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn,omitempty"`
+}
+
+// writeSBOM writes the software bill of materials of the version described by
+// mom to its www directory.
+func (b *Builder) writeSBOM(mom *swupd.Manifest) error {
+	bom, err := createSBOM(b.Config.Builder.ServerStateDir, mom)
+	if err != nil {
+		return errors.Wrap(err, "couldn't create SBOM")
+	}
+
+	filename := filepath.Join(b.Config.Builder.ServerStateDir, "www", fmt.Sprint(mom.Header.Version), SBOMFile)
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(bom); err != nil {
+		return errors.Wrapf(err, "couldn't write %s", filename)
+	}
+	return f.Close()
+}
+
+// createSBOM lists the bundles in mom. The packages of each bundle come from
+// its bundle-info file, their versions and licenses from the package lock of
+// the build, and the files with their hashes from the bundle manifest. Builds
+// without a package lock list the packages with the versions in the RPM file
+// names of the bundle-info files.
+func createSBOM(stateDir string, mom *swupd.Manifest) (*cdxBOM, error) {
+	version := fmt.Sprint(mom.Header.Version)
+	imageDir := filepath.Join(stateDir, "image", version)
+
+	lock, err := readPackageLock(filepath.Join(imageDir, PackageLockFile))
+	if os.IsNotExist(errors.Cause(err)) {
+		log.Printf("Warning: no package lock for version %s, the SBOM has no package checksums and licenses\n", version)
+		lock, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	bom := &cdxBOM{
+		BOMFormat:   "CycloneDX",
+		SpecVersion: "1.4",
+		Version:     1,
+		Metadata: cdxMetadata{
+			Timestamp: mom.Header.TimeStamp.UTC().Format(time.RFC3339),
+			Tools:     []cdxTool{{Vendor: "Clear Linux", Name: "mixer", Version: Version}},
+			Component: &cdxComponent{
+				Type:    "operating-system",
+				BOMRef:  "mix",
+				Name:    osReleaseName(filepath.Join(imageDir, "full", "usr/lib/os-release")),
+				Version: version,
+			},
+		},
+	}
+	mix := &cdxDependency{Ref: "mix"}
+	bom.Dependencies = append(bom.Dependencies, mix)
+
+	var bundles []*swupd.File
+	for _, f := range mom.Files {
+		if f.Type == swupd.TypeManifest && f.Status != swupd.StatusDeleted {
+			bundles = append(bundles, f)
+		}
+	}
+	sort.Slice(bundles, func(i, j int) bool {
+		return bundles[i].Name < bundles[j].Name
+	})
+
+	// Packages are keyed by name.arch, so each arch of a multilib package is
+	// a separate component.
+	used := make(map[string]*lockedPackage)
+	for _, f := range bundles {
+		ref := "bundle:" + f.Name
+		mix.DependsOn = append(mix.DependsOn, ref)
+
+		// Bundles deprecated in this version have no bundle-info file.
+		var info swupd.BundleInfo
+		infoPath := filepath.Join(imageDir, f.Name+"-info")
+		content, err := ioutil.ReadFile(infoPath)
+		if err == nil {
+			err = json.Unmarshal(content, &info)
+		} else if os.IsNotExist(err) {
+			err = nil
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't read %s", infoPath)
+		}
+
+		manifest, err := swupd.ParseManifestFile(filepath.Join(stateDir, "www", fmt.Sprint(f.Version), "Manifest."+f.Name))
+		if err != nil {
+			return nil, err
+		}
+
+		bundle := &cdxComponent{
+			Type:        "application",
+			BOMRef:      ref,
+			Name:        f.Name,
+			Version:     fmt.Sprint(f.Version),
+			Description: info.Header.Title,
+		}
+		for _, file := range manifest.Files {
+			if file.Status != swupd.StatusUnset || (file.Type != swupd.TypeFile && file.Type != swupd.TypeLink) {
+				continue
+			}
+			bundle.Components = append(bundle.Components, &cdxComponent{
+				Type:    "file",
+				Name:    file.Name,
+				Version: fmt.Sprint(file.Version),
+				Properties: []cdxProperty{
+					{Name: "swupd:hash", Value: file.Hash.String()},
+				},
+			})
+		}
+		bom.Components = append(bom.Components, bundle)
+
+		dep := &cdxDependency{Ref: ref}
+		for _, p := range bundlePackages(&info, lock) {
+			used[p.key()] = p
+			dep.DependsOn = append(dep.DependsOn, p.purl())
+		}
+		for _, include := range info.DirectIncludes {
+			dep.DependsOn = append(dep.DependsOn, "bundle:"+include)
+		}
+		bom.Dependencies = append(bom.Dependencies, dep)
+	}
+
+	var keys []string
+	for key := range used {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		bom.Components = append(bom.Components, used[key].sbomComponent())
+	}
+	return bom, nil
+}
+
+// bundlePackages returns the packages in the bundle, sorted by name.arch.
+// The packages are the RPM files in AllRpmPackages, found in the lock by
+// name.arch. Names in AllPackages without an RPM file, from bundle-info files
+// that don't list them, match the locked packages of every arch. Without a
+// lock, only the RPM files are listed, with the version in their names.
+func bundlePackages(info *swupd.BundleInfo, lock *packageLock) []*lockedPackage {
+	byKey := make(map[string]*lockedPackage)
+	byName := make(map[string][]*lockedPackage)
+	if lock != nil {
+		for _, p := range lock.Packages {
+			byKey[p.key()] = p
+			byName[p.Name] = append(byName[p.Name], p)
+		}
+	}
+
+	result := make(map[string]*lockedPackage)
+	found := make(map[string]bool)
+	for file := range info.AllRpmPackages {
+		p := parseRPMFilename(file)
+		if p == nil {
+			continue
+		}
+		found[p.Name] = true
+		if lock == nil {
+			result[p.key()] = p
+		} else if l, ok := byKey[p.key()]; ok {
+			result[p.key()] = l
+		}
+	}
+	for name := range info.AllPackages {
+		if found[name] {
+			continue
+		}
+		for _, p := range byName[name] {
+			result[p.key()] = p
+		}
+	}
+
+	pkgs := make([]*lockedPackage, 0, len(result))
+	for _, p := range result {
+		pkgs = append(pkgs, p)
+	}
+	sort.Slice(pkgs, func(i, j int) bool {
+		return pkgs[i].key() < pkgs[j].key()
+	})
+	return pkgs
+}
+
+// bundlePackageNames returns the sorted names of the packages in the bundle,
+// which are in AllPackages and, for packages installed by a different name,
+// in the file names in AllRpmPackages.
+func bundlePackageNames(info *swupd.BundleInfo) []string {
+	set := make(map[string]bool)
+	for name := range info.AllPackages {
+		set[name] = true
+	}
+	for file := range info.AllRpmPackages {
+		if p := parseRPMFilename(file); p != nil {
+			set[p.Name] = true
+		}
+	}
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseRPMFilename returns the package of an RPM file named
+// name-version-release.arch.rpm, or nil if the name doesn't match.
+func parseRPMFilename(file string) *lockedPackage {
+	nvr := strings.TrimSuffix(file, ".rpm")
+	i := strings.LastIndex(nvr, ".")
+	if i == -1 {
+		return nil
+	}
+	p := &lockedPackage{Arch: nvr[i+1:]}
+	nvr = nvr[:i]
+	if i = strings.LastIndex(nvr, "-"); i == -1 {
+		return nil
+	}
+	p.Release = nvr[i+1:]
+	nvr = nvr[:i]
+	if i = strings.LastIndex(nvr, "-"); i == -1 {
+		return nil
+	}
+	p.Version = nvr[i+1:]
+	p.Name = nvr[:i]
+	return p
+}
+
+// purl returns the package URL of the package, used to reference it in the
+// SBOM.
+func (p *lockedPackage) purl() string {
+	purl := fmt.Sprintf("pkg:rpm/%s@%s-%s?arch=%s", url.QueryEscape(p.Name), url.QueryEscape(p.Version), url.QueryEscape(p.Release), p.Arch)
+	if p.Epoch != "" && p.Epoch != "0" {
+		purl += "&epoch=" + p.Epoch
+	}
+	return purl
+}
+
+func (p *lockedPackage) sbomComponent() *cdxComponent {
+	c := &cdxComponent{
+		Type:    "library",
+		BOMRef:  p.purl(),
+		Name:    p.Name,
+		Version: p.evr(),
+		PURL:    p.purl(),
+	}
+	if sum := strings.TrimPrefix(p.Checksum, "sha256:"); sum != p.Checksum {
+		c.Hashes = []cdxHash{{Alg: "SHA-256", Content: sum}}
+	}
+	if p.License != "" && p.License != "(none)" {
+		var l cdxLicense
+		l.License.Name = p.License
+		c.Licenses = []cdxLicense{l}
+	}
+	if p.Repo != "" {
+		c.Properties = []cdxProperty{{Name: "mixer:repo", Value: p.Repo}}
+	}
+	return c
+}
+
+// osReleaseName returns the NAME in the os-release file, or "mix" if it is
+// not available.
+func osReleaseName(filename string) string {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "mix"
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if v := strings.TrimPrefix(scanner.Text(), "NAME="); v != scanner.Text() {
+			return strings.Trim(v, `"'`)
+		}
+	}
+	return "mix"
+}
@@ -0,0 +1,211 @@
+package builder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clearlinux/mixer-tools/swupd"
+)
+
+func writeTestManifest(t *testing.T, path string, version uint32, entries ...string) {
+	t.Helper()
+	content := fmt.Sprintf("MANIFEST\t30\nversion:\t%d\nprevious:\t0\nfilecount:\t%d\ntimestamp:\t1500000000\ncontentsize:\t0\n\n%s\n",
+		version, len(entries), strings.Join(entries, "\n"))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// setupSBOMTest writes the content of version 20 to a state directory, with
+// a package lock if withLock is set.
+func setupSBOMTest(t *testing.T, withLock bool) (string, *swupd.Manifest) {
+	t.Helper()
+	stateDir, err := ioutil.TempDir("", "sbom-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imageDir := filepath.Join(stateDir, "image", "20")
+	if err = os.MkdirAll(imageDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if withLock {
+		lock := testPackageLock()
+		lock.Packages[0].License = "MIT"
+		lock.Packages = append(lock.Packages,
+			&lockedPackage{Name: "a", Version: "1.2", Release: "3", Arch: "i686", Repo: "clear", Checksum: "sha256:a32"},
+			&lockedPackage{Name: "unused", Version: "1", Release: "1", Arch: "x86_64"},
+		)
+		if err = writePackageLock(lock, filepath.Join(imageDir, PackageLockFile)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	infos := map[string]*bundle{
+		"os-core": {
+			Name:           "os-core",
+			AllPackages:    map[string]bool{"a": true, "provided-name": true},
+			AllRpmPackages: map[string]bool{"a-1.2-3.x86_64.rpm": true, "a-1.2-3.i686.rpm": true},
+		},
+		"editors": {
+			Name:           "editors",
+			Header:         swupd.BundleHeader{Title: "Editors"},
+			DirectIncludes: []string{"os-core"},
+			AllPackages:    map[string]bool{"a": true},
+			AllRpmPackages: map[string]bool{"a-1.2-3.x86_64.rpm": true, "b-2.0-1.noarch.rpm": true},
+		},
+	}
+	for name, info := range infos {
+		if err = writeBundleInfo(info, filepath.Join(imageDir, name+"-info")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeTestManifest(t, filepath.Join(stateDir, "www", "20", "Manifest.os-core"), 20,
+		"D...\t"+strings.Repeat("0", 64)+"\t10\t/usr",
+		"F...\t"+strings.Repeat("1", 64)+"\t20\t/usr/bin/a",
+		".d..\t"+strings.Repeat("0", 64)+"\t20\t/usr/bin/removed",
+	)
+	writeTestManifest(t, filepath.Join(stateDir, "www", "10", "Manifest.editors"), 10,
+		"L...\t"+strings.Repeat("2", 64)+"\t10\t/usr/bin/b",
+	)
+
+	mom := &swupd.Manifest{
+		Header: swupd.ManifestHeader{Version: 20, TimeStamp: time.Unix(1500000000, 0)},
+		Files: []*swupd.File{
+			{Name: "os-core", Version: 20, Type: swupd.TypeManifest},
+			{Name: "editors", Version: 10, Type: swupd.TypeManifest},
+			{Name: "gone", Version: 20, Type: swupd.TypeManifest, Status: swupd.StatusDeleted},
+		},
+	}
+	return stateDir, mom
+}
+
+// sbomSummary returns the type and name or package URL of the components, and
+// the dependencies of the SBOM.
+func sbomSummary(bom *cdxBOM) ([]string, map[string][]string) {
+	var components []string
+	for _, c := range bom.Components {
+		name := c.Name + "@" + c.Version
+		if c.PURL != "" {
+			name = c.PURL
+		}
+		components = append(components, c.Type+":"+name)
+	}
+	deps := make(map[string][]string)
+	for _, d := range bom.Dependencies {
+		deps[d.Ref] = d.DependsOn
+	}
+	return components, deps
+}
+
+func TestCreateSBOM(t *testing.T) {
+	stateDir, mom := setupSBOMTest(t, true)
+	defer func() {
+		_ = os.RemoveAll(stateDir)
+	}()
+	bom, err := createSBOM(stateDir, mom)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each arch of a is a separate component.
+	components, deps := sbomSummary(bom)
+	expected := []string{
+		"application:editors@10",
+		"application:os-core@20",
+		"library:pkg:rpm/a@1.2-3?arch=i686",
+		"library:pkg:rpm/a@1.2-3?arch=x86_64",
+		"library:pkg:rpm/b@2.0-1?arch=noarch&epoch=1",
+	}
+	if !reflect.DeepEqual(components, expected) {
+		t.Fatalf("got components %v, want %v", components, expected)
+	}
+
+	osCore := bom.Components[1]
+	if len(osCore.Components) != 1 || osCore.Components[0].Name != "/usr/bin/a" || osCore.Components[0].Properties[0].Value != strings.Repeat("1", 64) {
+		t.Errorf("unexpected files in os-core: %+v", osCore.Components)
+	}
+	if bom.Components[0].Description != "Editors" || len(bom.Components[0].Components) != 1 {
+		t.Errorf("unexpected editors component: %+v", bom.Components[0])
+	}
+
+	if a := bom.Components[2]; a.Version != "1.2-3" || a.Licenses != nil || a.Hashes[0].Content != "a32" {
+		t.Errorf("unexpected package component: %+v", a)
+	}
+	if a := bom.Components[3]; a.Licenses[0].License.Name != "MIT" || a.Hashes[0].Content != "aa" || a.Properties[0].Value != "clear" {
+		t.Errorf("unexpected package component: %+v", a)
+	}
+	if b := bom.Components[4]; b.Version != "1:2.0-1" || b.Licenses != nil {
+		t.Errorf("unexpected package component: %+v", b)
+	}
+
+	expectedDeps := map[string][]string{
+		"mix":            {"bundle:editors", "bundle:os-core"},
+		"bundle:editors": {"pkg:rpm/a@1.2-3?arch=x86_64", "pkg:rpm/b@2.0-1?arch=noarch&epoch=1", "bundle:os-core"},
+		"bundle:os-core": {"pkg:rpm/a@1.2-3?arch=i686", "pkg:rpm/a@1.2-3?arch=x86_64"},
+	}
+	if !reflect.DeepEqual(deps, expectedDeps) {
+		t.Errorf("got dependencies %v, want %v", deps, expectedDeps)
+	}
+
+	if _, err = json.Marshal(bom); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCreateSBOMWithoutLock(t *testing.T) {
+	stateDir, mom := setupSBOMTest(t, false)
+	defer func() {
+		_ = os.RemoveAll(stateDir)
+	}()
+	bom, err := createSBOM(stateDir, mom)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The packages come from the RPM file names, which have no epoch.
+	components, deps := sbomSummary(bom)
+	expected := []string{
+		"application:editors@10",
+		"application:os-core@20",
+		"library:pkg:rpm/a@1.2-3?arch=i686",
+		"library:pkg:rpm/a@1.2-3?arch=x86_64",
+		"library:pkg:rpm/b@2.0-1?arch=noarch",
+	}
+	if !reflect.DeepEqual(components, expected) {
+		t.Fatalf("got components %v, want %v", components, expected)
+	}
+	for _, c := range bom.Components[2:] {
+		if c.Hashes != nil || c.Licenses != nil || c.Properties != nil {
+			t.Errorf("unexpected package component: %+v", c)
+		}
+	}
+	if d := deps["bundle:editors"]; !reflect.DeepEqual(d, []string{"pkg:rpm/a@1.2-3?arch=x86_64", "pkg:rpm/b@2.0-1?arch=noarch", "bundle:os-core"}) {
+		t.Errorf("got dependencies %v for editors", d)
+	}
+}
+
+func TestParseRPMFilename(t *testing.T) {
+	tests := map[string]*lockedPackage{
+		"a-1.2-3.x86_64.rpm":               {Name: "a", Version: "1.2", Release: "3", Arch: "x86_64"},
+		"python3-dev-3.7.0-100.noarch.rpm": {Name: "python3-dev", Version: "3.7.0", Release: "100", Arch: "noarch"},
+		"invalid.rpm":                      nil,
+		"no-arch.rpm":                      nil,
+	}
+	for file, expected := range tests {
+		if p := parseRPMFilename(file); !reflect.DeepEqual(p, expected) {
+			t.Errorf("got %+v for %s, want %+v", p, file, expected)
+		}
+	}
+}
@@ -142,6 +142,17 @@ func (b *Builder) buildUpdateContent(params UpdateParameters, timer *stopWatch)
 	// TODO: Create manifest tars for Manifest.MoM and the mom.UpdatedBundles.
 	timer.Stop()
 
+	fmt.Println("Writing SBOM")
+	if err = b.writeSBOM(&mom.Manifest); err != nil {
+		return err
+	}
+	if params.ReleaseNotes {
+		fmt.Println("Writing release notes")
+		if err = b.writeReleaseNotes(previous, b.MixVerUint32); err != nil {
+			return err
+		}
+	}
+
 	if !params.SkipFullfiles {
 		timer.Start("CREATE FULLFILES")
 		fmt.Printf("Using %d workers\n", b.NumFullfileWorkers)
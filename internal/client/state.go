@@ -10,6 +10,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/clearlinux/mixer-tools/swupd"
 )
@@ -103,6 +104,10 @@ func (cs *State) GetFile(elem ...string) (string, error) {
 	localFile := filepath.Join(cs.dir, joined)
 	if _, err := os.Stat(localFile); err == nil {
 		if !cs.NoCache {
+			// Keep track of the last use of the file, so the least recently used files
+			// can be removed when trimming the cache.
+			now := time.Now()
+			_ = os.Chtimes(localFile, now, now)
 			return localFile, nil
 		}
 		err = os.RemoveAll(localFile)
@@ -121,6 +126,40 @@ func (cs *State) GetFile(elem ...string) (string, error) {
 	return localFile, nil
 }
 
+// Size returns the size in bytes of a file in the repository, without downloading it. If the file
+// doesn't exist, the error satisfies os.IsNotExist.
+func (cs *State) Size(elem ...string) (int64, error) {
+	joined := filepath.Join(elem...)
+	if !cs.isRemote {
+		fi, err := os.Stat(filepath.Join(cs.baseContent, joined))
+		if err != nil {
+			return 0, err
+		}
+		return fi.Size(), nil
+	}
+
+	// Use the cached file if available.
+	if fi, err := os.Stat(filepath.Join(cs.dir, joined)); err == nil && !cs.NoCache {
+		return fi.Size(), nil
+	}
+
+	u := cs.baseContent + "/" + joined
+	res, err := http.Head(u)
+	if err != nil {
+		return 0, err
+	}
+	_ = res.Body.Close()
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return 0, &os.PathError{Op: "head", Path: u, Err: os.ErrNotExist}
+	case res.StatusCode != http.StatusOK:
+		return 0, fmt.Errorf("couldn't get size of %q: got response with code: %d %s", u, res.StatusCode, http.StatusText(res.StatusCode))
+	case res.ContentLength < 0:
+		return 0, fmt.Errorf("couldn't get size of %q: unknown content length", u)
+	}
+	return res.ContentLength, nil
+}
+
 // Path returns the local path to a cache file representing a file in the repository.
 func (cs *State) Path(elem ...string) string {
 	return filepath.Join(cs.dir, filepath.Join(elem...))
@@ -327,7 +366,9 @@ func (cs *State) extractFullfile(hdr *tar.Header, r io.Reader) error {
 		hash, herr := swupd.GetHashForFile(filename)
 		if herr == nil && hash == basename {
 			if !cs.NoCache {
-				// No work needed!
+				// No work needed! Just keep track of the use like in GetFile.
+				now := time.Now()
+				_ = os.Chtimes(filename, now, now)
 				return nil
 			}
 		} else if herr != nil {
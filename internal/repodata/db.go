@@ -0,0 +1,232 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package repodata
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// withSQLiteDB calls fn with the database of d. The database is decompressed
+// to a temporary file, since SQLite files are read by page.
+func (r *Repository) withSQLiteDB(d *MetaData, fn func(db *sqliteDB) error) error {
+	rc, err := r.open(d)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+
+	tmp, err := ioutil.TempFile("", "repodata-*.sqlite")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+	size, err := io.Copy(tmp, rc)
+	if err != nil {
+		return errors.Wrapf(err, "couldn't decompress %s", d.Location.Href)
+	}
+
+	db, err := openSQLite(tmp, size)
+	if err != nil {
+		return errors.Wrapf(err, "couldn't open %s", d.Location.Href)
+	}
+	return errors.Wrapf(fn(db), "couldn't read %s", d.Location.Href)
+}
+
+// parseBool parses the booleans stored by createrepo, which may be either
+// integers or TRUE and FALSE.
+func parseBool(v interface{}) bool {
+	s := sqliteString(v)
+	return s == "1" || strings.EqualFold(s, "TRUE")
+}
+
+var primaryPackageColumns = []string{
+	"pkgKey", "pkgId", "checksum_type", "name", "arch", "epoch", "version", "release",
+	"summary", "description", "url", "rpm_license", "rpm_vendor", "rpm_group",
+	"rpm_buildhost", "rpm_packager", "rpm_sourcerpm", "location_href",
+	"time_file", "time_build", "size_package", "size_installed", "size_archive",
+	"rpm_header_start", "rpm_header_end",
+}
+
+var primaryEntryColumns = []string{"pkgKey", "name", "flags", "epoch", "version", "release", "pre"}
+
+func (r *Repository) readPrimaryDB(d *MetaData) ([]*Package, error) {
+	var pkgs []*Package
+	err := r.withSQLiteDB(d, func(db *sqliteDB) error {
+		byKey := make(map[int64]*Package)
+		err := db.scan("packages", primaryPackageColumns, func(v []interface{}) error {
+			s := func(i int) string { return sqliteString(v[i]) }
+			p := &Package{
+				PkgID:         s(1),
+				ChecksumType:  s(2),
+				Name:          s(3),
+				Arch:          s(4),
+				Epoch:         s(5),
+				Version:       s(6),
+				Release:       s(7),
+				Summary:       s(8),
+				Description:   s(9),
+				URL:           s(10),
+				License:       s(11),
+				Vendor:        s(12),
+				Group:         s(13),
+				BuildHost:     s(14),
+				Packager:      s(15),
+				SourceRPM:     s(16),
+				Location:      s(17),
+				TimeFile:      sqliteInt(v[18]),
+				TimeBuild:     sqliteInt(v[19]),
+				PackageSize:   sqliteInt(v[20]),
+				InstalledSize: sqliteInt(v[21]),
+				ArchiveSize:   sqliteInt(v[22]),
+				HeaderStart:   sqliteInt(v[23]),
+				HeaderEnd:     sqliteInt(v[24]),
+			}
+			byKey[sqliteInt(v[0])] = p
+			pkgs = append(pkgs, p)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		tables := []struct {
+			name    string
+			entries func(p *Package) *[]Entry
+		}{
+			{"provides", func(p *Package) *[]Entry { return &p.Provides }},
+			{"requires", func(p *Package) *[]Entry { return &p.Requires }},
+			{"conflicts", func(p *Package) *[]Entry { return &p.Conflicts }},
+			{"obsoletes", func(p *Package) *[]Entry { return &p.Obsoletes }},
+		}
+		for _, t := range tables {
+			// Old databases may not have the conflicts and obsoletes
+			// tables.
+			if _, ok := db.tables[t.name]; !ok && t.name != "provides" && t.name != "requires" {
+				continue
+			}
+			err = db.scan(t.name, primaryEntryColumns, func(v []interface{}) error {
+				p, ok := byKey[sqliteInt(v[0])]
+				if !ok {
+					return nil
+				}
+				entries := t.entries(p)
+				*entries = append(*entries, Entry{
+					Name:    sqliteString(v[1]),
+					Flags:   sqliteString(v[2]),
+					Epoch:   sqliteString(v[3]),
+					Version: sqliteString(v[4]),
+					Release: sqliteString(v[5]),
+					Pre:     parseBool(v[6]),
+				})
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pkgs, nil
+}
+
+// readPkgIDs returns the pkgId of the wanted packages by their pkgKey in the
+// database.
+func readPkgIDs(db *sqliteDB, wanted map[string]*Package) (map[int64]string, error) {
+	ids := make(map[int64]string)
+	err := db.scan("packages", []string{"pkgKey", "pkgId"}, func(v []interface{}) error {
+		if id := sqliteString(v[1]); wanted[id] != nil {
+			ids[sqliteInt(v[0])] = id
+		}
+		return nil
+	})
+	return ids, err
+}
+
+// File types are encoded as one character per file in the SQLite filelists.
+var sqliteFileTypes = map[byte]string{
+	'f': FileTypeFile,
+	'd': FileTypeDir,
+	'g': FileTypeGhost,
+}
+
+func (r *Repository) readFilelistsDB(d *MetaData, wanted map[string]*Package) (map[string][]File, error) {
+	files := make(map[string][]File, len(wanted))
+	err := r.withSQLiteDB(d, func(db *sqliteDB) error {
+		ids, err := readPkgIDs(db, wanted)
+		if err != nil {
+			return err
+		}
+		return db.scan("filelist", []string{"pkgKey", "dirname", "filenames", "filetypes"}, func(v []interface{}) error {
+			id, ok := ids[sqliteInt(v[0])]
+			if !ok {
+				return nil
+			}
+			// File names in a directory are separated by '/', the only
+			// character that can't be part of them.
+			dir, names, types := sqliteString(v[1]), strings.Split(sqliteString(v[2]), "/"), sqliteString(v[3])
+			if len(names) != len(types) {
+				return errors.Errorf("mismatched file types for directory %s", dir)
+			}
+			for i, name := range names {
+				files[id] = append(files[id], File{
+					Name: strings.TrimSuffix(dir, "/") + "/" + name,
+					Type: sqliteFileTypes[types[i]],
+				})
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+func (r *Repository) readOtherDB(d *MetaData, wanted map[string]*Package) (map[string][]Changelog, error) {
+	changelogs := make(map[string][]Changelog, len(wanted))
+	err := r.withSQLiteDB(d, func(db *sqliteDB) error {
+		ids, err := readPkgIDs(db, wanted)
+		if err != nil {
+			return err
+		}
+		return db.scan("changelog", []string{"pkgKey", "author", "date", "changelog"}, func(v []interface{}) error {
+			if id, ok := ids[sqliteInt(v[0])]; ok {
+				changelogs[id] = append(changelogs[id], Changelog{
+					Author: sqliteString(v[1]),
+					Date:   sqliteInt(v[2]),
+					Text:   sqliteString(v[3]),
+				})
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changelogs, nil
+}
@@ -0,0 +1,331 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package repodata reads the metadata of RPM repositories, as created by
+// createrepo, without depending on dnf or sqlite3. Both the XML and the SQLite
+// variants of the primary, filelists and other metadata are supported. It also
+// generates the XML metadata for a directory of RPM files, replacing createrepo
+// for local repositories.
+package repodata
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+	"github.com/ulikunitz/xz"
+)
+
+// RepoMD is the index of the metadata of a repository, read from
+// repodata/repomd.xml.
+type RepoMD struct {
+	Revision string     `xml:"revision"`
+	Data     []MetaData `xml:"data"`
+}
+
+// MetaData describes one of the metadata files of a repository.
+type MetaData struct {
+	Type     string `xml:"type,attr"`
+	Location struct {
+		Href string `xml:"href,attr"`
+	} `xml:"location"`
+	Checksum struct {
+		Type  string `xml:"type,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"checksum"`
+	OpenChecksum struct {
+		Type  string `xml:"type,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"open-checksum"`
+	Timestamp int64 `xml:"timestamp"`
+	Size      int64 `xml:"size"`
+	OpenSize  int64 `xml:"open-size"`
+}
+
+// Package is a package in the primary metadata of a repository.
+type Package struct {
+	// PkgID is the checksum of the package file, which identifies the
+	// package in the other metadata files.
+	PkgID        string
+	ChecksumType string
+
+	Name    string
+	Arch    string
+	Epoch   string
+	Version string
+	Release string
+
+	Summary     string
+	Description string
+	URL         string
+	License     string
+	Vendor      string
+	Group       string
+	BuildHost   string
+	Packager    string
+	SourceRPM   string
+
+	// Location is the path of the package file relative to the repository.
+	Location string
+
+	// TimeFile is the modification time of the package file, and TimeBuild
+	// the time the package was built.
+	TimeFile  int64
+	TimeBuild int64
+
+	PackageSize   int64
+	InstalledSize int64
+	ArchiveSize   int64
+
+	// HeaderStart and HeaderEnd are the offsets of the header in the
+	// package file.
+	HeaderStart int64
+	HeaderEnd   int64
+
+	Provides  []Entry
+	Requires  []Entry
+	Conflicts []Entry
+	Obsoletes []Entry
+
+	// Files are only set after reading the filelists metadata.
+	Files []File
+
+	// Changelogs are only set after reading the other metadata.
+	Changelogs []Changelog
+}
+
+// Entry is a capability provided or required by a package.
+type Entry struct {
+	Name    string
+	Flags   string
+	Epoch   string
+	Version string
+	Release string
+	Pre     bool
+}
+
+// File types in the filelists metadata.
+const (
+	FileTypeFile  = ""
+	FileTypeDir   = "dir"
+	FileTypeGhost = "ghost"
+)
+
+// File is a file provided by a package.
+type File struct {
+	Name string
+	Type string
+}
+
+// Changelog is an entry of the changelog of a package.
+type Changelog struct {
+	Author string
+	Date   int64
+	Text   string
+}
+
+// EVR returns the [epoch:]version-release of the package, in the same form
+// used by dnf. The epoch is omitted when it is zero.
+func (p *Package) EVR() string {
+	evr := p.Version + "-" + p.Release
+	if p.Epoch != "" && p.Epoch != "0" {
+		evr = p.Epoch + ":" + evr
+	}
+	return evr
+}
+
+// NEVRA returns the name-[epoch:]version-release.arch of the package.
+func (p *Package) NEVRA() string {
+	return p.Name + "-" + p.EVR() + "." + p.Arch
+}
+
+// Filename returns the name of the package file.
+func (p *Package) Filename() string {
+	return path.Base(p.Location)
+}
+
+// Repository is a local directory with RPM repository metadata, either a
+// repository created by createrepo or a copy cached by dnf.
+type Repository struct {
+	Dir string
+	MD  *RepoMD
+}
+
+// Open reads the repomd.xml of the repository in dir.
+func Open(dir string) (*Repository, error) {
+	f, err := os.Open(filepath.Join(dir, "repodata", "repomd.xml"))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	var md RepoMD
+	if err = xml.NewDecoder(bufio.NewReader(f)).Decode(&md); err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse repomd.xml of %s", dir)
+	}
+	return &Repository{Dir: dir, MD: &md}, nil
+}
+
+// Data returns the metadata of the given type, or nil if the repository
+// doesn't have it.
+func (r *Repository) Data(dataType string) *MetaData {
+	for i := range r.MD.Data {
+		if r.MD.Data[i].Type == dataType {
+			return &r.MD.Data[i]
+		}
+	}
+	return nil
+}
+
+// Packages reads the packages from the primary metadata. The XML variant is
+// preferred, since it is always streamed instead of decompressed to a file.
+func (r *Repository) Packages() ([]*Package, error) {
+	if d := r.Data("primary"); d != nil {
+		return r.readPrimaryXML(d)
+	}
+	if d := r.Data("primary_db"); d != nil {
+		return r.readPrimaryDB(d)
+	}
+	return nil, errors.Errorf("no primary metadata in repository %s", r.Dir)
+}
+
+// ReadFiles sets the files of pkgs, which must come from the same repository,
+// from the filelists metadata.
+func (r *Repository) ReadFiles(pkgs []*Package) error {
+	byID := make(map[string]*Package, len(pkgs))
+	for _, p := range pkgs {
+		byID[p.PkgID] = p
+	}
+
+	var files map[string][]File
+	var err error
+	if d := r.Data("filelists"); d != nil {
+		files, err = r.readFilelistsXML(d, byID)
+	} else if d := r.Data("filelists_db"); d != nil {
+		files, err = r.readFilelistsDB(d, byID)
+	} else {
+		return errors.Errorf("no filelists metadata in repository %s", r.Dir)
+	}
+	if err != nil {
+		return err
+	}
+
+	for id, p := range byID {
+		p.Files = files[id]
+	}
+	return nil
+}
+
+// ReadChangelogs sets the changelogs of pkgs, which must come from the same
+// repository, from the other metadata.
+func (r *Repository) ReadChangelogs(pkgs []*Package) error {
+	byID := make(map[string]*Package, len(pkgs))
+	for _, p := range pkgs {
+		byID[p.PkgID] = p
+	}
+
+	var changelogs map[string][]Changelog
+	var err error
+	if d := r.Data("other"); d != nil {
+		changelogs, err = r.readOtherXML(d, byID)
+	} else if d := r.Data("other_db"); d != nil {
+		changelogs, err = r.readOtherDB(d, byID)
+	} else {
+		return errors.Errorf("no other metadata in repository %s", r.Dir)
+	}
+	if err != nil {
+		return err
+	}
+
+	for id, p := range byID {
+		p.Changelogs = changelogs[id]
+	}
+	return nil
+}
+
+// Compression algorithms have "magic" bytes in the beginning of the file to identify them.
+var (
+	gzipMagic  = []byte{0x1F, 0x8B}
+	xzMagic    = []byte{0xFD, '7', 'z', 'X', 'Z', 0x00}
+	bzip2Magic = []byte{'B', 'Z', 'h'}
+	zstdMagic  = []byte{0x28, 0xB5, 0x2F, 0xFD}
+)
+
+type readCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (rc *readCloser) Close() error {
+	var err error
+	for i := len(rc.closers) - 1; i >= 0; i-- {
+		if cerr := rc.closers[i].Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// open returns the uncompressed content of a metadata file. The compression
+// is guessed based on the magic in the file, like in swupd archives.
+func (r *Repository) open(d *MetaData) (io.ReadCloser, error) {
+	name := filepath.Join(r.Dir, filepath.FromSlash(d.Location.Href))
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(f)
+	h, _ := br.Peek(6)
+
+	result := &readCloser{Reader: br, closers: []io.Closer{f}}
+	switch {
+	case bytes.HasPrefix(h, gzipMagic):
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			_ = f.Close()
+			return nil, fmt.Errorf("couldn't decompress %s using gzip: %s", name, err)
+		}
+		result.Reader = gr
+		result.closers = append(result.closers, gr)
+	case bytes.HasPrefix(h, xzMagic):
+		xr, err := xz.NewReader(br)
+		if err != nil {
+			_ = f.Close()
+			return nil, fmt.Errorf("couldn't decompress %s using xz: %s", name, err)
+		}
+		result.Reader = xr
+	case bytes.HasPrefix(h, bzip2Magic):
+		result.Reader = bzip2.NewReader(br)
+	case bytes.HasPrefix(h, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			_ = f.Close()
+			return nil, fmt.Errorf("couldn't decompress %s using zstd: %s", name, err)
+		}
+		result.Reader = zr
+		result.closers = append(result.closers, zr.IOReadCloser())
+	}
+	return result, nil
+}
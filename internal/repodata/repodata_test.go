@@ -0,0 +1,315 @@
+package repodata
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+const testRepomd = `<?xml version="1.0" encoding="UTF-8"?>
+<repomd xmlns="http://linux.duke.edu/metadata/repo" xmlns:rpm="http://linux.duke.edu/metadata/rpm">
+  <revision>1530000000</revision>
+  <data type="primary">
+    <checksum type="sha256">aaaa</checksum>
+    <location href="repodata/primary.xml.gz"/>
+  </data>
+  <data type="filelists">
+    <checksum type="sha256">bbbb</checksum>
+    <location href="repodata/filelists.xml.gz"/>
+  </data>
+</repomd>
+`
+
+const testPrimary = `<?xml version="1.0" encoding="UTF-8"?>
+<metadata xmlns="http://linux.duke.edu/metadata/common" xmlns:rpm="http://linux.duke.edu/metadata/rpm" packages="2">
+<package type="rpm">
+  <name>foo</name>
+  <arch>x86_64</arch>
+  <version epoch="0" ver="1.2" rel="3"/>
+  <checksum type="sha256" pkgid="YES">1111</checksum>
+  <summary>The foo package</summary>
+  <description>Foo does things.</description>
+  <url>https://example.com/foo</url>
+  <size package="100" installed="2000" archive="2100"/>
+  <location href="Packages/foo-1.2-3.x86_64.rpm"/>
+  <format>
+    <rpm:license>MIT</rpm:license>
+    <rpm:sourcerpm>foo-1.2-3.src.rpm</rpm:sourcerpm>
+    <rpm:provides>
+      <rpm:entry name="foo" flags="EQ" epoch="0" ver="1.2" rel="3"/>
+    </rpm:provides>
+    <rpm:requires>
+      <rpm:entry name="bar" flags="GE" epoch="0" ver="2"/>
+      <rpm:entry name="/bin/sh" pre="1"/>
+    </rpm:requires>
+  </format>
+</package>
+<package type="rpm">
+  <name>bar</name>
+  <arch>noarch</arch>
+  <version epoch="1" ver="2.0" rel="1"/>
+  <checksum type="sha256" pkgid="YES">2222</checksum>
+  <summary>The bar package</summary>
+  <size package="10" installed="20" archive="30"/>
+  <location href="bar-2.0-1.noarch.rpm"/>
+  <format>
+    <rpm:license>GPLv2</rpm:license>
+  </format>
+</package>
+</metadata>
+`
+
+const testFilelists = `<?xml version="1.0" encoding="UTF-8"?>
+<filelists xmlns="http://linux.duke.edu/metadata/filelists" packages="2">
+<package pkgid="1111" name="foo" arch="x86_64">
+  <version epoch="0" ver="1.2" rel="3"/>
+  <file>/usr/bin/foo</file>
+  <file type="dir">/usr/share/foo</file>
+  <file type="ghost">/var/log/foo.log</file>
+</package>
+<package pkgid="2222" name="bar" arch="noarch">
+  <version epoch="1" ver="2.0" rel="1"/>
+  <file>/usr/bin/bar</file>
+</package>
+</filelists>
+`
+
+func writeGzip(t *testing.T, filename, content string) {
+	t.Helper()
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := gzip.NewWriter(f)
+	if _, err = w.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func setupRepo(t *testing.T, repomd string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "repodata-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Mkdir(filepath.Join(dir, "repodata"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "repodata", "repomd.xml"), []byte(repomd), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func checkPackages(t *testing.T, r *Repository) {
+	t.Helper()
+	pkgs, err := r.Packages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkgs) != 2 {
+		t.Fatalf("got %d packages, want 2", len(pkgs))
+	}
+
+	foo, bar := pkgs[0], pkgs[1]
+	if foo.Name != "foo" || foo.Arch != "x86_64" || foo.EVR() != "1.2-3" || foo.PkgID != "1111" {
+		t.Errorf("unexpected package %+v", foo)
+	}
+	if foo.Filename() != "foo-1.2-3.x86_64.rpm" {
+		t.Errorf("got filename %q", foo.Filename())
+	}
+	if foo.InstalledSize != 2000 || foo.PackageSize != 100 || foo.License != "MIT" {
+		t.Errorf("unexpected package %+v", foo)
+	}
+	if foo.SourceRPM != "foo-1.2-3.src.rpm" {
+		t.Errorf("got source rpm %q", foo.SourceRPM)
+	}
+	if len(foo.Provides) != 1 || foo.Provides[0].Name != "foo" {
+		t.Errorf("unexpected provides %+v", foo.Provides)
+	}
+	if len(foo.Requires) != 2 || foo.Requires[0].Flags != "GE" || foo.Requires[0].Pre || !foo.Requires[1].Pre {
+		t.Errorf("unexpected requires %+v", foo.Requires)
+	}
+	if bar.NEVRA() != "bar-1:2.0-1.noarch" {
+		t.Errorf("got NEVRA %q", bar.NEVRA())
+	}
+
+	if err = r.ReadFiles(pkgs[:1]); err != nil {
+		t.Fatal(err)
+	}
+	expected := []File{
+		{"/usr/bin/foo", FileTypeFile},
+		{"/usr/share/foo", FileTypeDir},
+		{"/var/log/foo.log", FileTypeGhost},
+	}
+	if !reflect.DeepEqual(foo.Files, expected) {
+		t.Errorf("got files %v, want %v", foo.Files, expected)
+	}
+	if bar.Files != nil {
+		t.Errorf("files of bar were read but not requested: %v", bar.Files)
+	}
+}
+
+func TestReadXML(t *testing.T) {
+	dir := setupRepo(t, testRepomd)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	writeGzip(t, filepath.Join(dir, "repodata", "primary.xml.gz"), testPrimary)
+	writeGzip(t, filepath.Join(dir, "repodata", "filelists.xml.gz"), testFilelists)
+
+	r, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.MD.Revision != "1530000000" || r.Data("primary") == nil || r.Data("other") != nil {
+		t.Errorf("unexpected repomd %+v", r.MD)
+	}
+	checkPackages(t, r)
+}
+
+const testRepomdCompressed = `<?xml version="1.0" encoding="UTF-8"?>
+<repomd xmlns="http://linux.duke.edu/metadata/repo">
+  <revision>1530000000</revision>
+  <data type="primary">
+    <location href="repodata/primary.xml.xz"/>
+  </data>
+  <data type="filelists">
+    <location href="repodata/filelists.xml.zst"/>
+  </data>
+</repomd>
+`
+
+func TestReadCompressed(t *testing.T) {
+	dir := setupRepo(t, testRepomdCompressed)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	var primary bytes.Buffer
+	xw, err := xz.NewWriter(&primary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = xw.Write([]byte(testPrimary)); err != nil {
+		t.Fatal(err)
+	}
+	if err = xw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "repodata", "primary.xml.xz"), primary.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	zw, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filelists := zw.EncodeAll([]byte(testFilelists), nil)
+	if err = ioutil.WriteFile(filepath.Join(dir, "repodata", "filelists.xml.zst"), filelists, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPackages(t, r)
+}
+
+const testRepomdDB = `<?xml version="1.0" encoding="UTF-8"?>
+<repomd xmlns="http://linux.duke.edu/metadata/repo">
+  <revision>1530000000</revision>
+  <data type="primary_db">
+    <location href="repodata/primary.sqlite.bz2"/>
+  </data>
+  <data type="filelists_db">
+    <location href="repodata/filelists.sqlite"/>
+  </data>
+  <data type="other_db">
+    <location href="repodata/other.sqlite.xz"/>
+  </data>
+</repomd>
+`
+
+// The databases in testdata/sqlite are created by gen.sh with sqlite3.
+func TestReadSQLite(t *testing.T) {
+	dir := setupRepo(t, testRepomdDB)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	for _, name := range []string{"primary.sqlite.bz2", "filelists.sqlite", "other.sqlite.xz"} {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", "sqlite", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(filepath.Join(dir, "repodata", name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPackages(t, r)
+
+	pkgs, err := r.Packages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	foo, bar := pkgs[0], pkgs[1]
+	// The description is stored in overflow pages.
+	if len(foo.Description) != 5000 || !strings.HasPrefix(foo.Description, "Foo does things. Foo") {
+		t.Errorf("got description of %d bytes: %.40q", len(foo.Description), foo.Description)
+	}
+	if foo.TimeFile != 1530000000 || foo.HeaderStart != 4504 || foo.HeaderEnd != 6000 || foo.Group != "Unspecified" {
+		t.Errorf("unexpected package %+v", foo)
+	}
+	if len(bar.Conflicts) != 1 || bar.Conflicts[0].Flags != "LT" || len(bar.Obsoletes) != 1 || bar.Obsoletes[0].Name != "oldbar" {
+		t.Errorf("unexpected conflicts %+v and obsoletes %+v", bar.Conflicts, bar.Obsoletes)
+	}
+
+	// The files of bar span interior pages.
+	if err = r.ReadFiles(pkgs[1:]); err != nil {
+		t.Fatal(err)
+	}
+	if len(bar.Files) != 400 {
+		t.Fatalf("got %d files, want 400", len(bar.Files))
+	}
+	last := File{"/usr/share/bar/200/b", FileTypeDir}
+	if bar.Files[0] != (File{"/usr/share/bar/1/a", FileTypeFile}) || bar.Files[399] != last {
+		t.Errorf("got files %v ... %v", bar.Files[0], bar.Files[399])
+	}
+
+	if err = r.ReadChangelogs(pkgs); err != nil {
+		t.Fatal(err)
+	}
+	expected := []Changelog{
+		{"Jane Doe <jane@example.com> - 1.2-3", 1500000000, "- Update to 1.2"},
+		{"Jane Doe <jane@example.com> - 1.1-1", 1400000000, "- Initial package"},
+	}
+	if !reflect.DeepEqual(foo.Changelogs, expected) || bar.Changelogs != nil {
+		t.Errorf("got changelogs %+v and %+v", foo.Changelogs, bar.Changelogs)
+	}
+}
+
+func TestOpenMissing(t *testing.T) {
+	if _, err := Open("/nonexistent"); !os.IsNotExist(err) {
+		t.Errorf("expected not exist error, got %v", err)
+	}
+}
@@ -0,0 +1,455 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package repodata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// This file reads SQLite database files as described in
+// https://www.sqlite.org/fileformat.html, limited to what is needed for the
+// metadata databases of repositories: scanning every row of a table. Indexes,
+// WITHOUT ROWID tables, UTF-16 databases and uncommitted WAL files are not
+// supported.
+
+const sqliteMagic = "SQLite format 3\x00"
+
+// B-tree page types of tables.
+const (
+	sqliteInteriorTable = 0x05
+	sqliteLeafTable     = 0x0D
+)
+
+// sqliteMaxDepth limits the depth of the table b-trees, so a corrupted
+// database with a loop of pages fails instead of recursing forever.
+const sqliteMaxDepth = 64
+
+// sqliteDB is a read-only SQLite database.
+type sqliteDB struct {
+	r         io.ReaderAt
+	pageSize  int64
+	usable    int64
+	pageCount int64
+	tables    map[string]*sqliteTable
+}
+
+type sqliteTable struct {
+	rootPage int64
+	columns  []string
+
+	// rowidColumn is the INTEGER PRIMARY KEY column, stored as the rowid
+	// instead of in the record, or -1.
+	rowidColumn int
+}
+
+// openSQLite reads the header and the schema of the database of the given
+// size in r.
+func openSQLite(r io.ReaderAt, size int64) (*sqliteDB, error) {
+	header := make([]byte, 100)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, errors.Wrap(err, "couldn't read SQLite header")
+	}
+	if string(header[:16]) != sqliteMagic {
+		return nil, errors.New("not a SQLite database")
+	}
+	db := &sqliteDB{r: r, pageSize: int64(binary.BigEndian.Uint16(header[16:]))}
+	if db.pageSize == 1 {
+		db.pageSize = 65536
+	}
+	if db.pageSize < 512 || db.pageSize&(db.pageSize-1) != 0 {
+		return nil, errors.Errorf("invalid SQLite page size %d", db.pageSize)
+	}
+	db.usable = db.pageSize - int64(header[20])
+	if db.usable < 480 {
+		return nil, errors.New("invalid SQLite reserved space")
+	}
+	if enc := binary.BigEndian.Uint32(header[56:]); enc != 0 && enc != 1 {
+		return nil, errors.Errorf("unsupported SQLite text encoding %d", enc)
+	}
+	db.pageCount = size / db.pageSize
+
+	// The schema is the sqlite_master table, with type, name, tbl_name,
+	// rootpage and sql columns, rooted at the first page.
+	master := &sqliteTable{rootPage: 1, columns: []string{"type", "name", "tbl_name", "rootpage", "sql"}, rowidColumn: -1}
+	db.tables = make(map[string]*sqliteTable)
+	err := db.scanTable(master, func(values []interface{}) error {
+		if len(values) < 5 || sqliteString(values[0]) != "table" {
+			return nil
+		}
+		root, ok := values[3].(int64)
+		if !ok {
+			return errors.Errorf("invalid root page for table %s", sqliteString(values[1]))
+		}
+		t, err := parseCreateTable(sqliteString(values[4]))
+		if err != nil {
+			return errors.Wrapf(err, "couldn't parse schema of table %s", sqliteString(values[1]))
+		}
+		t.rootPage = root
+		db.tables[strings.ToLower(sqliteString(values[1]))] = t
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't read SQLite schema")
+	}
+	return db, nil
+}
+
+// parseCreateTable returns the columns of the table in a CREATE TABLE
+// statement.
+func parseCreateTable(sql string) (*sqliteTable, error) {
+	start := strings.Index(sql, "(")
+	end := strings.LastIndex(sql, ")")
+	if start < 0 || end < start {
+		return nil, errors.New("no column definitions")
+	}
+	if strings.Contains(strings.ToUpper(sql[end:]), "WITHOUT ROWID") {
+		return nil, errors.New("WITHOUT ROWID tables are not supported")
+	}
+
+	// Split the definitions on the commas outside parentheses and quotes.
+	var defs []string
+	depth, last := 0, start+1
+	var quote byte
+	for i := start + 1; i < end; i++ {
+		c := sql[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '[':
+			quote = ']'
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			defs = append(defs, sql[last:i])
+			last = i + 1
+		}
+	}
+	defs = append(defs, sql[last:end])
+
+	t := &sqliteTable{rowidColumn: -1}
+	for _, def := range defs {
+		fields := strings.Fields(def)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "CONSTRAINT", "PRIMARY", "UNIQUE", "CHECK", "FOREIGN":
+			continue
+		}
+		upper := strings.ToUpper(strings.Join(fields[1:], " "))
+		if strings.HasPrefix(upper, "INTEGER PRIMARY KEY") && !strings.Contains(upper, "DESC") {
+			t.rowidColumn = len(t.columns)
+		}
+		t.columns = append(t.columns, strings.Trim(fields[0], "\"'`[]"))
+	}
+	return t, nil
+}
+
+// scan calls fn for each row of the table with the values of the given
+// columns. The values are nil, int64, float64, string or []byte. Columns
+// that are not in the table, or not in a row added before they were, are nil.
+func (db *sqliteDB) scan(table string, columns []string, fn func(values []interface{}) error) error {
+	t, ok := db.tables[strings.ToLower(table)]
+	if !ok {
+		return errors.Errorf("no table %s in database", table)
+	}
+	index := make([]int, len(columns))
+	for i, name := range columns {
+		index[i] = -1
+		for j, c := range t.columns {
+			if strings.EqualFold(c, name) {
+				index[i] = j
+			}
+		}
+	}
+	values := make([]interface{}, len(columns))
+	return db.scanTable(t, func(row []interface{}) error {
+		for i, j := range index {
+			values[i] = nil
+			if j >= 0 && j < len(row) {
+				values[i] = row[j]
+			}
+		}
+		return fn(values)
+	})
+}
+
+// scanTable calls fn with the values of all the columns of each row.
+func (db *sqliteDB) scanTable(t *sqliteTable, fn func(row []interface{}) error) error {
+	return db.scanPage(t.rootPage, 0, func(rowid int64, payload []byte) error {
+		row, err := decodeRecord(payload)
+		if err != nil {
+			return err
+		}
+		if t.rowidColumn >= 0 {
+			for len(row) <= t.rowidColumn {
+				row = append(row, nil)
+			}
+			row[t.rowidColumn] = rowid
+		}
+		return fn(row)
+	})
+}
+
+func (db *sqliteDB) readPage(n int64) ([]byte, error) {
+	if n < 1 || n > db.pageCount {
+		return nil, errors.Errorf("invalid SQLite page %d", n)
+	}
+	page := make([]byte, db.pageSize)
+	if _, err := db.r.ReadAt(page, (n-1)*db.pageSize); err != nil {
+		return nil, errors.Wrapf(err, "couldn't read SQLite page %d", n)
+	}
+	return page[:db.usable], nil
+}
+
+// scanPage calls fn with the rowid and the payload of each cell in the table
+// b-tree rooted at page n, in rowid order.
+func (db *sqliteDB) scanPage(n int64, depth int, fn func(rowid int64, payload []byte) error) error {
+	if depth > sqliteMaxDepth {
+		return errors.New("SQLite b-tree is too deep")
+	}
+	page, err := db.readPage(n)
+	if err != nil {
+		return err
+	}
+	// The first page starts with the database header.
+	off := 0
+	if n == 1 {
+		off = 100
+	}
+	if len(page) < off+12 {
+		return errors.Errorf("invalid SQLite page %d", n)
+	}
+	typ := page[off]
+	cells := int(binary.BigEndian.Uint16(page[off+3:]))
+	headerSize := 8
+	if typ == sqliteInteriorTable {
+		headerSize = 12
+	} else if typ != sqliteLeafTable {
+		return errors.Errorf("unexpected SQLite page type %#x in page %d", typ, n)
+	}
+	pointers := off + headerSize
+	if pointers+2*cells > len(page) {
+		return errors.Errorf("invalid cell count in SQLite page %d", n)
+	}
+
+	for i := 0; i < cells; i++ {
+		cell := int(binary.BigEndian.Uint16(page[pointers+2*i:]))
+		if cell >= len(page) {
+			return errors.Errorf("invalid cell offset in SQLite page %d", n)
+		}
+		if typ == sqliteInteriorTable {
+			if cell+4 > len(page) {
+				return errors.Errorf("invalid cell offset in SQLite page %d", n)
+			}
+			child := int64(binary.BigEndian.Uint32(page[cell:]))
+			if err = db.scanPage(child, depth+1, fn); err != nil {
+				return err
+			}
+			continue
+		}
+
+		size, k := sqliteVarint(page[cell:])
+		if k == 0 || size < 0 {
+			return errors.Errorf("invalid cell in SQLite page %d", n)
+		}
+		cell += k
+		rowid, k := sqliteVarint(page[cell:])
+		if k == 0 {
+			return errors.Errorf("invalid cell in SQLite page %d", n)
+		}
+		cell += k
+		payload, err := db.readPayload(page[cell:], size)
+		if err != nil {
+			return errors.Wrapf(err, "couldn't read cell in SQLite page %d", n)
+		}
+		if err = fn(rowid, payload); err != nil {
+			return err
+		}
+	}
+
+	if typ == sqliteInteriorTable {
+		return db.scanPage(int64(binary.BigEndian.Uint32(page[off+8:])), depth+1, fn)
+	}
+	return nil
+}
+
+// readPayload returns the payload of the given size of a table leaf cell,
+// which starts at data and continues in overflow pages when it doesn't fit.
+func (db *sqliteDB) readPayload(data []byte, size int64) ([]byte, error) {
+	u := db.usable
+	maxLocal := u - 35
+	local := size
+	if size > maxLocal {
+		minLocal := (u-12)*32/255 - 23
+		local = minLocal + (size-minLocal)%(u-4)
+		if local > maxLocal {
+			local = minLocal
+		}
+	}
+	if int64(len(data)) < local {
+		return nil, errors.New("payload exceeds the page")
+	}
+	payload := make([]byte, 0, size)
+	payload = append(payload, data[:local]...)
+	if local == size {
+		return payload, nil
+	}
+
+	if int64(len(data)) < local+4 {
+		return nil, errors.New("missing overflow page")
+	}
+	next := int64(binary.BigEndian.Uint32(data[local:]))
+	for pages := int64(0); int64(len(payload)) < size; pages++ {
+		if pages > db.pageCount {
+			return nil, errors.New("loop in overflow pages")
+		}
+		page, err := db.readPage(next)
+		if err != nil {
+			return nil, err
+		}
+		chunk := page[4:]
+		if remaining := size - int64(len(payload)); int64(len(chunk)) > remaining {
+			chunk = chunk[:remaining]
+		}
+		payload = append(payload, chunk...)
+		next = int64(binary.BigEndian.Uint32(page))
+	}
+	return payload, nil
+}
+
+// sqliteVarint decodes a SQLite varint, returning the value and the number of
+// bytes read, which is 0 if data is too short.
+func sqliteVarint(data []byte) (int64, int) {
+	var v uint64
+	for i := 0; i < 9 && i < len(data); i++ {
+		if i == 8 {
+			return int64(v<<8 | uint64(data[i])), 9
+		}
+		v = v<<7 | uint64(data[i]&0x7F)
+		if data[i]&0x80 == 0 {
+			return int64(v), i + 1
+		}
+	}
+	return 0, 0
+}
+
+// decodeRecord decodes the values of a record in the SQLite record format.
+func decodeRecord(payload []byte) ([]interface{}, error) {
+	headerSize, k := sqliteVarint(payload)
+	if k == 0 || headerSize < int64(k) || headerSize > int64(len(payload)) {
+		return nil, errors.New("invalid record header")
+	}
+	header := payload[k:headerSize]
+	body := payload[headerSize:]
+
+	var values []interface{}
+	for len(header) > 0 {
+		serial, k := sqliteVarint(header)
+		if k == 0 || serial < 0 {
+			return nil, errors.New("invalid record header")
+		}
+		header = header[k:]
+
+		var size int64
+		switch {
+		case serial >= 12:
+			size = (serial - 12) / 2
+		case serial >= 1 && serial <= 4:
+			size = serial
+		case serial == 5:
+			size = 6
+		case serial == 6 || serial == 7:
+			size = 8
+		case serial == 10 || serial == 11:
+			return nil, errors.New("invalid record serial type")
+		}
+		if int64(len(body)) < size {
+			return nil, errors.New("record values exceed the payload")
+		}
+		data := body[:size]
+		body = body[size:]
+
+		switch {
+		case serial == 0:
+			values = append(values, nil)
+		case serial <= 6:
+			// Big-endian two's complement integers of 1 to 8 bytes.
+			var v int64
+			if len(data) > 0 && data[0]&0x80 != 0 {
+				v = -1
+			}
+			for _, b := range data {
+				v = v<<8 | int64(b)
+			}
+			values = append(values, v)
+		case serial == 7:
+			values = append(values, math.Float64frombits(binary.BigEndian.Uint64(data)))
+		case serial == 8:
+			values = append(values, int64(0))
+		case serial == 9:
+			values = append(values, int64(1))
+		case serial%2 == 0:
+			values = append(values, append([]byte{}, data...))
+		default:
+			values = append(values, string(data))
+		}
+	}
+	return values, nil
+}
+
+// sqliteString converts a value to a string, like SQLite does when a value is
+// read as text. NULL is an empty string.
+func sqliteString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case []byte:
+		return string(bytes.TrimRight(v, "\x00"))
+	}
+	return ""
+}
+
+// sqliteInt converts a value to an integer, like SQLite does when a value is
+// read as an integer. Values that are not numbers are 0.
+func sqliteInt(v interface{}) int64 {
+	switch v := v.(type) {
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	case string:
+		n, _ := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		return n
+	}
+	return 0
+}
@@ -0,0 +1,16 @@
+-- The files of bar span several pages, so the filelist table has interior
+-- pages.
+CREATE TABLE db_info (dbversion INTEGER, checksum TEXT);
+CREATE TABLE packages (pkgKey INTEGER PRIMARY KEY, pkgId TEXT);
+CREATE TABLE filelist (pkgKey INTEGER, dirname TEXT, filenames TEXT, filetypes TEXT);
+CREATE INDEX keyfile ON filelist (pkgKey);
+CREATE INDEX pkgId ON packages (pkgId);
+INSERT INTO db_info VALUES (10, '0123');
+INSERT INTO packages VALUES (1, '1111');
+INSERT INTO packages VALUES (2, '2222');
+INSERT INTO filelist VALUES (1, '/usr/bin', 'foo', 'f');
+INSERT INTO filelist VALUES (1, '/usr/share', 'foo', 'd');
+INSERT INTO filelist VALUES (1, '/var/log', 'foo.log', 'g');
+INSERT INTO filelist
+	WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 200)
+	SELECT 2, '/usr/share/bar/' || i, 'a/b', 'fd' FROM n;
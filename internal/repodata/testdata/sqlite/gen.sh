@@ -0,0 +1,14 @@
+#!/bin/bash
+# Regenerates the SQLite metadata used by TestReadSQLite with sqlite3. The
+# small page size makes the tables span interior and overflow pages.
+set -euo pipefail
+
+cd "$(dirname "$0")"
+for name in primary filelists other; do
+	rm -f "$name.sqlite"
+	sqlite3 "$name.sqlite" "PRAGMA page_size = 512;" ".read $name.sql"
+done
+# createrepo compresses the databases, with bzip2 by default.
+rm -f primary.sqlite.bz2 other.sqlite.xz
+bzip2 primary.sqlite
+xz other.sqlite
@@ -0,0 +1,9 @@
+CREATE TABLE db_info (dbversion INTEGER, checksum TEXT);
+CREATE TABLE packages (pkgKey INTEGER PRIMARY KEY, pkgId TEXT);
+CREATE TABLE changelog (pkgKey INTEGER, author TEXT, date INTEGER, changelog TEXT);
+CREATE INDEX keychange ON changelog (pkgKey);
+INSERT INTO db_info VALUES (10, '0123');
+INSERT INTO packages VALUES (1, '1111');
+INSERT INTO packages VALUES (2, '2222');
+INSERT INTO changelog VALUES (1, 'Jane Doe <jane@example.com> - 1.2-3', 1500000000, '- Update to 1.2');
+INSERT INTO changelog VALUES (1, 'Jane Doe <jane@example.com> - 1.1-1', 1400000000, '- Initial package');
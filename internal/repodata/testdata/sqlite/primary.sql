@@ -0,0 +1,37 @@
+-- The schema of createrepo_c, with two packages. The description of foo does
+-- not fit in a page and is stored in overflow pages.
+CREATE TABLE db_info (dbversion INTEGER, checksum TEXT);
+CREATE TABLE packages (pkgKey INTEGER PRIMARY KEY, pkgId TEXT, name TEXT, arch TEXT, version TEXT,
+	epoch TEXT, release TEXT, summary TEXT, description TEXT, url TEXT, time_file INTEGER,
+	time_build INTEGER, rpm_license TEXT, rpm_vendor TEXT, rpm_group TEXT, rpm_buildhost TEXT,
+	rpm_sourcerpm TEXT, rpm_header_start INTEGER, rpm_header_end INTEGER, rpm_packager TEXT,
+	size_package INTEGER, size_installed INTEGER, size_archive INTEGER, location_href TEXT,
+	location_base TEXT, checksum_type TEXT);
+CREATE TABLE files (name TEXT, type TEXT, pkgKey INTEGER);
+CREATE TABLE requires (name TEXT, flags TEXT, epoch TEXT, version TEXT, release TEXT, pkgKey INTEGER, pre BOOLEAN DEFAULT FALSE);
+CREATE TABLE provides (name TEXT, flags TEXT, epoch TEXT, version TEXT, release TEXT, pkgKey INTEGER);
+CREATE TABLE conflicts (name TEXT, flags TEXT, epoch TEXT, version TEXT, release TEXT, pkgKey INTEGER);
+CREATE TABLE obsoletes (name TEXT, flags TEXT, epoch TEXT, version TEXT, release TEXT, pkgKey INTEGER);
+CREATE INDEX packagename ON packages (name);
+CREATE INDEX packageId ON packages (pkgId);
+CREATE INDEX pkgprovides ON provides (pkgKey);
+CREATE TRIGGER removals AFTER DELETE ON packages
+	BEGIN
+	DELETE FROM files WHERE pkgKey = old.pkgKey;
+	DELETE FROM requires WHERE pkgKey = old.pkgKey;
+	DELETE FROM provides WHERE pkgKey = old.pkgKey;
+	END;
+INSERT INTO db_info VALUES (10, '0123');
+INSERT INTO packages VALUES (1, '1111', 'foo', 'x86_64', '1.2', '0', '3', 'The foo package',
+	substr(replace(hex(zeroblob(2000)), '00', 'Foo does things. '), 1, 5000),
+	'https://example.com/foo', 1530000000, 1520000000, 'MIT', NULL, 'Unspecified', 'builder',
+	'foo-1.2-3.src.rpm', 4504, 6000, NULL, 100, 2000, 2100, 'Packages/foo-1.2-3.x86_64.rpm',
+	NULL, 'sha256');
+INSERT INTO packages VALUES (2, '2222', 'bar', 'noarch', '2.0', '1', '1', 'The bar package', NULL,
+	NULL, 1530000000, 1520000000, 'GPLv2', NULL, NULL, NULL, NULL, 0, 0, NULL, 10, 20, 30,
+	'bar-2.0-1.noarch.rpm', NULL, 'sha256');
+INSERT INTO provides VALUES ('foo', 'EQ', '0', '1.2', '3', 1);
+INSERT INTO requires VALUES ('bar', 'GE', '0', '2', NULL, 1, 'FALSE');
+INSERT INTO requires VALUES ('/bin/sh', NULL, NULL, NULL, NULL, 1, 'TRUE');
+INSERT INTO conflicts VALUES ('baz', 'LT', '0', '1', NULL, 2);
+INSERT INTO obsoletes VALUES ('oldbar', NULL, NULL, NULL, NULL, 2);
@@ -0,0 +1,543 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package repodata
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/clearlinux/mixer-tools/internal/rpm"
+	"github.com/pkg/errors"
+)
+
+// changelogLimit is the number of changelog entries kept for each package,
+// the same default used by createrepo.
+const changelogLimit = 10
+
+// Sense bits of the dependency flags in the RPM header.
+const (
+	senseLess    = 1 << 1
+	senseGreater = 1 << 2
+	senseEqual   = 1 << 3
+	senseMask    = senseLess | senseGreater | senseEqual
+
+	// Requirements needed by the package scripts are marked as pre.
+	sensePre = 1<<6 | 1<<9 | 1<<10
+)
+
+// senseFlags maps the sense bits to the flags in the metadata. Other
+// combinations are not valid comparisons and have no flags.
+var senseFlags = map[int64]string{
+	senseLess:                 "LT",
+	senseGreater:              "GT",
+	senseEqual:                "EQ",
+	senseLess | senseEqual:    "LE",
+	senseGreater | senseEqual: "GE",
+}
+
+// primaryFiles matches the files that are listed in the primary metadata in
+// addition to the filelists, so common file dependencies can be resolved
+// without reading all the files.
+var primaryFiles = regexp.MustCompile(`^(/etc/|.*bin/|/usr/lib/sendmail$)`)
+
+// PackageFromRPM reads the metadata of the RPM file at filename, including
+// its files and changelogs. Location is the path of the file relative to the
+// repository.
+func PackageFromRPM(filename, location string) (*Package, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	rp, err := rpm.ReadPackage(bufio.NewReader(f))
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't read %s", filename)
+	}
+	hash := sha256.New()
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if _, err = io.Copy(hash, f); err != nil {
+		return nil, errors.Wrapf(err, "couldn't read %s", filename)
+	}
+
+	h := rp.Header
+	p := &Package{
+		PkgID:        hex.EncodeToString(hash.Sum(nil)),
+		ChecksumType: "sha256",
+		Name:         h.String(rpm.TagName),
+		Arch:         h.String(rpm.TagArch),
+		Epoch:        "0",
+		Version:      h.String(rpm.TagVersion),
+		Release:      h.String(rpm.TagRelease),
+		Summary:      h.String(rpm.TagSummary),
+		Description:  h.String(rpm.TagDescription),
+		URL:          h.String(rpm.TagURL),
+		License:      h.String(rpm.TagLicense),
+		Vendor:       h.String(rpm.TagVendor),
+		Group:        h.String(rpm.TagGroup),
+		BuildHost:    h.String(rpm.TagBuildHost),
+		Packager:     h.String(rpm.TagPackager),
+		SourceRPM:    h.String(rpm.TagSourceRPM),
+		Location:     location,
+		TimeFile:     fi.ModTime().Unix(),
+		TimeBuild:    firstInt(h.Ints(rpm.TagBuildTime)),
+		PackageSize:  fi.Size(),
+		Provides:     readEntries(h, rpm.TagProvideName, rpm.TagProvideFlags, rpm.TagProvideVersion),
+		Requires:     readEntries(h, rpm.TagRequireName, rpm.TagRequireFlags, rpm.TagRequireVersion),
+		Conflicts:    readEntries(h, rpm.TagConflictName, rpm.TagConflictFlags, rpm.TagConflictVersion),
+		Obsoletes:    readEntries(h, rpm.TagObsoleteName, rpm.TagObsoleteFlags, rpm.TagObsoleteVersion),
+	}
+	if epoch := h.Ints(rpm.TagEpoch); len(epoch) > 0 {
+		p.Epoch = strconv.FormatInt(epoch[0], 10)
+	}
+	// Like rpm, consider packages without a source RPM to be source
+	// packages themselves.
+	if !h.Has(rpm.TagSourceRPM) {
+		p.Arch = "src"
+	}
+
+	p.InstalledSize = firstInt(h.Ints(rpm.TagLongSize))
+	if p.InstalledSize == 0 {
+		p.InstalledSize = firstInt(h.Ints(rpm.TagSize))
+	}
+	p.ArchiveSize = firstInt(h.Ints(rpm.TagArchiveSize))
+	if p.ArchiveSize == 0 {
+		p.ArchiveSize = firstInt(rp.Signature.Ints(rpm.SigTagLongArchiveSize))
+	}
+	if p.ArchiveSize == 0 {
+		p.ArchiveSize = firstInt(rp.Signature.Ints(rpm.SigTagPayloadSize))
+	}
+	p.HeaderStart, p.HeaderEnd = rp.HeaderRange()
+
+	for _, file := range rp.Files() {
+		f := File{Name: file.Name, Type: FileTypeFile}
+		if file.Flags&rpm.FileFlagGhost != 0 {
+			f.Type = FileTypeGhost
+		} else if file.Mode&syscall.S_IFMT == syscall.S_IFDIR {
+			f.Type = FileTypeDir
+		}
+		p.Files = append(p.Files, f)
+	}
+
+	// Changelogs are stored from the newest in the header, but listed from
+	// the oldest in the metadata.
+	times := h.Ints(rpm.TagChangelogTime)
+	names := h.Strings(rpm.TagChangelogName)
+	texts := h.Strings(rpm.TagChangelogText)
+	for i := 0; i < len(times) && i < len(names) && i < len(texts) && i < changelogLimit; i++ {
+		p.Changelogs = append([]Changelog{{Author: names[i], Date: times[i], Text: texts[i]}}, p.Changelogs...)
+	}
+	return p, nil
+}
+
+func firstInt(v []int64) int64 {
+	if len(v) == 0 {
+		return 0
+	}
+	return v[0]
+}
+
+// readEntries reads the dependencies stored in the name, flags and version
+// tags of the header.
+func readEntries(h *rpm.Header, nameTag, flagsTag, versionTag int32) []Entry {
+	names := h.Strings(nameTag)
+	flags := h.Ints(flagsTag)
+	versions := h.Strings(versionTag)
+
+	var entries []Entry
+	seen := make(map[Entry]bool)
+	for i, name := range names {
+		var e Entry
+		e.Name = name
+		var f int64
+		if i < len(flags) {
+			f = flags[i]
+		}
+		// Requirements on rpmlib features are satisfied by rpm itself.
+		if nameTag == rpm.TagRequireName {
+			if strings.HasPrefix(name, "rpmlib(") {
+				continue
+			}
+			e.Pre = f&sensePre != 0
+		}
+		e.Flags = senseFlags[f&senseMask]
+		if i < len(versions) && versions[i] != "" {
+			e.Epoch, e.Version, e.Release = splitEVR(versions[i])
+		}
+		if seen[e] {
+			continue
+		}
+		seen[e] = true
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// splitEVR splits a [epoch:]version[-release] string. The epoch defaults to 0
+// like in createrepo.
+func splitEVR(evr string) (epoch, version, release string) {
+	epoch = "0"
+	if i := strings.IndexByte(evr, ':'); i >= 0 {
+		if i > 0 {
+			epoch = evr[:i]
+		}
+		evr = evr[i+1:]
+	}
+	version = evr
+	if i := strings.LastIndexByte(evr, '-'); i >= 0 {
+		version, release = evr[:i], evr[i+1:]
+	}
+	return epoch, version, release
+}
+
+// UpdateStats has the number of packages changed by Update.
+type UpdateStats struct {
+	Added   int
+	Removed int
+	Reused  int
+}
+
+// Update generates the metadata for the RPM files in dir. The existing
+// metadata is reused for the files that didn't change since it was generated,
+// so only added files need to be read. The metadata is not written again if
+// no files were added or removed.
+func Update(dir string) (*UpdateStats, error) {
+	var names []string
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() && path == filepath.Join(dir, "repodata") {
+			return filepath.SkipDir
+		}
+		if fi.Mode().IsRegular() && strings.HasSuffix(path, ".rpm") {
+			names = append(names, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't list RPM files in %s", dir)
+	}
+
+	old, complete := readExisting(dir)
+	stats := &UpdateStats{}
+	pkgs := make([]*Package, 0, len(names))
+	for _, name := range names {
+		rel, err := filepath.Rel(dir, name)
+		if err != nil {
+			return nil, err
+		}
+		location := filepath.ToSlash(rel)
+
+		fi, err := os.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		if p, ok := old[location]; ok && p.TimeFile == fi.ModTime().Unix() && p.PackageSize == fi.Size() {
+			delete(old, location)
+			pkgs = append(pkgs, p)
+			stats.Reused++
+			continue
+		}
+
+		p, err := PackageFromRPM(name, location)
+		if err != nil {
+			return nil, err
+		}
+		pkgs = append(pkgs, p)
+		stats.Added++
+	}
+	stats.Removed = len(old)
+
+	if complete && stats.Added == 0 && stats.Removed == 0 {
+		return stats, nil
+	}
+	if err = Write(dir, pkgs); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// readExisting returns the packages in the existing metadata of dir by
+// location, with their files and changelogs. When the metadata is missing or
+// can't be read, no packages are returned and complete is false.
+func readExisting(dir string) (pkgs map[string]*Package, complete bool) {
+	r, err := Open(dir)
+	if err != nil {
+		return nil, false
+	}
+	list, err := r.Packages()
+	if err != nil {
+		return nil, false
+	}
+	if err = r.ReadFiles(list); err != nil {
+		return nil, false
+	}
+	if err = r.ReadChangelogs(list); err != nil {
+		return nil, false
+	}
+	pkgs = make(map[string]*Package, len(list))
+	for _, p := range list {
+		pkgs[p.Location] = p
+	}
+	return pkgs, true
+}
+
+// Write generates the primary, filelists and other metadata of pkgs and the
+// repomd.xml that points to them in the repodata directory of dir. Metadata
+// files that are no longer used are removed.
+func Write(dir string, pkgs []*Package) error {
+	sorted := make([]*Package, len(pkgs))
+	copy(sorted, pkgs)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Location < sorted[j].Location
+	})
+
+	repodataDir := filepath.Join(dir, "repodata")
+	if err := os.MkdirAll(repodataDir, 0755); err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	md := RepoMD{Revision: strconv.FormatInt(now, 10)}
+	generators := []struct {
+		dataType string
+		write    func(io.Writer, []*Package)
+	}{
+		{"primary", writePrimary},
+		{"filelists", writeFilelists},
+		{"other", writeOther},
+	}
+	for _, g := range generators {
+		var content bytes.Buffer
+		g.write(&content, sorted)
+		d, err := writeMetaData(repodataDir, g.dataType, content.Bytes())
+		if err != nil {
+			return err
+		}
+		d.Timestamp = now
+		md.Data = append(md.Data, *d)
+	}
+
+	var buf bytes.Buffer
+	writeRepoMD(&buf, &md)
+	tmp := filepath.Join(repodataDir, ".repomd.xml.tmp")
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(repodataDir, "repomd.xml")); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	// Only remove the old metadata after the new repomd.xml is in place, so
+	// the repository is always consistent.
+	used := map[string]bool{"repomd.xml": true}
+	for _, d := range md.Data {
+		used[filepath.Base(d.Location.Href)] = true
+	}
+	entries, err := ioutil.ReadDir(repodataDir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !used[e.Name()] {
+			if err = os.RemoveAll(filepath.Join(repodataDir, e.Name())); err != nil {
+				return errors.Wrapf(err, "couldn't remove old metadata")
+			}
+		}
+	}
+	return nil
+}
+
+// writeMetaData compresses content into a file named after its checksum, as
+// done by createrepo.
+func writeMetaData(repodataDir, dataType string, content []byte) (*MetaData, error) {
+	var compressed bytes.Buffer
+	gw := gzip.NewWriter(&compressed)
+	if _, err := gw.Write(content); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+
+	openSum := sha256.Sum256(content)
+	sum := sha256.Sum256(compressed.Bytes())
+	name := fmt.Sprintf("%x-%s.xml.gz", sum, dataType)
+
+	var d MetaData
+	d.Type = dataType
+	d.Location.Href = "repodata/" + name
+	d.Checksum.Type = "sha256"
+	d.Checksum.Value = hex.EncodeToString(sum[:])
+	d.OpenChecksum.Type = "sha256"
+	d.OpenChecksum.Value = hex.EncodeToString(openSum[:])
+	d.Size = int64(compressed.Len())
+	d.OpenSize = int64(len(content))
+
+	if err := ioutil.WriteFile(filepath.Join(repodataDir, name), compressed.Bytes(), 0644); err != nil {
+		return nil, errors.Wrapf(err, "couldn't write %s metadata", dataType)
+	}
+	return &d, nil
+}
+
+// esc returns s escaped for use in XML character data and attributes.
+func esc(s string) string {
+	var buf bytes.Buffer
+	_ = xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+const xmlHeader = `<?xml version="1.0" encoding="UTF-8"?>` + "\n"
+
+func writeVersion(w io.Writer, indent string, p *Package) {
+	_, _ = fmt.Fprintf(w, "%s<version epoch=\"%s\" ver=\"%s\" rel=\"%s\"/>\n", indent, esc(p.Epoch), esc(p.Version), esc(p.Release))
+}
+
+func writeEntries(w io.Writer, element string, entries []Entry) {
+	if len(entries) == 0 {
+		return
+	}
+	_, _ = fmt.Fprintf(w, "    <rpm:%s>\n", element)
+	for _, e := range entries {
+		_, _ = fmt.Fprintf(w, "      <rpm:entry name=\"%s\"", esc(e.Name))
+		if e.Flags != "" {
+			_, _ = fmt.Fprintf(w, " flags=\"%s\" epoch=\"%s\" ver=\"%s\"", e.Flags, esc(e.Epoch), esc(e.Version))
+			if e.Release != "" {
+				_, _ = fmt.Fprintf(w, " rel=\"%s\"", esc(e.Release))
+			}
+		}
+		if e.Pre {
+			_, _ = fmt.Fprint(w, " pre=\"1\"")
+		}
+		_, _ = fmt.Fprint(w, "/>\n")
+	}
+	_, _ = fmt.Fprintf(w, "    </rpm:%s>\n", element)
+}
+
+func writeFile(w io.Writer, indent string, f File) {
+	if f.Type != FileTypeFile {
+		_, _ = fmt.Fprintf(w, "%s<file type=\"%s\">%s</file>\n", indent, f.Type, esc(f.Name))
+	} else {
+		_, _ = fmt.Fprintf(w, "%s<file>%s</file>\n", indent, esc(f.Name))
+	}
+}
+
+func writePrimary(w io.Writer, pkgs []*Package) {
+	_, _ = fmt.Fprint(w, xmlHeader)
+	_, _ = fmt.Fprintf(w, "<metadata xmlns=\"http://linux.duke.edu/metadata/common\" xmlns:rpm=\"http://linux.duke.edu/metadata/rpm\" packages=\"%d\">\n", len(pkgs))
+	for _, p := range pkgs {
+		_, _ = fmt.Fprint(w, "<package type=\"rpm\">\n")
+		_, _ = fmt.Fprintf(w, "  <name>%s</name>\n", esc(p.Name))
+		_, _ = fmt.Fprintf(w, "  <arch>%s</arch>\n", esc(p.Arch))
+		writeVersion(w, "  ", p)
+		_, _ = fmt.Fprintf(w, "  <checksum type=\"%s\" pkgid=\"YES\">%s</checksum>\n", esc(p.ChecksumType), esc(p.PkgID))
+		_, _ = fmt.Fprintf(w, "  <summary>%s</summary>\n", esc(p.Summary))
+		_, _ = fmt.Fprintf(w, "  <description>%s</description>\n", esc(p.Description))
+		_, _ = fmt.Fprintf(w, "  <packager>%s</packager>\n", esc(p.Packager))
+		_, _ = fmt.Fprintf(w, "  <url>%s</url>\n", esc(p.URL))
+		_, _ = fmt.Fprintf(w, "  <time file=\"%d\" build=\"%d\"/>\n", p.TimeFile, p.TimeBuild)
+		_, _ = fmt.Fprintf(w, "  <size package=\"%d\" installed=\"%d\" archive=\"%d\"/>\n", p.PackageSize, p.InstalledSize, p.ArchiveSize)
+		_, _ = fmt.Fprintf(w, "  <location href=\"%s\"/>\n", esc(p.Location))
+		_, _ = fmt.Fprint(w, "  <format>\n")
+		_, _ = fmt.Fprintf(w, "    <rpm:license>%s</rpm:license>\n", esc(p.License))
+		_, _ = fmt.Fprintf(w, "    <rpm:vendor>%s</rpm:vendor>\n", esc(p.Vendor))
+		_, _ = fmt.Fprintf(w, "    <rpm:group>%s</rpm:group>\n", esc(p.Group))
+		_, _ = fmt.Fprintf(w, "    <rpm:buildhost>%s</rpm:buildhost>\n", esc(p.BuildHost))
+		_, _ = fmt.Fprintf(w, "    <rpm:sourcerpm>%s</rpm:sourcerpm>\n", esc(p.SourceRPM))
+		_, _ = fmt.Fprintf(w, "    <rpm:header-range start=\"%d\" end=\"%d\"/>\n", p.HeaderStart, p.HeaderEnd)
+		writeEntries(w, "provides", p.Provides)
+		writeEntries(w, "requires", p.Requires)
+		writeEntries(w, "conflicts", p.Conflicts)
+		writeEntries(w, "obsoletes", p.Obsoletes)
+		for _, f := range p.Files {
+			if f.Type != FileTypeGhost && primaryFiles.MatchString(f.Name) {
+				writeFile(w, "    ", f)
+			}
+		}
+		_, _ = fmt.Fprint(w, "  </format>\n")
+		_, _ = fmt.Fprint(w, "</package>\n")
+	}
+	_, _ = fmt.Fprint(w, "</metadata>\n")
+}
+
+func writeFilelists(w io.Writer, pkgs []*Package) {
+	_, _ = fmt.Fprint(w, xmlHeader)
+	_, _ = fmt.Fprintf(w, "<filelists xmlns=\"http://linux.duke.edu/metadata/filelists\" packages=\"%d\">\n", len(pkgs))
+	for _, p := range pkgs {
+		_, _ = fmt.Fprintf(w, "<package pkgid=\"%s\" name=\"%s\" arch=\"%s\">\n", esc(p.PkgID), esc(p.Name), esc(p.Arch))
+		writeVersion(w, "  ", p)
+		for _, f := range p.Files {
+			writeFile(w, "  ", f)
+		}
+		_, _ = fmt.Fprint(w, "</package>\n")
+	}
+	_, _ = fmt.Fprint(w, "</filelists>\n")
+}
+
+func writeOther(w io.Writer, pkgs []*Package) {
+	_, _ = fmt.Fprint(w, xmlHeader)
+	_, _ = fmt.Fprintf(w, "<otherdata xmlns=\"http://linux.duke.edu/metadata/other\" packages=\"%d\">\n", len(pkgs))
+	for _, p := range pkgs {
+		_, _ = fmt.Fprintf(w, "<package pkgid=\"%s\" name=\"%s\" arch=\"%s\">\n", esc(p.PkgID), esc(p.Name), esc(p.Arch))
+		writeVersion(w, "  ", p)
+		for _, c := range p.Changelogs {
+			_, _ = fmt.Fprintf(w, "  <changelog author=\"%s\" date=\"%d\">%s</changelog>\n", esc(c.Author), c.Date, esc(c.Text))
+		}
+		_, _ = fmt.Fprint(w, "</package>\n")
+	}
+	_, _ = fmt.Fprint(w, "</otherdata>\n")
+}
+
+func writeRepoMD(w io.Writer, md *RepoMD) {
+	_, _ = fmt.Fprint(w, xmlHeader)
+	_, _ = fmt.Fprint(w, "<repomd xmlns=\"http://linux.duke.edu/metadata/repo\" xmlns:rpm=\"http://linux.duke.edu/metadata/rpm\">\n")
+	_, _ = fmt.Fprintf(w, "  <revision>%s</revision>\n", esc(md.Revision))
+	for _, d := range md.Data {
+		_, _ = fmt.Fprintf(w, "  <data type=\"%s\">\n", esc(d.Type))
+		_, _ = fmt.Fprintf(w, "    <checksum type=\"%s\">%s</checksum>\n", d.Checksum.Type, d.Checksum.Value)
+		_, _ = fmt.Fprintf(w, "    <open-checksum type=\"%s\">%s</open-checksum>\n", d.OpenChecksum.Type, d.OpenChecksum.Value)
+		_, _ = fmt.Fprintf(w, "    <location href=\"%s\"/>\n", esc(d.Location.Href))
+		_, _ = fmt.Fprintf(w, "    <timestamp>%d</timestamp>\n", d.Timestamp)
+		_, _ = fmt.Fprintf(w, "    <size>%d</size>\n", d.Size)
+		_, _ = fmt.Fprintf(w, "    <open-size>%d</open-size>\n", d.OpenSize)
+		_, _ = fmt.Fprint(w, "  </data>\n")
+	}
+	_, _ = fmt.Fprint(w, "</repomd>\n")
+}
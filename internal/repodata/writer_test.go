@@ -0,0 +1,256 @@
+package repodata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/clearlinux/mixer-tools/internal/rpm"
+)
+
+type testEntry struct {
+	tag   int32
+	typ   uint32
+	count int
+	data  []byte
+}
+
+// buildTestHeader returns an RPM header structure with the entries.
+func buildTestHeader(entries []testEntry) []byte {
+	var store bytes.Buffer
+	var index []uint32
+	for _, e := range entries {
+		align := map[uint32]int{3: 2, 4: 4}[e.typ]
+		for align > 0 && store.Len()%align != 0 {
+			store.WriteByte(0)
+		}
+		index = append(index, uint32(e.tag), e.typ, uint32(store.Len()), uint32(e.count))
+		store.Write(e.data)
+	}
+	var buf bytes.Buffer
+	buf.Write([]byte{0x8E, 0xAD, 0xE8, 0x01, 0, 0, 0, 0})
+	_ = binary.Write(&buf, binary.BigEndian, []uint32{uint32(len(entries)), uint32(store.Len())})
+	_ = binary.Write(&buf, binary.BigEndian, index)
+	buf.Write(store.Bytes())
+	return buf.Bytes()
+}
+
+func strEntry(tag int32, s string) testEntry {
+	return testEntry{tag, 6, 1, append([]byte(s), 0)}
+}
+
+func strsEntry(tag int32, s ...string) testEntry {
+	var data []byte
+	for _, v := range s {
+		data = append(append(data, v...), 0)
+	}
+	return testEntry{tag, 8, len(s), data}
+}
+
+func intsEntry(tag int32, v ...uint32) testEntry {
+	data := make([]byte, 4*len(v))
+	for i, n := range v {
+		binary.BigEndian.PutUint32(data[4*i:], n)
+	}
+	return testEntry{tag, 4, len(v), data}
+}
+
+func shortsEntry(tag int32, v ...uint16) testEntry {
+	data := make([]byte, 2*len(v))
+	for i, n := range v {
+		binary.BigEndian.PutUint16(data[2*i:], n)
+	}
+	return testEntry{tag, 3, len(v), data}
+}
+
+// writeTestRPM writes an RPM file without payload, which is enough for the
+// metadata.
+func writeTestRPM(t *testing.T, filename, name, version string) {
+	t.Helper()
+	h := buildTestHeader([]testEntry{
+		strEntry(rpm.TagName, name),
+		strEntry(rpm.TagVersion, version),
+		strEntry(rpm.TagRelease, "1"),
+		strEntry(rpm.TagSummary, "The "+name+" package"),
+		strEntry(rpm.TagDescription, "Uses <xml> & such."),
+		intsEntry(rpm.TagBuildTime, 1500000000),
+		intsEntry(rpm.TagSize, 1234),
+		strEntry(rpm.TagLicense, "MIT"),
+		strEntry(rpm.TagArch, "x86_64"),
+		shortsEntry(rpm.TagFileModes, 040755, 0100755, 0100644),
+		intsEntry(rpm.TagFileFlags, 0, 0, rpm.FileFlagGhost),
+		strEntry(rpm.TagSourceRPM, name+"-"+version+"-1.src.rpm"),
+		strsEntry(rpm.TagProvideName, name),
+		intsEntry(rpm.TagRequireFlags, 8|4, 1<<24, 1<<9),
+		strsEntry(rpm.TagRequireName, "bar", "rpmlib(PayloadIsZstd)", "/bin/sh"),
+		strsEntry(rpm.TagRequireVersion, "1:2-3", "5.4.18-1", ""),
+		intsEntry(rpm.TagChangelogTime, 1500000000, 1400000000),
+		strsEntry(rpm.TagChangelogName, "B <b@example.com> - "+version, "A <a@example.com> - 0.1"),
+		strsEntry(rpm.TagChangelogText, "- Update", "- Initial"),
+		intsEntry(rpm.TagProvideFlags, 8),
+		strsEntry(rpm.TagProvideVersion, version+"-1"),
+		intsEntry(rpm.TagDirIndexes, 0, 1, 2),
+		strsEntry(rpm.TagBasenames, name, name, name+".log"),
+		strsEntry(rpm.TagDirNames, "/usr/share/", "/usr/bin/", "/var/log/"),
+	})
+	sig := buildTestHeader([]testEntry{intsEntry(rpm.SigTagPayloadSize, 4321)})
+
+	var buf bytes.Buffer
+	lead := make([]byte, 96)
+	copy(lead, []byte{0xED, 0xAB, 0xEE, 0xDB})
+	buf.Write(lead)
+	buf.Write(sig)
+	for buf.Len()%8 != 0 {
+		buf.WriteByte(0)
+	}
+	buf.Write(h)
+	if err := ioutil.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPackageFromRPM(t *testing.T) {
+	dir, err := ioutil.TempDir("", "repodata-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	filename := filepath.Join(dir, "foo.rpm")
+	writeTestRPM(t, filename, "foo", "1.0")
+
+	p, err := PackageFromRPM(filename, "foo.rpm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.NEVRA() != "foo-1.0-1.x86_64" || p.SourceRPM != "foo-1.0-1.src.rpm" || len(p.PkgID) != 64 {
+		t.Errorf("unexpected package %+v", p)
+	}
+	if p.InstalledSize != 1234 || p.ArchiveSize != 4321 || p.TimeBuild != 1500000000 {
+		t.Errorf("unexpected sizes or times in %+v", p)
+	}
+	if p.HeaderStart == 0 || p.HeaderEnd <= p.HeaderStart {
+		t.Errorf("unexpected header range %d-%d", p.HeaderStart, p.HeaderEnd)
+	}
+
+	expectedProvides := []Entry{{Name: "foo", Flags: "EQ", Epoch: "0", Version: "1.0", Release: "1"}}
+	if !reflect.DeepEqual(p.Provides, expectedProvides) {
+		t.Errorf("got provides %+v, want %+v", p.Provides, expectedProvides)
+	}
+	expectedRequires := []Entry{
+		{Name: "bar", Flags: "GE", Epoch: "1", Version: "2", Release: "3"},
+		{Name: "/bin/sh", Pre: true},
+	}
+	if !reflect.DeepEqual(p.Requires, expectedRequires) {
+		t.Errorf("got requires %+v, want %+v", p.Requires, expectedRequires)
+	}
+	expectedFiles := []File{
+		{"/usr/share/foo", FileTypeDir},
+		{"/usr/bin/foo", FileTypeFile},
+		{"/var/log/foo.log", FileTypeGhost},
+	}
+	if !reflect.DeepEqual(p.Files, expectedFiles) {
+		t.Errorf("got files %+v, want %+v", p.Files, expectedFiles)
+	}
+	if len(p.Changelogs) != 2 || p.Changelogs[0].Text != "- Initial" || p.Changelogs[1].Date != 1500000000 {
+		t.Errorf("unexpected changelogs %+v", p.Changelogs)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "repodata-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	writeTestRPM(t, filepath.Join(dir, "foo-1.0-1.x86_64.rpm"), "foo", "1.0")
+	writeTestRPM(t, filepath.Join(dir, "bar-2.0-1.x86_64.rpm"), "bar", "2.0")
+
+	stats, err := Update(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *stats != (UpdateStats{Added: 2}) {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	r, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkgs, err := r.Packages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkgs) != 2 || pkgs[0].Name != "bar" || pkgs[1].Name != "foo" {
+		t.Fatalf("unexpected packages %+v", pkgs)
+	}
+	expected, err := PackageFromRPM(filepath.Join(dir, "foo-1.0-1.x86_64.rpm"), "foo-1.0-1.x86_64.rpm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.ReadFiles(pkgs); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.ReadChangelogs(pkgs); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pkgs[1], expected) {
+		t.Errorf("metadata doesn't match the RPM\ngot  %+v\nwant %+v", pkgs[1], expected)
+	}
+
+	// Nothing changed, so the metadata is kept.
+	stats, err = Update(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *stats != (UpdateStats{Reused: 2}) {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	// Make sure the revision changes when the metadata is written again.
+	time.Sleep(1100 * time.Millisecond)
+	if err = os.Remove(filepath.Join(dir, "bar-2.0-1.x86_64.rpm")); err != nil {
+		t.Fatal(err)
+	}
+	writeTestRPM(t, filepath.Join(dir, "baz-3.0-1.x86_64.rpm"), "baz", "3.0")
+	stats, err = Update(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *stats != (UpdateStats{Added: 1, Removed: 1, Reused: 1}) {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	r2, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r2.MD.Revision == r.MD.Revision {
+		t.Errorf("revision was not updated")
+	}
+	pkgs, err = r2.Packages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkgs) != 2 || pkgs[0].Name != "baz" || pkgs[1].Name != "foo" {
+		t.Fatalf("unexpected packages %+v", pkgs)
+	}
+
+	// Old metadata files are removed.
+	entries, err := ioutil.ReadDir(filepath.Join(dir, "repodata"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 {
+		t.Errorf("got %d files in repodata, want 4", len(entries))
+	}
+}
@@ -0,0 +1,259 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package repodata
+
+import (
+	"encoding/xml"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+type xmlEntry struct {
+	Name  string `xml:"name,attr"`
+	Flags string `xml:"flags,attr"`
+	Epoch string `xml:"epoch,attr"`
+	Ver   string `xml:"ver,attr"`
+	Rel   string `xml:"rel,attr"`
+	Pre   string `xml:"pre,attr"`
+}
+
+// xmlPackage is a package element of primary.xml. The rpm namespaced elements
+// are matched by local name only.
+type xmlPackage struct {
+	Type    string `xml:"type,attr"`
+	Name    string `xml:"name"`
+	Arch    string `xml:"arch"`
+	Version struct {
+		Epoch string `xml:"epoch,attr"`
+		Ver   string `xml:"ver,attr"`
+		Rel   string `xml:"rel,attr"`
+	} `xml:"version"`
+	Checksum struct {
+		Type  string `xml:"type,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"checksum"`
+	Summary     string `xml:"summary"`
+	Description string `xml:"description"`
+	Packager    string `xml:"packager"`
+	URL         string `xml:"url"`
+	Time        struct {
+		File  int64 `xml:"file,attr"`
+		Build int64 `xml:"build,attr"`
+	} `xml:"time"`
+	Size struct {
+		Package   int64 `xml:"package,attr"`
+		Installed int64 `xml:"installed,attr"`
+		Archive   int64 `xml:"archive,attr"`
+	} `xml:"size"`
+	Location struct {
+		Href string `xml:"href,attr"`
+	} `xml:"location"`
+	Format struct {
+		License     string `xml:"license"`
+		Vendor      string `xml:"vendor"`
+		Group       string `xml:"group"`
+		BuildHost   string `xml:"buildhost"`
+		SourceRPM   string `xml:"sourcerpm"`
+		HeaderRange struct {
+			Start int64 `xml:"start,attr"`
+			End   int64 `xml:"end,attr"`
+		} `xml:"header-range"`
+		Provides  []xmlEntry `xml:"provides>entry"`
+		Requires  []xmlEntry `xml:"requires>entry"`
+		Conflicts []xmlEntry `xml:"conflicts>entry"`
+		Obsoletes []xmlEntry `xml:"obsoletes>entry"`
+	} `xml:"format"`
+}
+
+func convertEntries(entries []xmlEntry) []Entry {
+	if len(entries) == 0 {
+		return nil
+	}
+	result := make([]Entry, 0, len(entries))
+	for _, e := range entries {
+		result = append(result, Entry{
+			Name:    e.Name,
+			Flags:   e.Flags,
+			Epoch:   e.Epoch,
+			Version: e.Ver,
+			Release: e.Rel,
+			Pre:     e.Pre == "1",
+		})
+	}
+	return result
+}
+
+// decodeElements calls fn for each start element with the given local name in
+// r, so large metadata files don't need to be kept in memory.
+func decodeElements(r io.Reader, local string, fn func(*xml.Decoder, *xml.StartElement) error) error {
+	d := xml.NewDecoder(r)
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if se, ok := tok.(xml.StartElement); ok && se.Name.Local == local {
+			if err = fn(d, &se); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (r *Repository) readPrimaryXML(d *MetaData) ([]*Package, error) {
+	f, err := r.open(d)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	var pkgs []*Package
+	err = decodeElements(f, "package", func(dec *xml.Decoder, se *xml.StartElement) error {
+		var xp xmlPackage
+		if err := dec.DecodeElement(&xp, se); err != nil {
+			return err
+		}
+		if xp.Type != "" && xp.Type != "rpm" {
+			return nil
+		}
+		pkgs = append(pkgs, &Package{
+			PkgID:         xp.Checksum.Value,
+			ChecksumType:  xp.Checksum.Type,
+			Name:          xp.Name,
+			Arch:          xp.Arch,
+			Epoch:         xp.Version.Epoch,
+			Version:       xp.Version.Ver,
+			Release:       xp.Version.Rel,
+			Summary:       xp.Summary,
+			Description:   xp.Description,
+			URL:           xp.URL,
+			License:       xp.Format.License,
+			Vendor:        xp.Format.Vendor,
+			Group:         xp.Format.Group,
+			BuildHost:     xp.Format.BuildHost,
+			Packager:      xp.Packager,
+			SourceRPM:     xp.Format.SourceRPM,
+			Location:      xp.Location.Href,
+			TimeFile:      xp.Time.File,
+			TimeBuild:     xp.Time.Build,
+			PackageSize:   xp.Size.Package,
+			InstalledSize: xp.Size.Installed,
+			ArchiveSize:   xp.Size.Archive,
+			HeaderStart:   xp.Format.HeaderRange.Start,
+			HeaderEnd:     xp.Format.HeaderRange.End,
+			Provides:      convertEntries(xp.Format.Provides),
+			Requires:      convertEntries(xp.Format.Requires),
+			Conflicts:     convertEntries(xp.Format.Conflicts),
+			Obsoletes:     convertEntries(xp.Format.Obsoletes),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse %s", d.Location.Href)
+	}
+	return pkgs, nil
+}
+
+type xmlFilelistPackage struct {
+	PkgID string `xml:"pkgid,attr"`
+	Files []struct {
+		Type string `xml:"type,attr"`
+		Name string `xml:",chardata"`
+	} `xml:"file"`
+}
+
+func (r *Repository) readFilelistsXML(d *MetaData, wanted map[string]*Package) (map[string][]File, error) {
+	f, err := r.open(d)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	files := make(map[string][]File, len(wanted))
+	err = decodeElements(f, "package", func(dec *xml.Decoder, se *xml.StartElement) error {
+		// Skip packages that are not wanted without decoding their files.
+		for _, a := range se.Attr {
+			if a.Name.Local == "pkgid" {
+				if _, ok := wanted[a.Value]; !ok {
+					return dec.Skip()
+				}
+			}
+		}
+		var xp xmlFilelistPackage
+		if err := dec.DecodeElement(&xp, se); err != nil {
+			return err
+		}
+		list := make([]File, 0, len(xp.Files))
+		for _, file := range xp.Files {
+			list = append(list, File{Name: file.Name, Type: file.Type})
+		}
+		files[xp.PkgID] = list
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse %s", d.Location.Href)
+	}
+	return files, nil
+}
+
+type xmlOtherPackage struct {
+	PkgID      string `xml:"pkgid,attr"`
+	Changelogs []struct {
+		Author string `xml:"author,attr"`
+		Date   int64  `xml:"date,attr"`
+		Text   string `xml:",chardata"`
+	} `xml:"changelog"`
+}
+
+func (r *Repository) readOtherXML(d *MetaData, wanted map[string]*Package) (map[string][]Changelog, error) {
+	f, err := r.open(d)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	changelogs := make(map[string][]Changelog, len(wanted))
+	err = decodeElements(f, "package", func(dec *xml.Decoder, se *xml.StartElement) error {
+		for _, a := range se.Attr {
+			if a.Name.Local == "pkgid" {
+				if _, ok := wanted[a.Value]; !ok {
+					return dec.Skip()
+				}
+			}
+		}
+		var xp xmlOtherPackage
+		if err := dec.DecodeElement(&xp, se); err != nil {
+			return err
+		}
+		for _, c := range xp.Changelogs {
+			changelogs[xp.PkgID] = append(changelogs[xp.PkgID], Changelog{Author: c.Author, Date: c.Date, Text: c.Text})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse %s", d.Location.Href)
+	}
+	return changelogs, nil
+}
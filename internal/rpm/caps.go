@@ -0,0 +1,136 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpm
+
+import (
+	"encoding/binary"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// capabilityNames are the capabilities known by libcap, by number.
+var capabilityNames = []string{
+	"cap_chown", "cap_dac_override", "cap_dac_read_search", "cap_fowner",
+	"cap_fsetid", "cap_kill", "cap_setgid", "cap_setuid", "cap_setpcap",
+	"cap_linux_immutable", "cap_net_bind_service", "cap_net_broadcast",
+	"cap_net_admin", "cap_net_raw", "cap_ipc_lock", "cap_ipc_owner",
+	"cap_sys_module", "cap_sys_rawio", "cap_sys_chroot", "cap_sys_ptrace",
+	"cap_sys_pacct", "cap_sys_admin", "cap_sys_boot", "cap_sys_nice",
+	"cap_sys_resource", "cap_sys_time", "cap_sys_tty_config", "cap_mknod",
+	"cap_lease", "cap_audit_write", "cap_audit_control", "cap_setfcap",
+	"cap_mac_override", "cap_mac_admin", "cap_syslog", "cap_wake_alarm",
+	"cap_block_suspend", "cap_audit_read", "cap_perfmon", "cap_bpf",
+	"cap_checkpoint_restore",
+}
+
+// Values used in the security.capability extended attribute.
+const (
+	vfsCapRevision2  = 0x02000000
+	vfsCapFlagsEffec = 0x000001
+	capabilityXattr  = "security.capability"
+)
+
+// encodeFileCaps converts capabilities in the text form used by the
+// RPMTAG_FILECAPS tag, e.g. "cap_net_admin,cap_net_raw+ep", to the value of
+// the security.capability extended attribute.
+func encodeFileCaps(text string) ([]byte, error) {
+	var permitted, inheritable uint64
+	effective := false
+
+	for _, clause := range strings.Fields(text) {
+		i := strings.IndexAny(clause, "=+-")
+		if i < 0 {
+			return nil, errors.Errorf("invalid capabilities %q", text)
+		}
+		var caps uint64
+		names := clause[:i]
+		if names == "" || names == "all" {
+			caps = 1<<uint(len(capabilityNames)) - 1
+		} else {
+			for _, name := range strings.Split(names, ",") {
+				n, err := capabilityNumber(name)
+				if err != nil {
+					return nil, errors.Wrapf(err, "invalid capabilities %q", text)
+				}
+				caps |= 1 << uint(n)
+			}
+		}
+
+		// Each clause has one or more operators followed by flags.
+		ops := clause[i:]
+		for len(ops) > 0 {
+			op := ops[0]
+			j := 1
+			for j < len(ops) && !strings.ContainsRune("=+-", rune(ops[j])) {
+				j++
+			}
+			flags := ops[1:j]
+			ops = ops[j:]
+
+			if op == '=' {
+				permitted &^= caps
+				inheritable &^= caps
+				effective = false
+			}
+			for _, f := range flags {
+				var set *uint64
+				switch f {
+				case 'p':
+					set = &permitted
+				case 'i':
+					set = &inheritable
+				case 'e':
+					effective = op != '-'
+					continue
+				default:
+					return nil, errors.Errorf("invalid capability flag %q in %q", f, text)
+				}
+				if op == '-' {
+					*set &^= caps
+				} else {
+					*set |= caps
+				}
+			}
+		}
+	}
+
+	magic := uint32(vfsCapRevision2)
+	if effective {
+		magic |= vfsCapFlagsEffec
+	}
+	data := make([]byte, 20)
+	binary.LittleEndian.PutUint32(data[0:], magic)
+	binary.LittleEndian.PutUint32(data[4:], uint32(permitted))
+	binary.LittleEndian.PutUint32(data[8:], uint32(inheritable))
+	binary.LittleEndian.PutUint32(data[12:], uint32(permitted>>32))
+	binary.LittleEndian.PutUint32(data[16:], uint32(inheritable>>32))
+	return data, nil
+}
+
+func capabilityNumber(name string) (int, error) {
+	name = strings.ToLower(name)
+	for i, n := range capabilityNames {
+		if n == name {
+			return i, nil
+		}
+	}
+	// libcap also accepts the number of the capability.
+	if n, err := strconv.Atoi(name); err == nil && n >= 0 && n < 64 {
+		return n, nil
+	}
+	return 0, errors.Errorf("unknown capability %s", name)
+}
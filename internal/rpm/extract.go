@@ -0,0 +1,625 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpm
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// File is a file of a package, as described in its header.
+type File struct {
+	Name     string
+	Mode     uint32
+	Size     int64
+	Rdev     uint64
+	Mtime    int64
+	LinkTo   string
+	User     string
+	Group    string
+	Caps     string
+	Inode    int64
+	Flags    int64
+	Digest   string
+	nlink    int
+	lastLink bool
+}
+
+// Files returns the files of the package from its header.
+func (p *Package) Files() []*File {
+	h := p.Header
+	var names []string
+	if h.Has(TagBasenames) {
+		dirs := h.Strings(TagDirNames)
+		indexes := h.Ints(TagDirIndexes)
+		for i, base := range h.Strings(TagBasenames) {
+			if i < len(indexes) && int(indexes[i]) < len(dirs) {
+				names = append(names, dirs[indexes[i]]+base)
+			}
+		}
+	} else {
+		names = h.Strings(TagOldFilenames)
+	}
+
+	sizes := h.Ints(TagLongFileSizes)
+	if sizes == nil {
+		sizes = h.Ints(TagFileSizes)
+	}
+	modes := h.Ints(TagFileModes)
+	rdevs := h.Ints(TagFileRdevs)
+	mtimes := h.Ints(TagFileMtimes)
+	linktos := h.Strings(TagFileLinkTos)
+	users := h.Strings(TagFileUserName)
+	groups := h.Strings(TagFileGroupName)
+	caps := h.Strings(TagFileCaps)
+	inodes := h.Ints(TagFileInodes)
+	flags := h.Ints(TagFileFlags)
+	digests := h.Strings(TagFileDigests)
+
+	intAt := func(a []int64, i int) int64 {
+		if i < len(a) {
+			return a[i]
+		}
+		return 0
+	}
+	strAt := func(a []string, i int) string {
+		if i < len(a) {
+			return a[i]
+		}
+		return ""
+	}
+
+	files := make([]*File, len(names))
+	for i, name := range names {
+		files[i] = &File{
+			Name:   name,
+			Mode:   uint32(intAt(modes, i)),
+			Size:   intAt(sizes, i),
+			Rdev:   uint64(intAt(rdevs, i)),
+			Mtime:  intAt(mtimes, i),
+			LinkTo: strAt(linktos, i),
+			User:   strAt(users, i),
+			Group:  strAt(groups, i),
+			Caps:   strAt(caps, i),
+			Inode:  intAt(inodes, i),
+			Flags:  intAt(flags, i),
+			Digest: strAt(digests, i),
+		}
+	}
+
+	// Regular files sharing an inode are hardlinks. Only the last one of
+	// them has its content in the payload.
+	links := make(map[int64][]*File)
+	for _, f := range files {
+		if f.Mode&syscall.S_IFMT == syscall.S_IFREG && f.Flags&FileFlagGhost == 0 {
+			links[f.Inode] = append(links[f.Inode], f)
+		}
+	}
+	for _, l := range links {
+		for _, f := range l {
+			f.nlink = len(l)
+		}
+		l[len(l)-1].lastLink = true
+	}
+	return files
+}
+
+// payloadReader returns the uncompressed payload of p, read from r.
+func (p *Package) payloadReader(r io.Reader) (io.ReadCloser, error) {
+	compressor := p.Header.String(TagPayloadCompressor)
+	switch compressor {
+	case "", "gzip":
+		return gzip.NewReader(r)
+	case "bzip2":
+		return ioutil.NopCloser(bzip2.NewReader(r)), nil
+	case "xz":
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(xr), nil
+	case "lzma":
+		lr, err := lzma.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(lr), nil
+	case "zstd":
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	}
+	return nil, errors.Errorf("unsupported payload compressor %s", compressor)
+}
+
+// Magic of the cpio formats used in RPM payloads. The stripped format, used for
+// packages with files larger than 4GB, only has the index of the file in the
+// header, and the rest of its metadata must be taken from the header.
+const (
+	cpioNewcMagic     = "070701"
+	cpioCRCMagic      = "070702"
+	cpioStrippedMagic = "07070X"
+	cpioTrailer       = "TRAILER!!!"
+	cpioNewcSize      = 110
+)
+
+type cpioEntry struct {
+	name  string
+	index int
+	ino   int64
+	mode  uint32
+	nlink int
+	mtime int64
+	size  int64
+	rdev  uint64
+}
+
+type cpioReader struct {
+	r   *bufio.Reader
+	pos int64
+}
+
+func (c *cpioReader) read(n int64) ([]byte, error) {
+	buf := make([]byte, n)
+	_, err := io.ReadFull(c.r, buf)
+	c.pos += n
+	return buf, err
+}
+
+func (c *cpioReader) align() error {
+	if pad := (4 - c.pos%4) % 4; pad > 0 {
+		_, err := c.read(pad)
+		return err
+	}
+	return nil
+}
+
+func mkdev(major, minor uint64) uint64 {
+	return (minor & 0xff) | ((major & 0xfff) << 8) | ((minor &^ 0xff) << 12) | ((major &^ 0xfff) << 32)
+}
+
+// next reads the header of the next entry. The data of the entry must be read
+// by the caller before calling next again.
+func (c *cpioReader) next() (*cpioEntry, error) {
+	if err := c.align(); err != nil {
+		return nil, err
+	}
+	magic, err := c.read(6)
+	if err != nil {
+		return nil, err
+	}
+
+	switch string(magic) {
+	case cpioStrippedMagic:
+		hex, err := c.read(8)
+		if err != nil {
+			return nil, err
+		}
+		index, err := strconv.ParseUint(string(hex), 16, 32)
+		if err != nil {
+			return nil, errors.Errorf("invalid cpio header")
+		}
+		if err = c.align(); err != nil {
+			return nil, err
+		}
+		return &cpioEntry{index: int(index)}, nil
+	case cpioNewcMagic, cpioCRCMagic:
+	default:
+		return nil, errors.Errorf("invalid cpio magic %q", magic)
+	}
+
+	hdr, err := c.read(cpioNewcSize - 6)
+	if err != nil {
+		return nil, err
+	}
+	var fields [13]uint64
+	for i := range fields {
+		fields[i], err = strconv.ParseUint(string(hdr[i*8:(i+1)*8]), 16, 32)
+		if err != nil {
+			return nil, errors.Errorf("invalid cpio header")
+		}
+	}
+	name, err := c.read(int64(fields[11]))
+	if err != nil {
+		return nil, err
+	}
+	if err = c.align(); err != nil {
+		return nil, err
+	}
+	return &cpioEntry{
+		name:  strings.TrimRight(string(name), "\x00"),
+		index: -1,
+		ino:   int64(fields[0]),
+		mode:  uint32(fields[1]),
+		nlink: int(fields[4]),
+		mtime: int64(fields[5]),
+		size:  int64(fields[6]),
+		rdev:  mkdev(fields[9], fields[10]),
+	}, nil
+}
+
+// tmpCounter makes the temporary names used while extracting unique, so
+// packages can be extracted concurrently into the same root.
+var tmpCounter uint64
+
+func tmpName(target string) string {
+	return fmt.Sprintf("%s;%x", target, atomic.AddUint64(&tmpCounter, 1))
+}
+
+type extractor struct {
+	root   string
+	owners *Owners
+	isRoot bool
+}
+
+// maxSymlinks is the number of symlinks followed when resolving a path, as in
+// the Linux kernel.
+const maxSymlinks = 40
+
+// targetPath returns the path in root for the name of a file in a package,
+// making sure it doesn't point outside of root.
+func (x *extractor) targetPath(name string) (string, error) {
+	name = path.Clean("/" + strings.TrimPrefix(name, "."))
+	if name == "/" {
+		return "", errors.Errorf("invalid file name in payload")
+	}
+	return x.resolve(name, false)
+}
+
+// resolve returns the path in root for name, an absolute slash separated
+// path. The symlinks in its directories, and in the last element when
+// followLast is set, are resolved as if root was the root directory, so
+// neither absolute symlinks nor ".." lead outside of root.
+func (x *extractor) resolve(name string, followLast bool) (string, error) {
+	resolved := "/"
+	rest := strings.Split(name, "/")
+	links := 0
+	for len(rest) > 0 {
+		elem := rest[0]
+		rest = rest[1:]
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			resolved = path.Dir(resolved)
+			continue
+		}
+		next := path.Join(resolved, elem)
+		if len(rest) == 0 && !followLast {
+			resolved = next
+			break
+		}
+
+		p := filepath.Join(x.root, filepath.FromSlash(next))
+		fi, err := os.Lstat(p)
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			// Missing directories are created later.
+			resolved = next
+			continue
+		}
+		if links++; links > maxSymlinks {
+			return "", errors.Errorf("too many levels of symbolic links in %s", name)
+		}
+		target, err := os.Readlink(p)
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(target) {
+			resolved = "/"
+		}
+		rest = append(strings.Split(target, "/"), rest...)
+	}
+	return filepath.Join(x.root, filepath.FromSlash(resolved)), nil
+}
+
+// setMetadata sets the ownership, mode, capabilities and modification time of
+// an extracted file, in that order, since changing the owner clears the setuid
+// bits and the capabilities.
+func (x *extractor) setMetadata(p string, f *File) error {
+	typ := f.Mode & syscall.S_IFMT
+	if x.isRoot {
+		if err := os.Lchown(p, x.owners.UID(f.User), x.owners.GID(f.Group)); err != nil {
+			return err
+		}
+	}
+	if typ == syscall.S_IFLNK {
+		return nil
+	}
+	if err := syscall.Chmod(p, f.Mode&07777); err != nil {
+		return &os.PathError{Op: "chmod", Path: p, Err: err}
+	}
+	if f.Caps != "" && typ == syscall.S_IFREG {
+		caps, err := encodeFileCaps(f.Caps)
+		if err != nil {
+			return err
+		}
+		if err = syscall.Setxattr(p, capabilityXattr, caps, 0); err != nil {
+			return errors.Wrapf(err, "couldn't set capabilities of %s", f.Name)
+		}
+	}
+	mtime := time.Unix(f.Mtime, 0)
+	return os.Chtimes(p, mtime, mtime)
+}
+
+// replace creates a file with create at a temporary name next to target, and
+// moves it over target.
+func (x *extractor) replace(target string, f *File, create func(tmp string) error) error {
+	tmp := tmpName(target)
+	if err := create(tmp); err != nil {
+		return err
+	}
+	if err := x.setMetadata(tmp, f); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func (x *extractor) extractDir(target string, f *File) error {
+	fi, err := os.Lstat(target)
+	switch {
+	case err == nil && fi.IsDir():
+	case err == nil && fi.Mode()&os.ModeSymlink != 0:
+		// Keep symlinks to directories in root, like the /lib ->
+		// usr/lib compatibility links.
+		rel, rerr := filepath.Rel(x.root, target)
+		if rerr != nil {
+			return rerr
+		}
+		dir, rerr := x.resolve("/"+filepath.ToSlash(rel), true)
+		if rerr != nil {
+			return rerr
+		}
+		if st, serr := os.Lstat(dir); serr == nil && st.IsDir() {
+			return nil
+		}
+		fallthrough
+	case err == nil:
+		if err = os.Remove(target); err != nil {
+			return err
+		}
+		fallthrough
+	default:
+		if err = os.Mkdir(target, 0755); err != nil && !os.IsExist(err) {
+			return err
+		}
+	}
+	return x.setMetadata(target, f)
+}
+
+func (x *extractor) extractRegular(target string, f *File, data io.Reader) error {
+	return x.replace(target, f, func(tmp string) error {
+		out, err := os.OpenFile(tmp, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		if _, err = io.Copy(out, data); err != nil {
+			_ = out.Close()
+			_ = os.Remove(tmp)
+			return err
+		}
+		return out.Close()
+	})
+}
+
+func (x *extractor) extractLink(target, existing string) error {
+	tmp := tmpName(target)
+	if err := os.Link(existing, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func (x *extractor) extract(target string, f *File, data io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	switch f.Mode & syscall.S_IFMT {
+	case syscall.S_IFDIR:
+		return x.extractDir(target, f)
+	case syscall.S_IFREG:
+		return x.extractRegular(target, f, data)
+	case syscall.S_IFLNK:
+		linkTo := f.LinkTo
+		if data != nil {
+			content, err := ioutil.ReadAll(data)
+			if err != nil {
+				return err
+			}
+			linkTo = string(content)
+		}
+		return x.replace(target, f, func(tmp string) error {
+			return os.Symlink(linkTo, tmp)
+		})
+	case syscall.S_IFCHR, syscall.S_IFBLK, syscall.S_IFIFO, syscall.S_IFSOCK:
+		return x.replace(target, f, func(tmp string) error {
+			return syscall.Mknod(tmp, f.Mode, int(f.Rdev))
+		})
+	}
+	return errors.Errorf("unsupported file type %o for %s", f.Mode&syscall.S_IFMT, f.Name)
+}
+
+// Extract extracts the payload of the RPM file into root. The files keep the
+// modes, modification times and capabilities from the package. When running
+// as root, their ownership is also set, with the user and group names mapped
+// to IDs with owners. Existing files are replaced, so packages sharing files
+// and directories can be extracted concurrently into the same root.
+func Extract(filename, root string, owners *Owners) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	br := bufio.NewReaderSize(f, 128*1024)
+	pkg, err := ReadPackage(br)
+	if err != nil {
+		return errors.Wrapf(err, "couldn't read %s", filename)
+	}
+	if format := pkg.Header.String(TagPayloadFormat); format != "" && format != "cpio" {
+		return errors.Errorf("unsupported payload format %s in %s", format, filename)
+	}
+	payload, err := pkg.payloadReader(br)
+	if err != nil {
+		return errors.Wrapf(err, "couldn't read payload of %s", filename)
+	}
+	defer func() {
+		_ = payload.Close()
+	}()
+
+	if err = extractPayload(pkg, payload, root, owners); err != nil {
+		return errors.Wrapf(err, "couldn't extract %s", filename)
+	}
+	return nil
+}
+
+func extractPayload(pkg *Package, payload io.Reader, root string, owners *Owners) error {
+	files := pkg.Files()
+	byName := make(map[string]*File, len(files))
+	for _, f := range files {
+		byName[f.Name] = f
+	}
+
+	x := &extractor{root: root, owners: owners, isRoot: os.Geteuid() == 0}
+	c := &cpioReader{r: bufio.NewReader(payload)}
+
+	// Hardlinks have their content only in the last entry of each set. The
+	// entries before it are kept until the content is extracted.
+	pending := make(map[int64][]string)
+	pendingFiles := make(map[int64]*File)
+	extracted := make(map[int64]string)
+
+	for {
+		e, err := c.next()
+		if err != nil {
+			return err
+		}
+		if e.name == cpioTrailer {
+			break
+		}
+
+		var f *File
+		if e.index >= 0 {
+			if e.index >= len(files) {
+				return errors.Errorf("invalid file index %d in payload", e.index)
+			}
+			f = files[e.index]
+			e.name = f.Name
+			e.ino = f.Inode
+			e.nlink = f.nlink
+			e.size = f.Size
+			if f.nlink > 1 && !f.lastLink {
+				e.size = 0
+			}
+			if typ := f.Mode & syscall.S_IFMT; typ != syscall.S_IFREG && typ != syscall.S_IFLNK {
+				e.size = 0
+			}
+		} else {
+			name := path.Clean("/" + strings.TrimPrefix(e.name, "."))
+			f = byName[name]
+			if f == nil {
+				// Not described in the header, so only the metadata in
+				// the payload is known.
+				f = &File{Name: name, Mode: e.mode, Size: e.size, Rdev: e.rdev, Mtime: e.mtime}
+			}
+			// The payload has the actual device numbers.
+			f.Rdev = e.rdev
+		}
+
+		target, err := x.targetPath(e.name)
+		if err != nil {
+			return err
+		}
+		data := io.LimitReader(c.r, e.size)
+
+		if f.Mode&syscall.S_IFMT == syscall.S_IFREG && e.nlink > 1 {
+			switch {
+			case extracted[e.ino] != "":
+				err = x.extractLink(target, extracted[e.ino])
+			case e.size == 0:
+				pending[e.ino] = append(pending[e.ino], target)
+				pendingFiles[e.ino] = f
+			default:
+				if err = x.extract(target, f, data); err != nil {
+					break
+				}
+				extracted[e.ino] = target
+				for _, p := range pending[e.ino] {
+					if err = x.extractLink(p, target); err != nil {
+						break
+					}
+				}
+				delete(pending, e.ino)
+			}
+		} else {
+			var content io.Reader
+			if e.size > 0 || f.Mode&syscall.S_IFMT == syscall.S_IFREG {
+				content = data
+			}
+			err = x.extract(target, f, content)
+		}
+		if err != nil {
+			return err
+		}
+
+		// Skip what was not read of the data.
+		if _, err = io.Copy(ioutil.Discard, data); err != nil {
+			return err
+		}
+		c.pos += e.size
+	}
+
+	// Hardlinked empty files have no entry with content.
+	for ino, targets := range pending {
+		if err := x.extract(targets[0], pendingFiles[ino], strings.NewReader("")); err != nil {
+			return err
+		}
+		for _, p := range targets[1:] {
+			if err := x.extractLink(p, targets[0]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
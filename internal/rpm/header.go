@@ -0,0 +1,332 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rpm reads RPM package files and extracts their payload, without
+// depending on rpm or rpm2archive.
+package rpm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// Tags of the RPM header used by this package.
+const (
+	TagName              = 1000
+	TagVersion           = 1001
+	TagRelease           = 1002
+	TagEpoch             = 1003
+	TagSummary           = 1004
+	TagDescription       = 1005
+	TagBuildTime         = 1006
+	TagBuildHost         = 1007
+	TagSize              = 1009
+	TagVendor            = 1011
+	TagLicense           = 1014
+	TagPackager          = 1015
+	TagGroup             = 1016
+	TagURL               = 1020
+	TagArch              = 1022
+	TagOldFilenames      = 1027
+	TagFileSizes         = 1028
+	TagFileModes         = 1030
+	TagFileRdevs         = 1033
+	TagFileMtimes        = 1034
+	TagFileDigests       = 1035
+	TagFileLinkTos       = 1036
+	TagFileFlags         = 1037
+	TagFileUserName      = 1039
+	TagFileGroupName     = 1040
+	TagSourceRPM         = 1044
+	TagArchiveSize       = 1046
+	TagProvideName       = 1047
+	TagRequireFlags      = 1048
+	TagRequireName       = 1049
+	TagRequireVersion    = 1050
+	TagConflictFlags     = 1053
+	TagConflictName      = 1054
+	TagConflictVersion   = 1055
+	TagChangelogTime     = 1080
+	TagChangelogName     = 1081
+	TagChangelogText     = 1082
+	TagObsoleteName      = 1090
+	TagFileInodes        = 1096
+	TagProvideFlags      = 1112
+	TagProvideVersion    = 1113
+	TagObsoleteFlags     = 1114
+	TagObsoleteVersion   = 1115
+	TagDirIndexes        = 1116
+	TagBasenames         = 1117
+	TagDirNames          = 1118
+	TagPayloadFormat     = 1124
+	TagPayloadCompressor = 1125
+	TagLongFileSizes     = 5008
+	TagLongSize          = 5009
+	TagFileCaps          = 5010
+	TagPayloadDigest     = 5092
+	TagPayloadDigestAlgo = 5093
+)
+
+// Tags of the signature header used by this package.
+const (
+	SigTagDSA             = 267
+	SigTagRSA             = 268
+	SigTagLongArchiveSize = 271
+	SigTagPGP             = 1002
+	SigTagGPG             = 1005
+	SigTagPayloadSize     = 1007
+)
+
+// File flags in TagFileFlags.
+const (
+	FileFlagConfig = 1 << 0
+	FileFlagDoc    = 1 << 1
+	FileFlagGhost  = 1 << 6
+)
+
+// Types of the header entries.
+const (
+	typeNull        = 0
+	typeChar        = 1
+	typeInt8        = 2
+	typeInt16       = 3
+	typeInt32       = 4
+	typeInt64       = 5
+	typeString      = 6
+	typeBin         = 7
+	typeStringArray = 8
+	typeI18NString  = 9
+)
+
+var (
+	leadMagic   = []byte{0xED, 0xAB, 0xEE, 0xDB}
+	headerMagic = []byte{0x8E, 0xAD, 0xE8, 0x01}
+)
+
+const leadSize = 96
+
+type headerEntry struct {
+	tag    int32
+	typ    uint32
+	offset int32
+	count  uint32
+}
+
+// Header is a header structure of an RPM file, either the signature header or
+// the main header.
+type Header struct {
+	entries map[int32]headerEntry
+	store   []byte
+	// raw has the complete header as read from the file, including the
+	// magic, which is what the header signatures are computed over.
+	raw []byte
+}
+
+// Package has the headers of an RPM file. The reader is left at the start of
+// the payload.
+type Package struct {
+	Signature *Header
+	Header    *Header
+}
+
+// ReadPackage reads the lead and the headers of an RPM file from r.
+func ReadPackage(r io.Reader) (*Package, error) {
+	lead := make([]byte, leadSize)
+	if _, err := io.ReadFull(r, lead); err != nil {
+		return nil, errors.Wrap(err, "couldn't read RPM lead")
+	}
+	if !bytes.Equal(lead[:4], leadMagic) {
+		return nil, errors.New("not an RPM file")
+	}
+
+	sig, err := readHeader(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't read RPM signature header")
+	}
+	// The signature header is padded to a multiple of 8 bytes.
+	if pad := (8 - len(sig.raw)%8) % 8; pad > 0 {
+		if _, err = io.CopyN(ioutil.Discard, r, int64(pad)); err != nil {
+			return nil, errors.Wrap(err, "couldn't read RPM signature header")
+		}
+	}
+
+	h, err := readHeader(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't read RPM header")
+	}
+	return &Package{Signature: sig, Header: h}, nil
+}
+
+// maxHeaderSize limits the memory used by corrupted headers, it is the same
+// limit used by rpm.
+const maxHeaderSize = 256 * 1024 * 1024
+
+func readHeader(r io.Reader) (*Header, error) {
+	intro := make([]byte, 16)
+	if _, err := io.ReadFull(r, intro); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(intro[:4], headerMagic) {
+		return nil, errors.New("bad header magic")
+	}
+	nindex := binary.BigEndian.Uint32(intro[8:12])
+	hsize := binary.BigEndian.Uint32(intro[12:16])
+	if uint64(nindex)*16+uint64(hsize) > maxHeaderSize {
+		return nil, errors.New("header is too large")
+	}
+
+	raw := make([]byte, 16+int(nindex)*16+int(hsize))
+	copy(raw, intro)
+	if _, err := io.ReadFull(r, raw[16:]); err != nil {
+		return nil, err
+	}
+
+	h := &Header{
+		entries: make(map[int32]headerEntry, nindex),
+		store:   raw[16+int(nindex)*16:],
+		raw:     raw,
+	}
+	for i := 0; i < int(nindex); i++ {
+		b := raw[16+i*16:]
+		e := headerEntry{
+			tag:    int32(binary.BigEndian.Uint32(b[0:])),
+			typ:    binary.BigEndian.Uint32(b[4:]),
+			offset: int32(binary.BigEndian.Uint32(b[8:])),
+			count:  binary.BigEndian.Uint32(b[12:]),
+		}
+		if e.offset < 0 || int(e.offset) > len(h.store) {
+			return nil, errors.Errorf("invalid offset for tag %d", e.tag)
+		}
+		h.entries[e.tag] = e
+	}
+	return h, nil
+}
+
+// Raw returns the header as stored in the RPM file.
+func (h *Header) Raw() []byte {
+	return h.raw
+}
+
+// Has returns whether the header has the tag.
+func (h *Header) Has(tag int32) bool {
+	_, ok := h.entries[tag]
+	return ok
+}
+
+func (h *Header) data(tag int32, size int) ([]byte, headerEntry, bool) {
+	e, ok := h.entries[tag]
+	if !ok {
+		return nil, e, false
+	}
+	end := int(e.offset) + size*int(e.count)
+	if size == 0 || end > len(h.store) {
+		return h.store[e.offset:], e, true
+	}
+	return h.store[e.offset:end], e, true
+}
+
+// Strings returns the value of a string, string array or i18n string tag.
+func (h *Header) Strings(tag int32) []string {
+	data, e, ok := h.data(tag, 0)
+	if !ok {
+		return nil
+	}
+	switch e.typ {
+	case typeString, typeStringArray, typeI18NString:
+	default:
+		return nil
+	}
+	result := make([]string, 0, e.count)
+	for i := uint32(0); i < e.count; i++ {
+		end := bytes.IndexByte(data, 0)
+		if end < 0 {
+			break
+		}
+		result = append(result, string(data[:end]))
+		data = data[end+1:]
+	}
+	return result
+}
+
+// String returns the value of a string tag, or the first string of an array.
+func (h *Header) String(tag int32) string {
+	s := h.Strings(tag)
+	if len(s) == 0 {
+		return ""
+	}
+	return s[0]
+}
+
+// Ints returns the value of an integer tag of any size.
+func (h *Header) Ints(tag int32) []int64 {
+	e, ok := h.entries[tag]
+	if !ok {
+		return nil
+	}
+	size := map[uint32]int{typeChar: 1, typeInt8: 1, typeInt16: 2, typeInt32: 4, typeInt64: 8}[e.typ]
+	if size == 0 {
+		return nil
+	}
+	data, _, _ := h.data(tag, size)
+	result := make([]int64, 0, e.count)
+	for i := 0; i+size <= len(data) && len(result) < int(e.count); i += size {
+		switch size {
+		case 1:
+			result = append(result, int64(data[i]))
+		case 2:
+			result = append(result, int64(binary.BigEndian.Uint16(data[i:])))
+		case 4:
+			result = append(result, int64(binary.BigEndian.Uint32(data[i:])))
+		case 8:
+			result = append(result, int64(binary.BigEndian.Uint64(data[i:])))
+		}
+	}
+	return result
+}
+
+// Bytes returns the value of a binary tag.
+func (h *Header) Bytes(tag int32) []byte {
+	data, e, ok := h.data(tag, 1)
+	if !ok || e.typ != typeBin {
+		return nil
+	}
+	return data
+}
+
+// HeaderRange returns the offsets of the start and the end of the main header
+// in the RPM file.
+func (p *Package) HeaderRange() (start, end int64) {
+	start = leadSize + int64(len(p.Signature.raw))
+	start += int64((8 - len(p.Signature.raw)%8) % 8)
+	return start, start + int64(len(p.Header.raw))
+}
+
+// Name returns the name of the package.
+func (p *Package) Name() string {
+	return p.Header.String(TagName)
+}
+
+// NEVRA returns the name-[epoch:]version-release.arch of the package.
+func (p *Package) NEVRA() string {
+	evr := p.Header.String(TagVersion) + "-" + p.Header.String(TagRelease)
+	if epoch := p.Header.Ints(TagEpoch); len(epoch) > 0 && epoch[0] != 0 {
+		evr = strconv.FormatInt(epoch[0], 10) + ":" + evr
+	}
+	return p.Name() + "-" + evr + "." + p.Header.String(TagArch)
+}
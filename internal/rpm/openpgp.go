@@ -0,0 +1,187 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpm
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	// Register the hashes used by OpenPGP signatures.
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	pgperrors "github.com/ProtonMail/go-crypto/openpgp/errors"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/pkg/errors"
+)
+
+// The OpenPGP keys and signatures are handled by the go-crypto package, which
+// checks the binding signatures of subkeys and refuses signatures made by
+// expired or revoked keys. RPM signatures must also be binary signatures made
+// with a hash that is not deprecated.
+
+// pgpConfig holds the defaults of go-crypto, which also decide the hashes
+// rejected for signatures: MD5, RIPEMD-160 and SHA-1.
+var pgpConfig = &packet.Config{}
+
+// pgpHashes maps the OpenPGP hash algorithm IDs, which rpm also uses for the
+// payload digest, to the hashes considered secure.
+var pgpHashes = map[byte]crypto.Hash{
+	8:  crypto.SHA256,
+	9:  crypto.SHA384,
+	10: crypto.SHA512,
+	11: crypto.SHA224,
+}
+
+const pgpArmorBegin = "-----BEGIN PGP PUBLIC KEY BLOCK-----"
+
+// KeyID is the 64-bit identifier of an OpenPGP key.
+type KeyID uint64
+
+func (id KeyID) String() string {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(id))
+	return strings.ToUpper(hex.EncodeToString(b[:]))
+}
+
+// PublicKey describes a key used to check signatures. Subkeys have the user ID
+// of their primary key.
+type PublicKey struct {
+	ID          KeyID
+	Fingerprint string
+	UserID      string
+}
+
+func newPublicKey(key openpgp.Key) *PublicKey {
+	k := &PublicKey{
+		ID:          KeyID(key.PublicKey.KeyId),
+		Fingerprint: strings.ToUpper(hex.EncodeToString(key.PublicKey.Fingerprint)),
+	}
+	if id := key.Entity.PrimaryIdentity(); id != nil {
+		k.UserID = id.Name
+	}
+	return k
+}
+
+// Keyring is a set of trusted public keys.
+type Keyring struct {
+	entities openpgp.EntityList
+}
+
+// NewKeyring returns an empty keyring.
+func NewKeyring() *Keyring {
+	return &Keyring{}
+}
+
+// Len returns the number of keys, including subkeys, in the keyring.
+func (k *Keyring) Len() int {
+	n := 0
+	for _, e := range k.entities {
+		n += 1 + len(e.Subkeys)
+	}
+	return n
+}
+
+// Key returns the key with the given ID, or nil if it is not in the keyring.
+func (k *Keyring) Key(id KeyID) *PublicKey {
+	keys := k.entities.KeysById(uint64(id))
+	if len(keys) == 0 {
+		return nil
+	}
+	return newPublicKey(keys[0])
+}
+
+// AddFile adds the keys of an armored or binary key file to the keyring.
+func (k *Keyring) AddFile(filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	return errors.Wrapf(k.Add(data), "couldn't read keys from %s", filename)
+}
+
+// Add adds the keys in data, which may be armored or binary, to the keyring.
+// An armored file may contain multiple key blocks. Subkeys without a valid
+// binding signature are ignored.
+func (k *Keyring) Add(data []byte) error {
+	if !bytes.Contains(data, []byte(pgpArmorBegin)) {
+		entities, err := openpgp.ReadKeyRing(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		k.entities = append(k.entities, entities...)
+		return nil
+	}
+
+	var added openpgp.EntityList
+	for {
+		i := bytes.Index(data, []byte(pgpArmorBegin))
+		if i < 0 {
+			break
+		}
+		data = data[i:]
+		block, err := armor.Decode(bytes.NewReader(data))
+		if err != nil {
+			return errors.Wrap(err, "invalid armored key")
+		}
+		entities, err := openpgp.ReadKeyRing(block.Body)
+		if err != nil {
+			return err
+		}
+		added = append(added, entities...)
+		data = data[len(pgpArmorBegin):]
+	}
+	k.entities = append(k.entities, added...)
+	return nil
+}
+
+// check verifies the detached OpenPGP signature sig of the signed data and
+// returns the key that made it.
+func (k *Keyring) check(sig []byte, signed io.Reader) (*PublicKey, error) {
+	p, err := packet.Read(bytes.NewReader(sig))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid signature")
+	}
+	s, ok := p.(*packet.Signature)
+	if !ok {
+		return nil, errors.New("invalid signature: not a signature packet")
+	}
+	if s.IssuerKeyId == nil {
+		return nil, errors.New("invalid signature: no issuer")
+	}
+	issuer := KeyID(*s.IssuerKeyId)
+	if s.SigType != packet.SigTypeBinary {
+		return nil, errors.Errorf("invalid signature by key %s: not a binary signature (type %#x)", issuer, uint8(s.SigType))
+	}
+	if pgpConfig.RejectMessageHashAlgorithm(s.Hash) {
+		return nil, errors.Errorf("invalid signature by key %s: insecure hash %s", issuer, s.Hash)
+	}
+
+	_, _, err = openpgp.VerifyDetachedSignature(k.entities, signed, bytes.NewReader(sig), pgpConfig)
+	if err == pgperrors.ErrUnknownIssuer {
+		return nil, errors.Errorf("signed with unknown key %s", issuer)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid signature by key %s", issuer)
+	}
+	return k.Key(issuer), nil
+}
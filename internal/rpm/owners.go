@@ -0,0 +1,84 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpm
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Owners maps the user and group names of the files in packages to the IDs
+// used in a root filesystem. Names not known are mapped to root, the same way
+// rpm does.
+type Owners struct {
+	users  map[string]int
+	groups map[string]int
+}
+
+// The databases are read from etc, and from the stateless defaults used by
+// Clear Linux, with the ones in etc taking precedence.
+var ownerDirs = []string{"etc", "usr/share/defaults/etc"}
+
+// LoadOwners reads the passwd and group files of the root filesystem in root.
+// Missing files are not an error, since the files may not have been installed
+// yet.
+func LoadOwners(root string) *Owners {
+	o := &Owners{
+		users:  map[string]int{"root": 0},
+		groups: map[string]int{"root": 0},
+	}
+	for i := len(ownerDirs) - 1; i >= 0; i-- {
+		readIDs(filepath.Join(root, ownerDirs[i], "passwd"), o.users)
+		readIDs(filepath.Join(root, ownerDirs[i], "group"), o.groups)
+	}
+	return o
+}
+
+// readIDs reads the name and ID fields of a passwd or group file into ids.
+func readIDs(filename string, ids map[string]int) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) < 3 || fields[0] == "" || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		id, err := strconv.Atoi(fields[2])
+		if err != nil {
+			continue
+		}
+		ids[fields[0]] = id
+	}
+}
+
+// UID returns the user ID of the user name.
+func (o *Owners) UID(name string) int {
+	if o == nil {
+		return 0
+	}
+	return o.users[name]
+}
+
+// GID returns the group ID of the group name.
+func (o *Owners) GID(name string) int {
+	if o == nil {
+		return 0
+	}
+	return o.groups[name]
+}
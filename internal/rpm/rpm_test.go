@@ -0,0 +1,485 @@
+package rpm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// testHeader builds the bytes of an RPM header structure.
+type testHeader struct {
+	entries []headerEntry
+	store   bytes.Buffer
+}
+
+func (h *testHeader) add(tag int32, typ uint32, count int, data []byte, align int) {
+	for h.store.Len()%align != 0 {
+		h.store.WriteByte(0)
+	}
+	h.entries = append(h.entries, headerEntry{tag: tag, typ: typ, offset: int32(h.store.Len()), count: uint32(count)})
+	h.store.Write(data)
+}
+
+func (h *testHeader) addString(tag int32, s string) {
+	h.add(tag, typeString, 1, append([]byte(s), 0), 1)
+}
+
+func (h *testHeader) addStrings(tag int32, s ...string) {
+	var data []byte
+	for _, v := range s {
+		data = append(data, v...)
+		data = append(data, 0)
+	}
+	h.add(tag, typeStringArray, len(s), data, 1)
+}
+
+func (h *testHeader) addInt16(tag int32, v ...uint16) {
+	data := make([]byte, 2*len(v))
+	for i, n := range v {
+		binary.BigEndian.PutUint16(data[2*i:], n)
+	}
+	h.add(tag, typeInt16, len(v), data, 2)
+}
+
+func (h *testHeader) addInt32(tag int32, v ...uint32) {
+	data := make([]byte, 4*len(v))
+	for i, n := range v {
+		binary.BigEndian.PutUint32(data[4*i:], n)
+	}
+	h.add(tag, typeInt32, len(v), data, 4)
+}
+
+func (h *testHeader) bytes() []byte {
+	var buf bytes.Buffer
+	buf.Write(headerMagic)
+	buf.Write([]byte{0, 0, 0, 0})
+	_ = binary.Write(&buf, binary.BigEndian, uint32(len(h.entries)))
+	_ = binary.Write(&buf, binary.BigEndian, uint32(h.store.Len()))
+	for _, e := range h.entries {
+		_ = binary.Write(&buf, binary.BigEndian, []uint32{uint32(e.tag), e.typ, uint32(e.offset), e.count})
+	}
+	buf.Write(h.store.Bytes())
+	return buf.Bytes()
+}
+
+type testFile struct {
+	name    string
+	mode    uint16
+	content string
+	linkTo  string
+	user    string
+	group   string
+	caps    string
+	inode   uint32
+}
+
+var testFiles = []testFile{
+	{name: "/usr/bin", mode: syscall.S_IFDIR | 0755, user: "root", group: "root", inode: 1},
+	{name: "/usr/bin/foo", mode: syscall.S_IFREG | 04755, content: "foo content", user: "tester", group: "testers", inode: 2},
+	{name: "/usr/bin/bar", mode: syscall.S_IFLNK | 0777, linkTo: "foo", user: "root", group: "root", inode: 3},
+	{name: "/usr/bin/h1", mode: syscall.S_IFREG | 0644, content: "linked", user: "root", group: "root", inode: 4},
+	{name: "/usr/bin/h2", mode: syscall.S_IFREG | 0644, content: "linked", user: "root", group: "root", inode: 4},
+	{name: "/usr/bin/ping", mode: syscall.S_IFREG | 0755, content: "ping", user: "nobody-known", group: "root", caps: "cap_net_raw=ep", inode: 5},
+}
+
+func pad4(buf *bytes.Buffer) {
+	for buf.Len()%4 != 0 {
+		buf.WriteByte(0)
+	}
+}
+
+func writeNewcEntry(buf *bytes.Buffer, name string, ino, mode, nlink uint32, data string) {
+	fmt.Fprintf(buf, "070701%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X",
+		ino, mode, 0, 0, nlink, 1500000000, len(data), 0, 0, 0, 0, len(name)+1, 0)
+	buf.WriteString(name)
+	buf.WriteByte(0)
+	pad4(buf)
+	buf.WriteString(data)
+	pad4(buf)
+}
+
+// buildTestRPM returns an RPM with testFiles, with a payload in the newc or in
+// the stripped cpio format, compressed with compressor.
+func buildTestRPM(stripped bool, compressor string) []byte {
+	var h testHeader
+	h.addString(TagName, "test")
+	h.addString(TagVersion, "1.0")
+	h.addString(TagRelease, "1")
+	h.addInt32(TagEpoch, 2)
+	h.addString(TagArch, "x86_64")
+	h.addString(TagPayloadFormat, "cpio")
+	h.addString(TagPayloadCompressor, compressor)
+
+	var basenames, linktos, users, groups, caps []string
+	var dirindexes, sizes, mtimes, inodes []uint32
+	var modes []uint16
+	dirs := []string{"/usr/", "/usr/bin/"}
+	for _, f := range testFiles {
+		dir, base := filepath.Split(f.name)
+		basenames = append(basenames, base)
+		if dir == "/usr/" {
+			dirindexes = append(dirindexes, 0)
+		} else {
+			dirindexes = append(dirindexes, 1)
+		}
+		size := len(f.content)
+		if f.linkTo != "" {
+			size = len(f.linkTo)
+		}
+		sizes = append(sizes, uint32(size))
+		mtimes = append(mtimes, 1500000000)
+		inodes = append(inodes, f.inode)
+		modes = append(modes, f.mode)
+		linktos = append(linktos, f.linkTo)
+		users = append(users, f.user)
+		groups = append(groups, f.group)
+		caps = append(caps, f.caps)
+	}
+	h.addStrings(TagBasenames, basenames...)
+	h.addStrings(TagDirNames, dirs...)
+	h.addInt32(TagDirIndexes, dirindexes...)
+	h.addInt32(TagFileSizes, sizes...)
+	h.addInt16(TagFileModes, modes...)
+	h.addInt32(TagFileMtimes, mtimes...)
+	h.addInt32(TagFileInodes, inodes...)
+	h.addStrings(TagFileLinkTos, linktos...)
+	h.addStrings(TagFileUserName, users...)
+	h.addStrings(TagFileGroupName, groups...)
+	h.addStrings(TagFileCaps, caps...)
+
+	var cpio bytes.Buffer
+	for i, f := range testFiles {
+		data := f.content
+		if f.linkTo != "" {
+			data = f.linkTo
+		}
+		nlink := uint32(1)
+		if f.inode == 4 {
+			nlink = 2
+			// Only the last hardlink has the content.
+			if f.name == "/usr/bin/h1" {
+				data = ""
+			}
+		}
+		if stripped {
+			fmt.Fprintf(&cpio, "07070X%08X", i)
+			pad4(&cpio)
+			cpio.WriteString(data)
+			pad4(&cpio)
+		} else {
+			writeNewcEntry(&cpio, "."+f.name, f.inode, uint32(f.mode), nlink, data)
+		}
+	}
+	writeNewcEntry(&cpio, cpioTrailer, 0, 0, 1, "")
+
+	var rpm bytes.Buffer
+	lead := make([]byte, leadSize)
+	copy(lead, leadMagic)
+	rpm.Write(lead)
+	var sig testHeader
+	sig.addString(1000, "sig")
+	sigBytes := sig.bytes()
+	rpm.Write(sigBytes)
+	for rpm.Len()%8 != 0 {
+		rpm.WriteByte(0)
+	}
+	rpm.Write(h.bytes())
+	var w io.WriteCloser
+	switch compressor {
+	case "xz":
+		w, _ = xz.NewWriter(&rpm)
+	case "lzma":
+		w, _ = lzma.NewWriter(&rpm)
+	case "zstd":
+		w, _ = zstd.NewWriter(&rpm)
+	default:
+		w = gzip.NewWriter(&rpm)
+	}
+	_, _ = w.Write(cpio.Bytes())
+	_ = w.Close()
+	return rpm.Bytes()
+}
+
+func TestReadPackage(t *testing.T) {
+	pkg, err := ReadPackage(bytes.NewReader(buildTestRPM(false, "gzip")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkg.NEVRA() != "test-2:1.0-1.x86_64" {
+		t.Errorf("got NEVRA %q", pkg.NEVRA())
+	}
+	files := pkg.Files()
+	if len(files) != len(testFiles) {
+		t.Fatalf("got %d files, want %d", len(files), len(testFiles))
+	}
+	for i, f := range files {
+		tf := testFiles[i]
+		if f.Name != tf.name || f.Mode != uint32(tf.mode) || f.User != tf.user || f.Caps != tf.caps || f.LinkTo != tf.linkTo {
+			t.Errorf("got file %+v, want %+v", f, tf)
+		}
+	}
+	if !files[4].lastLink || files[3].lastLink || files[3].nlink != 2 {
+		t.Errorf("hardlinks not detected")
+	}
+
+	if _, err = ReadPackage(bytes.NewReader(make([]byte, 200))); err == nil {
+		t.Errorf("no error reading a file that is not an RPM")
+	}
+}
+
+func checkExtracted(t *testing.T, root string) {
+	t.Helper()
+
+	var names []string
+	err := filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, p)
+		names = append(names, rel)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	expected := []string{".", "etc", "etc/group", "etc/passwd", "usr", "usr/bin", "usr/bin/bar", "usr/bin/foo", "usr/bin/h1", "usr/bin/h2", "usr/bin/ping"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("got files %v, want %v", names, expected)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(root, "usr/bin/foo"))
+	if err != nil || string(content) != "foo content" {
+		t.Errorf("got content %q (%v)", content, err)
+	}
+	var st syscall.Stat_t
+	if err = syscall.Lstat(filepath.Join(root, "usr/bin/foo"), &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Mode != syscall.S_IFREG|04755 {
+		t.Errorf("got mode %o for foo", st.Mode)
+	}
+	if os.Geteuid() == 0 && (st.Uid != 1234 || st.Gid != 4321) {
+		t.Errorf("got owner %d:%d for foo", st.Uid, st.Gid)
+	}
+	if st.Mtim.Sec != 1500000000 {
+		t.Errorf("got mtime %d for foo", st.Mtim.Sec)
+	}
+
+	if link, err := os.Readlink(filepath.Join(root, "usr/bin/bar")); err != nil || link != "foo" {
+		t.Errorf("got link %q (%v)", link, err)
+	}
+
+	var h1, h2 syscall.Stat_t
+	_ = syscall.Stat(filepath.Join(root, "usr/bin/h1"), &h1)
+	_ = syscall.Stat(filepath.Join(root, "usr/bin/h2"), &h2)
+	if h1.Ino != h2.Ino || h1.Nlink != 2 {
+		t.Errorf("h1 and h2 are not hardlinks")
+	}
+	if content, _ := ioutil.ReadFile(filepath.Join(root, "usr/bin/h1")); string(content) != "linked" {
+		t.Errorf("got content %q for h1", content)
+	}
+
+	if os.Geteuid() == 0 {
+		if err = syscall.Lstat(filepath.Join(root, "usr/bin/ping"), &st); err != nil {
+			t.Fatal(err)
+		}
+		if st.Uid != 0 {
+			t.Errorf("unknown user not mapped to root")
+		}
+		buf := make([]byte, 64)
+		n, err := syscall.Getxattr(filepath.Join(root, "usr/bin/ping"), capabilityXattr, buf)
+		if err != nil {
+			t.Errorf("couldn't read capabilities: %s", err)
+		} else if expected, _ := encodeFileCaps("cap_net_raw=ep"); !bytes.Equal(buf[:n], expected) {
+			t.Errorf("got capabilities %x, want %x", buf[:n], expected)
+		}
+	}
+}
+
+func testExtract(t *testing.T, stripped bool, compressor string) {
+	dir, err := ioutil.TempDir("", "rpm-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	rpmFile := filepath.Join(dir, "test.rpm")
+	if err = ioutil.WriteFile(rpmFile, buildTestRPM(stripped, compressor), 0644); err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(dir, "root")
+	if err = os.MkdirAll(filepath.Join(root, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	_ = ioutil.WriteFile(filepath.Join(root, "etc/passwd"), []byte("root:x:0:0::/root:/bin/sh\ntester:x:1234:4321::/:/bin/false\n"), 0644)
+	_ = ioutil.WriteFile(filepath.Join(root, "etc/group"), []byte("root:x:0:\ntesters:x:4321:\n"), 0644)
+
+	// Extracting twice replaces the existing files.
+	for i := 0; i < 2; i++ {
+		if err = Extract(rpmFile, root, LoadOwners(root)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkExtracted(t, root)
+}
+
+func TestExtract(t *testing.T) {
+	testExtract(t, false, "gzip")
+}
+
+func TestExtractStripped(t *testing.T) {
+	testExtract(t, true, "gzip")
+}
+
+func TestExtractCompressors(t *testing.T) {
+	for _, compressor := range []string{"xz", "lzma", "zstd"} {
+		t.Run(compressor, func(t *testing.T) {
+			testExtract(t, false, compressor)
+		})
+	}
+}
+
+func TestLoadOwners(t *testing.T) {
+	root, err := ioutil.TempDir("", "rpm-owners-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(root)
+	}()
+	_ = os.MkdirAll(filepath.Join(root, "etc"), 0755)
+	_ = os.MkdirAll(filepath.Join(root, "usr/share/defaults/etc"), 0755)
+	_ = ioutil.WriteFile(filepath.Join(root, "usr/share/defaults/etc/passwd"), []byte("a:x:10:10::/:/bin/false\nb:x:11:11::/:/bin/false\n"), 0644)
+	_ = ioutil.WriteFile(filepath.Join(root, "etc/passwd"), []byte("# comment\nb:x:20:20::/:/bin/false\n"), 0644)
+
+	o := LoadOwners(root)
+	if o.UID("a") != 10 || o.UID("b") != 20 || o.UID("unknown") != 0 || o.GID("a") != 0 {
+		t.Errorf("unexpected owners %+v", o)
+	}
+}
+
+func TestEncodeFileCaps(t *testing.T) {
+	tests := []struct {
+		text        string
+		effective   bool
+		permitted   uint32
+		inheritable uint32
+	}{
+		{"cap_net_raw=ep", true, 1 << 13, 0},
+		{"cap_net_admin,cap_net_raw+ep", true, 1<<12 | 1<<13, 0},
+		{"cap_chown=pi", false, 1, 1},
+		{"cap_chown,cap_kill=p cap_kill-p", false, 1, 0},
+	}
+	for _, tt := range tests {
+		data, err := encodeFileCaps(tt.text)
+		if err != nil {
+			t.Errorf("%s: %s", tt.text, err)
+			continue
+		}
+		magic := binary.LittleEndian.Uint32(data)
+		if (magic&vfsCapFlagsEffec != 0) != tt.effective || magic&^vfsCapFlagsEffec != vfsCapRevision2 {
+			t.Errorf("%s: got magic %x", tt.text, magic)
+		}
+		if p := binary.LittleEndian.Uint32(data[4:]); p != tt.permitted {
+			t.Errorf("%s: got permitted %x, want %x", tt.text, p, tt.permitted)
+		}
+		if i := binary.LittleEndian.Uint32(data[8:]); i != tt.inheritable {
+			t.Errorf("%s: got inheritable %x, want %x", tt.text, i, tt.inheritable)
+		}
+	}
+
+	if _, err := encodeFileCaps("cap_bogus=ep"); err == nil {
+		t.Errorf("no error for unknown capability")
+	}
+}
+
+func TestExtractSymlinksInRoot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rpm-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	// The host directory stands for the /etc of the host: the absolute
+	// symlinks to it in root must not be followed outside of root.
+	root, host := filepath.Join(dir, "root"), filepath.Join(dir, "host")
+	for _, d := range []string{filepath.Join(root, "usr", "lib"), filepath.Join(host, "dir")} {
+		if err = os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range map[string]string{
+		"foo":  host,
+		"dir":  filepath.Join(host, "dir"),
+		"up":   "../../../..",
+		"lib":  "/usr/lib",
+		"loop": "loop",
+	} {
+		if err = os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	x := &extractor{root: root, owners: LoadOwners(root)}
+	file := &File{Mode: syscall.S_IFREG | 0644, Mtime: 1500000000}
+	dirFile := &File{Mode: syscall.S_IFDIR | 0755, Mtime: 1500000000}
+	for name, expected := range map[string]string{
+		"./foo/x": filepath.Join(root, host, "x"),
+		"./up/x":  filepath.Join(root, "x"),
+		"./lib/x": filepath.Join(root, "usr", "lib", "x"),
+	} {
+		target, err := x.targetPath(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if target != expected {
+			t.Errorf("got target %s for %s, want %s", target, name, expected)
+		}
+		if err = x.extract(target, file, strings.NewReader("content")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = os.Lstat(filepath.Join(host, "x")); !os.IsNotExist(err) {
+		t.Errorf("file extracted outside of root: %v", err)
+	}
+	if _, err = x.targetPath("./loop/x"); err == nil || !strings.Contains(err.Error(), "too many levels") {
+		t.Errorf("got error %v for symlink loop", err)
+	}
+
+	// A symlink to a directory is kept only when the directory is in root.
+	for name, kept := range map[string]bool{"./lib": true, "./dir": false} {
+		target, err := x.targetPath(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = x.extract(target, dirFile, nil); err != nil {
+			t.Fatal(err)
+		}
+		fi, err := os.Lstat(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isLink := fi.Mode()&os.ModeSymlink != 0; isLink != kept || !kept && !fi.IsDir() {
+			t.Errorf("got mode %s for %s", fi.Mode(), name)
+		}
+	}
+}
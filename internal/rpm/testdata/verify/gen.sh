@@ -0,0 +1,70 @@
+#!/bin/bash
+# Regenerates the keys and signatures used by TestVerify with gpg. The header
+# and payload are the signed data of the test RPM and are not regenerated.
+set -euo pipefail
+
+cd "$(dirname "$0")"
+export GNUPGHOME=$(mktemp -d)
+trap 'rm -rf "$GNUPGHOME"' EXIT
+
+gpg="gpg --batch --quiet --pinentry-mode loopback --passphrase="
+cat header payload > full
+
+fpr() {
+	$gpg --with-colons --list-keys "$1" | awk -F: '$1 == "fpr" { print $10; exit }'
+}
+subfpr() {
+	$gpg --with-colons --list-keys "$1" | awk -F: '$1 == "fpr" { n++ } $1 == "fpr" && n == 2 { print $10; exit }'
+}
+sign() {
+	local key=$1 out=$2 in=$3
+	shift 3
+	$gpg --yes --digest-algo SHA256 "$@" --local-user "$key!" --output "$out" --detach-sign "$in"
+}
+
+# A certification only primary key with a signing subkey, like the keys of
+# most distributions.
+$gpg --quick-generate-key "Signer <signer@example.com>" rsa3072 cert never
+signer=$(fpr signer@example.com)
+$gpg --quick-add-key "$signer" rsa2048 sign never
+signer_sub=$(subfpr signer@example.com)
+sign "$signer_sub" signer-header.sig header
+sign "$signer_sub" signer-full.sig full
+sign "$signer_sub" signer-sha1.sig header --digest-algo SHA1
+sign "$signer_sub" signer-text.sig header --textmode
+
+$gpg --quick-generate-key "EdDSA Signer <eddsa@example.com>" ed25519 sign never
+eddsa=$(fpr eddsa@example.com)
+sign "$eddsa" eddsa-header.sig header
+sign "$eddsa" eddsa-full.sig full
+
+$gpg --quick-generate-key "Other <other@example.com>" rsa2048 sign never
+other=$(fpr other@example.com)
+sign "$other" other-header.sig header
+
+# A key that expired one day after creating it and signing the header.
+past="--faked-system-time 20200101T000000"
+$gpg $past --quick-generate-key "Expired <expired@example.com>" rsa2048 sign 1d
+expired=$(fpr expired@example.com)
+sign "$expired" expired-header.sig header $past
+
+$gpg --quick-generate-key "Revoked <revoked@example.com>" rsa2048 sign never
+revoked=$(fpr revoked@example.com)
+sign "$revoked" revoked-header.sig header
+
+# gpg creates a revocation certificate with each key, which is disabled by
+# a colon before the armor.
+sed 's/^:-----/-----/' "$GNUPGHOME/openpgp-revocs.d/$revoked.rev" | $gpg --import
+
+# The trusted keys are in a single file with one armored block each.
+rm -f keyring.asc
+for key in "$signer" "$eddsa" "$expired" "$revoked"; do
+	$gpg --armor --export "$key" >> keyring.asc
+done
+$gpg --export "$signer" > signer.gpg
+$gpg --export "$other" > other.gpg
+
+for key in signer signer_sub eddsa other expired revoked; do
+	echo "$key ${!key}"
+done > keys
+rm full
@@ -0,0 +1,95 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNotSigned is returned by Verify for packages without signatures.
+var ErrNotSigned = errors.New("package is not signed")
+
+// Verify checks that the RPM file at filename is signed by one of the keys in
+// keyring and returns the key. Like rpm, a signature of the header alone is
+// preferred, in which case the payload is checked against the digest in the
+// signed header. Older packages are checked with the signature of both the
+// header and the payload.
+func Verify(filename string, keyring *Keyring) (*PublicKey, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	r := bufio.NewReader(f)
+	p, err := ReadPackage(r)
+	if err != nil {
+		return nil, err
+	}
+
+	headerSig := findSignature(p.Signature, SigTagRSA, SigTagDSA)
+	fullSig := findSignature(p.Signature, SigTagPGP, SigTagGPG)
+	digests := p.Header.Strings(TagPayloadDigest)
+
+	if headerSig == nil || len(digests) == 0 {
+		switch {
+		case fullSig != nil:
+			return keyring.check(fullSig, io.MultiReader(bytes.NewReader(p.Header.Raw()), r))
+		case headerSig != nil:
+			return nil, errors.New("payload is not covered by the signature")
+		default:
+			return nil, ErrNotSigned
+		}
+	}
+
+	key, err := keyring.check(headerSig, bytes.NewReader(p.Header.Raw()))
+	if err != nil {
+		return nil, err
+	}
+	algo := int64(8)
+	if v := p.Header.Ints(TagPayloadDigestAlgo); len(v) > 0 {
+		algo = v[0]
+	}
+	h, ok := pgpHashes[byte(algo)]
+	if !ok || !h.Available() {
+		return nil, errors.Errorf("unsupported payload digest algorithm %d", algo)
+	}
+	payloadHash := h.New()
+	if _, err = io.Copy(payloadHash, r); err != nil {
+		return nil, errors.Wrap(err, "couldn't read payload")
+	}
+	if hex.EncodeToString(payloadHash.Sum(nil)) != digests[0] {
+		return nil, errors.New("payload digest mismatch")
+	}
+	return key, nil
+}
+
+// findSignature returns the first of the tags present in the signature header.
+func findSignature(sigHeader *Header, tags ...int32) []byte {
+	for _, tag := range tags {
+		if data := sigHeader.Bytes(tag); data != nil {
+			return data
+		}
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package rpm
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// The keys and signatures in testdata/verify are created by gen.sh with gpg.
+const verifyTestData = "testdata/verify"
+
+func readTestData(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := ioutil.ReadFile(filepath.Join(verifyTestData, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// readTestKeys returns the fingerprints of the test keys by name.
+func readTestKeys(t *testing.T) map[string]string {
+	t.Helper()
+	keys := make(map[string]string)
+	s := bufio.NewScanner(bytes.NewReader(readTestData(t, "keys")))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) != 2 {
+			t.Fatalf("invalid line in keys: %q", s.Text())
+		}
+		keys[fields[0]] = fields[1]
+	}
+	return keys
+}
+
+func (h *testHeader) addBin(tag int32, data []byte) {
+	h.add(tag, typeBin, len(data), data, 1)
+}
+
+// buildSignedRPM returns an RPM with the test header and payload, and the
+// given signature tag. A zero tag returns an unsigned RPM.
+func buildSignedRPM(t *testing.T, tag int32, sig []byte) []byte {
+	t.Helper()
+	var sigHeader testHeader
+	if tag != 0 {
+		sigHeader.addBin(tag, sig)
+	} else {
+		sigHeader.addString(1000, "sig")
+	}
+
+	var rpm bytes.Buffer
+	lead := make([]byte, leadSize)
+	copy(lead, leadMagic)
+	rpm.Write(lead)
+	rpm.Write(sigHeader.bytes())
+	for rpm.Len()%8 != 0 {
+		rpm.WriteByte(0)
+	}
+	rpm.Write(readTestData(t, "header"))
+	rpm.Write(readTestData(t, "payload"))
+	return rpm.Bytes()
+}
+
+// graftSubkey returns the primary key of primary with the subkeys of other,
+// whose binding signatures are then made by a different key.
+func graftSubkey(t *testing.T, primary, other []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	copyPackets := func(data []byte, subkeys bool) {
+		r := packet.NewOpaqueReader(bytes.NewReader(data))
+		inSubkey := false
+		for {
+			p, err := r.Next()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Tag == 14 {
+				inSubkey = true
+			}
+			if inSubkey == subkeys {
+				if err = p.Serialize(&buf); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+	copyPackets(primary, false)
+	copyPackets(other, true)
+	return buf.Bytes()
+}
+
+func TestKeyring(t *testing.T) {
+	keys := readTestKeys(t)
+
+	kr := NewKeyring()
+	if err := kr.AddFile(filepath.Join(verifyTestData, "keyring.asc")); err != nil {
+		t.Fatal(err)
+	}
+	// The signer has a subkey, the other keys have none.
+	if kr.Len() != 5 {
+		t.Errorf("got %d keys, want 5", kr.Len())
+	}
+	for _, name := range []string{"signer", "signer_sub", "eddsa", "expired", "revoked"} {
+		fpr := keys[name]
+		var id KeyID
+		for _, c := range fpr[len(fpr)-16:] {
+			id = id<<4 | KeyID(strings.IndexRune("0123456789ABCDEF", c))
+		}
+		k := kr.Key(id)
+		if k == nil {
+			t.Errorf("key %s (%s) not found", name, fpr)
+			continue
+		}
+		if k.ID != id || k.Fingerprint != fpr {
+			t.Errorf("got key %s (%s), want %s (%s)", k.ID, k.Fingerprint, id, fpr)
+		}
+	}
+	if k := kr.Key(0x1234); k != nil {
+		t.Errorf("unexpected key %+v", k)
+	}
+
+	binary := NewKeyring()
+	if err := binary.Add(readTestData(t, "signer.gpg")); err != nil {
+		t.Fatal(err)
+	}
+	if binary.Len() != 2 {
+		t.Errorf("got %d keys in binary keyring, want 2", binary.Len())
+	}
+
+	// A single changed character corrupts the key.
+	armored := readTestData(t, "keyring.asc")
+	corrupted := append([]byte{}, armored...)
+	i := bytes.Index(corrupted, []byte("\n\n")) + 100
+	corrupted[i] ^= 0x01
+	if err := NewKeyring().Add(corrupted); err == nil {
+		t.Error("unexpected success reading corrupted key")
+	}
+	// The signing subkey of signer bound to other, so the binding signature
+	// is not valid.
+	grafted := graftSubkey(t, readTestData(t, "other.gpg"), readTestData(t, "signer.gpg"))
+	if err := NewKeyring().Add(grafted); err == nil || !strings.Contains(err.Error(), "subkey signature invalid") {
+		t.Errorf("got error %v reading key with invalid subkey binding", err)
+	}
+	if err := NewKeyring().Add([]byte("not a key")); err == nil {
+		t.Error("unexpected success reading invalid key")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rpm-verify-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	keys := readTestKeys(t)
+	kr := NewKeyring()
+	if err = kr.AddFile(filepath.Join(verifyTestData, "keyring.asc")); err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := func(rpm []byte) []byte {
+		rpm[len(rpm)-1] ^= 0xFF
+		return rpm
+	}
+
+	testCases := []struct {
+		name   string
+		rpm    []byte
+		errMsg string
+		key    string
+	}{
+		{"subkey-header", buildSignedRPM(t, SigTagRSA, readTestData(t, "signer-header.sig")), "", "signer_sub"},
+		{"subkey-full", buildSignedRPM(t, SigTagPGP, readTestData(t, "signer-full.sig")), "", "signer_sub"},
+		{"eddsa-header", buildSignedRPM(t, SigTagDSA, readTestData(t, "eddsa-header.sig")), "", "eddsa"},
+		{"eddsa-full", buildSignedRPM(t, SigTagGPG, readTestData(t, "eddsa-full.sig")), "", "eddsa"},
+		{"unsigned", buildSignedRPM(t, 0, nil), "not signed", ""},
+		{"unknown-key", buildSignedRPM(t, SigTagRSA, readTestData(t, "other-header.sig")), "unknown key", ""},
+		{"expired", buildSignedRPM(t, SigTagRSA, readTestData(t, "expired-header.sig")), "key expired", ""},
+		{"revoked", buildSignedRPM(t, SigTagRSA, readTestData(t, "revoked-header.sig")), "revoked key", ""},
+		{"sha1", buildSignedRPM(t, SigTagRSA, readTestData(t, "signer-sha1.sig")), "insecure hash", ""},
+		{"text", buildSignedRPM(t, SigTagRSA, readTestData(t, "signer-text.sig")), "not a binary signature", ""},
+		{"wrong-data", buildSignedRPM(t, SigTagRSA, readTestData(t, "signer-full.sig")), "invalid signature", ""},
+		{"garbage", buildSignedRPM(t, SigTagRSA, []byte("garbage")), "invalid signature", ""},
+		{"tampered-header", tampered(buildSignedRPM(t, SigTagRSA, readTestData(t, "signer-header.sig"))), "payload digest mismatch", ""},
+		{"tampered-full", tampered(buildSignedRPM(t, SigTagPGP, readTestData(t, "signer-full.sig"))), "invalid signature", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			filename := filepath.Join(dir, tc.name+".rpm")
+			if err := ioutil.WriteFile(filename, tc.rpm, 0644); err != nil {
+				t.Fatal(err)
+			}
+			key, err := Verify(filename, kr)
+			if tc.errMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.errMsg) {
+					t.Fatalf("got error %v, want %q", err, tc.errMsg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if key.Fingerprint != keys[tc.key] {
+				t.Errorf("got key %s, want %s", key.Fingerprint, keys[tc.key])
+			}
+			if tc.key == "signer_sub" && key.UserID != "Signer <signer@example.com>" {
+				t.Errorf("got user ID %q", key.UserID)
+			}
+		})
+	}
+}
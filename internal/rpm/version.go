@@ -0,0 +1,164 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpm
+
+import (
+	"strconv"
+	"strings"
+)
+
+// CompareVersions compares two version or release strings the same way rpm
+// does, returning -1, 0 or 1 if a is older, equal or newer than b.
+func CompareVersions(a, b string) int {
+	if a == b {
+		return 0
+	}
+	isSeparator := func(r rune) bool {
+		return !isDigit(r) && !isAlpha(r) && r != '~' && r != '^'
+	}
+
+	for len(a) > 0 || len(b) > 0 {
+		a = strings.TrimLeftFunc(a, isSeparator)
+		b = strings.TrimLeftFunc(b, isSeparator)
+
+		// A tilde sorts before everything, even the end of the version.
+		if strings.HasPrefix(a, "~") || strings.HasPrefix(b, "~") {
+			if !strings.HasPrefix(a, "~") {
+				return 1
+			}
+			if !strings.HasPrefix(b, "~") {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+
+		// A caret sorts after the end of the version, but before anything
+		// else.
+		if strings.HasPrefix(a, "^") || strings.HasPrefix(b, "^") {
+			if a == "" {
+				return -1
+			}
+			if b == "" {
+				return 1
+			}
+			if !strings.HasPrefix(a, "^") {
+				return 1
+			}
+			if !strings.HasPrefix(b, "^") {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+
+		if a == "" || b == "" {
+			break
+		}
+
+		// Compare the next segment of digits or letters. A numeric
+		// segment is always newer than an alphabetic one.
+		numeric := isDigit(rune(a[0]))
+		match := isAlpha
+		if numeric {
+			match = isDigit
+		}
+		var segA, segB string
+		segA, a = splitSegment(a, match)
+		segB, b = splitSegment(b, match)
+		if segB == "" {
+			if numeric {
+				return 1
+			}
+			return -1
+		}
+		if numeric {
+			segA = strings.TrimLeft(segA, "0")
+			segB = strings.TrimLeft(segB, "0")
+			if len(segA) != len(segB) {
+				return compareInts(len(segA), len(segB))
+			}
+		}
+		if c := strings.Compare(segA, segB); c != 0 {
+			return c
+		}
+	}
+
+	// The version with characters left is newer.
+	switch {
+	case a == "" && b == "":
+		return 0
+	case a == "":
+		return -1
+	default:
+		return 1
+	}
+}
+
+// CompareEVR compares two [epoch:]version[-release] strings, returning -1, 0
+// or 1 if a is older, equal or newer than b. Releases are only compared if
+// both strings have one.
+func CompareEVR(a, b string) int {
+	epochA, versionA, releaseA := splitEVR(a)
+	epochB, versionB, releaseB := splitEVR(b)
+	if epochA != epochB {
+		return compareInts(epochA, epochB)
+	}
+	if c := CompareVersions(versionA, versionB); c != 0 {
+		return c
+	}
+	if releaseA == "" || releaseB == "" {
+		return 0
+	}
+	return CompareVersions(releaseA, releaseB)
+}
+
+func splitEVR(evr string) (epoch int, version, release string) {
+	if i := strings.Index(evr, ":"); i != -1 {
+		epoch, _ = strconv.Atoi(evr[:i])
+		evr = evr[i+1:]
+	}
+	version = evr
+	if i := strings.LastIndex(evr, "-"); i != -1 {
+		version, release = evr[:i], evr[i+1:]
+	}
+	return epoch, version, release
+}
+
+func splitSegment(s string, match func(rune) bool) (segment, rest string) {
+	i := strings.IndexFunc(s, func(r rune) bool { return !match(r) })
+	if i == -1 {
+		return s, ""
+	}
+	return s[:i], s[i:]
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+func isAlpha(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,51 @@
+package rpm
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "2.0", -1},
+		{"2.0.1", "2.0", 1},
+		{"1.10", "1.9", 1},
+		{"1.001", "1.1", 0},
+		{"1.0a", "1.0", 1},
+		{"1.0", "1.a", 1},
+		{"1.0~rc1", "1.0", -1},
+		{"1.0~rc1", "1.0~rc2", -1},
+		{"1.0^git1", "1.0", 1},
+		{"1.0^git1", "1.0.1", -1},
+		{"1_0", "1.0", 0},
+		{"abc", "abd", -1},
+	}
+	for _, tt := range tests {
+		if c := CompareVersions(tt.a, tt.b); c != tt.expected {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.a, tt.b, c, tt.expected)
+		}
+		if c := CompareVersions(tt.b, tt.a); c != -tt.expected {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.b, tt.a, c, -tt.expected)
+		}
+	}
+}
+
+func TestCompareEVR(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.0-1", "1.0-1", 0},
+		{"1.0-2", "1.0-10", -1},
+		{"1:1.0-1", "2.0-1", 1},
+		{"0:1.0-1", "1.0-1", 0},
+		{"1.0", "1.0-5", 0},
+		{"1.1-1", "1.0-9", 1},
+	}
+	for _, tt := range tests {
+		if c := CompareEVR(tt.a, tt.b); c != tt.expected {
+			t.Errorf("CompareEVR(%q, %q) = %d, want %d", tt.a, tt.b, c, tt.expected)
+		}
+	}
+}
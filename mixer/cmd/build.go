@@ -47,11 +47,14 @@ type buildCmdFlags struct {
 	template        string
 	skipFullfiles   bool
 	skipPacks       bool
+	releaseNotes    bool
 	to              int
 	from            int
 	toRepoURLs      *map[string]string
 	fromRepoURLs    *map[string]string
 	skipFormatCheck bool
+	locked          bool
+	lockFile        string
 
 	numFullfileWorkers int
 	numDeltaWorkers    int
@@ -144,6 +147,14 @@ var buildBundlesCmd = &cobra.Command{
 			fail(err)
 		}
 		setWorkers(b)
+		if buildFlags.locked || buildFlags.lockFile != "" {
+			b.LockFile = buildFlags.lockFile
+			if b.LockFile == "" {
+				if b.LockFile, err = b.DefaultPackageLock(); err != nil {
+					fail(err)
+				}
+			}
+		}
 		err = buildBundles(b, buildFlags.noSigning, buildFlags.clean, buildFlags.downloadRetries)
 		if err != nil {
 			fail(err)
@@ -338,6 +349,7 @@ var buildFormatOldCmd = &cobra.Command{
 			SkipSigning:   buildFlags.noSigning,
 			SkipFullfiles: buildFlags.skipFullfiles,
 			SkipPacks:     buildFlags.skipPacks,
+			ReleaseNotes:  buildFlags.releaseNotes,
 		}
 		if err = b.BuildUpdate(params); err != nil {
 			failf("Couldn't build update: %s", err)
@@ -412,6 +424,7 @@ var buildFormatNewCmd = &cobra.Command{
 			SkipSigning:   buildFlags.noSigning,
 			SkipFullfiles: buildFlags.skipFullfiles,
 			SkipPacks:     buildFlags.skipPacks,
+			ReleaseNotes:  buildFlags.releaseNotes,
 		}
 		err = b.BuildUpdate(params)
 		if err != nil {
@@ -448,6 +461,7 @@ var buildUpdateCmd = &cobra.Command{
 			SkipSigning:   buildFlags.noSigning,
 			SkipFullfiles: buildFlags.skipFullfiles,
 			SkipPacks:     buildFlags.skipPacks,
+			ReleaseNotes:  buildFlags.releaseNotes,
 		}
 		err = b.BuildUpdate(params)
 		if err != nil {
@@ -497,6 +511,7 @@ var buildAllCmd = &cobra.Command{
 			SkipSigning:   buildFlags.noSigning,
 			SkipFullfiles: buildFlags.skipFullfiles,
 			SkipPacks:     buildFlags.skipPacks,
+			ReleaseNotes:  buildFlags.releaseNotes,
 		}
 		err = b.BuildUpdate(params)
 		if err != nil {
@@ -677,6 +692,7 @@ func setUpdateFlags(cmd *cobra.Command) {
 	cmd.Flags().BoolVar(&buildFlags.noPublish, "no-publish", false, "Do not update the latest version after update")
 	cmd.Flags().BoolVar(&buildFlags.skipFullfiles, "skip-fullfiles", false, "Do not generate fullfiles")
 	cmd.Flags().BoolVar(&buildFlags.skipPacks, "skip-packs", false, "Do not generate zero packs")
+	cmd.Flags().BoolVar(&buildFlags.releaseNotes, "release-notes", false, "Write the changes from the previous version to "+builder.ReleaseNotesFile)
 
 	var unusedStringFlag string
 	cmd.Flags().StringVar(&unusedStringFlag, "prefix", "", "Supply prefix for where the swupd binaries live")
@@ -734,6 +750,8 @@ func init() {
 
 	buildBundlesCmd.Flags().BoolVar(&buildFlags.clean, "clean", false, "Wipe the /image and /www dirs if they exist")
 	buildBundlesCmd.Flags().BoolVar(&buildFlags.noSigning, "no-signing", false, "Do not generate a certificate to sign the Manifest.MoM")
+	buildBundlesCmd.Flags().BoolVar(&buildFlags.locked, "locked", false, "Resolve packages exactly from the package lock of the mix version, or of the previous one, and fail on drift")
+	buildBundlesCmd.Flags().StringVar(&buildFlags.lockFile, "lock-file", "", "Path to the package lock used by --locked, implies --locked")
 
 	unusedBoolFlag := false
 	buildBundlesCmd.Flags().BoolVar(&unusedBoolFlag, "new-chroots", false, "")
@@ -765,8 +783,6 @@ func init() {
 	externalDeps[buildBundlesCmd] = []string{
 		"rpm",
 		"dnf",
-		"rpm2archive",
-		"tar",
 	}
 	externalDeps[buildUpdateCmd] = []string{
 		"openssl",
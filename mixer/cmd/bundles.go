@@ -15,6 +15,9 @@
 package cmd
 
 import (
+	"os"
+	"runtime"
+
 	"github.com/clearlinux/mixer-tools/builder"
 
 	"github.com/pkg/errors"
@@ -241,6 +244,249 @@ Passing '--all-local' will run validation on all bundles in local-bundles.`,
 	},
 }
 
+// Bundle why command ('mixer bundle why')
+type bundleWhyCmdFlags struct {
+	locked   bool
+	lockFile string
+}
+
+var bundleWhyFlags bundleWhyCmdFlags
+
+var bundleWhyCmd = &cobra.Command{
+	Use:   "why <package|path>",
+	Short: "Explain why a package or file is in the mix",
+	Long: `Reports which bundles of the mix contain a package, or the package providing
+an absolute file path. For each bundle, shows the chain of includes from the
+Mix Bundle List to the bundle, and whether the package is listed directly in
+the bundle or is a dependency. For dependencies, shows the chain of
+requirements from the package listed in the bundle that pulled it in.
+
+Files from content directories are reported with the bundle that provides
+them.
+
+Packages are resolved with the same package pins as in 'mixer build bundles',
+and with --locked or --lock-file, with the same package lock.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		b, err := builder.NewFromConfig(configFile)
+		if err != nil {
+			fail(err)
+		}
+		if bundleWhyFlags.locked || bundleWhyFlags.lockFile != "" {
+			b.LockFile = bundleWhyFlags.lockFile
+			if b.LockFile == "" {
+				if b.LockFile, err = b.DefaultPackageLock(); err != nil {
+					fail(err)
+				}
+			}
+		}
+
+		err = b.BundleWhy(args[0])
+		if err != nil {
+			fail(err)
+		}
+	},
+}
+
+// Bundle graph command ('mixer bundle graph')
+type bundleGraphCmdFlags struct {
+	format string
+	bundle string
+}
+
+var bundleGraphFlags bundleGraphCmdFlags
+
+var bundleGraphCmd = &cobra.Command{
+	Use:   "graph",
+	Short: "Print the graph of the bundles in the mix",
+	Long: `Prints the graph of the bundles in the mix, recursively following includes
+and also-adds, in either:
+  dot   The Graphviz DOT language (DEFAULT)
+  json  A JSON object with the list of nodes and edges
+
+Each node carries the title, status and maintainer from the bundle header, and
+the number of packages listed in the bundle and in the bundle plus its
+includes. Include and also-add edges are distinguished.
+
+Passing '--bundle' restricts the graph to that bundle and the bundles it
+includes.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		b, err := builder.NewFromConfig(configFile)
+		if err != nil {
+			fail(err)
+		}
+
+		switch bundleGraphFlags.format {
+		case "dot":
+			err = b.BundleGraph(os.Stdout, builder.DOTGraph, bundleGraphFlags.bundle)
+		case "json":
+			err = b.BundleGraph(os.Stdout, builder.JSONGraph, bundleGraphFlags.bundle)
+		default:
+			return errors.Errorf("invalid format %q, must be dot or json", bundleGraphFlags.format)
+		}
+		if err != nil {
+			fail(err)
+		}
+
+		return nil
+	},
+}
+
+// Bundle lint command ('mixer bundle lint')
+type bundleLintCmdFlags struct {
+	failOn string
+}
+
+var bundleLintFlags bundleLintCmdFlags
+
+var bundleLintCmd = &cobra.Command{
+	Use:   "lint",
+	Short: "Look for problems across the bundles in the mix",
+	Long: `Looks for problems across the bundles in the mix and the local bundles, and
+prints a report with the severity of each problem:
+  error    An active bundle includes a deprecated bundle
+  warning  A local bundle lists a package it already gets through an include,
+           is empty, or is missing the TITLE or DESCRIPTION header; or a
+           bundle in the mix has an also-add target not in the Mix Bundle List
+  info     A local bundle is not in the mix and not included by any bundle
+
+Problems in the definition of upstream bundles are not reported, since
+upstream bundles are trusted. A non-zero return code is returned if any
+problem has the severity passed to '--fail-on' or higher.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		failOn, err := builder.ParseLintSeverity(bundleLintFlags.failOn)
+		if err != nil {
+			return err
+		}
+
+		b, err := builder.NewFromConfig(configFile)
+		if err != nil {
+			fail(err)
+		}
+
+		err = b.LintBundles(failOn)
+		if err != nil {
+			fail(err)
+		}
+
+		return nil
+	},
+}
+
+// Bundle diff command ('mixer bundle diff')
+type bundleDiffCmdFlags struct {
+	from string
+	to   string
+}
+
+var bundleDiffFlags bundleDiffCmdFlags
+
+var bundleDiffCmd = &cobra.Command{
+	Use:   "diff [<bundle>...]",
+	Short: "Show differences between local and upstream bundle definitions",
+	Long: `Shows the differences in the headers, includes, also-adds, excludes and
+packages of bundle definitions.
+
+By default, the bundles in local-bundles are compared against the upstream
+bundles they override, from the upstream version of the mix or the version
+passed to '--to'.
+
+Passing '--from' compares the upstream bundles in that version against the
+ones in the upstream version of the mix or the version passed to '--to'. This
+shows what changed upstream after 'mixer versions update'.
+
+When no bundles are passed, all bundles are compared.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		b, err := builder.NewFromConfig(configFile)
+		if err != nil {
+			fail(err)
+		}
+
+		err = b.DiffBundles(args, bundleDiffFlags.from, bundleDiffFlags.to)
+		if err != nil {
+			fail(err)
+		}
+	},
+}
+
+// Bundle rebase command ('mixer bundle rebase')
+type bundleRebaseCmdFlags struct {
+	base string
+}
+
+var bundleRebaseFlags bundleRebaseCmdFlags
+
+var bundleRebaseCmd = &cobra.Command{
+	Use:   "rebase [<bundle>...]",
+	Short: "Merge upstream changes into local bundles copied from upstream",
+	Long: `Merges the changes made upstream into the local bundles that override upstream
+bundles, usually after 'mixer versions update'.
+
+'mixer bundle create' records the upstream version each upstream bundle is
+copied from. Rebase runs a three-way merge of the upstream bundle in that
+version, the upstream bundle in the upstream version of the mix and the local
+bundle. Changes made only upstream or only locally are applied automatically.
+Lines changed differently in both are marked as conflicts in the local bundle,
+and must be resolved by hand. The recorded version is then updated to the
+upstream version of the mix.
+
+For local bundles without a recorded version, pass the upstream version they
+were copied from with '--base'.
+
+When no bundles are passed, all local bundles are rebased.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		b, err := builder.NewFromConfig(configFile)
+		if err != nil {
+			fail(err)
+		}
+
+		err = b.RebaseBundles(args, bundleRebaseFlags.base)
+		if err != nil {
+			fail(err)
+		}
+	},
+}
+
+// Bundle size command ('mixer bundle size')
+type bundleSizeCmdFlags struct {
+	numBundleWorkers int
+}
+
+var bundleSizeFlags bundleSizeCmdFlags
+
+var bundleSizeCmd = &cobra.Command{
+	Use:   "size",
+	Short: "Estimate the installed size of the bundles in the mix",
+	Long: `Estimates the installed size of each bundle in the mix without building it.
+The packages of each bundle are resolved the same way as in 'mixer build
+bundles', and their installed sizes, in bytes, are taken from the repository
+metadata.
+
+For each bundle, PACKAGES and SIZE exclude the packages of os-core and of the
+bundles it includes, the same way their files are subtracted from the bundle
+manifest. TOTAL PACKAGES and TOTAL SIZE are for all packages of the bundle.
+The last line has the totals for the whole mix.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		b, err := builder.NewFromConfig(configFile)
+		if err != nil {
+			fail(err)
+		}
+
+		b.NumBundleWorkers = bundleSizeFlags.numBundleWorkers
+		if b.NumBundleWorkers < 1 {
+			b.NumBundleWorkers = runtime.NumCPU()
+		}
+
+		err = b.BundleSizes()
+		if err != nil {
+			fail(err)
+		}
+	},
+}
+
 // List of all bundle commands
 var bundlesCmds = []*cobra.Command{
 	bundleAddCmd,
@@ -248,6 +494,12 @@ var bundlesCmds = []*cobra.Command{
 	bundleListCmd,
 	bundleCreateCmd,
 	bundleValidateCmd,
+	bundleWhyCmd,
+	bundleGraphCmd,
+	bundleLintCmd,
+	bundleDiffCmd,
+	bundleRebaseCmd,
+	bundleSizeCmd,
 }
 
 func init() {
@@ -276,4 +528,19 @@ func init() {
 
 	bundleValidateCmd.Flags().BoolVar(&bundleValidateFlags.allLocal, "all-local", false, "Validate all local bundles")
 	bundleValidateCmd.Flags().BoolVar(&bundleValidateFlags.strict, "strict", false, "Strict validation (see usage)")
+
+	bundleGraphCmd.Flags().StringVar(&bundleGraphFlags.format, "format", "dot", "Output format: dot or json")
+	bundleGraphCmd.Flags().StringVar(&bundleGraphFlags.bundle, "bundle", "", "Only graph this bundle and the bundles it includes")
+
+	bundleSizeCmd.Flags().IntVar(&bundleSizeFlags.numBundleWorkers, "bundle-workers", 0, "Number of parallel workers when resolving packages, 0 means number of CPUs")
+
+	bundleWhyCmd.Flags().BoolVar(&bundleWhyFlags.locked, "locked", false, "Resolve packages from the package lock used by 'mixer build bundles --locked'")
+	bundleWhyCmd.Flags().StringVar(&bundleWhyFlags.lockFile, "lock-file", "", "Path to the package lock used by --locked, implies --locked")
+
+	bundleRebaseCmd.Flags().StringVar(&bundleRebaseFlags.base, "base", "", "Upstream version the local bundles were copied from")
+
+	bundleDiffCmd.Flags().StringVar(&bundleDiffFlags.from, "from", "", "Compare the upstream bundles of this upstream version")
+	bundleDiffCmd.Flags().StringVar(&bundleDiffFlags.to, "to", "", "Compare against the upstream bundles of this upstream version")
+
+	bundleLintCmd.Flags().StringVar(&bundleLintFlags.failOn, "fail-on", "warning", "Fail if a problem has this severity or higher: info, warning or error")
 }
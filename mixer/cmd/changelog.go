@@ -0,0 +1,73 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/clearlinux/mixer-tools/builder"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var changelogFlags struct {
+	from   uint32
+	to     uint32
+	format string
+}
+
+var changelogCmd = &cobra.Command{
+	Use:   "changelog",
+	Short: "Report the changes between two versions of the mix",
+	Long: `Reports the changes between two built versions of the mix, in either:
+  markdown  A Markdown document (DEFAULT)
+  json      A JSON object
+
+The report lists the bundles added, removed and modified, the packages added,
+removed, upgraded and downgraded in each bundle, and the number of files added,
+removed and modified in each bundle manifest.
+
+By default the changes are from the previous version of '--to' to the current
+mix version. Both versions must have been built with 'mixer build update'.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		b, err := builder.NewFromConfig(configFile)
+		if err != nil {
+			fail(err)
+		}
+
+		switch changelogFlags.format {
+		case "markdown":
+			err = b.Changelog(os.Stdout, changelogFlags.from, changelogFlags.to, builder.MarkdownChangelog)
+		case "json":
+			err = b.Changelog(os.Stdout, changelogFlags.from, changelogFlags.to, builder.JSONChangelog)
+		default:
+			return errors.Errorf("invalid format %q, must be markdown or json", changelogFlags.format)
+		}
+		if err != nil {
+			fail(err)
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(changelogCmd)
+
+	changelogCmd.Flags().Uint32Var(&changelogFlags.from, "from", 0, "Version to report the changes from, defaults to the previous version of --to")
+	changelogCmd.Flags().Uint32Var(&changelogFlags.to, "to", 0, "Version to report the changes to, defaults to the current mix version")
+	changelogCmd.Flags().StringVar(&changelogFlags.format, "format", "markdown", "Output format: markdown or json")
+}
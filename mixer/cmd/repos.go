@@ -15,7 +15,11 @@
 package cmd
 
 import (
+	"bufio"
 	"fmt"
+	"io"
+	"os"
+	"strconv"
 	"strings"
 
 	"github.com/clearlinux/mixer-tools/builder"
@@ -45,11 +49,19 @@ var removeRepoCmd = &cobra.Command{
 	Run:   runRemoveRepo,
 }
 
+type listReposCmdFlags struct {
+	json bool
+}
+
+var listReposFlags listReposCmdFlags
+
 var listReposCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List all configured RPM Repositories",
-	Long:  `List all RPM repositories configured in the DNF configuration file used by mixer`,
-	Run:   runListRepos,
+	Long: `List all RPM repositories configured in the DNF configuration file used by
+mixer. With '--json', all the settings of each repository are printed as a JSON
+list.`,
+	Run: runListRepos,
 }
 
 var initRepoCmd = &cobra.Command{
@@ -78,6 +90,116 @@ supported.`,
 	Run:  runExcludesRepo,
 }
 
+var setIncludesRepoCmd = &cobra.Command{
+	Use:   "include <repo> <pkg> [<pkg>...]",
+	Short: "Only use the given packages from a specified repo",
+	Long: `Only use the given packages from a specified repo. Other packages in the
+repo will be ignored during build bundles. Globbing is supported.`,
+	Args: cobra.MinimumNArgs(2),
+	Run:  runIncludesRepo,
+}
+
+var enableRepoCmd = &cobra.Command{
+	Use:   "enable <name>",
+	Short: "Enable repo <name>",
+	Long:  `Enable the repo <name>, so it is used when building bundles`,
+	Args:  cobra.ExactArgs(1),
+	Run:   runEnableRepo,
+}
+
+var disableRepoCmd = &cobra.Command{
+	Use:   "disable <name>",
+	Short: "Disable repo <name>",
+	Long: `Disable the repo <name>. The repo is kept in the DNF configuration but is
+not used when building bundles.`,
+	Args: cobra.ExactArgs(1),
+	Run:  runDisableRepo,
+}
+
+var setPriorityRepoCmd = &cobra.Command{
+	Use:   "set-priority <name> <priority>",
+	Short: "Sets the priority of repo <name>",
+	Long: `Sets the priority of repo <name>, 1 or higher. When a package is
+available in multiple repos, the one from the repo with the lowest priority
+value is used. Repos without a priority have priority 99.`,
+	Args: cobra.ExactArgs(2),
+	Run:  runSetPriorityRepo,
+}
+
+type setGPGCheckRepoCmdFlags struct {
+	gpgKey string
+}
+
+var setGPGCheckRepoFlags setGPGCheckRepoCmdFlags
+
+var setGPGCheckRepoCmd = &cobra.Command{
+	Use:   "set-gpgcheck <name> <true|false>",
+	Short: "Enables or disables the signature check for repo <name>",
+	Long: `Enables or disables the signature check of the packages in repo <name>.
+The key used to check the signatures is set with '--gpgkey', which is required
+to enable the check unless the repo already has a key.`,
+	Args: cobra.ExactArgs(2),
+	Run:  runSetGPGCheckRepo,
+}
+
+var setModuleHotfixesRepoCmd = &cobra.Command{
+	Use:   "set-module-hotfixes <name> <true|false>",
+	Short: "Sets whether module filtering applies to repo <name>",
+	Long: `Sets the module_hotfixes option of repo <name>. When true, packages of the
+repo are used even if they are filtered out by modules.`,
+	Args: cobra.ExactArgs(2),
+	Run:  runSetModuleHotfixesRepo,
+}
+
+type setProxyRepoCmdFlags struct {
+	username      string
+	passwordStdin bool
+}
+
+// proxyPasswordEnv is the environment variable with the proxy password, so it
+// doesn't show up in the command line of mixer.
+const proxyPasswordEnv = "MIXER_PROXY_PASSWORD"
+
+var setProxyRepoFlags setProxyRepoCmdFlags
+
+var setProxyRepoCmd = &cobra.Command{
+	Use:   "set-proxy <name> <url>",
+	Short: "Sets the proxy used for repo <name>",
+	Long: `Sets the proxy used to access repo <name> to <url>. Use 'main' as <name>
+to set the proxy for all the repos. An empty <url> removes the proxy settings.
+
+The proxy password is read from the first line of the standard input with
+--password-stdin, or else from the MIXER_PROXY_PASSWORD environment variable.
+The DNF conf file is only readable by its owner when it has a proxy password.`,
+	Args: cobra.ExactArgs(2),
+	Run:  runSetProxyRepo,
+}
+
+var localRepoCmd = &cobra.Command{
+	Use:   "local",
+	Short: "List or remove RPMs in the local repository",
+	Long: `List or remove the RPMs added to the local repository with
+'mixer add-rpms'.`,
+}
+
+var listLocalRepoCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the RPMs in the local repository",
+	Long:  `List the RPMs in the local repository, based on its metadata`,
+	Args:  cobra.NoArgs,
+	Run:   runListLocalRepo,
+}
+
+var removeLocalRepoCmd = &cobra.Command{
+	Use:   "remove <rpm> [<rpm>...]",
+	Short: "Remove RPMs from the local repository",
+	Long: `Remove RPMs from the local repository and from LOCAL_RPM_DIR, and
+update the repository metadata. Each <rpm> is either the file name of an RPM or
+the name of a package, which removes all the RPMs of that package.`,
+	Args: cobra.MinimumNArgs(1),
+	Run:  runRemoveLocalRepo,
+}
+
 var repoCmds = []*cobra.Command{
 	addRepoCmd,
 	removeRepoCmd,
@@ -85,12 +207,27 @@ var repoCmds = []*cobra.Command{
 	initRepoCmd,
 	setURLRepoCmd,
 	setExcludesRepoCmd,
+	setIncludesRepoCmd,
+	enableRepoCmd,
+	disableRepoCmd,
+	setPriorityRepoCmd,
+	setGPGCheckRepoCmd,
+	setModuleHotfixesRepoCmd,
+	setProxyRepoCmd,
+	localRepoCmd,
 }
 
 func init() {
 	for _, cmd := range repoCmds {
 		repoCmd.AddCommand(cmd)
 	}
+	localRepoCmd.AddCommand(listLocalRepoCmd)
+	localRepoCmd.AddCommand(removeLocalRepoCmd)
+
+	listReposCmd.Flags().BoolVar(&listReposFlags.json, "json", false, "Print the repos and their settings as JSON")
+	setGPGCheckRepoCmd.Flags().StringVar(&setGPGCheckRepoFlags.gpgKey, "gpgkey", "", "Path or https URL of the GPG key used to check the packages")
+	setProxyRepoCmd.Flags().StringVar(&setProxyRepoFlags.username, "username", "", "User name for the proxy")
+	setProxyRepoCmd.Flags().BoolVar(&setProxyRepoFlags.passwordStdin, "password-stdin", false, "Read the password for the proxy from the standard input")
 
 	RootCmd.AddCommand(repoCmd)
 }
@@ -140,7 +277,7 @@ func runListRepos(cmd *cobra.Command, args []string) {
 		fail(err)
 	}
 
-	err = b.ListRepos()
+	err = b.ListRepos(os.Stdout, listReposFlags.json)
 	if err != nil {
 		fail(err)
 	}
@@ -170,3 +307,146 @@ func runSetURLRepo(cmd *cobra.Command, args []string) {
 	}
 	fmt.Printf("Set %s baseurl to %s.\n", args[0], args[1])
 }
+
+func runIncludesRepo(cmd *cobra.Command, args []string) {
+	b, err := builder.NewFromConfig(configFile)
+	if err != nil {
+		fail(err)
+	}
+
+	err = b.SetIncludesRepo(args[0], strings.Join(args[1:], " "))
+	if err != nil {
+		fail(err)
+	}
+	fmt.Printf("Included packages from repo %s:\n%s\n", args[0], strings.Join(args[1:], "\n"))
+}
+
+func runEnableRepo(cmd *cobra.Command, args []string) {
+	b, err := builder.NewFromConfig(configFile)
+	if err != nil {
+		fail(err)
+	}
+
+	err = b.SetRepoEnabled(args[0], true)
+	if err != nil {
+		fail(err)
+	}
+	fmt.Printf("Enabled %s repo.\n", args[0])
+}
+
+func runDisableRepo(cmd *cobra.Command, args []string) {
+	b, err := builder.NewFromConfig(configFile)
+	if err != nil {
+		fail(err)
+	}
+
+	err = b.SetRepoEnabled(args[0], false)
+	if err != nil {
+		fail(err)
+	}
+	fmt.Printf("Disabled %s repo.\n", args[0])
+}
+
+func runSetPriorityRepo(cmd *cobra.Command, args []string) {
+	priority, err := strconv.Atoi(args[1])
+	if err != nil {
+		failf("invalid priority %q", args[1])
+	}
+
+	b, err := builder.NewFromConfig(configFile)
+	if err != nil {
+		fail(err)
+	}
+
+	err = b.SetRepoPriority(args[0], priority)
+	if err != nil {
+		fail(err)
+	}
+	fmt.Printf("Set %s priority to %d.\n", args[0], priority)
+}
+
+func runSetGPGCheckRepo(cmd *cobra.Command, args []string) {
+	enabled, err := strconv.ParseBool(args[1])
+	if err != nil {
+		failf("invalid value %q, must be true or false", args[1])
+	}
+
+	b, err := builder.NewFromConfig(configFile)
+	if err != nil {
+		fail(err)
+	}
+
+	err = b.SetRepoGPGCheck(args[0], enabled, setGPGCheckRepoFlags.gpgKey)
+	if err != nil {
+		fail(err)
+	}
+	fmt.Printf("Set %s gpgcheck to %t.\n", args[0], enabled)
+}
+
+func runSetModuleHotfixesRepo(cmd *cobra.Command, args []string) {
+	enabled, err := strconv.ParseBool(args[1])
+	if err != nil {
+		failf("invalid value %q, must be true or false", args[1])
+	}
+
+	b, err := builder.NewFromConfig(configFile)
+	if err != nil {
+		fail(err)
+	}
+
+	err = b.SetRepoModuleHotfixes(args[0], enabled)
+	if err != nil {
+		fail(err)
+	}
+	fmt.Printf("Set %s module_hotfixes to %t.\n", args[0], enabled)
+}
+
+func runSetProxyRepo(cmd *cobra.Command, args []string) {
+	b, err := builder.NewFromConfig(configFile)
+	if err != nil {
+		fail(err)
+	}
+
+	password := os.Getenv(proxyPasswordEnv)
+	if setProxyRepoFlags.passwordStdin {
+		password, err = bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			fail(err)
+		}
+		password = strings.TrimRight(password, "\r\n")
+	}
+
+	err = b.SetRepoProxy(args[0], args[1], setProxyRepoFlags.username, password)
+	if err != nil {
+		fail(err)
+	}
+	if args[1] == "" {
+		fmt.Printf("Removed %s proxy.\n", args[0])
+	} else {
+		fmt.Printf("Set %s proxy to %s.\n", args[0], args[1])
+	}
+}
+
+func runListLocalRepo(cmd *cobra.Command, args []string) {
+	b, err := builder.NewFromConfig(configFile)
+	if err != nil {
+		fail(err)
+	}
+
+	err = b.ListLocalRPMs(os.Stdout)
+	if err != nil {
+		fail(err)
+	}
+}
+
+func runRemoveLocalRepo(cmd *cobra.Command, args []string) {
+	b, err := builder.NewFromConfig(configFile)
+	if err != nil {
+		fail(err)
+	}
+
+	err = b.RemoveLocalRPMs(args)
+	if err != nil {
+		fail(err)
+	}
+}
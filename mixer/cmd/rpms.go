@@ -36,10 +36,6 @@ func init() {
 	for _, cmd := range rpmCmds {
 		RootCmd.AddCommand(cmd)
 	}
-
-	externalDeps[addRPMCmd] = []string{
-		"createrepo_c",
-	}
 }
 
 func runAddRPM(cmd *cobra.Command, args []string) {
@@ -170,7 +170,7 @@ func runListRepos(cmd *cobra.Command, args []string) {
 		fail(err)
 	}
 
-	err = b.ListRepos()
+	err = b.ListRepos(os.Stdout, false)
 	if err != nil {
 		fail(err)
 	}
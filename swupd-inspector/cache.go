@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cacheLimitEnv can be used to set a default for --cache-limit, e.g. for all users in a shared
+// machine.
+const cacheLimitEnv = "SWUPD_INSPECTOR_CACHE_LIMIT"
+
+// defaultCacheDir returns the cache directory based on XDG_CACHE_HOME, or $HOME/.cache if the
+// variable is not set.
+func defaultCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "swupd-inspector"), nil
+}
+
+// defaultConfigDir returns the configuration directory based on XDG_CONFIG_HOME, or
+// $HOME/.config if the variable is not set.
+func defaultConfigDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "swupd-inspector"), nil
+}
+
+// listStateDirs returns the state directories in cacheDir, one for each content base that was
+// used. Other files are skipped.
+func listStateDirs(cacheDir string) ([]string, error) {
+	fis, err := ioutil.ReadDir(cacheDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var dirs []string
+	for _, fi := range fis {
+		if !fi.IsDir() {
+			log.Printf("Skipping unknown file %s/%s", cacheDir, fi.Name())
+			continue
+		}
+		stateDir := filepath.Join(cacheDir, fi.Name())
+		_, err = os.Stat(filepath.Join(stateDir, "content"))
+		if err != nil {
+			log.Printf("Skipping unrecognized directory %s", stateDir)
+			continue
+		}
+		dirs = append(dirs, stateDir)
+	}
+	return dirs, nil
+}
+
+type cachedFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// listCachedFiles returns all regular files in a state directory, except the ones used to
+// identify the state itself. The files are sorted from the least to the most recently used.
+func listCachedFiles(stateDir string) ([]*cachedFile, error) {
+	var files []*cachedFile
+	err := filepath.Walk(stateDir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() || path == filepath.Join(stateDir, "content") {
+			return nil
+		}
+		files = append(files, &cachedFile{path: path, size: fi.Size(), modTime: fi.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// The client State updates the modification time of the files when they are used from the
+	// cache, so they can be used for LRU.
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	return files, nil
+}
+
+// pruneStateDir removes the least recently used files from stateDir until its total size is at
+// most limit bytes.
+func pruneStateDir(stateDir string, limit int64) error {
+	files, err := listCachedFiles(stateDir)
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, f := range files {
+		total += f.size
+	}
+	for _, f := range files {
+		if total <= limit {
+			break
+		}
+		err = os.Remove(f.path)
+		if err != nil {
+			return fmt.Errorf("couldn't remove cached file: %s", err)
+		}
+		total -= f.size
+	}
+	return nil
+}
+
+// removeOlderThan removes the files in stateDir that were not used since cutoff.
+func removeOlderThan(stateDir string, cutoff time.Time) (count int, size int64, err error) {
+	files, err := listCachedFiles(stateDir)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, f := range files {
+		if !f.modTime.Before(cutoff) {
+			break
+		}
+		err = os.Remove(f.path)
+		if err != nil {
+			return count, size, fmt.Errorf("couldn't remove cached file: %s", err)
+		}
+		count++
+		size += f.size
+	}
+	return count, size, nil
+}
+
+// parseSize parses sizes like 500M or 10G, using powers of 1024. A number without suffix is in
+// bytes.
+func parseSize(orig string) (int64, error) {
+	s := strings.TrimSpace(strings.ToUpper(orig))
+	multiplier := int64(1)
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		case 'T':
+			multiplier = 1 << 40
+		}
+		if multiplier > 1 {
+			s = s[:n-1]
+		}
+	}
+	value, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", orig)
+	}
+	return value * multiplier, nil
+}
+
+// parseAge parses a duration like time.ParseDuration, but also accepts a number of days, like 30d.
+func parseAge(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.ParseUint(s[:len(s)-1], 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number of days %q", s)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
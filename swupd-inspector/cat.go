@@ -5,6 +5,7 @@ import (
 	"io"
 	"log"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 
@@ -12,9 +13,12 @@ import (
 	"github.com/clearlinux/mixer-tools/swupd"
 )
 
-// TODO: Support reading text files.
+type catFlags struct {
+	pager  bool
+	binary bool
+}
 
-func runCat(cacheDir, url, arg string) {
+func runCat(cacheDir string, flags *catFlags, url, arg string) {
 	base, version := parseURL(url)
 	stateDir := filepath.Join(cacheDir, convertContentBaseToDirname(base))
 	state, err := client.NewState(stateDir, base)
@@ -27,13 +31,10 @@ func runCat(cacheDir, url, arg string) {
 		log.Fatalf("ERROR: %s", err)
 	}
 
+	var path string
 	switch {
 	case arg == "Manifest.MoM", arg == "Manifest.full":
-		path, err := state.GetFile(version, arg)
-		if err != nil {
-			log.Fatalf("ERROR: %s", err)
-		}
-		err = copyFileToStdout(path)
+		path, err = state.GetFile(version, arg)
 		if err != nil {
 			log.Fatalf("ERROR: %s", err)
 		}
@@ -51,17 +52,50 @@ func runCat(cacheDir, url, arg string) {
 		if found == nil {
 			log.Fatalf("ERROR: Manifest.MoM for version %s doesn't have a bundle named %s", version, name)
 		}
-		path, err := state.GetFile(fmt.Sprint(found.Version), arg)
+		path, err = state.GetFile(fmt.Sprint(found.Version), arg)
 		if err != nil {
 			log.Fatalf("ERROR: %s", err)
 		}
-		err = copyFileToStdout(path)
+
+	case len(arg) > 0 && arg[0] == '/', isHashPrefix(arg):
+		var found *swupd.File
+		if arg[0] == '/' {
+			found, err = findFileByName(state, mom, arg)
+		} else {
+			found, err = findFileByHash(state, mom, arg)
+		}
+		if err != nil {
+			log.Fatalf("ERROR: %s", err)
+		}
+		if found == nil {
+			log.Fatalf("ERROR: %s not found in version %s", arg, version)
+		}
+		if found.Type != swupd.TypeFile {
+			log.Fatalf("ERROR: %s is not a regular file", found.Name)
+		}
+		path, err = getStagedFullfile(state, found)
+		if err != nil {
+			log.Fatalf("ERROR: %s", err)
+		}
+		text, err := isTextFile(path)
 		if err != nil {
 			log.Fatalf("ERROR: %s", err)
 		}
+		if !text && !flags.binary {
+			log.Fatalf("ERROR: %s is a binary file, use --binary to print it anyway or 'get' to download it", found.Name)
+		}
 
 	default:
-		log.Fatalf("Second argument to 'cat' must be a Manifest name")
+		log.Fatalf("Second argument to 'cat' must be a Manifest name, an absolute path or a hash")
+	}
+
+	if flags.pager && isTerminal(os.Stdout) {
+		err = runPager(path)
+	} else {
+		err = copyFileToStdout(path)
+	}
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
 	}
 }
 
@@ -76,3 +110,25 @@ func copyFileToStdout(src string) error {
 	_, err = io.Copy(os.Stdout, srcF)
 	return err
 }
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// runPager shows the file using the program in $PAGER, or less if the variable is not set.
+func runPager(path string) error {
+	pager := os.Getenv("PAGER")
+	if pager == "" {
+		pager = "less"
+	}
+	args := strings.Fields(pager)
+	cmd := exec.Command(args[0], append(args[1:], path)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
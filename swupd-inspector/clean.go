@@ -1,29 +1,57 @@
 package main
 
 import (
-	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"time"
 )
 
-func runClean(cacheDir string) {
-	fis, err := ioutil.ReadDir(cacheDir)
+type cleanFlags struct {
+	olderThan string
+	url       string
+}
+
+func runClean(cacheDir string, flags *cleanFlags) {
+	stateDirs, err := listStateDirs(cacheDir)
 	if err != nil {
 		log.Fatalf("ERROR: %s", err)
 	}
 
-	for _, fi := range fis {
-		if !fi.IsDir() {
-			log.Printf("Skipping unknown file %s/%s", cacheDir, fi.Name())
-			continue
+	if flags.url != "" {
+		base := parseBaseURL(flags.url)
+		stateDir := filepath.Join(cacheDir, convertContentBaseToDirname(base))
+		var found bool
+		for _, dir := range stateDirs {
+			if dir == stateDir {
+				found = true
+				break
+			}
+		}
+		if !found {
+			log.Printf("No cached content for %s", base)
+			return
 		}
-		stateDir := filepath.Join(cacheDir, fi.Name())
-		_, err = os.Stat(filepath.Join(stateDir, "content"))
+		stateDirs = []string{stateDir}
+	}
+
+	if flags.olderThan != "" {
+		age, err := parseAge(flags.olderThan)
 		if err != nil {
-			log.Printf("Skipping unrecognized directory %s", stateDir)
-			continue
+			log.Fatalf("ERROR: %s", err)
+		}
+		cutoff := time.Now().Add(-age)
+		for _, stateDir := range stateDirs {
+			count, size, err := removeOlderThan(stateDir, cutoff)
+			if err != nil {
+				log.Fatalf("ERROR: %s", err)
+			}
+			log.Printf("Deleted %d files (%d bytes) from %s", count, size, stateDir)
 		}
+		return
+	}
+
+	for _, stateDir := range stateDirs {
 		log.Printf("Deleting directory %s", stateDir)
 		err = os.RemoveAll(stateDir)
 		if err != nil {
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+
+	"github.com/clearlinux/mixer-tools/internal/client"
+	"github.com/clearlinux/mixer-tools/swupd"
+)
+
+// textSniffLen is how much of a file is inspected when deciding if it is text.
+const textSniffLen = 8000
+
+// isTextFile reports whether the regular file at path looks like text. Like
+// other tools, a file is considered binary if it has a NUL byte near its start.
+func isTextFile(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	buf := make([]byte, textSniffLen)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+	return bytes.IndexByte(buf[:n], 0) == -1, nil
+}
+
+// getStagedFullfile makes sure the fullfile for file is extracted in the state and
+// returns the path to it.
+func getStagedFullfile(state *client.State, file *swupd.File) (string, error) {
+	err := state.GetFullfile(fmt.Sprint(file.Version), file.Hash.String())
+	if err != nil {
+		return "", err
+	}
+	return state.Path("staged", file.Hash.String()), nil
+}
+
+// printContentDiff writes to w a unified diff between the contents of files a and b. Only
+// regular text files are compared, for the other cases a short note is written.
+func printContentDiff(w io.Writer, stateA *client.State, a *swupd.File, stateB *client.State, b *swupd.File) error {
+	if a.Hash == b.Hash {
+		return nil
+	}
+	if a.Type != swupd.TypeFile || b.Type != swupd.TypeFile {
+		_, err := fmt.Fprintf(w, "Files %s (%s) and %s (%s) differ\n", a.Name, a.Type, b.Name, b.Type)
+		return err
+	}
+
+	pathA, err := getStagedFullfile(stateA, a)
+	if err != nil {
+		return err
+	}
+	pathB, err := getStagedFullfile(stateB, b)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range []string{pathA, pathB} {
+		text, terr := isTextFile(p)
+		if terr != nil {
+			return terr
+		}
+		if !text {
+			_, err = fmt.Fprintf(w, "Binary files %s and %s differ\n", a.Name, b.Name)
+			return err
+		}
+	}
+
+	labelA := fmt.Sprintf("a%s (%d)", a.Name, a.Version)
+	labelB := fmt.Sprintf("b%s (%d)", b.Name, b.Version)
+	cmd := exec.Command("diff", "-u", "--label", labelA, "--label", labelB, pathA, pathB)
+	cmd.Stdout = w
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	// Exit status 1 from diff just means the files are different.
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't compare %s and %s: %s", pathA, pathB, err)
+	}
+	return nil
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"log"
+	"os"
 	"path/filepath"
 	"sort"
+	"text/tabwriter"
 
 	"github.com/clearlinux/mixer-tools/internal/client"
 	"github.com/clearlinux/mixer-tools/swupd"
@@ -22,14 +26,81 @@ var (
 type diffFlags struct {
 	noColor bool
 	strict  bool
+	summary bool
+	json    bool
+	content bool
 }
 
+// diffResult is the outcome of comparing two versions of swupd content. It is
+// also the format of the --json output.
+type diffResult struct {
+	BaseA    string        `json:"base_a"`
+	VersionA string        `json:"version_a"`
+	BaseB    string        `json:"base_b"`
+	VersionB string        `json:"version_b"`
+	Bundles  []*bundleDiff `json:"bundles"`
+	Total    diffSummary   `json:"total"`
+}
+
+type diffSummary struct {
+	Added            int   `json:"added"`
+	Removed          int   `json:"removed"`
+	Modified         int   `json:"modified"`
+	Renamed          int   `json:"renamed"`
+	ContentSizeDelta int64 `json:"content_size_delta"`
+}
+
+// bundleDiff contains the differences for a single bundle. Bundles that only
+// exist in one of the versions have all their files added or removed.
+type bundleDiff struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+
+	VersionA     uint32 `json:"version_a,omitempty"`
+	VersionB     uint32 `json:"version_b,omitempty"`
+	FileCountA   uint32 `json:"file_count_a"`
+	FileCountB   uint32 `json:"file_count_b"`
+	ContentSizeA uint64 `json:"content_size_a"`
+	ContentSizeB uint64 `json:"content_size_b"`
+
+	AddedIncludes   []string `json:"added_includes,omitempty"`
+	RemovedIncludes []string `json:"removed_includes,omitempty"`
+
+	diffSummary
+	Files []*fileDiff `json:"files,omitempty"`
+}
+
+type fileDiff struct {
+	Name        string `json:"name"`
+	OldName     string `json:"old_name,omitempty"`
+	Change      string `json:"change"`
+	FlagsA      string `json:"flags_a,omitempty"`
+	FlagsB      string `json:"flags_b,omitempty"`
+	HashA       string `json:"hash_a,omitempty"`
+	HashB       string `json:"hash_b,omitempty"`
+	VersionA    uint32 `json:"version_a,omitempty"`
+	VersionB    uint32 `json:"version_b,omitempty"`
+	ContentDiff string `json:"content_diff,omitempty"`
+
+	a, b *swupd.File
+}
+
+// Values for the Status of bundleDiff and Change of fileDiff.
+const (
+	changeAdded    = "added"
+	changeRemoved  = "removed"
+	changeModified = "modified"
+	changeRenamed  = "renamed"
+)
+
 func runDiff(cacheDir string, flags *diffFlags, urlA, urlB string) {
-	if flags.noColor {
+	if flags.noColor || flags.json {
 		RED = ""
 		GREEN = ""
 		RESET = ""
 	}
+	// Only the JSON and summary outputs skip the per-file listing.
+	verbose := !flags.json && !flags.summary
 
 	baseA, versionA := parseURL(urlA)
 	baseB, versionB := parseURL(urlB)
@@ -70,7 +141,8 @@ func runDiff(cacheDir string, flags *diffFlags, urlA, urlB string) {
 		log.Fatalf("ERROR: %s", err)
 	}
 
-	fmt.Printf(`=== Differences from A to B
+	if !flags.json {
+		fmt.Printf(`=== Differences from A to B
 
   A Base:            %s
   A Version:         %s
@@ -81,11 +153,18 @@ func runDiff(cacheDir string, flags *diffFlags, urlA, urlB string) {
   B State directory: %s
 
 `, baseA, versionA, stateDirA, baseB, versionB, stateDirB)
+	}
 
-	sortFiles(momA)
-	sortFiles(momB)
+	result := &diffResult{
+		BaseA:    baseA,
+		VersionA: versionA,
+		BaseB:    baseB,
+		VersionB: versionB,
+	}
 
-	fmt.Println("=== Manifest.MoM")
+	if verbose {
+		fmt.Println("=== Manifest.MoM")
+	}
 
 	type bundlePair struct {
 		Name string
@@ -95,155 +174,233 @@ func runDiff(cacheDir string, flags *diffFlags, urlA, urlB string) {
 
 	// TODO: Check all them are manifests...
 
-	walkFiles(momA.Files, momB.Files, func(a, b *swupd.File) {
-		switch {
-		case a == nil:
-			fmt.Printf("%s+%s%s %s%s\n", GREEN, b.Type, b.Status, b.Name, RESET)
-		case b == nil:
-			fmt.Printf("%s-%s%s %s%s\n", RED, a.Type, a.Status, a.Name, RESET)
-		default:
-			if a.Type != b.Type || a.Status != b.Status {
+	momDiff := swupd.DiffManifests(momA, momB, swupd.DiffOptions{Strict: flags.strict})
+	for _, b := range momDiff.Added {
+		bundles = append(bundles, &bundlePair{Name: b.Name, B: b})
+	}
+	for _, a := range momDiff.Removed {
+		bundles = append(bundles, &bundlePair{Name: a.Name, A: a})
+	}
+	for _, c := range momDiff.Modified {
+		bundles = append(bundles, &bundlePair{Name: c.New.Name, A: c.Old, B: c.New})
+	}
+	sort.Slice(bundles, func(i, j int) bool {
+		return bundles[i].Name < bundles[j].Name
+	})
+
+	if verbose {
+		for _, pair := range bundles {
+			a, b := pair.A, pair.B
+			switch {
+			case a == nil:
+				fmt.Printf("%s+%s%s %s%s\n", GREEN, b.Type, b.Status, b.Name, RESET)
+			case b == nil:
 				fmt.Printf("%s-%s%s %s%s\n", RED, a.Type, a.Status, a.Name, RESET)
-				fmt.Printf("%s+%s%s %s%s\n", RED, a.Type, a.Status, a.Name, RESET)
-			} else {
+			case a.Type != b.Type || a.Status != b.Status:
+				fmt.Printf("%s-%s%s %s%s\n", RED, a.Type, a.Status, a.Name, RESET)
+				fmt.Printf("%s+%s%s %s%s\n", GREEN, b.Type, b.Status, b.Name, RESET)
+			default:
 				fmt.Printf(" %s%s %s", a.Type, a.Status, a.Name)
 				if flags.strict && a.Version != b.Version {
 					fmt.Printf(" (VERSION: %s-%d%s / %s+%d%s)", RED, a.Version, RESET, GREEN, b.Version, RESET)
 				}
 				if a.Hash != b.Hash {
-					pair := &bundlePair{
-						Name: a.Name,
-						A:    a,
-						B:    b,
-					}
-					bundles = append(bundles, pair)
 					fmt.Printf(" (HASH: %s-%s%s / %s+%s%s)", RED, a.Hash.String()[:7], RESET, GREEN, b.Hash.String()[:7], RESET)
 				}
 				fmt.Println()
 			}
 		}
-	})
-	fmt.Println()
-
-	flagString := func(f *swupd.File) string {
-		result, err := f.GetFlagString()
-		if err != nil {
-			result = "...."
-		}
-		return result[:3]
+		fmt.Println()
 	}
 
 	for _, pair := range bundles {
-		mA, err := stateA.GetBundleManifest(fmt.Sprint(pair.A.Version), pair.Name, "")
-		if err != nil {
-			log.Fatalf("ERROR: %s", err)
-		}
-
-		mB, err := stateB.GetBundleManifest(fmt.Sprint(pair.B.Version), pair.Name, "")
-		if err != nil {
-			log.Fatalf("ERROR: %s", err)
+		var mA, mB *swupd.Manifest
+		// Only the hash of the bundle manifests matter here, changes
+		// in the version are already reported in the MoM.
+		if pair.A != nil && pair.B != nil && pair.A.Hash == pair.B.Hash {
+			continue
 		}
-
-		fmt.Printf("=== Manifest.%s A=%d B=%d\n", pair.Name, mA.Header.Version, mB.Header.Version)
-
-		var extraIncludesA, extraIncludesB []string
-		{
-			includesA := map[string]bool{}
-			for _, inc := range mA.Header.Includes {
-				includesA[inc.Name] = true
+		if pair.A != nil {
+			mA, err = stateA.GetBundleManifest(fmt.Sprint(pair.A.Version), pair.Name, "")
+			if err != nil {
+				log.Fatalf("ERROR: %s", err)
 			}
-			includesB := map[string]bool{}
-			for _, inc := range mB.Header.Includes {
-				includesB[inc.Name] = true
+		}
+		if pair.B != nil {
+			mB, err = stateB.GetBundleManifest(fmt.Sprint(pair.B.Version), pair.Name, "")
+			if err != nil {
+				log.Fatalf("ERROR: %s", err)
 			}
-			for name := range includesA {
-				if !includesB[name] {
-					extraIncludesA = append(extraIncludesA, name)
+		}
+
+		bd := diffBundle(flags, pair.Name, mA, mB)
+		if flags.content {
+			for _, fd := range bd.Files {
+				if fd.Change != changeModified || fd.HashA == fd.HashB {
+					continue
 				}
-			}
-			for name := range includesB {
-				if !includesA[name] {
-					extraIncludesB = append(extraIncludesB, name)
+				var buf bytes.Buffer
+				err = printContentDiff(&buf, stateA, fd.a, stateB, fd.b)
+				if err != nil {
+					log.Fatalf("ERROR: %s", err)
 				}
+				fd.ContentDiff = buf.String()
 			}
 		}
+		result.Bundles = append(result.Bundles, bd)
 
-		if mA.Header.FileCount != mB.Header.FileCount {
-			fmt.Printf("%s-filecount: %d%s\n", RED, mA.Header.FileCount, RESET)
-			fmt.Printf("%s+filecount: %d%s\n", GREEN, mB.Header.FileCount, RESET)
-		}
-		// TODO: Compare ContentSize?
+		result.Total.Added += bd.Added
+		result.Total.Removed += bd.Removed
+		result.Total.Modified += bd.Modified
+		result.Total.Renamed += bd.Renamed
+		result.Total.ContentSizeDelta += bd.ContentSizeDelta
 
-		for _, name := range extraIncludesA {
-			fmt.Printf("%s-includes: %s%s\n", RED, name, RESET)
+		// Bundles added or removed are only part of the summary, listing
+		// all their files would not be useful.
+		if verbose && bd.Status == changeModified {
+			printBundleDiff(flags, bd)
 		}
-		for _, name := range extraIncludesB {
-			fmt.Printf("%s+includes: %s%s\n", GREEN, name, RESET)
+	}
+
+	if flags.json {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(result)
+		if err != nil {
+			log.Fatalf("ERROR: %s", err)
 		}
+		return
+	}
 
-		// TODO: Move sort files to walker?
-		sortFiles(mA)
-		sortFiles(mB)
-		walkFiles(mA.Files, mB.Files, func(a, b *swupd.File) {
-			switch {
-			case a == nil:
-				fmt.Printf("%s+%s %s%s\n", GREEN, flagString(b), b.Name, RESET)
-			case b == nil:
-				fmt.Printf("%s-%s %s%s\n", RED, flagString(a), a.Name, RESET)
-			default:
-				if flagString(a) != flagString(b) {
-					fmt.Printf("%s-%s %s%s\n", RED, flagString(a), a.Name, RESET)
-					fmt.Printf("%s+%s %s%s\n", GREEN, flagString(b), b.Name, RESET)
-				} else if a.Rename != b.Rename || a.Hash != b.Hash || (flags.strict && a.Version != b.Version) {
-					fmt.Printf(" %s %s", flagString(a), a.Name)
-					if flags.strict && a.Version != b.Version {
-						fmt.Printf(" (VERSION: %s-%d%s / %s+%d%s)", RED, a.Version, RESET, GREEN, b.Version, RESET)
-					}
-					if a.Rename != b.Rename {
-						fmt.Printf(" (RENAME: %s-%d%s / %s+%d%s)", RED, a.Rename, RESET, GREEN, b.Rename, RESET)
-					}
-					if a.Hash != b.Hash {
-						fmt.Printf(" (HASH: %s-%s%s / %s+%s%s)", RED, a.Hash.String()[:7], RESET, GREEN, b.Hash.String()[:7], RESET)
-					}
-					fmt.Println()
-				}
-			}
-		})
-		fmt.Println()
+	printDiffSummary(result)
+}
+
+// diffBundle compares two versions of a bundle manifest, either of which might be nil if the
+// bundle doesn't exist in that version.
+func diffBundle(flags *diffFlags, name string, mA, mB *swupd.Manifest) *bundleDiff {
+	bd := &bundleDiff{Name: name}
+	switch {
+	case mA == nil:
+		bd.Status = changeAdded
+	case mB == nil:
+		bd.Status = changeRemoved
+	default:
+		bd.Status = changeModified
+	}
+	if mA != nil {
+		bd.VersionA = mA.Header.Version
+		bd.FileCountA = mA.Header.FileCount
+		bd.ContentSizeA = mA.Header.ContentSize
+	}
+	if mB != nil {
+		bd.VersionB = mB.Header.Version
+		bd.FileCountB = mB.Header.FileCount
+		bd.ContentSizeB = mB.Header.ContentSize
+	}
+	bd.ContentSizeDelta = int64(bd.ContentSizeB) - int64(bd.ContentSizeA)
+
+	d := swupd.DiffManifests(mA, mB, swupd.DiffOptions{Strict: flags.strict, DetectRenames: true})
+	bd.AddedIncludes = d.AddedIncludes
+	bd.RemovedIncludes = d.RemovedIncludes
+	bd.Added = len(d.Added)
+	bd.Removed = len(d.Removed)
+	bd.Modified = len(d.Modified)
+	bd.Renamed = len(d.Renamed)
+
+	for _, f := range d.Added {
+		bd.Files = append(bd.Files, newFileDiff(changeAdded, nil, f))
+	}
+	for _, f := range d.Removed {
+		bd.Files = append(bd.Files, newFileDiff(changeRemoved, f, nil))
+	}
+	for _, c := range d.Modified {
+		bd.Files = append(bd.Files, newFileDiff(changeModified, c.Old, c.New))
 	}
+	for _, r := range d.Renamed {
+		bd.Files = append(bd.Files, newFileDiff(changeRenamed, r.Old, r.New))
+	}
+	sort.Slice(bd.Files, func(i, j int) bool {
+		return bd.Files[i].Name < bd.Files[j].Name
+	})
+	return bd
+}
 
-	// TODO: Print number of mismatches, new files in B and missing files in A.
+func newFileDiff(change string, a, b *swupd.File) *fileDiff {
+	fd := &fileDiff{Change: change, a: a, b: b}
+	if a != nil {
+		fd.Name = a.Name
+		fd.FlagsA = fileFlags(a)
+		fd.HashA = a.Hash.String()
+		fd.VersionA = a.Version
+	}
+	if b != nil {
+		if a != nil && a.Name != b.Name {
+			fd.OldName = a.Name
+		}
+		fd.Name = b.Name
+		fd.FlagsB = fileFlags(b)
+		fd.HashB = b.Hash.String()
+		fd.VersionB = b.Version
+	}
+	return fd
 }
 
-func walkFiles(filesA, filesB []*swupd.File, fn func(a, b *swupd.File)) {
-	indexA := 0
-	indexB := 0
-	for indexA < len(filesA) && indexB < len(filesB) {
-		fileA := filesA[indexA]
-		fileB := filesB[indexB]
+func printBundleDiff(flags *diffFlags, bd *bundleDiff) {
+	fmt.Printf("=== Manifest.%s A=%d B=%d\n", bd.Name, bd.VersionA, bd.VersionB)
+
+	if bd.FileCountA != bd.FileCountB {
+		fmt.Printf("%s-filecount: %d%s\n", RED, bd.FileCountA, RESET)
+		fmt.Printf("%s+filecount: %d%s\n", GREEN, bd.FileCountB, RESET)
+	}
+	if bd.ContentSizeA != bd.ContentSizeB {
+		fmt.Printf("%s-contentsize: %d%s\n", RED, bd.ContentSizeA, RESET)
+		fmt.Printf("%s+contentsize: %d%s\n", GREEN, bd.ContentSizeB, RESET)
+	}
+	for _, name := range bd.RemovedIncludes {
+		fmt.Printf("%s-includes: %s%s\n", RED, name, RESET)
+	}
+	for _, name := range bd.AddedIncludes {
+		fmt.Printf("%s+includes: %s%s\n", GREEN, name, RESET)
+	}
+
+	for _, fd := range bd.Files {
 		switch {
-		case fileA.Name < fileB.Name:
-			indexA++
-			fn(fileA, nil)
-		case fileA.Name > fileB.Name:
-			indexB++
-			fn(nil, fileB)
-		case fileA.Name == fileB.Name:
-			indexA++
-			indexB++
-			fn(fileA, fileB)
+		case fd.Change == changeAdded:
+			fmt.Printf("%s+%s %s%s\n", GREEN, fd.FlagsB, fd.Name, RESET)
+		case fd.Change == changeRemoved:
+			fmt.Printf("%s-%s %s%s\n", RED, fd.FlagsA, fd.Name, RESET)
+		case fd.Change == changeRenamed:
+			fmt.Printf(" %s %s%s%s => %s%s%s\n", fd.FlagsB, RED, fd.OldName, RESET, GREEN, fd.Name, RESET)
+		case fd.FlagsA != fd.FlagsB:
+			fmt.Printf("%s-%s %s%s\n", RED, fd.FlagsA, fd.Name, RESET)
+			fmt.Printf("%s+%s %s%s\n", GREEN, fd.FlagsB, fd.Name, RESET)
+		default:
+			fmt.Printf(" %s %s", fd.FlagsA, fd.Name)
+			if flags.strict && fd.VersionA != fd.VersionB {
+				fmt.Printf(" (VERSION: %s-%d%s / %s+%d%s)", RED, fd.VersionA, RESET, GREEN, fd.VersionB, RESET)
+			}
+			if fd.HashA != fd.HashB {
+				fmt.Printf(" (HASH: %s-%s%s / %s+%s%s)", RED, fd.HashA[:7], RESET, GREEN, fd.HashB[:7], RESET)
+			}
+			fmt.Println()
+		}
+		if fd.ContentDiff != "" {
+			fmt.Print(fd.ContentDiff)
 		}
 	}
-	for indexA < len(filesA) {
-		fileA := filesA[indexA]
-		indexA++
-		fn(fileA, nil)
-	}
-	for indexB < len(filesB) {
-		fileB := filesB[indexB]
-		indexB++
-		fn(nil, fileB)
+	fmt.Println()
+}
+
+func printDiffSummary(result *diffResult) {
+	fmt.Println("=== Summary")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "BUNDLE\tSTATUS\tADDED\tREMOVED\tMODIFIED\tRENAMED\tCONTENT SIZE\tDELTA\t")
+	for _, bd := range result.Bundles {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t%+d\t\n", bd.Name, bd.Status, bd.Added, bd.Removed, bd.Modified, bd.Renamed, bd.ContentSizeB, bd.ContentSizeDelta)
 	}
+	t := result.Total
+	fmt.Fprintf(w, "%s\t\t%d\t%d\t%d\t%d\t\t%+d\t\n", "total", t.Added, t.Removed, t.Modified, t.Renamed, t.ContentSizeDelta)
+	_ = w.Flush()
 }
 
 func sortFiles(m *swupd.Manifest) {
@@ -60,14 +60,7 @@ func runGet(cacheDir, url, arg string) {
 		}
 
 	case len(arg) > 0 && arg[0] == '/':
-		var found *swupd.File
-		err := visitAllFiles(state, mom, func(bundle, file *swupd.File) bool {
-			if file.Name == arg {
-				found = file
-				return true
-			}
-			return false
-		})
+		found, err := findFileByName(state, mom, arg)
 		if err != nil {
 			log.Fatalf("ERROR: %s", err)
 		}
@@ -80,16 +73,8 @@ func runGet(cacheDir, url, arg string) {
 			log.Fatalf("ERROR: %s", err)
 		}
 
-	case len(arg) == len(swupd.AllZeroHash):
-		// TODO: Support shortened hashes.
-		var found *swupd.File
-		err := visitAllFiles(state, mom, func(bundle, file *swupd.File) bool {
-			if file.Hash.String() == arg {
-				found = file
-				return true
-			}
-			return false
-		})
+	case isHashPrefix(arg):
+		found, err := findFileByHash(state, mom, arg)
 		if err != nil {
 			log.Fatalf("ERROR: %s", err)
 		}
@@ -126,6 +111,62 @@ func visitAllFiles(state *client.State, mom *swupd.Manifest, visitFunc func(bund
 	return nil
 }
 
+// minHashPrefixLen is the minimum length of a shortened hash accepted as argument.
+const minHashPrefixLen = 6
+
+// isHashPrefix tells whether s can be a full or shortened file hash.
+func isHashPrefix(s string) bool {
+	if len(s) < minHashPrefixLen || len(s) > len(swupd.AllZeroHash) {
+		return false
+	}
+	for _, r := range s {
+		if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// findFileByName returns the first present entry for the file name in the bundles of mom, or nil
+// if it is not found.
+func findFileByName(state *client.State, mom *swupd.Manifest, name string) (*swupd.File, error) {
+	var found *swupd.File
+	err := visitAllFiles(state, mom, func(bundle, file *swupd.File) bool {
+		if file.Name == name && file.Present() {
+			found = file
+			return true
+		}
+		return false
+	})
+	return found, err
+}
+
+// findFileByHash returns an entry for the file with the given hash, which can be shortened as
+// long as it is not ambiguous, or nil if it is not found.
+func findFileByHash(state *client.State, mom *swupd.Manifest, prefix string) (*swupd.File, error) {
+	var found *swupd.File
+	var ambiguous bool
+	err := visitAllFiles(state, mom, func(bundle, file *swupd.File) bool {
+		if !file.Present() || !strings.HasPrefix(file.Hash.String(), prefix) {
+			return false
+		}
+		if found != nil && found.Hash != file.Hash {
+			ambiguous = true
+			return true
+		}
+		found = file
+		// A full hash can't be ambiguous, so stop at the first match.
+		return len(prefix) == len(swupd.AllZeroHash)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if ambiguous {
+		return nil, fmt.Errorf("hash %s is ambiguous, use more characters", prefix)
+	}
+	return found, nil
+}
+
 func downloadFullfile(state *client.State, file *swupd.File) error {
 	fullfile := file.Hash.String() + ".tar"
 	f, err := state.GetFile(fmt.Sprint(file.Version), "files", fullfile)
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/clearlinux/mixer-tools/internal/client"
+	"github.com/clearlinux/mixer-tools/swupd"
+)
+
+func writeTestManifest(t *testing.T, path string, version uint32, entries ...string) {
+	t.Helper()
+	content := fmt.Sprintf("MANIFEST\t30\nversion:\t%d\nprevious:\t0\nfilecount:\t%d\ntimestamp:\t1500000000\ncontentsize:\t0\n\n%s\n",
+		version, len(entries), strings.Join(entries, "\n"))
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindFileByHash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "swupd-inspector-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	hash := func(prefix string) string { return prefix + strings.Repeat("0", 64-len(prefix)) }
+	content := filepath.Join(dir, "content")
+	if err = os.MkdirAll(filepath.Join(content, "10"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestManifest(t, filepath.Join(content, "10", "Manifest.os-core"), 10,
+		"F...\t"+hash("abcdef1")+"\t10\t/a",
+		"F...\t"+hash("123456a")+"\t10\t/b",
+		"F...\t"+hash("123456b")+"\t10\t/c",
+		"F...\t"+hash("dddddd")+"\t10\t/d",
+		".d..\t"+hash("eeeeee")+"\t10\t/deleted",
+	)
+	// The same file in another bundle is not ambiguous.
+	writeTestManifest(t, filepath.Join(content, "10", "Manifest.editors"), 10,
+		"F...\t"+hash("dddddd")+"\t10\t/d",
+	)
+	mom := &swupd.Manifest{Files: []*swupd.File{
+		{Name: "os-core", Version: 10},
+		{Name: "editors", Version: 10},
+	}}
+	state, err := client.NewState(filepath.Join(dir, "state"), content)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		arg    string
+		name   string
+		errMsg string
+	}{
+		{arg: "abcdef", name: "/a"},
+		{arg: hash("abcdef1"), name: "/a"},
+		{arg: "123456a", name: "/b"},
+		{arg: "123456", errMsg: "ambiguous"},
+		{arg: "dddddd", name: "/d"},
+		{arg: "abcde", errMsg: "not a hash"},
+		{arg: "abcdeg", errMsg: "not a hash"},
+		{arg: hash("abcdef1") + "0", errMsg: "not a hash"},
+		{arg: "ffffff"},
+		{arg: "eeeeee"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.arg, func(t *testing.T) {
+			var found *swupd.File
+			var err error
+			if isHashPrefix(tc.arg) {
+				found, err = findFileByHash(state, mom, tc.arg)
+			} else {
+				err = fmt.Errorf("not a hash")
+			}
+			if tc.errMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.errMsg) {
+					t.Fatalf("got error %v, want %q", err, tc.errMsg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case tc.name == "" && found != nil:
+				t.Errorf("unexpected file %s", found.Name)
+			case tc.name != "" && (found == nil || found.Name != tc.name):
+				t.Errorf("got file %v, want %s", found, tc.name)
+			}
+		})
+	}
+}
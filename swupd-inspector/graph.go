@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/clearlinux/mixer-tools/internal/client"
+)
+
+type graphFlags struct {
+	format    string
+	highlight string
+}
+
+type bundleGraph struct {
+	Nodes []*bundleNode `json:"nodes"`
+	Edges []*bundleEdge `json:"edges"`
+}
+
+type bundleNode struct {
+	Name        string `json:"name"`
+	Version     uint32 `json:"version"`
+	FileCount   uint32 `json:"file_count"`
+	ContentSize uint64 `json:"content_size"`
+	Highlighted bool   `json:"highlighted,omitempty"`
+}
+
+type bundleEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Type string `json:"type"`
+}
+
+// Values for the Type of bundleEdge, matching the manifest header fields.
+const (
+	edgeIncludes = "includes"
+	edgeAlsoAdd  = "also-add"
+)
+
+func runGraph(cacheDir string, flags *graphFlags, url string) {
+	if flags.format != "dot" && flags.format != "json" {
+		log.Fatalf("ERROR: invalid format %q, must be dot or json", flags.format)
+	}
+
+	base, version := parseURL(url)
+	stateDir := filepath.Join(cacheDir, convertContentBaseToDirname(base))
+	state, err := client.NewState(stateDir, base)
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+
+	mom, err := state.GetMoM(version)
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+	sortFiles(mom)
+
+	g := &bundleGraph{}
+	nodes := make(map[string]*bundleNode, len(mom.Files))
+	for _, bundleF := range mom.Files {
+		m, err := state.GetBundleManifest(fmt.Sprint(bundleF.Version), bundleF.Name, "")
+		if err != nil {
+			log.Fatalf("ERROR: %s", err)
+		}
+		node := &bundleNode{
+			Name:        bundleF.Name,
+			Version:     bundleF.Version,
+			FileCount:   m.Header.FileCount,
+			ContentSize: m.Header.ContentSize,
+		}
+		g.Nodes = append(g.Nodes, node)
+		nodes[node.Name] = node
+		for _, inc := range m.Header.Includes {
+			g.Edges = append(g.Edges, &bundleEdge{From: node.Name, To: inc.Name, Type: edgeIncludes})
+		}
+		for _, opt := range m.Header.Optional {
+			g.Edges = append(g.Edges, &bundleEdge{From: node.Name, To: opt.Name, Type: edgeAlsoAdd})
+		}
+	}
+
+	if flags.highlight != "" {
+		if nodes[flags.highlight] == nil {
+			log.Fatalf("ERROR: Manifest.MoM for version %s doesn't have a bundle named %s", version, flags.highlight)
+		}
+		for name := range includesClosure(g, flags.highlight) {
+			if node := nodes[name]; node != nil {
+				node.Highlighted = true
+			}
+		}
+	}
+
+	if flags.format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(g)
+	} else {
+		err = writeGraphDot(os.Stdout, g, flags.highlight)
+	}
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+}
+
+// includesClosure returns the bundle name and all the bundles it includes, directly or not.
+func includesClosure(g *bundleGraph, name string) map[string]bool {
+	closure := map[string]bool{name: true}
+	queue := []string{name}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, e := range g.Edges {
+			if e.From == current && e.Type == edgeIncludes && !closure[e.To] {
+				closure[e.To] = true
+				queue = append(queue, e.To)
+			}
+		}
+	}
+	return closure
+}
+
+func writeGraphDot(w io.Writer, g *bundleGraph, highlight string) error {
+	var err error
+	printf := func(format string, a ...interface{}) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, a...)
+		}
+	}
+
+	printf("digraph bundles {\n")
+	printf("\tnode [shape=box];\n")
+	for _, n := range g.Nodes {
+		attrs := ""
+		switch {
+		case n.Name == highlight:
+			attrs = ", style=filled, fillcolor=gold"
+		case n.Highlighted:
+			attrs = ", style=filled, fillcolor=lightblue"
+		}
+		printf("\t%q [label=\"%s\\n%d files, %d bytes\"%s];\n", n.Name, n.Name, n.FileCount, n.ContentSize, attrs)
+	}
+	for _, e := range g.Edges {
+		if e.Type == edgeAlsoAdd {
+			printf("\t%q -> %q [style=dashed, label=%q];\n", e.From, e.To, e.Type)
+		} else {
+			printf("\t%q -> %q;\n", e.From, e.To)
+		}
+	}
+	printf("}\n")
+	return err
+}
@@ -3,15 +3,20 @@ package main
 import (
 	"fmt"
 	"log"
+	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/clearlinux/mixer-tools/internal/client"
 	"github.com/clearlinux/mixer-tools/swupd"
 )
 
-// TODO: Consider a --unique flag that would not print consecutive versions that have the same hash.
+type logFlags struct {
+	unique bool
+	diff   bool
+}
 
-func runLog(cacheDir, url, filename string) {
+func runLog(cacheDir string, flags *logFlags, url, arg string) {
 	base, version := parseURL(url)
 	stateDir := filepath.Join(cacheDir, convertContentBaseToDirname(base))
 	state, err := client.NewState(stateDir, base)
@@ -19,12 +24,20 @@ func runLog(cacheDir, url, filename string) {
 		log.Fatalf("ERROR: %s", err)
 	}
 
-	if filename == "" || filename[0] != '/' {
-		// TODO: Support Manifest.* files too, but showing the diff of the files?
-		log.Fatalf("Second argument to 'log' must be an absolute path")
+	switch {
+	case arg == "Manifest.MoM", arg == "Manifest.full":
+		log.Fatalf("ERROR: 'log' doesn't support %s, only bundle manifests", arg)
+	case strings.HasPrefix(arg, "Manifest."):
+		runManifestLog(state, flags, base, version, arg[9:])
+	case len(arg) > 0 && arg[0] == '/':
+		runFileLog(state, flags, base, version, arg)
+	default:
+		log.Fatalf("Second argument to 'log' must be an absolute path or a bundle Manifest name")
 	}
+}
 
-	var lastVersion uint32
+func runFileLog(state *client.State, flags *logFlags, base, version, filename string) {
+	var last *swupd.File
 	lastBundle := ""
 
 	for version != "0" {
@@ -73,9 +86,16 @@ func runLog(cacheDir, url, filename string) {
 		if found == nil {
 			break
 		}
-		if lastVersion != found.Version {
+		if last == nil || (last.Version != found.Version && (!flags.unique || last.Hash != found.Hash)) {
+			// The diff of the previously printed version is shown after it, like "git log -p".
+			if last != nil && flags.diff {
+				err = printContentDiff(os.Stdout, state, found, state, last)
+				if err != nil {
+					log.Fatalf("ERROR: %s", err)
+				}
+			}
 			fmt.Printf("%s/%d/files/%s.tar\n", base, found.Version, found.Hash)
-			lastVersion = found.Version
+			last = found
 		}
 
 		// Look at the immediate previous OS version, unless we already know the file is
@@ -90,7 +110,101 @@ func runLog(cacheDir, url, filename string) {
 	if lastBundle == "" {
 		log.Fatalf("ERROR: file %s not found in version %s", filename, version)
 	}
+}
 
+func runManifestLog(state *client.State, flags *logFlags, base, version, name string) {
+	var history []*swupd.Manifest
+
+	for version != "0" {
+		mom, err := state.GetMoM(version)
+		if err != nil {
+			log.Fatalf("ERROR: %s", err)
+		}
+
+		var bundleF *swupd.File
+		for _, f := range mom.Files {
+			if f.Name == name {
+				bundleF = f
+				break
+			}
+		}
+		if bundleF == nil {
+			break
+		}
+
+		if len(history) == 0 || history[len(history)-1].Header.Version != bundleF.Version {
+			m, err := state.GetBundleManifest(fmt.Sprint(bundleF.Version), name, "")
+			if err != nil {
+				log.Fatalf("ERROR: %s", err)
+			}
+			history = append(history, m)
+		}
+
+		// Same as in the file log, skip directly to the version of the manifest when possible.
+		v := mom.Header.Previous
+		if v > bundleF.Version {
+			v = bundleF.Version
+		}
+		version = fmt.Sprint(v)
+	}
+
+	if len(history) == 0 {
+		log.Fatalf("ERROR: bundle %s not found in version %s", name, version)
+	}
+
+	// Each manifest is compared with the next older one in the history. Versions without any
+	// change are only skipped with --unique, and the oldest manifest is always printed.
+	for i, m := range history {
+		var prev *swupd.Manifest
+		if i+1 < len(history) {
+			prev = history[i+1]
+		}
+		printManifestLogEntry(state, flags, base, m, prev)
+	}
+}
+
+func printManifestLogEntry(state *client.State, flags *logFlags, base string, m, prev *swupd.Manifest) {
+	var d *swupd.ManifestDiff
+	if prev != nil {
+		d = swupd.DiffManifests(prev, m, swupd.DiffOptions{})
+		unchanged := len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Modified) == 0 &&
+			len(d.AddedIncludes) == 0 && len(d.RemovedIncludes) == 0 &&
+			prev.Header.FileCount == m.Header.FileCount
+		if flags.unique && unchanged {
+			return
+		}
+	}
+
+	fmt.Printf("%s/%d/Manifest.%s\n", base, m.Header.Version, m.Name)
+	if prev == nil {
+		fmt.Printf("  filecount: %d\n", m.Header.FileCount)
+		for _, inc := range m.Header.Includes {
+			fmt.Printf("  includes: %s\n", inc.Name)
+		}
+		fmt.Println()
+		return
+	}
+
+	fmt.Printf("  filecount: %d (%+d)\n", m.Header.FileCount, int64(m.Header.FileCount)-int64(prev.Header.FileCount))
+	for _, name := range d.RemovedIncludes {
+		fmt.Printf("  -includes: %s\n", name)
+	}
+	for _, name := range d.AddedIncludes {
+		fmt.Printf("  +includes: %s\n", name)
+	}
+	fmt.Printf("  files: %d added, %d removed, %d modified\n", len(d.Added), len(d.Removed), len(d.Modified))
+	fmt.Println()
+
+	if !flags.diff {
+		return
+	}
+	for _, c := range d.Modified {
+		err := printContentDiff(os.Stdout, state, c.Old, state, c.New)
+		if err != nil {
+			log.Fatalf("ERROR: %s", err)
+		}
+	}
+	fmt.Println()
 }
 
 func visitFilesInBundle(state *client.State, bundleFile *swupd.File, visitFunc func(bundle, file *swupd.File) bool) error {
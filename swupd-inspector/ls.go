@@ -0,0 +1,144 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/clearlinux/mixer-tools/internal/client"
+	"github.com/clearlinux/mixer-tools/swupd"
+)
+
+type lsFlags struct {
+	recursive bool
+	all       bool
+	size      bool
+}
+
+func runLs(cacheDir string, flags *lsFlags, url, arg, dir string) {
+	base, version := parseURL(url)
+	stateDir := filepath.Join(cacheDir, convertContentBaseToDirname(base))
+	state, err := client.NewState(stateDir, base)
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+
+	if !strings.HasPrefix(arg, "Manifest.") || arg == "Manifest.MoM" {
+		log.Fatalf("Second argument to 'ls' must be a bundle Manifest name or Manifest.full")
+	}
+	if !strings.HasPrefix(dir, "/") {
+		log.Fatalf("ERROR: path %s must be absolute", dir)
+	}
+	dir = path.Clean(dir)
+
+	var m *swupd.Manifest
+	if arg == "Manifest.full" {
+		m, err = getFullManifest(state, version)
+	} else {
+		var mom *swupd.Manifest
+		mom, err = state.GetMoM(version)
+		if err != nil {
+			log.Fatalf("ERROR: %s", err)
+		}
+		name := arg[9:]
+		var found *swupd.File
+		for _, f := range mom.Files {
+			if f.Name == name {
+				found = f
+				break
+			}
+		}
+		if found == nil {
+			log.Fatalf("ERROR: Manifest.MoM for version %s doesn't have a bundle named %s", version, name)
+		}
+		m, err = state.GetBundleManifest(fmt.Sprint(found.Version), name, "")
+	}
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+	sortFiles(m)
+
+	var entries []*swupd.File
+	for _, f := range m.Files {
+		if !flags.all && !f.Present() {
+			continue
+		}
+		switch {
+		case f.Name == dir && f.Type != swupd.TypeDirectory:
+			// Listing a single file.
+			entries = append(entries, f)
+		case dir == "/" && f.Name != "/" && (flags.recursive || path.Dir(f.Name) == "/"):
+			entries = append(entries, f)
+		case strings.HasPrefix(f.Name, dir+"/") && (flags.recursive || path.Dir(f.Name) == dir):
+			entries = append(entries, f)
+		}
+	}
+	if len(entries) == 0 {
+		log.Fatalf("ERROR: no entries for %s in %s", dir, arg)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	for _, f := range entries {
+		size := "-"
+		name := f.Name
+		if !flags.recursive && f.Name != dir {
+			name = path.Base(f.Name)
+		}
+		// Only regular files have a size worth downloading the fullfile for.
+		if flags.size && f.Present() && f.Type == swupd.TypeFile {
+			hdr, err := getFullfileHeader(state, f)
+			if err != nil {
+				log.Fatalf("ERROR: %s", err)
+			}
+			size = fmt.Sprint(hdr.Size)
+		}
+		fmt.Fprintf(w, "%c\t%s\t%s\t%d\t%s\t%s\n", typeChar(f), fileFlags(f), size, f.Version, f.Hash.String()[:12], name)
+	}
+	_ = w.Flush()
+}
+
+func typeChar(f *swupd.File) byte {
+	switch f.Type {
+	case swupd.TypeDirectory:
+		return 'd'
+	case swupd.TypeLink:
+		return 'l'
+	case swupd.TypeManifest:
+		return 'm'
+	default:
+		return '-'
+	}
+}
+
+// getFullfileHeader returns the tar header of the fullfile for file, which contains the
+// information about the original file, like its size.
+func getFullfileHeader(state *client.State, file *swupd.File) (*tar.Header, error) {
+	tarred, err := state.GetFile(fmt.Sprint(file.Version), "files", file.Hash.String()+".tar")
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(tarred)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	tr, err := swupd.NewCompressedTarReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tr.Close()
+	}()
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read fullfile %s: %s", tarred, err)
+	}
+	return hdr, nil
+}
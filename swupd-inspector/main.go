@@ -4,7 +4,6 @@ import (
 	"bufio"
 	"log"
 	"os"
-	"os/user"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -12,28 +11,20 @@ import (
 	"github.com/spf13/cobra"
 )
 
-// TODO: Flag to set cacheDir.
-
-// TODO: Use XDG_* environment variables instead of ~/.config and ~/.cache by default.
-
-// TODO: Take into account deleted files, right now 'get' fails trying to download 0000...0.tar.
-
 func main() {
 	log.SetFlags(0)
 
-	user, err := user.Current()
+	configDir, err := defaultConfigDir()
 	if err != nil {
 		log.Fatal(err)
 	}
+	applyUserAliases(filepath.Join(configDir, "aliases"))
 
-	applyUserAliases(filepath.Join(user.HomeDir, ".config/swupd-inspector/aliases"))
-
-	// TODO: Use os.UserCacheDir instead of user.HomeDir+".cache".
-	cacheDir := filepath.Join(user.HomeDir, ".cache", "swupd-inspector")
-	err = os.MkdirAll(cacheDir, 0755)
+	cacheDir, err := defaultCacheDir()
 	if err != nil {
-		log.Fatalf("couldn't create cache directory: %s", err)
+		log.Fatal(err)
 	}
+	var cacheLimit string
 
 	rootCmd := &cobra.Command{
 		Use:   "swupd-inspector",
@@ -41,7 +32,14 @@ func main() {
 		Long: `Inspect and download swupd content
 
 The program will cache everything downloaded, and can keep content from
-different sources. The cache directory is $HOME/.cache/swupd-inspector.
+different sources. The cache directory is $XDG_CACHE_HOME/swupd-inspector,
+or $HOME/.cache/swupd-inspector if XDG_CACHE_HOME is not set. Use
+--cache-dir to use a different directory.
+
+The cache of each content source can be limited with --cache-limit (or
+the SWUPD_INSPECTOR_CACHE_LIMIT environment variable) to a size like
+500M or 10G. When the limit is exceeded, the least recently used files
+are removed after the command runs.
 
 The URLs must refer to a specific version like
 https://download.clearlinux.org/update/20520. Absolute local paths can
@@ -49,10 +47,38 @@ also be used instead of URLs.
 
 It is possible to refer to content by aliases. The alias 'clear' works
 by default, so clear/20520 refer to the same as the URL above. Other
-aliases can be defined in $HOME/.config/swupd-inspector/aliases in the
-format ALIAS=URL per line.
+aliases can be defined in $XDG_CONFIG_HOME/swupd-inspector/aliases (by
+default $HOME/.config/swupd-inspector/aliases) in the format ALIAS=URL
+per line.
 `,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			err := os.MkdirAll(cacheDir, 0755)
+			if err != nil {
+				log.Fatalf("couldn't create cache directory: %s", err)
+			}
+		},
+		PersistentPostRun: func(cmd *cobra.Command, args []string) {
+			if cacheLimit == "" {
+				return
+			}
+			limit, err := parseSize(cacheLimit)
+			if err != nil {
+				log.Fatalf("ERROR: %s", err)
+			}
+			stateDirs, err := listStateDirs(cacheDir)
+			if err != nil {
+				log.Fatalf("ERROR: %s", err)
+			}
+			for _, stateDir := range stateDirs {
+				err = pruneStateDir(stateDir, limit)
+				if err != nil {
+					log.Fatalf("ERROR: %s", err)
+				}
+			}
+		},
 	}
+	rootCmd.PersistentFlags().StringVar(&cacheDir, "cache-dir", cacheDir, "directory to cache downloaded content")
+	rootCmd.PersistentFlags().StringVar(&cacheLimit, "cache-limit", os.Getenv(cacheLimitEnv), "maximum size of the cache for each content source, e.g. 10G")
 
 	diffFlags := &diffFlags{}
 	diffCmd := &cobra.Command{
@@ -63,6 +89,14 @@ format ALIAS=URL per line.
 The filenames and flags will be compared, recursing to the
 bundles. Use --strict to also compare the version numbers of the
 files. Use --no-color to not emit escape codes in the output.
+
+After the differences, a summary with the number of added, removed and
+modified files and the content size change of each bundle is
+printed. Use --summary to print only the summary, or --json to get all
+the differences in JSON format.
+
+Use --content to download the fullfiles of modified files and show a
+unified diff of the contents of text files.
 `,
 		Args: cobra.ExactArgs(2),
 		Run: func(cmd *cobra.Command, args []string) {
@@ -71,6 +105,9 @@ files. Use --no-color to not emit escape codes in the output.
 	}
 	diffCmd.Flags().BoolVar(&diffFlags.noColor, "no-color", false, "disable colored output")
 	diffCmd.Flags().BoolVar(&diffFlags.strict, "strict", false, "compare version numbers of files")
+	diffCmd.Flags().BoolVar(&diffFlags.summary, "summary", false, "only print the summary of differences")
+	diffCmd.Flags().BoolVar(&diffFlags.json, "json", false, "print the differences in JSON format")
+	diffCmd.Flags().BoolVar(&diffFlags.content, "content", false, "show diff of text file contents")
 	rootCmd.AddCommand(diffCmd)
 
 	getCmd := &cobra.Command{
@@ -88,7 +125,8 @@ Different types of content can be downloaded:
       The FILENAME must be an absolute path.
 
   swupd-inspector get URL HASH
-      Download the fullfile corresponding to the hash.
+      Download the fullfile corresponding to the hash. The hash can be
+      shortened as long as it is not ambiguous.
 `,
 		Args: cobra.ExactArgs(2),
 		Run: func(cmd *cobra.Command, args []string) {
@@ -97,36 +135,187 @@ Different types of content can be downloaded:
 	}
 	rootCmd.AddCommand(getCmd)
 
+	cleanFlags := &cleanFlags{}
 	cleanCmd := &cobra.Command{
 		Use:   "clean",
 		Short: "Clean up any cached content",
-		Args:  cobra.NoArgs,
+		Long: `Clean up any cached content.
+
+By default the cache for all content is deleted. Use --url to only clean
+the cache for a single content base, like https://download.clearlinux.org/update
+or an alias. Use --older-than to only delete files that were not used
+recently, with a duration like 12h or 30d.
+`,
+		Args: cobra.NoArgs,
 		Run: func(cmd *cobra.Command, args []string) {
-			runClean(cacheDir)
+			runClean(cacheDir, cleanFlags)
 		},
 	}
+	cleanCmd.Flags().StringVar(&cleanFlags.olderThan, "older-than", "", "only delete files not used for this long")
+	cleanCmd.Flags().StringVar(&cleanFlags.url, "url", "", "only delete cached content from this base URL")
 	rootCmd.AddCommand(cleanCmd)
 
+	catFlags := &catFlags{}
 	catCmd := &cobra.Command{
-		Use:   "cat [flags] URL Manifest.NAME",
-		Short: "Print the contents of a Manifest",
-		Args:  cobra.ExactArgs(2),
+		Use:   "cat [flags] URL (Manifest.NAME|FILENAME|HASH)",
+		Short: "Print the contents of a Manifest or a file",
+		Long: `Print the contents of a Manifest or a file.
+
+  swupd-inspector cat URL Manifest.NAME
+      Print the contents of the given Manifest.
+
+  swupd-inspector cat URL FILENAME
+      Print the contents of the file. The FILENAME must be an absolute
+      path.
+
+  swupd-inspector cat URL HASH
+      Print the contents of the file corresponding to the hash. The
+      hash can be shortened as long as it is not ambiguous.
+
+Binary files are not printed unless --binary is used. Use --pager to
+show the contents using $PAGER (or less) when the output is a terminal.
+`,
+		Args: cobra.ExactArgs(2),
 		Run: func(cmd *cobra.Command, args []string) {
-			runCat(cacheDir, args[0], args[1])
+			runCat(cacheDir, catFlags, args[0], args[1])
 		},
 	}
+	catCmd.Flags().BoolVar(&catFlags.pager, "pager", false, "show the contents using a pager")
+	catCmd.Flags().BoolVar(&catFlags.binary, "binary", false, "print binary files")
 	rootCmd.AddCommand(catCmd)
 
+	lsFlags := &lsFlags{}
+	lsCmd := &cobra.Command{
+		Use:   "ls [flags] URL Manifest.NAME [PATH]",
+		Short: "List the contents of a bundle",
+		Long: `List the contents of a bundle.
+
+List the entries of the bundle Manifest (or Manifest.full) inside PATH,
+by default /. If PATH is a file, only the file is listed. Use -R to list
+the entries recursively.
+
+For each entry the type, flags, size, version, hash and name are
+printed. The size of regular files is taken from their fullfile, so
+it is only shown with --size, which downloads the fullfile of every
+listed file. Deleted and ghosted entries are skipped unless --all is
+used.
+`,
+		Args: cobra.RangeArgs(2, 3),
+		Run: func(cmd *cobra.Command, args []string) {
+			dir := "/"
+			if len(args) == 3 {
+				dir = args[2]
+			}
+			runLs(cacheDir, lsFlags, args[0], args[1], dir)
+		},
+	}
+	lsCmd.Flags().BoolVarP(&lsFlags.recursive, "recursive", "R", false, "list entries recursively")
+	lsCmd.Flags().BoolVar(&lsFlags.all, "all", false, "include deleted and ghosted entries")
+	lsCmd.Flags().BoolVarP(&lsFlags.size, "size", "s", false, "download the fullfiles of regular files to show their sizes")
+	rootCmd.AddCommand(lsCmd)
+
+	logFlags := &logFlags{}
 	logCmd := &cobra.Command{
-		Use:   "log [flags] URL FILENAME",
+		Use:   "log [flags] URL (FILENAME|Manifest.NAME)",
 		Short: "Print FILENAME version and all previous versions",
-		Args:  cobra.ExactArgs(2),
+		Long: `Print FILENAME version and all previous versions.
+
+  swupd-inspector log URL FILENAME
+      Print the fullfile URL for every version of FILENAME. The
+      FILENAME must be an absolute path. Use --unique to skip versions
+      that have the same hash as the one printed before.
+
+  swupd-inspector log URL Manifest.NAME
+      Print every version of the bundle manifest, with the changes in
+      includes and files from the previous one. Use --unique to skip
+      versions without any changes.
+
+Use --diff to also print a unified diff of the contents of text files
+between consecutive versions.
+`,
+		Args: cobra.ExactArgs(2),
 		Run: func(cmd *cobra.Command, args []string) {
-			runLog(cacheDir, args[0], args[1])
+			runLog(cacheDir, logFlags, args[0], args[1])
 		},
 	}
+	logCmd.Flags().BoolVar(&logFlags.unique, "unique", false, "skip versions without changes")
+	logCmd.Flags().BoolVar(&logFlags.diff, "diff", false, "show diff of text file contents between versions")
 	rootCmd.AddCommand(logCmd)
 
+	sizeFlags := &sizeFlags{}
+	sizeCmd := &cobra.Command{
+		Use:   "size [flags] FROM-URL TO-URL",
+		Short: "Estimate the download size of an update",
+		Long: `Estimate the download size of an update.
+
+Calculate what a client with a set of bundles would download to update
+from the version in FROM-URL to the version in TO-URL. Both URLs must
+refer to the same content. For each bundle that changed, the size of
+its manifest (or delta manifest when available), its pack (zero pack
+for bundles new to the client) and the fullfiles not covered by a
+pack are added up.
+
+By default all bundles in the FROM-URL version are considered. Use
+--bundles to set which bundles the client has, the bundles they include
+are also taken into account.
+`,
+		Args: cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			runSize(cacheDir, sizeFlags, args[0], args[1])
+		},
+	}
+	sizeCmd.Flags().StringSliceVar(&sizeFlags.bundles, "bundles", nil, "comma-separated list of bundles installed in the client")
+	rootCmd.AddCommand(sizeCmd)
+
+	graphFlags := &graphFlags{}
+	graphCmd := &cobra.Command{
+		Use:   "graph [flags] URL",
+		Short: "Print the bundle dependency graph",
+		Long: `Print the bundle dependency graph.
+
+The graph is built from the includes and also-add headers of all the
+bundle manifests listed in the MoM, and printed in DOT (default) or JSON
+format. Each bundle has its file count and content size. The also-add
+edges are drawn dashed in DOT.
+
+Use --highlight to mark a bundle and all the bundles it includes,
+directly or not.
+`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			runGraph(cacheDir, graphFlags, args[0])
+		},
+	}
+	graphCmd.Flags().StringVar(&graphFlags.format, "format", "dot", "output format: dot or json")
+	graphCmd.Flags().StringVar(&graphFlags.highlight, "highlight", "", "highlight the transitive includes of a bundle")
+	rootCmd.AddCommand(graphCmd)
+
+	searchFlags := &searchFlags{}
+	searchCmd := &cobra.Command{
+		Use:   "search [flags] URL PATTERN",
+		Short: "Find which bundles ship the files matching PATTERN",
+		Long: `Find which bundles ship the files matching PATTERN.
+
+The PATTERN is a shell glob that must match the entire absolute path of
+a file, e.g. /usr/lib64/libssl*. Use --regexp to use a regular
+expression instead. For each match the bundle, flags, hash, version and
+filename are printed.
+
+Manifest.full is used first to check for matches, so bundle manifests
+are only downloaded when there is something to find. Use --full to
+print the entries from Manifest.full without bundle information. Deleted
+and ghosted entries are skipped unless --all is used.
+`,
+		Args: cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			runSearch(cacheDir, searchFlags, args[0], args[1])
+		},
+	}
+	searchCmd.Flags().BoolVar(&searchFlags.regexp, "regexp", false, "interpret PATTERN as a regular expression")
+	searchCmd.Flags().BoolVar(&searchFlags.all, "all", false, "include deleted and ghosted entries")
+	searchCmd.Flags().BoolVar(&searchFlags.full, "full", false, "only search Manifest.full, without bundle information")
+	rootCmd.AddCommand(searchCmd)
+
 	_ = rootCmd.Execute()
 }
 
@@ -184,24 +373,7 @@ func parseURL(s string) (base string, version string) {
 		log.Fatalf("ERROR: couldn't parse empty URL")
 	}
 
-	for alias, aliasBase := range aliases {
-		if strings.HasPrefix(s, alias+"/") {
-			s = aliasBase + s[len(alias):]
-			break
-		}
-	}
-
-	switch {
-	case strings.HasPrefix(s, "/"):
-		s = filepath.Clean(s)
-	case strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://"):
-		// Eat the extra slashes in the end.
-		for len(s) > 0 && s[len(s)-1] == '/' {
-			s = s[:len(s)-1]
-		}
-	default:
-		log.Fatalf("ERROR: Invalid URL: %s", s)
-	}
+	s = parseBaseURL(s)
 
 	sep := strings.LastIndex(s, "/")
 	if sep == -1 {
@@ -221,6 +393,30 @@ func parseURL(s string) (base string, version string) {
 	return base, version
 }
 
+// parseBaseURL resolves aliases and normalizes a URL or local path, without
+// expecting a version in the end.
+func parseBaseURL(s string) string {
+	for alias, aliasBase := range aliases {
+		if s == alias || strings.HasPrefix(s, alias+"/") {
+			s = aliasBase + s[len(alias):]
+			break
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(s, "/"):
+		s = filepath.Clean(s)
+	case strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://"):
+		// Eat the extra slashes in the end.
+		for len(s) > 0 && s[len(s)-1] == '/' {
+			s = s[:len(s)-1]
+		}
+	default:
+		log.Fatalf("ERROR: Invalid URL: %s", s)
+	}
+	return s
+}
+
 func convertContentBaseToDirname(content string) string {
 	return strings.Map(func(r rune) rune {
 		switch {
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/clearlinux/mixer-tools/internal/client"
+	"github.com/clearlinux/mixer-tools/swupd"
+)
+
+type searchFlags struct {
+	regexp bool
+	all    bool
+	full   bool
+}
+
+func runSearch(cacheDir string, flags *searchFlags, url, pattern string) {
+	base, version := parseURL(url)
+	stateDir := filepath.Join(cacheDir, convertContentBaseToDirname(base))
+	state, err := client.NewState(stateDir, base)
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+
+	match, err := newPathMatcher(pattern, flags.regexp)
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+
+	accept := func(f *swupd.File) bool {
+		if !flags.all && !f.Present() {
+			return false
+		}
+		return match(f.Name)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	printMatch := func(bundle string, f *swupd.File) {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", bundle, fileFlags(f), f.Hash, f.Version, f.Name)
+	}
+
+	// Manifest.full has an entry for every file in the version, so it is
+	// used to find out whether there are any matches at all before walking
+	// all the bundle manifests. When it is not available, fall back to the
+	// walk.
+	var candidates map[string]bool
+	full, err := getFullManifest(state, version)
+	if err != nil {
+		if flags.full {
+			log.Fatalf("ERROR: %s", err)
+		}
+		log.Printf("Warning: couldn't use Manifest.full, searching all bundles: %s", err)
+	} else {
+		candidates = make(map[string]bool)
+		for _, f := range full.Files {
+			if accept(f) {
+				candidates[f.Name] = true
+				if flags.full {
+					printMatch("-", f)
+				}
+			}
+		}
+	}
+
+	if !flags.full && (candidates == nil || len(candidates) > 0) {
+		mom, err := state.GetMoM(version)
+		if err != nil {
+			log.Fatalf("ERROR: %s", err)
+		}
+		err = visitAllFiles(state, mom, func(bundle, file *swupd.File) bool {
+			if candidates != nil && !candidates[file.Name] {
+				return false
+			}
+			if accept(file) {
+				printMatch(bundle.Name, file)
+			}
+			return false
+		})
+		if err != nil {
+			log.Fatalf("ERROR: %s", err)
+		}
+	}
+
+	err = w.Flush()
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+
+	if candidates != nil && len(candidates) == 0 {
+		log.Fatalf("No files matching %s found in version %s", pattern, version)
+	}
+}
+
+// newPathMatcher returns a function that checks file names against pattern,
+// which is either a shell glob that must match the whole name or, if
+// useRegexp is set, a regular expression.
+func newPathMatcher(pattern string, useRegexp bool) (func(name string) bool, error) {
+	if useRegexp {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression %q: %s", pattern, err)
+		}
+		return re.MatchString, nil
+	}
+
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("glob pattern %q must be an absolute path", pattern)
+	}
+	// Validate the pattern once, so errors are not silently ignored during the matching.
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid glob pattern %q: %s", pattern, err)
+	}
+	return func(name string) bool {
+		ok, _ := path.Match(pattern, name)
+		return ok
+	}, nil
+}
+
+func getFullManifest(state *client.State, version string) (*swupd.Manifest, error) {
+	fullPath, err := state.GetFile(version, "Manifest.full")
+	if err != nil {
+		return nil, err
+	}
+	return swupd.ParseManifestFile(fullPath)
+}
+
+func fileFlags(f *swupd.File) string {
+	result, err := f.GetFlagString()
+	if err != nil {
+		return "...."
+	}
+	return result
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/clearlinux/mixer-tools/internal/client"
+	"github.com/clearlinux/mixer-tools/swupd"
+)
+
+type sizeFlags struct {
+	bundles []string
+}
+
+// bundleUpdateSize is the estimated download cost of updating one bundle.
+type bundleUpdateSize struct {
+	Name        string
+	FromVersion uint32
+	ToVersion   uint32
+
+	Manifest      int64
+	DeltaManifest bool
+	Pack          int64
+	ZeroPack      bool
+	Fullfiles     int64
+	FullfileCount int
+}
+
+func (b *bundleUpdateSize) total() int64 {
+	return b.Manifest + b.Pack + b.Fullfiles
+}
+
+func runSize(cacheDir string, flags *sizeFlags, urlFrom, urlTo string) {
+	base, fromVersion := parseURL(urlFrom)
+	baseTo, toVersion := parseURL(urlTo)
+	if base != baseTo {
+		log.Fatalf("ERROR: both URLs must refer to the same content, got %s and %s", base, baseTo)
+	}
+
+	stateDir := filepath.Join(cacheDir, convertContentBaseToDirname(base))
+	state, err := client.NewState(stateDir, base)
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+
+	momFrom, err := state.GetMoM(fromVersion)
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+	momTo, err := state.GetMoM(toVersion)
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+
+	fromBundles := make(map[string]*swupd.File, len(momFrom.Files))
+	for _, f := range momFrom.Files {
+		fromBundles[f.Name] = f
+	}
+	toBundles := make(map[string]*swupd.File, len(momTo.Files))
+	for _, f := range momTo.Files {
+		toBundles[f.Name] = f
+	}
+
+	// By default consider a client that has all the bundles available in the
+	// from version.
+	names := flags.bundles
+	if len(names) == 0 {
+		for _, f := range momFrom.Files {
+			names = append(names, f.Name)
+		}
+	}
+
+	// The client also gets all the bundles included by the ones it has, so
+	// follow the includes of the manifests in the to version.
+	manifests := make(map[string]*swupd.Manifest)
+	var queue []string
+	queued := make(map[string]bool)
+	for _, name := range names {
+		if !queued[name] {
+			queue = append(queue, name)
+			queued[name] = true
+		}
+	}
+	for i := 0; i < len(queue); i++ {
+		name := queue[i]
+		bundleF, ok := toBundles[name]
+		if !ok {
+			if _, inFrom := fromBundles[name]; !inFrom {
+				log.Fatalf("ERROR: bundle %s not found in versions %s or %s", name, fromVersion, toVersion)
+			}
+			log.Printf("Warning: bundle %s was removed in version %s", name, toVersion)
+			continue
+		}
+		m, err := state.GetBundleManifest(fmt.Sprint(bundleF.Version), name, "")
+		if err != nil {
+			log.Fatalf("ERROR: %s", err)
+		}
+		manifests[name] = m
+		for _, inc := range m.Header.Includes {
+			if !queued[inc.Name] {
+				queue = append(queue, inc.Name)
+				queued[inc.Name] = true
+			}
+		}
+	}
+
+	momSize, err := state.Size(toVersion, "Manifest.MoM.tar")
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+
+	var result []*bundleUpdateSize
+	// A fullfile is downloaded only once, even if used by multiple bundles.
+	seenHashes := make(map[swupd.Hashval]bool)
+	for _, name := range queue {
+		m := manifests[name]
+		if m == nil {
+			continue
+		}
+		bs := &bundleUpdateSize{
+			Name:      name,
+			ToVersion: toBundles[name].Version,
+		}
+		if f, ok := fromBundles[name]; ok {
+			bs.FromVersion = f.Version
+		}
+		if bs.FromVersion == bs.ToVersion {
+			// Bundle didn't change, nothing to download.
+			continue
+		}
+		to := fmt.Sprint(bs.ToVersion)
+
+		// Prefer the delta manifest when available.
+		if bs.FromVersion > 0 {
+			bs.Manifest, err = state.Size(to, fmt.Sprintf("Manifest-%s-delta-from-%d", name, bs.FromVersion))
+			if err == nil {
+				bs.DeltaManifest = true
+			} else if !os.IsNotExist(err) {
+				log.Fatalf("ERROR: %s", err)
+			}
+		}
+		if !bs.DeltaManifest {
+			bs.Manifest, err = state.Size(to, "Manifest."+name+".tar")
+			if err != nil {
+				log.Fatalf("ERROR: %s", err)
+			}
+		}
+
+		bs.ZeroPack = bs.FromVersion == 0
+		bs.Pack, err = state.Size(to, swupd.GetPackFilename(name, bs.FromVersion))
+		if err != nil && !os.IsNotExist(err) {
+			log.Fatalf("ERROR: %s", err)
+		}
+		havePack := err == nil
+
+		for _, f := range m.Files {
+			if !f.Present() || f.Type == swupd.TypeDirectory || f.Version <= bs.FromVersion || seenHashes[f.Hash] {
+				continue
+			}
+			seenHashes[f.Hash] = true
+			if havePack {
+				continue
+			}
+			var size int64
+			size, err = state.Size(fmt.Sprint(f.Version), "files", f.Hash.String()+".tar")
+			if err != nil {
+				log.Fatalf("ERROR: %s", err)
+			}
+			bs.Fullfiles += size
+			bs.FullfileCount++
+		}
+		result = append(result, bs)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	fmt.Printf("Update from %s to %s for bundles: %s\n\n", fromVersion, toVersion, strings.Join(queue, ", "))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "BUNDLE\tFROM\tTO\tMANIFEST\tPACK\tFULLFILES\tTOTAL\t")
+	total := momSize
+	for _, bs := range result {
+		manifest := fmt.Sprint(bs.Manifest)
+		if bs.DeltaManifest {
+			manifest += " (delta)"
+		}
+		pack := "-"
+		if bs.Pack > 0 {
+			pack = fmt.Sprint(bs.Pack)
+			if bs.ZeroPack {
+				pack += " (zero)"
+			}
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%d (%d files)\t%d\t\n", bs.Name, bs.FromVersion, bs.ToVersion, manifest, pack, bs.Fullfiles, bs.FullfileCount, bs.total())
+		total += bs.total()
+	}
+	fmt.Fprintf(w, "%s\t\t\t%d\t\t\t%d\t\n", "Manifest.MoM", momSize, momSize)
+	fmt.Fprintf(w, "%s\t\t\t\t\t\t%d\t\n", "total", total)
+	_ = w.Flush()
+}
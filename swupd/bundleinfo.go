@@ -32,6 +32,13 @@ type BundleHeader struct {
 	Maintainer   string
 }
 
+// PackagePin describes the version a package is pinned to in a bundle
+// definition
+type PackagePin struct {
+	Version string
+	Arch    string `json:",omitempty"`
+}
+
 // BundleInfo describes the JSON object to be read from the *-info files
 type BundleInfo struct {
 	Name             string
@@ -39,9 +46,13 @@ type BundleInfo struct {
 	Header           BundleHeader
 	DirectIncludes   []string
 	OptionalIncludes []string
+	Excludes         []string
 	DirectPackages   map[string]bool
 	AllPackages      map[string]bool
+	AllRpmPackages   map[string]bool
+	PackagePins      map[string]*PackagePin
 	Files            map[string]bool
+	ContentFiles     map[string]bool
 }
 
 // GetBundleInfo loads the BundleInfo member of m from the bundle-info file at
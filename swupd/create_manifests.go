@@ -202,7 +202,6 @@ func processBundles(ui UpdateInfo, c config, numWorkers int) ([]*Manifest, error
 			return nil, err
 		}
 		changedIncludes := includesChanged(bundle, oldM)
-		oldM.sortFilesName()
 		changedFiles, added, deleted := bundle.linkPeersAndChange(oldM, ui.minVersion)
 		// if nothing changed, skip
 		if changedFiles == 0 && added == 0 && deleted == 0 && !changedIncludes {
@@ -361,8 +361,9 @@ func (m *Manifest) sortFilesVersionName() {
 // linkPeersAndChange
 // At this point Manifest m should only have the files that were present in the
 // chroot for that manifest. Link delta peers with the oldManifest if the file
-// in the oldManifest is not deleted or ghosted. Expects m and oldManifest
-// files lists to be sorted by name only.
+// in the oldManifest is not deleted or ghosted. The files are compared with
+// DiffManifests, which doesn't depend on the order of the file lists, but the
+// result is sorted by name.
 //
 // An important note is that deletes must persist over minversions but not over
 // format bumps.
@@ -370,89 +371,63 @@ func (m *Manifest) linkPeersAndChange(oldManifest *Manifest, minVersion uint32)
 	// set previous version to oldManifest version
 	m.Header.Previous = oldManifest.Header.Version
 
-	var changed, removed, added []*File
-	nx := 0 // new manifest file index
-	ox := 0 // old manifest file index
-	mFilesLen := len(m.Files)
-	omFilesLen := len(oldManifest.Files)
-	for nx < mFilesLen && ox < omFilesLen {
-		nf := m.Files[nx]
-		of := oldManifest.Files[ox]
-		if nf.Name == of.Name {
-			// if it is the same name check if anything about the file has changed.
-			// if something has changed update the version to current version and
-			// record that the file has changed
-			if of.Status == StatusDeleted || of.Status == StatusGhosted {
-				nf.Version = m.Header.Version
-				added = append(added, nf)
-				ox++
-				nx++
-				continue
-			}
-			if nf.Hash == of.Hash && of.Version >= minVersion {
-				// same contents, version doesn't change.
-				nf.Version = of.Version
-			} else {
-				// if the file isn't exactly the same, record the change
-				// and update the version
-				// this case is also hit when the old file is older than the
-				// minversion
-				nf.Version = m.Header.Version
-				changed = append(changed, nf)
-				// set up peers since old file exists
-				nf.DeltaPeer = of
-				of.DeltaPeer = nf
-			}
-			// advance indices for both file lists since we had a match
-			nx++
-			ox++
-		} else if nf.Name < of.Name {
-			// if the file does not exist in the old manifest it is a new
-			// file in this manifest. Update the version and record that
-			// it is added.
-			nf.Version = m.Header.Version
-			added = append(added, nf)
-			// look at next file in current manifest
-			nx++
-		} else {
-			// if the file exists in the old manifest and does not *yet* exist
-			// in the new manifest, it was deleted or is an old deleted/ghosted
-			// file
-			if !of.Present() {
-				if of.Status == StatusDeleted && m.Header.Format == oldManifest.Header.Format {
-					m.Files = append(m.Files, of)
-				}
-				ox++
-				continue
-			}
-			// this is a new deleted file
-			m.newDeleted(of)
-			removed = append(removed, of)
-			// look at next file in old manifest
-			ox++
-		}
+	d := DiffManifests(oldManifest, m, DiffOptions{Unchanged: true})
+
+	// Files that didn't exist in the old manifest, or were deleted or
+	// ghosted there, are new in this manifest.
+	for _, nf := range d.Added {
+		nf.Version = m.Header.Version
 	}
 
-	// anything remaining in m does not exist in oldManifest
-	for _, nf := range m.Files[nx:mFilesLen] {
+	// Only a change of contents or an old file older than the minversion
+	// makes the file change. The flags of m are not final yet, they are set
+	// later by the heuristics.
+	changed := 0
+	link := func(c *FileChange) {
+		nf := c.New
+		of := c.Old
+		if !c.HashChanged && of.Version >= minVersion {
+			// same contents, version doesn't change.
+			nf.Version = of.Version
+			return
+		}
 		nf.Version = m.Header.Version
-		added = append(added, nf)
+		changed++
+		// set up peers since old file exists
+		nf.DeltaPeer = of
+		of.DeltaPeer = nf
+	}
+	for _, c := range d.Modified {
+		link(c)
+	}
+	for _, c := range d.Unchanged {
+		link(c)
 	}
 
-	// anything remaining in oldManifest is newly deleted in the new manifest
-	for _, of := range oldManifest.Files[ox:omFilesLen] {
-		if of.Status == StatusDeleted || of.Status == StatusGhosted {
-			continue
+	// Old deleted files persist over minversions, but not over format
+	// bumps, as long as the file is still not in the new manifest.
+	if m.Header.Format == oldManifest.Header.Format {
+		names := make(map[string]bool, len(m.Files))
+		for _, nf := range m.Files {
+			names[nf.Name] = true
 		}
+		for _, of := range oldManifest.Files {
+			if of.Status == StatusDeleted && !names[of.Name] {
+				m.Files = append(m.Files, of)
+			}
+		}
+	}
+
+	// Files removed from the old manifest are newly deleted.
+	for _, of := range d.Removed {
 		m.newDeleted(of)
-		removed = append(removed, of)
 	}
 
 	// finally re-sort since we changed the order
 	m.sortFilesName()
 	// return the number of changed, added, or deleted files in this
 	// manifest
-	return len(changed), len(added), len(removed)
+	return changed, len(d.Added), len(d.Removed)
 }
 
 func (m *Manifest) newDeleted(df *File) {
@@ -928,7 +903,6 @@ func writeIndexManifest(c *config, ui *UpdateInfo, oldMoM *Manifest, bundles []*
 		idxMan.subtractManifests(newOsCore)
 	}
 
-	oldM.sortFilesName()
 	_, _, _ = idxMan.linkPeersAndChange(oldM, ui.minVersion)
 
 	// Fix version of deleted files in the case of a minVersion
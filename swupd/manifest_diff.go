@@ -0,0 +1,270 @@
+// Copyright 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package swupd
+
+import (
+	"fmt"
+	"sort"
+)
+
+// DiffOptions controls how two manifests are compared by DiffManifests.
+type DiffOptions struct {
+	// Strict also compares the version numbers of the files and the
+	// version related header fields (version, previous, minversion and
+	// timestamp). Without it, a file that only got a new version is not
+	// considered modified.
+	Strict bool
+
+	// DetectRenames pairs removed and added files with the same contents
+	// and reports them as renames instead.
+	DetectRenames bool
+
+	// Unchanged also reports the files present in both manifests that are
+	// not modified, so callers can carry information over from the old
+	// manifest.
+	Unchanged bool
+}
+
+// FileChange describes a file present in both manifests that is different.
+type FileChange struct {
+	Old *File
+	New *File
+
+	HashChanged    bool
+	FlagsChanged   bool
+	VersionChanged bool
+}
+
+// FileRename describes a file removed in the old manifest that has its
+// contents added in the new manifest with a different name.
+type FileRename struct {
+	Old *File
+	New *File
+}
+
+// HeaderChange describes a header field with different values in the
+// manifests.
+type HeaderChange struct {
+	Field string
+	Old   string
+	New   string
+}
+
+// ManifestDiff contains the differences between two manifests. For MoMs, the
+// files are the bundle manifests.
+//
+// Deleted and ghosted entries are considered not present, so a file deleted
+// in the new manifest is in Removed, and a file that was deleted in the old
+// manifest and is back in the new one is in Added.
+type ManifestDiff struct {
+	Added    []*File
+	Removed  []*File
+	Modified []*FileChange
+	Renamed  []*FileRename
+
+	// Unchanged is only filled when DiffOptions.Unchanged is set.
+	Unchanged []*FileChange
+
+	Header []*HeaderChange
+
+	AddedIncludes   []string
+	RemovedIncludes []string
+	AddedOptional   []string
+	RemovedOptional []string
+
+	// Minversion is set when a file has the same contents in both
+	// manifests but a different version, which happens after a minversion
+	// bump. It is set independently of DiffOptions.Strict.
+	Minversion bool
+}
+
+// Empty tells whether the manifests have no differences.
+func (d *ManifestDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Modified) == 0 &&
+		len(d.Renamed) == 0 && len(d.Header) == 0 &&
+		len(d.AddedIncludes) == 0 && len(d.RemovedIncludes) == 0 &&
+		len(d.AddedOptional) == 0 && len(d.RemovedOptional) == 0
+}
+
+// DiffManifests compares the manifests oldM and newM, either of which can be
+// nil to represent a manifest without files, e.g. when a bundle is added or
+// removed. The manifests are not modified.
+func DiffManifests(oldM, newM *Manifest, opts DiffOptions) *ManifestDiff {
+	if oldM == nil {
+		oldM = &Manifest{}
+	}
+	if newM == nil {
+		newM = &Manifest{}
+	}
+
+	d := &ManifestDiff{}
+	d.diffHeader(oldM, newM, opts.Strict)
+	d.AddedIncludes, d.RemovedIncludes = diffManifestNames(oldM.Header.Includes, newM.Header.Includes)
+	d.AddedOptional, d.RemovedOptional = diffManifestNames(oldM.Header.Optional, newM.Header.Optional)
+
+	walkManifestFiles(sortedFiles(oldM), sortedFiles(newM), func(of, nf *File) {
+		oldPresent := of != nil && of.Present()
+		newPresent := nf != nil && nf.Present()
+		switch {
+		case !oldPresent && newPresent:
+			d.Added = append(d.Added, nf)
+		case oldPresent && !newPresent:
+			d.Removed = append(d.Removed, of)
+		case oldPresent && newPresent:
+			c := &FileChange{
+				Old:            of,
+				New:            nf,
+				HashChanged:    of.Hash != nf.Hash,
+				FlagsChanged:   flagsChanged(of, nf),
+				VersionChanged: of.Version != nf.Version,
+			}
+			if !c.HashChanged && c.VersionChanged {
+				d.Minversion = true
+			}
+			if c.HashChanged || c.FlagsChanged || (opts.Strict && c.VersionChanged) {
+				d.Modified = append(d.Modified, c)
+			} else if opts.Unchanged {
+				d.Unchanged = append(d.Unchanged, c)
+			}
+		}
+	})
+
+	if opts.DetectRenames {
+		d.detectRenames()
+	}
+	return d
+}
+
+func (d *ManifestDiff) diffHeader(oldM, newM *Manifest, strict bool) {
+	oh := &oldM.Header
+	nh := &newM.Header
+	add := func(field string, oldValue, newValue interface{}) {
+		o := fmt.Sprint(oldValue)
+		n := fmt.Sprint(newValue)
+		if o != n {
+			d.Header = append(d.Header, &HeaderChange{Field: field, Old: o, New: n})
+		}
+	}
+	add("MANIFEST", oh.Format, nh.Format)
+	if strict {
+		add("version", oh.Version, nh.Version)
+		add("previous", oh.Previous, nh.Previous)
+		add("minversion", oh.MinVersion, nh.MinVersion)
+		add("timestamp", oh.TimeStamp.Unix(), nh.TimeStamp.Unix())
+	}
+	add("filecount", oh.FileCount, nh.FileCount)
+	add("contentsize", oh.ContentSize, nh.ContentSize)
+}
+
+// detectRenames moves the pairs of removed and added regular files with the
+// same hash to Renamed.
+func (d *ManifestDiff) detectRenames() {
+	removedByHash := make(map[Hashval][]*File)
+	for _, f := range d.Removed {
+		if f.Type == TypeFile && f.Hash != 0 {
+			removedByHash[f.Hash] = append(removedByHash[f.Hash], f)
+		}
+	}
+	if len(removedByHash) == 0 {
+		return
+	}
+
+	renamedOld := make(map[*File]bool)
+	var added []*File
+	for _, f := range d.Added {
+		candidates := removedByHash[f.Hash]
+		if f.Type != TypeFile || len(candidates) == 0 {
+			added = append(added, f)
+			continue
+		}
+		d.Renamed = append(d.Renamed, &FileRename{Old: candidates[0], New: f})
+		renamedOld[candidates[0]] = true
+		removedByHash[f.Hash] = candidates[1:]
+	}
+	d.Added = added
+
+	var removed []*File
+	for _, f := range d.Removed {
+		if !renamedOld[f] {
+			removed = append(removed, f)
+		}
+	}
+	d.Removed = removed
+}
+
+func flagsChanged(a, b *File) bool {
+	return a.Type != b.Type || a.Status != b.Status || a.Modifier != b.Modifier || a.Rename != b.Rename
+}
+
+// sortedFiles returns a copy of the files in m sorted by name.
+func sortedFiles(m *Manifest) []*File {
+	files := make([]*File, len(m.Files))
+	copy(files, m.Files)
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+	return files
+}
+
+// walkManifestFiles calls fn for each file name in the two lists sorted by
+// name, passing nil when the file is not in one of them.
+func walkManifestFiles(oldFiles, newFiles []*File, fn func(of, nf *File)) {
+	i := 0
+	j := 0
+	for i < len(oldFiles) && j < len(newFiles) {
+		of := oldFiles[i]
+		nf := newFiles[j]
+		switch {
+		case of.Name < nf.Name:
+			fn(of, nil)
+			i++
+		case of.Name > nf.Name:
+			fn(nil, nf)
+			j++
+		default:
+			fn(of, nf)
+			i++
+			j++
+		}
+	}
+	for ; i < len(oldFiles); i++ {
+		fn(oldFiles[i], nil)
+	}
+	for ; j < len(newFiles); j++ {
+		fn(nil, newFiles[j])
+	}
+}
+
+// diffManifestNames returns the names only in newList and the names only in
+// oldList, each in the order of its list.
+func diffManifestNames(oldList, newList []*Manifest) (added, removed []string) {
+	oldNames := make(map[string]bool, len(oldList))
+	for _, m := range oldList {
+		oldNames[m.Name] = true
+	}
+	newNames := make(map[string]bool, len(newList))
+	for _, m := range newList {
+		newNames[m.Name] = true
+		if !oldNames[m.Name] {
+			added = append(added, m.Name)
+		}
+	}
+	for _, m := range oldList {
+		if !newNames[m.Name] {
+			removed = append(removed, m.Name)
+		}
+	}
+	return added, removed
+}
@@ -0,0 +1,163 @@
+package swupd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func fileNames(files []*File) []string {
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+func TestDiffManifests(t *testing.T) {
+	mOld := &Manifest{
+		Header: ManifestHeader{
+			Format:    25,
+			Version:   10,
+			FileCount: 6,
+			Includes:  []*Manifest{{Name: "os-core"}, {Name: "editors"}},
+		},
+		Files: []*File{
+			{Name: "/same", Type: TypeFile, Hash: 1, Version: 10},
+			{Name: "/modified", Type: TypeFile, Hash: 2, Version: 10},
+			{Name: "/removed", Type: TypeFile, Hash: 3, Version: 10},
+			{Name: "/was-deleted", Status: StatusDeleted, Version: 10},
+			{Name: "/flags", Type: TypeFile, Hash: 4, Version: 10},
+			{Name: "/minversion", Type: TypeFile, Hash: 5, Version: 10},
+		},
+	}
+	mNew := &Manifest{
+		Header: ManifestHeader{
+			Format:    25,
+			Version:   20,
+			Previous:  10,
+			FileCount: 7,
+			Includes:  []*Manifest{{Name: "os-core"}, {Name: "python3-basic"}},
+		},
+		Files: []*File{
+			{Name: "/added", Type: TypeFile, Hash: 6, Version: 20},
+			{Name: "/flags", Type: TypeFile, Modifier: ModifierConfig, Hash: 4, Version: 10},
+			{Name: "/minversion", Type: TypeFile, Hash: 5, Version: 20},
+			{Name: "/modified", Type: TypeFile, Hash: 7, Version: 20},
+			{Name: "/removed", Status: StatusDeleted, Version: 20},
+			{Name: "/same", Type: TypeFile, Hash: 1, Version: 10},
+			{Name: "/was-deleted", Type: TypeFile, Hash: 8, Version: 20},
+		},
+	}
+
+	d := DiffManifests(mOld, mNew, DiffOptions{})
+
+	if got, expected := fileNames(d.Added), []string{"/added", "/was-deleted"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("added files are %v when %v was expected", got, expected)
+	}
+	if got, expected := fileNames(d.Removed), []string{"/removed"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("removed files are %v when %v was expected", got, expected)
+	}
+	var modified []string
+	for _, c := range d.Modified {
+		modified = append(modified, c.New.Name)
+	}
+	if expected := []string{"/flags", "/modified"}; !reflect.DeepEqual(modified, expected) {
+		t.Errorf("modified files are %v when %v was expected", modified, expected)
+	}
+	if !d.Modified[0].FlagsChanged || d.Modified[0].HashChanged {
+		t.Errorf("/flags change not detected as only a flags change")
+	}
+	if !d.Minversion {
+		t.Errorf("minversion change not detected")
+	}
+	if !reflect.DeepEqual(d.AddedIncludes, []string{"python3-basic"}) || !reflect.DeepEqual(d.RemovedIncludes, []string{"editors"}) {
+		t.Errorf("includes changes are +%v -%v when +[python3-basic] -[editors] was expected", d.AddedIncludes, d.RemovedIncludes)
+	}
+	if len(d.Header) != 1 || d.Header[0].Field != "filecount" {
+		t.Errorf("expected only filecount header change in non-strict mode, got %d changes", len(d.Header))
+	}
+
+	// Strict mode also reports changes in versions.
+	d = DiffManifests(mOld, mNew, DiffOptions{Strict: true})
+	if len(d.Modified) != 3 {
+		t.Errorf("%d files detected as modified in strict mode when 3 were expected", len(d.Modified))
+	}
+	if len(d.Header) != 3 {
+		t.Errorf("%d header changes detected in strict mode when 3 were expected", len(d.Header))
+	}
+
+	// Unchanged files are only reported when requested.
+	if len(d.Unchanged) != 0 {
+		t.Errorf("unchanged files reported without the Unchanged option")
+	}
+	d = DiffManifests(mOld, mNew, DiffOptions{Unchanged: true})
+	var unchanged []string
+	for _, c := range d.Unchanged {
+		unchanged = append(unchanged, c.New.Name)
+	}
+	if expected := []string{"/minversion", "/same"}; !reflect.DeepEqual(unchanged, expected) {
+		t.Errorf("unchanged files are %v when %v was expected", unchanged, expected)
+	}
+
+	// The manifests must not be modified.
+	if mNew.Files[0].Name != "/added" || mOld.Files[0].Name != "/same" {
+		t.Errorf("DiffManifests changed the order of the manifest files")
+	}
+}
+
+func TestDiffManifestsRenames(t *testing.T) {
+	mOld := &Manifest{
+		Files: []*File{
+			{Name: "/old-name", Type: TypeFile, Hash: 1},
+			{Name: "/old-dir", Type: TypeDirectory, Hash: 2},
+		},
+	}
+	mNew := &Manifest{
+		Files: []*File{
+			{Name: "/new-name", Type: TypeFile, Hash: 1},
+			{Name: "/new-dir", Type: TypeDirectory, Hash: 2},
+		},
+	}
+
+	d := DiffManifests(mOld, mNew, DiffOptions{})
+	if len(d.Renamed) != 0 || len(d.Added) != 2 || len(d.Removed) != 2 {
+		t.Errorf("renames detected without DetectRenames")
+	}
+
+	d = DiffManifests(mOld, mNew, DiffOptions{DetectRenames: true})
+	if len(d.Renamed) != 1 {
+		t.Fatalf("%d renames detected when 1 was expected", len(d.Renamed))
+	}
+	if d.Renamed[0].Old.Name != "/old-name" || d.Renamed[0].New.Name != "/new-name" {
+		t.Errorf("rename detected from %s to %s", d.Renamed[0].Old.Name, d.Renamed[0].New.Name)
+	}
+	if got := fileNames(d.Added); !reflect.DeepEqual(got, []string{"/new-dir"}) {
+		t.Errorf("added files are %v when only /new-dir was expected", got)
+	}
+	if got := fileNames(d.Removed); !reflect.DeepEqual(got, []string{"/old-dir"}) {
+		t.Errorf("removed files are %v when only /old-dir was expected", got)
+	}
+}
+
+func TestDiffManifestsNil(t *testing.T) {
+	m := &Manifest{
+		Files: []*File{
+			{Name: "/a", Type: TypeFile, Hash: 1},
+			{Name: "/b", Status: StatusDeleted},
+		},
+	}
+
+	d := DiffManifests(nil, m, DiffOptions{})
+	if got := fileNames(d.Added); !reflect.DeepEqual(got, []string{"/a"}) {
+		t.Errorf("added files are %v when only /a was expected", got)
+	}
+
+	d = DiffManifests(m, nil, DiffOptions{})
+	if got := fileNames(d.Removed); !reflect.DeepEqual(got, []string{"/a"}) {
+		t.Errorf("removed files are %v when only /a was expected", got)
+	}
+
+	if d = DiffManifests(m, m, DiffOptions{Strict: true}); !d.Empty() {
+		t.Errorf("diff of a manifest with itself is not empty")
+	}
+}
@@ -419,10 +419,6 @@ func TestLinkPeersAndChange(t *testing.T) {
 		"6": {false, ""},
 	}
 
-	// linkPeersAndChange requires mNew and mOld to have file lists sorted
-	// by name.
-	mNew.sortFilesName()
-	mOld.sortFilesName()
 	changed, added, deleted := mNew.linkPeersAndChange(&mOld, 0)
 	if changed != 2 {
 		t.Errorf("%v files detected as changed when 2 was expected", changed)
@@ -450,6 +446,49 @@ func TestLinkPeersAndChange(t *testing.T) {
 	}
 }
 
+func TestLinkPeersAndChangeMinversion(t *testing.T) {
+	mOld := Manifest{
+		Header: ManifestHeader{Format: 25, Version: 20},
+		Files: []*File{
+			{Name: "/deleted", Status: StatusDeleted, Version: 10},
+			{Name: "/new", Hash: 1, Version: 20},
+			{Name: "/old", Hash: 2, Version: 10},
+		},
+	}
+	mNew := Manifest{
+		Header: ManifestHeader{Format: 25, Version: 30},
+		Files: []*File{
+			{Name: "/old", Hash: 2, Info: sizer(0)},
+			{Name: "/new", Hash: 1, Info: sizer(0)},
+		},
+	}
+
+	changed, added, deleted := mNew.linkPeersAndChange(&mOld, 15)
+	if changed != 1 || added != 0 || deleted != 0 {
+		t.Fatalf("%d changed, %d added, %d deleted when only 1 changed was expected", changed, added, deleted)
+	}
+	expected := []struct {
+		name    string
+		version uint32
+	}{
+		{"/deleted", 10},
+		{"/new", 20},
+		{"/old", 30},
+	}
+	if len(mNew.Files) != len(expected) {
+		t.Fatalf("manifest has %d files when %d were expected", len(mNew.Files), len(expected))
+	}
+	for i, e := range expected {
+		f := mNew.Files[i]
+		if f.Name != e.name || f.Version != e.version {
+			t.Errorf("file %d is %s at version %d when %s at version %d was expected", i, f.Name, f.Version, e.name, e.version)
+		}
+	}
+	if mNew.Files[2].DeltaPeer == nil {
+		t.Errorf("file older than minversion has no delta peer")
+	}
+}
+
 func TestHasTypeChanges(t *testing.T) {
 	mUnchanged := Manifest{
 		Files: []*File{